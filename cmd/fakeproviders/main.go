@@ -0,0 +1,70 @@
+// Command fakeproviders serves the fake Groq, ElevenLabs and Google
+// Text-to-Speech APIs of pkg/fakeprovider, so the hub can run without
+// provider credentials.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"anne-hub/pkg/fakeprovider"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:4010", "listen address")
+	script := flag.String("script", "", "JSON file with the responses to queue per endpoint")
+	latency := flag.Duration("latency", 0, "latency added to every unscripted response")
+	flag.Parse()
+
+	s := fakeprovider.New()
+	if *latency > 0 {
+		for _, endpoint := range fakeprovider.Endpoints {
+			s.SetDefault(endpoint, fakeprovider.Response{Latency: fakeprovider.Duration(*latency)})
+		}
+	}
+	if *script != "" {
+		if err := loadScript(s, *script); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	base := "http://" + *addr
+	if strings.HasPrefix(*addr, ":") {
+		base = "http://localhost" + *addr
+	}
+	log.Printf("fake providers listening on %s, point the hub at them with:", *addr)
+	log.Printf("  GROQ_BASE_URL=%s%s GROQ_API_KEY=fake", base, fakeprovider.GroqPath)
+	log.Printf("  ELEVENLABS_BASE_URL=%s%s ELEVENLABS_API_KEY=fake", base, fakeprovider.ElevenLabsPath)
+	log.Printf("  GOOGLE_TTS_ENDPOINT=%s%s", base, fakeprovider.GooglePath)
+
+	srv := &http.Server{Addr: *addr, Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	log.Fatal(srv.ListenAndServe())
+}
+
+// loadScript queues the responses of a file like
+//
+//	{"groq.chat": [{"status": 429, "headers": {"Retry-After": "1"}}, {"text": "Hi!"}]}
+func loadScript(s *fakeprovider.Server, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var scripts map[string][]fakeprovider.Response
+	if err := json.Unmarshal(data, &scripts); err != nil {
+		return err
+	}
+	for endpoint, responses := range scripts {
+		if !slices.Contains(fakeprovider.Endpoints, endpoint) {
+			return fmt.Errorf("%s: unknown endpoint %q, expected one of %s", path, endpoint, strings.Join(fakeprovider.Endpoints, ", "))
+		}
+		s.Script(endpoint, responses...)
+	}
+	return nil
+}
@@ -0,0 +1,4 @@
+DROP TABLE usage_counters;
+
+ALTER TABLE users
+DROP COLUMN plan;
@@ -0,0 +1,16 @@
+ALTER TABLE users
+ADD COLUMN plan VARCHAR(64) NOT NULL DEFAULT '';
+
+-- Usage of a user or device per window, for the quotas of its plan. subject
+-- is "user:<uuid>" or "device:<id>", period is "minute" or "day".
+CREATE TABLE usage_counters (
+  subject VARCHAR(64) NOT NULL,
+  period VARCHAR(16) NOT NULL,
+  window_start TIMESTAMP NOT NULL,
+  turns INTEGER NOT NULL DEFAULT 0,
+  audio_ms BIGINT NOT NULL DEFAULT 0,
+  tokens BIGINT NOT NULL DEFAULT 0,
+  PRIMARY KEY (subject, period, window_start)
+);
+
+CREATE INDEX usage_counters_window_start_idx ON usage_counters (window_start);
@@ -0,0 +1,22 @@
+-- What each stage of a turn used and its estimated cost, priced when the
+-- turn ran. Records outlive their user, so past costs stay in the totals.
+CREATE TABLE usage_records (
+  id BIGSERIAL PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL,
+  turn_id VARCHAR(64) NOT NULL,
+  user_id UUID REFERENCES users (id) ON DELETE SET NULL,
+  device_id VARCHAR(64) NOT NULL DEFAULT '',
+  stage VARCHAR(8) NOT NULL,
+  provider VARCHAR(64) NOT NULL,
+  model VARCHAR(128) NOT NULL DEFAULT '',
+  prompt_tokens INTEGER NOT NULL DEFAULT 0,
+  completion_tokens INTEGER NOT NULL DEFAULT 0,
+  characters INTEGER NOT NULL DEFAULT 0,
+  audio_ms BIGINT NOT NULL DEFAULT 0,
+  cached BOOLEAN NOT NULL DEFAULT false,
+  cost_usd DOUBLE PRECISION NOT NULL DEFAULT 0
+);
+
+CREATE INDEX usage_records_user_id_created_at_idx ON usage_records (user_id, created_at);
+CREATE INDEX usage_records_device_id_created_at_idx ON usage_records (device_id, created_at);
+CREATE INDEX usage_records_created_at_idx ON usage_records (created_at);
@@ -0,0 +1,15 @@
+// Package migrations embeds the SQL migrations so the binary does not
+// depend on the working directory.
+package migrations
+
+import "embed"
+
+// FS holds the PostgreSQL migrations.
+//
+//go:embed *.sql
+var FS embed.FS
+
+// SQLite holds the SQLite migrations, in the sqlite directory.
+//
+//go:embed sqlite/*.sql
+var SQLite embed.FS
@@ -0,0 +1,6 @@
+DROP TABLE interests;
+DROP TABLE conversations;
+DROP TABLE tasks;
+DROP TABLE devices;
+DROP TABLE companion_apps;
+DROP TABLE users;
@@ -0,0 +1,62 @@
+-- The schema of the PostgreSQL migrations up to 000011, for SQLite. UUIDs
+-- are generated by the hub, JSON is stored as text.
+
+CREATE TABLE users (
+  id TEXT PRIMARY KEY,
+  username TEXT NOT NULL UNIQUE,
+  email TEXT NOT NULL UNIQUE,
+  password_hash TEXT NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  age INTEGER,
+  first_name TEXT,
+  last_name TEXT,
+  country TEXT,
+  city TEXT
+);
+
+CREATE TABLE companion_apps (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  settings TEXT,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  user_id TEXT REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE devices (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  device_name TEXT NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  last_synced TIMESTAMP,
+  companion_app_id INTEGER REFERENCES companion_apps (id) ON DELETE SET NULL,
+  user_id TEXT REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE tasks (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  title TEXT NOT NULL,
+  description TEXT,
+  due_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  completed BOOLEAN DEFAULT false,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  user_id TEXT REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE conversations (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id TEXT REFERENCES users (id) ON DELETE CASCADE,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  model_used TEXT,
+  conversation_history TEXT,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  system_prompt TEXT
+);
+
+CREATE TABLE interests (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  name TEXT NOT NULL,
+  description TEXT NOT NULL,
+  level INTEGER NOT NULL,
+  level_accuracy INTEGER NOT NULL,
+  user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,3 @@
+DROP TABLE usage_counters;
+
+ALTER TABLE users DROP COLUMN plan;
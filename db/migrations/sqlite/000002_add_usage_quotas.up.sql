@@ -0,0 +1,15 @@
+ALTER TABLE users ADD COLUMN plan TEXT NOT NULL DEFAULT '';
+
+-- Usage of a user or device per window, for the quotas of its plan. subject
+-- is "user:<uuid>" or "device:<id>", period is "minute" or "day".
+CREATE TABLE usage_counters (
+  subject TEXT NOT NULL,
+  period TEXT NOT NULL,
+  window_start TIMESTAMP NOT NULL,
+  turns INTEGER NOT NULL DEFAULT 0,
+  audio_ms INTEGER NOT NULL DEFAULT 0,
+  tokens INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (subject, period, window_start)
+);
+
+CREATE INDEX usage_counters_window_start_idx ON usage_counters (window_start);
@@ -0,0 +1,22 @@
+-- What each stage of a turn used and its estimated cost, priced when the
+-- turn ran. Records outlive their user, so past costs stay in the totals.
+CREATE TABLE usage_records (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  created_at TIMESTAMP NOT NULL,
+  turn_id TEXT NOT NULL,
+  user_id TEXT REFERENCES users (id) ON DELETE SET NULL,
+  device_id TEXT NOT NULL DEFAULT '',
+  stage TEXT NOT NULL,
+  provider TEXT NOT NULL,
+  model TEXT NOT NULL DEFAULT '',
+  prompt_tokens INTEGER NOT NULL DEFAULT 0,
+  completion_tokens INTEGER NOT NULL DEFAULT 0,
+  characters INTEGER NOT NULL DEFAULT 0,
+  audio_ms INTEGER NOT NULL DEFAULT 0,
+  cached BOOLEAN NOT NULL DEFAULT false,
+  cost_usd REAL NOT NULL DEFAULT 0
+);
+
+CREATE INDEX usage_records_user_id_created_at_idx ON usage_records (user_id, created_at);
+CREATE INDEX usage_records_device_id_created_at_idx ON usage_records (device_id, created_at);
+CREATE INDEX usage_records_created_at_idx ON usage_records (created_at);
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"anne-hub/pkg/config"
+
+	"github.com/labstack/echo/v4"
+	"gopkg.in/yaml.v3"
+)
+
+// AdminAuth only lets requests carrying "Authorization: Bearer <token>"
+// through. With an empty token the admin routes are disabled.
+func AdminAuth(token string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if token == "" {
+				return c.JSON(http.StatusNotFound, map[string]string{
+					"error": "Not found.",
+				})
+			}
+
+			got, ok := strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				return c.JSON(http.StatusUnauthorized, map[string]string{
+					"error": "Unauthorized.",
+				})
+			}
+			return next(c)
+		}
+	}
+}
+
+// ConfigHandler returns the running configuration as YAML, with secrets redacted.
+func ConfigHandler(cfg *config.Config) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		out, err := yaml.Marshal(cfg.Redacted())
+		if err != nil {
+			logger.ErrorContext(c.Request().Context(), "failed to encode config", "error", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to encode config.",
+			})
+		}
+		return c.Blob(http.StatusOK, "application/yaml", out)
+	}
+}
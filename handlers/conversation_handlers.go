@@ -4,109 +4,176 @@ package handlers
 
 import (
 	"anne-hub/models"
-	"anne-hub/pkg/fs"
-	"anne-hub/pkg/groq"
+	"anne-hub/pkg/apology"
+	"anne-hub/pkg/audiostore"
+	"anne-hub/pkg/codec"
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/logging"
+	"anne-hub/pkg/metrics"
 	"anne-hub/pkg/pcm"
+	"anne-hub/pkg/quota"
 	"anne-hub/pkg/systemprompt"
+	"anne-hub/pkg/tracing"
+	"anne-hub/repository"
 	"anne-hub/services"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
-	"log"
+	"math"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
 
+var logger = logging.For("handlers")
+
 var conversationResetMinutes = 15
 
+// minRequestDuration is the shortest recording worth sending to STT.
+var minRequestDuration = 500 * time.Millisecond
+
+// ConversationHandlers serves the HTTP and WebSocket conversation routes.
+type ConversationHandlers struct {
+	store repository.Store
+	deps  Deps
+	// maxTurn is the longest audio a WebSocket turn may have
+	maxTurn time.Duration
+	// prosody enables the analysis of how requests are spoken
+	prosody bool
+}
+
+func NewConversationHandlers(store repository.Store, deps Deps, audio config.AudioConfig) *ConversationHandlers {
+	return &ConversationHandlers{store: store, deps: deps, maxTurn: audio.MaxTurnDuration, prosody: audio.Prosody}
+}
+
 // ConversationHandler handles incoming conversation requests.
-func ConversationHandler(c echo.Context) error {
-	log.Println("Entered ConversationHandler")
+func (h *ConversationHandlers) ConversationHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 
 	var req models.AnneWearConversationRequest
 	contentType := c.Request().Header.Get("Content-Type")
-	log.Printf("Content-Type: %s", contentType)
 
 	// Parse request based on Content-Type
 	if err := parseRequest(c, contentType, &req); err != nil {
 		return err
 	}
 
+	ctx = logging.With(ctx, "user_id", logging.PII(req.UserID.String()), "device_id", logging.PII(strconv.Itoa(req.DeviceID)))
+
+	req.AudioFormat = req.AudioFormat.WithDefaults()
+	if err := req.AudioFormat.Validate(); err != nil {
+		logger.WarnContext(ctx, "invalid audio format", "error", err)
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid audio format: " + err.Error(),
+		})
+	}
+
+	codecLabel := codec.Normalize(req.AudioCodec)
+	if !codec.IsSupported(codecLabel) {
+		codecLabel = "unsupported"
+	}
+	metrics.RequestAudioBytes.WithLabelValues("http", codecLabel).Observe(float64(len(req.RequestPCM)))
+	if err := decodeRequestAudio(ctx, &req); err != nil {
+		return err
+	}
+	duration := req.AudioFormat.Duration(len(req.RequestPCM))
+	metrics.RequestAudioSeconds.WithLabelValues("http").Observe(duration.Seconds())
+
 	// Validate RequestPCM
-	if len(req.RequestPCM) < 16000 {
-		log.Println("RequestPCM is too short")
+	if duration < minRequestDuration {
+		logger.InfoContext(ctx, "request audio too short", "duration", duration)
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error": "The request is too short.",
 		})
 	}
-	log.Println("RequestPCM length is valid")
+
+	if frame, limited := h.rateLimited(ctx, req.UserID, strconv.Itoa(req.DeviceID), duration, req.Language); limited {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(frame.RetryAfter))
+		return c.JSON(http.StatusTooManyRequests, frame)
+	}
 
 	// Fetch previous conversation
-	lastConversation, conversationHistory, err := services.GetPreviousConversation(req.UserID, conversationResetMinutes)
+	lastConversation, conversationHistory, err := services.GetPreviousConversation(ctx, h.store.Conversations(), req.UserID, conversationResetMinutes)
 	if err != nil {
-		log.Printf("Failed to query conversation: %v\n", err)
+		logger.ErrorContext(ctx, "failed to query conversation", "error", err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to query conversation.",
 		})
 	}
 
-	systemPrompt := systemprompt.DynamicGeneration(req.UserID)
+	systemPrompt := systemprompt.DynamicGeneration(ctx, h.store, req.UserID, h.deps.Emotions.For("").Emotions)
+	var prosody *pcm.Prosody
+	if h.prosody {
+		prosody = analyzeProsody(ctx, req.RequestPCM, req.AudioFormat)
+	}
+	if prosody != nil {
+		systemPrompt += systemprompt.VoiceCues(*prosody)
+	}
 
 	// Handle audio conversion
-	wavData, err := processPCMData(req.RequestPCM)
+	wavData, err := processPCMData(ctx, req.RequestPCM, req.AudioFormat)
 	if err != nil {
 		return err
 	}
 
-	// Save WAV file
-	if err := fs.WriteWAVDataToFile("recording.wav", wavData); err != nil {
-		log.Println("Error saving WAV file")
+	turnID := audiostore.NewTurnID()
+	ctx, finishCassette := h.deps.Cassettes.Start(ctx, turnID)
+	defer finishCassette()
+	usage := h.deps.Accounting.Turn(turnID, req.UserID, strconv.Itoa(req.DeviceID))
+	defer usage.Save(ctx)
+
+	// Archive the request audio
+	requestAudio, err := h.deps.Audio.Save(ctx, req.UserID, turnID, audiostore.Request, wavData)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to archive request audio", "error", err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to save WAV file.",
 		})
 	}
 
 	// Generate transcription
-	transcription, err := groq.GenerateWhisperTranscription(wavData, req.Language)
+	transcription, sttProvider, err := h.deps.Pipeline.Transcribe(ctx, wavData, req.Language)
 	if err != nil {
-		log.Printf("Failed to get transcription: %v\n", err)
+		logger.ErrorContext(ctx, "transcription failed", "error", err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to get transcription: " + err.Error(),
 		})
 	}
-	log.Printf("Transcription received: %s\n", transcription)
+	usage.STT(sttProvider, duration)
+	logger.InfoContext(ctx, "transcription received", "transcript", logging.Transcript(transcription))
 
 	// Append user message to conversation history
-	services.AppendMessageToConversationHistory(&conversationHistory, "user", transcription)
+	userMessage := services.AppendMessageToConversationHistory(&conversationHistory, "user", transcription)
+	userMessage.AudioCodec = req.AudioCodec
+	userMessage.RequestAudio = requestAudio
+	userMessage.STTProvider = sttProvider
+	userMessage.Prosody = prosody
 
 	// Generate LLM response
-	llmResponse, err := groq.GenerateLLMResponseFromConversationData(conversationHistory, systemPrompt, req.Language)
+	llmResponse, llmProvider, err := h.deps.Pipeline.Complete(ctx, conversationHistory, systemPrompt, req.Language)
 	if err != nil {
-		log.Printf("Error generating LLM response: %v\n", err)
+		logger.ErrorContext(ctx, "llm request failed", "error", err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to generate LLM response.",
 		})
 	}
-
-	if len(llmResponse.Choices) == 0 {
-		log.Println("No choices returned from LLM response")
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "No choices returned from LLM response.",
-		})
-	}
+	h.countTokens(ctx, req.UserID, llmResponse)
+	usage.LLM(llmProvider, llmResponse)
 
 	assistantResponse := llmResponse.Choices[0].Message.Content
-	log.Printf("Assistant response extracted: %s\n", assistantResponse)
 
 	// Append assistant message to conversation history
-	services.AppendMessageToConversationHistory(&conversationHistory, "assistant", assistantResponse)
+	assistantMessage := services.AppendMessageToConversationHistory(&conversationHistory, "assistant", assistantResponse)
+	assistantMessage.LLMProvider = llmProvider
 
 	// Marshal conversation history
 	convoJSON, err := json.Marshal(conversationHistory)
 	if err != nil {
-		log.Printf("Error marshalling ConversationHistory: %v\n", err)
+		logger.ErrorContext(ctx, "failed to marshal conversation history", "error", err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to process conversation history.",
 		})
@@ -114,17 +181,16 @@ func ConversationHandler(c echo.Context) error {
 
 	// Insert or update conversation in the database
 	if lastConversation == nil {
-		if err := services.InsertNewConversation(req.UserID, convoJSON); err != nil {
+		if err := services.InsertNewConversation(ctx, h.store.Conversations(), req.UserID, convoJSON); err != nil {
 			return err
 		}
 	} else {
-		if err := services.UpdateExistingConversation(lastConversation.ID, convoJSON); err != nil {
+		if err := services.UpdateExistingConversation(ctx, h.store.Conversations(), lastConversation.ID, convoJSON); err != nil {
 			return err
 		}
 	}
 
-	log.Printf("Final assistant response to send: %s\n", assistantResponse)
-	c.Logger().Info("Returning response to user")
+	logger.InfoContext(ctx, "assistant response", "response", logging.Transcript(assistantResponse))
 
 	return c.JSON(http.StatusOK, map[string]string{
 		"transcription": assistantResponse,
@@ -133,22 +199,20 @@ func ConversationHandler(c echo.Context) error {
 
 // parseRequest parses the incoming request based on Content-Type.
 func parseRequest(c echo.Context, contentType string, req *models.AnneWearConversationRequest) error {
+	ctx := c.Request().Context()
 	if contentType == "application/json" {
-		log.Println("Processing JSON payload")
 		if err := c.Bind(req); err != nil {
-			log.Printf("Bind error: %v\n", err)
+			logger.WarnContext(ctx, "invalid request payload", "error", err)
 			return c.JSON(http.StatusBadRequest, map[string]string{
 				"error": "Invalid request payload.",
 			})
 		}
-		log.Println("Successfully bound request to AnneWearConversationRequest model")
 	} else if contentType == "application/octet-stream" {
-		log.Println("Processing raw PCM data")
 		if err := parsePCMRequest(c, req); err != nil {
 			return err
 		}
 	} else {
-		log.Printf("Unsupported Content-Type: %s\n", contentType)
+		logger.WarnContext(ctx, "unsupported content type", "content_type", contentType)
 		return c.JSON(http.StatusUnsupportedMediaType, map[string]string{
 			"error": "Unsupported Media Type.",
 		})
@@ -158,14 +222,15 @@ func parseRequest(c echo.Context, contentType string, req *models.AnneWearConver
 
 // parsePCMRequest handles the parsing of PCM data requests.
 func parsePCMRequest(c echo.Context, req *models.AnneWearConversationRequest) error {
+	ctx := c.Request().Context()
 	pcmData, err := io.ReadAll(c.Request().Body)
 	if err != nil {
-		log.Printf("Error reading PCM data: %v\n", err)
+		logger.WarnContext(ctx, "failed to read request body", "error", err)
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error": "Failed to read PCM data.",
 		})
 	}
-	log.Printf("Received PCM data of size: %d bytes", len(pcmData))
+	logger.DebugContext(ctx, "received audio", "bytes", len(pcmData))
 
 	if len(pcmData) == 0 {
 		return c.JSON(http.StatusBadRequest, map[string]string{
@@ -178,7 +243,7 @@ func parsePCMRequest(c echo.Context, req *models.AnneWearConversationRequest) er
 	language := c.Request().Header.Get("X-Language")
 
 	if userIDStr == "" || deviceIDStr == "" || language == "" {
-		log.Println("Missing required headers: X-User-ID, X-Device-ID, X-Language")
+		logger.WarnContext(ctx, "missing required headers")
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error": "Missing required headers: X-User-ID, X-Device-ID, X-Language",
 		})
@@ -186,7 +251,7 @@ func parsePCMRequest(c echo.Context, req *models.AnneWearConversationRequest) er
 
 	userID, err := uuid.Parse(userIDStr)
 	if err != nil {
-		log.Printf("Invalid UserID format: %v\n", err)
+		logger.WarnContext(ctx, "invalid user id", "user_id", logging.PII(userIDStr))
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error": "Invalid UserID format.",
 		})
@@ -194,40 +259,176 @@ func parsePCMRequest(c echo.Context, req *models.AnneWearConversationRequest) er
 
 	deviceID, err := strconv.Atoi(deviceIDStr)
 	if err != nil {
-		log.Printf("Invalid DeviceID format: %v\n", err)
+		logger.WarnContext(ctx, "invalid device id", "device_id", logging.PII(deviceIDStr))
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error": "Invalid DeviceID format.",
 		})
 	}
 
+	format := pcm.M5Format
+	if spec := c.Request().Header.Get("X-Audio-Format"); spec != "" {
+		format, err = pcm.ParseFormat(spec)
+		if err != nil {
+			logger.WarnContext(ctx, "invalid X-Audio-Format header", "error", err)
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid X-Audio-Format header: " + err.Error(),
+			})
+		}
+	}
+
+	blockSize := 0
+	if blockSizeStr := c.Request().Header.Get("X-Audio-Block-Size"); blockSizeStr != "" {
+		blockSize, err = strconv.Atoi(blockSizeStr)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid X-Audio-Block-Size header.",
+			})
+		}
+	}
+
 	*req = models.AnneWearConversationRequest{
-		UserID:     userID,
-		DeviceID:   deviceID,
-		RequestPCM: pcmData,
-		Language:   language,
+		UserID:         userID,
+		DeviceID:       deviceID,
+		RequestPCM:     pcmData,
+		AudioFormat:    format,
+		AudioCodec:     c.Request().Header.Get("X-Audio-Codec"),
+		AudioBlockSize: blockSize,
+		Language:       language,
 	}
 
-	log.Println("Constructed AnneWearConversationRequest from raw PCM data and headers")
 	return nil
 }
 
 
 
-// processPCMData converts PCM data to WAV format.
-func processPCMData(pcmData []byte) ([]byte, error) {
-	log.Println("Converting PCM data to WAV format")
-	wavData, err := pcm.ToWAV(pcmData)
+// decodeRequestAudio decompresses RequestPCM in place when the device uploaded
+// it with a codec, leaving AudioFormat describing the decoded PCM.
+func decodeRequestAudio(ctx context.Context, req *models.AnneWearConversationRequest) error {
+	req.AudioCodec = codec.Normalize(req.AudioCodec)
+	if req.AudioCodec == codec.PCM {
+		return nil
+	}
+
+	if !codec.IsSupported(req.AudioCodec) {
+		logger.WarnContext(ctx, "unsupported audio codec", "codec", req.AudioCodec)
+		return &echo.HTTPError{
+			Code: http.StatusUnsupportedMediaType,
+			Message: map[string]interface{}{
+				"error":     "Unsupported audio codec: " + req.AudioCodec,
+				"supported": codec.Supported(),
+			},
+		}
+	}
+
+	decoded, format, err := codec.DecodeBody(req.AudioCodec, req.AudioFormat, req.AudioBlockSize, req.RequestPCM)
+	if err != nil {
+		logger.WarnContext(ctx, "failed to decode audio", "codec", req.AudioCodec, "error", err)
+		return &echo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "Failed to decode audio.",
+			Internal: err,
+		}
+	}
+	logger.DebugContext(ctx, "decoded audio", "codec", req.AudioCodec, "bytes", len(req.RequestPCM), "pcm_bytes", len(decoded))
+
+	req.RequestPCM = decoded
+	req.AudioFormat = format
+	return nil
+}
+
+// processPCMData normalizes device audio to pcm.SpeechFormat and wraps it as WAV.
+func processPCMData(ctx context.Context, pcmData []byte, format pcm.Format) ([]byte, error) {
+	speechPCM, err := pcm.Convert(pcmData, format, pcm.SpeechFormat)
 	if err != nil {
-		log.Printf("Failed to convert PCM to WAV: %v\n", err)
+		logger.WarnContext(ctx, "failed to convert audio to speech format", "format", format.String(), "error", err)
+		return nil, &echo.HTTPError{
+			Code:     http.StatusBadRequest,
+			Message:  "Failed to convert audio format.",
+			Internal: err,
+		}
+	}
+
+	wavData, err := pcm.ToWAV(speechPCM, pcm.SpeechFormat)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to convert PCM to WAV", "error", err)
 		return nil, &echo.HTTPError{
 			Code:     http.StatusInternalServerError,
 			Message:  "Failed to convert PCM to WAV.",
 			Internal: err,
 		}
 	}
-	log.Println("PCM data successfully converted to WAV format")
 	return wavData, nil
 }
 
+// analyzeProsody returns how the request audio was spoken, nil when it has
+// no speech or cannot be analyzed.
+func analyzeProsody(ctx context.Context, data []byte, format pcm.Format) *pcm.Prosody {
+	ctx, span := tracing.Start(ctx, "pcm.AnalyzeProsody")
+	defer span.End()
 
+	p, err := pcm.AnalyzeProsody(data, format)
+	if err != nil {
+		logger.WarnContext(ctx, "failed to analyze prosody", "error", err)
+		return nil
+	}
+	if p.Mood == "" {
+		return nil
+	}
+	logger.DebugContext(ctx, "prosody analyzed", "mood", p.Mood, "arousal", p.Arousal, "valence", p.Valence, "pitch_hz", p.PitchHz, "speaking_rate", p.SpeakingRate)
+	return &p
+}
+
+// errorFrame returns the error frame for code. Codes with an apology carry
+// its text and emotion in language, and the URL of its audio while spoken
+// errors are enabled.
+func (h *ConversationHandlers) errorFrame(ctx context.Context, code models.ErrorCode, message, language string) models.ErrorFrame {
+	frame := models.ErrorFrame{Type: "error", Code: code, Message: message}
+
+	if text, emotion, ok := apology.Lookup(code, language); ok {
+		frame.Text = text
+		frame.Emotion = emotion
+		if h.deps.Apologies != nil {
+			audioURL, err := h.deps.Apologies.URL(ctx, code, language, responseAudioURLTTL)
+			if err != nil {
+				logger.WarnContext(ctx, "failed to get spoken apology", "code", code, "error", err)
+			} else {
+				frame.AudioURL = audioURL
+			}
+		}
+	}
+	return frame
+}
+
+// rateLimited counts a turn against the quotas of the user's plan and
+// returns the rate_limited frame to answer with when the turn is over a
+// limit. A failing quota check lets the turn through, so Anne keeps talking
+// while the database struggles.
+func (h *ConversationHandlers) rateLimited(ctx context.Context, userID uuid.UUID, deviceID string, audio time.Duration, language string) (models.ErrorFrame, bool) {
+	if h.deps.Quota == nil {
+		return models.ErrorFrame{}, false
+	}
 
+	err := h.deps.Quota.Allow(ctx, userID, deviceID, audio)
+	var exceeded *quota.ExceededError
+	if errors.As(err, &exceeded) {
+		logger.InfoContext(ctx, "quota exceeded", "plan", exceeded.Plan, "limit", exceeded.Limit, "retry_after", exceeded.RetryAfter)
+		frame := h.errorFrame(ctx, models.ErrorRateLimited, "Quota exceeded: "+exceeded.Limit+".", language)
+		frame.RetryAfter = int(math.Ceil(exceeded.RetryAfter.Seconds()))
+		return frame, true
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to check quota, allowing the turn", "error", err)
+	}
+	return models.ErrorFrame{}, false
+}
+
+// countTokens counts the tokens of an LLM answer against the user's daily
+// quota.
+func (h *ConversationHandlers) countTokens(ctx context.Context, userID uuid.UUID, resp models.GroqLLMResponse) {
+	if h.deps.Quota == nil {
+		return
+	}
+	if err := h.deps.Quota.AddTokens(ctx, userID, resp.Usage.TotalTokens); err != nil {
+		logger.WarnContext(ctx, "failed to count tokens", "error", err)
+	}
+}
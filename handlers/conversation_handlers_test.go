@@ -0,0 +1,259 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"anne-hub/handlers"
+	"anne-hub/models"
+	"anne-hub/pkg/accounting"
+	"anne-hub/pkg/audiostore"
+	"anne-hub/pkg/blob"
+	"anne-hub/pkg/cassette"
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/db"
+	"anne-hub/pkg/emotion"
+	"anne-hub/pkg/fakeprovider"
+	"anne-hub/pkg/phrasepack"
+	"anne-hub/pkg/pipeline"
+	"anne-hub/pkg/quota"
+	"anne-hub/repository"
+	"anne-hub/repository/sqlite"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/labstack/echo/v4"
+)
+
+// newTestHandlers creates the conversation handlers of cfg on a migrated
+// SQLite database and local storage in a temporary directory.
+func newTestHandlers(t *testing.T, cfg *config.Config) (*handlers.ConversationHandlers, repository.Store) {
+	t.Helper()
+	dir := t.TempDir()
+	cfg.Database.Driver = "sqlite"
+	cfg.Database.Path = filepath.Join(dir, "anne-hub.db")
+	cfg.Storage.Dir = filepath.Join(dir, "data")
+	// Apologies would be rendered in the background, against the providers
+	cfg.Audio.SpokenErrors = false
+
+	conn, err := db.Connect(cfg.Database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	m, err := db.NewMigrator(conn, cfg.Database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		t.Fatal(err)
+	}
+	store := sqlite.New(conn)
+
+	blobs := blob.Setup(cfg.Storage)
+	p := pipeline.Setup(cfg, nil)
+	deps := handlers.Deps{
+		Pipeline:    p,
+		Blobs:       blobs,
+		Audio:       audiostore.Setup(cfg.Audio, blobs),
+		Emotions:    emotion.Setup(cfg.Emotion),
+		PhrasePacks: phrasepack.Setup(p, blobs),
+		Accounting:  accounting.New(cfg.Pricing, store, p),
+		Quota:       quota.Setup(cfg.Quota, store),
+		Cassettes:   cassette.Setup(cfg.Cassette),
+	}
+	return handlers.NewConversationHandlers(store, deps, cfg.Audio), store
+}
+
+// createUser stores a user for the requests of a test.
+func createUser(t *testing.T, store repository.Store) models.User {
+	t.Helper()
+	user := models.User{Username: "anne-test", Email: "anne-test@example.com"}
+	if err := store.Users().Create(context.Background(), &user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// tone is a second of a 220 Hz sine in the M5 format.
+func tone() []byte {
+	var buf bytes.Buffer
+	for i := 0; i < 16000; i++ {
+		s := int16(8000 * math.Sin(2*math.Pi*220*float64(i)/16000))
+		binary.Write(&buf, binary.LittleEndian, s)
+	}
+	return buf.Bytes()
+}
+
+// converse sends a turn of raw PCM to the HTTP conversation handler.
+func converse(t *testing.T, h *handlers.ConversationHandlers, user models.User) (int, map[string]any) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/ConversationHandler", bytes.NewReader(tone()))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-User-ID", user.ID.String())
+	req.Header.Set("X-Device-ID", "7")
+	req.Header.Set("X-Language", "en")
+	rec := httptest.NewRecorder()
+
+	e := echo.New()
+	if err := h.ConversationHandler(e.NewContext(req, rec)); err != nil {
+		e.HTTPErrorHandler(err, e.NewContext(req, rec))
+	}
+
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, body
+}
+
+func TestConversationHandlerWithFakeProviders(t *testing.T) {
+	fake := fakeprovider.Start()
+	defer fake.Close()
+	cfg := config.Defaults()
+	fake.Configure(&cfg)
+	h, store := newTestHandlers(t, &cfg)
+	user := createUser(t, store)
+	ctx := context.Background()
+
+	status, body := converse(t, h, user)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, body %v", status, body)
+	}
+	if want := "You said: " + fakeprovider.DefaultTranscript; body["transcription"] != want {
+		t.Errorf("reply = %q, want %q", body["transcription"], want)
+	}
+
+	requests := fake.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("provider requests = %+v, want a transcription and a completion", requests)
+	}
+	if r := requests[0]; r.Endpoint != fakeprovider.GroqTranscription || r.Language != "en" || r.Bytes == 0 {
+		t.Errorf("first request = %+v, want the transcription of the audio", r)
+	}
+	if r := requests[1]; r.Endpoint != fakeprovider.GroqChat || r.Text != fakeprovider.DefaultTranscript {
+		t.Errorf("second request = %+v, want a completion of the transcript", r)
+	}
+
+	conversation, err := store.Conversations().Latest(ctx, user.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var history models.ConversationHistory
+	if err := json.Unmarshal(conversation.ConversationHistory, &history); err != nil {
+		t.Fatal(err)
+	}
+	if m := history.Messages; len(m) != 2 || m[0].Content != fakeprovider.DefaultTranscript || m[1].Content != body["transcription"] {
+		t.Errorf("history = %+v, want the transcript and the reply", m)
+	}
+
+	summary, err := store.UsageRecords().Summarize(ctx, repository.UsageQuery{GroupBy: repository.ByProvider, UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary) == 0 {
+		t.Error("no usage recorded for the turn")
+	}
+}
+
+func TestConversationHandlerProviderFailure(t *testing.T) {
+	fake := fakeprovider.Start()
+	defer fake.Close()
+	fake.SetDefault(fakeprovider.GroqChat, fakeprovider.Response{Status: http.StatusBadRequest, Text: "model not found"})
+	cfg := config.Defaults()
+	fake.Configure(&cfg)
+	h, store := newTestHandlers(t, &cfg)
+	user := createUser(t, store)
+
+	status, body := converse(t, h, user)
+	if status != http.StatusInternalServerError || body["error"] == "" {
+		t.Errorf("status = %d, body %v, want a 500 with an error", status, body)
+	}
+	if _, err := store.Conversations().Latest(context.Background(), user.ID, time.Hour); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("conversation of the failed turn: %v, want none", err)
+	}
+}
+
+func TestConversationHandlerQuota(t *testing.T) {
+	fake := fakeprovider.Start()
+	defer fake.Close()
+	cfg := config.Defaults()
+	fake.Configure(&cfg)
+	cfg.Quota.Plans["standard"] = config.PlanConfig{DeviceTurnsPerMinute: 1}
+	h, store := newTestHandlers(t, &cfg)
+	user := createUser(t, store)
+
+	if status, body := converse(t, h, user); status != http.StatusOK {
+		t.Fatalf("first turn: status = %d, body %v", status, body)
+	}
+	status, body := converse(t, h, user)
+	if status != http.StatusTooManyRequests {
+		t.Fatalf("second turn: status = %d, body %v, want 429", status, body)
+	}
+	if body["code"] != string(models.ErrorRateLimited) || body["retry_after"] == nil {
+		t.Errorf("second turn: body %v, want a rate_limited frame with retry_after", body)
+	}
+	if n := len(fake.Requests()); n != 2 {
+		t.Errorf("provider requests = %d, want only the ones of the first turn", n)
+	}
+}
+
+func TestConversationHandlerReplaysCassette(t *testing.T) {
+	dir := t.TempDir()
+
+	// Record a turn against the fake providers
+	fake := fakeprovider.Start()
+	fake.Script(fakeprovider.GroqChat, fakeprovider.Response{Text: "Recorded reply."})
+	cfg := config.Defaults()
+	fake.Configure(&cfg)
+	cfg.Cassette = config.CassetteConfig{Mode: cassette.ModeRecord, Dir: dir}
+	h, store := newTestHandlers(t, &cfg)
+	status, recorded := converse(t, h, createUser(t, store))
+	fake.Close()
+	if status != http.StatusOK {
+		t.Fatalf("recording: status = %d, body %v", status, recorded)
+	}
+
+	cassettes, err := cassette.LoadAll(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cassettes) != 1 || len(cassettes[0].Interactions) != 2 {
+		t.Fatalf("recorded %d cassettes, want one with a transcription and a completion", len(cassettes))
+	}
+	for _, i := range cassettes[0].Interactions {
+		if i.Request.Header.Get("Authorization") != "" {
+			t.Errorf("cassette holds the API key of %s", i.Request.URL)
+		}
+	}
+
+	// Replay it with the providers gone
+	cfg = config.Defaults()
+	fake.Configure(&cfg)
+	cfg.Cassette = config.CassetteConfig{Mode: cassette.ModeReplay, Dir: dir}
+	// A request missing from the cassette fails like the network
+	cfg.Providers.MaxRetries = 0
+	h, store = newTestHandlers(t, &cfg)
+	user := createUser(t, store)
+	status, replayed := converse(t, h, user)
+	if status != http.StatusOK {
+		t.Fatalf("replay: status = %d, body %v", status, replayed)
+	}
+	if replayed["transcription"] != "Recorded reply." {
+		t.Errorf("replayed reply = %q, want the recorded one", replayed["transcription"])
+	}
+
+	// Every recording was used up
+	if status, body := converse(t, h, user); status != http.StatusInternalServerError {
+		t.Errorf("turn after the cassette ran out: status = %d, body %v, want 500", status, body)
+	}
+}
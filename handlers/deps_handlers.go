@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"anne-hub/pkg/accounting"
+	"anne-hub/pkg/apology"
+	"anne-hub/pkg/audiostore"
+	"anne-hub/pkg/blob"
+	"anne-hub/pkg/cassette"
+	"anne-hub/pkg/emotion"
+	"anne-hub/pkg/phrasepack"
+	"anne-hub/pkg/pipeline"
+	"anne-hub/pkg/quota"
+)
+
+// Deps are the services the handlers use besides the repository, created
+// from the configuration at startup.
+type Deps struct {
+	Pipeline    *pipeline.Pipeline
+	Blobs       blob.Store
+	Audio       *audiostore.Store
+	Emotions    *emotion.Catalogs
+	PhrasePacks *phrasepack.Builder
+	Accounting  *accounting.Accountant
+	// Quota is nil while quotas are disabled
+	Quota *quota.Limiter
+	// Apologies is nil while spoken errors are disabled
+	Apologies *apology.Speaker
+	// Cassettes is nil while cassettes are off
+	Cassettes *cassette.Recorder
+}
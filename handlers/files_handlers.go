@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"anne-hub/pkg/blob"
+
+	"github.com/labstack/echo/v4"
+)
+
+// FileHandlers serves files from blob storage.
+type FileHandlers struct {
+	blobs blob.Store
+}
+
+func NewFileHandlers(blobs blob.Store) *FileHandlers {
+	return &FileHandlers{blobs: blobs}
+}
+
+// StaticFilesHandler serves public assets stored under "static/" in blob storage.
+func (h *FileHandlers) StaticFilesHandler(c echo.Context) error {
+	// Join cleans "..", which must not lead out of static/
+	name := c.Param("*")
+	key := blob.Join("static", name)
+	if err := blob.ValidateKey(name); err != nil || !strings.HasPrefix(key, "static/") {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid file path.",
+		})
+	}
+
+	return serveBlob(c, h.blobs, key)
+}
+
+// SignedBlobHandler serves blobs of the local backend to holders of a URL
+// from blob.LocalStore.SignedURL. S3 signed URLs point at the bucket instead.
+func (h *FileHandlers) SignedBlobHandler(c echo.Context) error {
+	local, ok := h.blobs.(*blob.LocalStore)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Not found.",
+		})
+	}
+
+	key := c.Param("*")
+	if err := local.Verify(key, c.QueryParam("expires"), c.QueryParam("sig")); err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"error": "Invalid or expired URL.",
+		})
+	}
+
+	return serveBlob(c, h.blobs, key)
+}
+
+func serveBlob(c echo.Context, blobs blob.Store, key string) error {
+	data, err := blobs.Get(c.Request().Context(), key)
+	if errors.Is(err, blob.ErrNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "File not found.",
+		})
+	}
+	if err != nil {
+		logger.ErrorContext(c.Request().Context(), "failed to read blob", "key", key, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to read file.",
+		})
+	}
+
+	return c.Blob(http.StatusOK, blob.ContentType(key), data)
+}
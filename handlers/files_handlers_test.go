@@ -0,0 +1,92 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"anne-hub/handlers"
+	"anne-hub/pkg/blob"
+
+	"github.com/labstack/echo/v4"
+)
+
+func newFileServer(t *testing.T) (*echo.Echo, *blob.LocalStore) {
+	t.Helper()
+	blobs, err := blob.NewLocalStore(t.TempDir(), "", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"static/logo.png", "audio/anne/1.wav"} {
+		if err := blobs.Put(context.Background(), key, []byte(key), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := handlers.NewFileHandlers(blobs)
+	e := echo.New()
+	e.GET("/files/*", h.StaticFilesHandler)
+	e.GET("/blobs/*", h.SignedBlobHandler)
+	return e, blobs
+}
+
+func get(e *echo.Echo, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+func TestStaticFilesHandler(t *testing.T) {
+	e, _ := newFileServer(t)
+
+	// Escaped paths are not unescaped, so they name a key in static/
+	for target, want := range map[string]int{
+		"/files/logo.png":                  http.StatusOK,
+		"/files/missing.png":               http.StatusNotFound,
+		"/files/../audio/anne/1.wav":       http.StatusBadRequest,
+		"/files/%2e%2e/audio/anne/1.wav":   http.StatusNotFound,
+		"/files/a/../../audio/anne/1.wav":  http.StatusBadRequest,
+		"/files/..%2faudio%2fanne%2f1.wav": http.StatusNotFound,
+	} {
+		rec := get(e, target)
+		if rec.Code != want {
+			t.Errorf("GET %s = %d %s, want %d", target, rec.Code, rec.Body, want)
+		}
+		if want == http.StatusOK && (rec.Body.String() != "static/logo.png" || rec.Header().Get("Content-Type") != "image/png") {
+			t.Errorf("GET %s = %q as %s", target, rec.Body, rec.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestSignedBlobHandler(t *testing.T) {
+	e, blobs := newFileServer(t)
+
+	signed, err := blobs.SignedURL(context.Background(), "audio/anne/1.wav", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := blobs.SignedURL(context.Background(), "audio/anne/1.wav", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(signed)
+
+	for target, want := range map[string]int{
+		signed:                                  http.StatusOK,
+		expired:                                 http.StatusForbidden,
+		u.Path:                                  http.StatusForbidden,
+		"/blobs/static/logo.png?" + u.RawQuery:  http.StatusForbidden,
+		"/blobs/audio/anne/2.wav?" + u.RawQuery: http.StatusForbidden,
+	} {
+		rec := get(e, target)
+		if rec.Code != want {
+			t.Errorf("GET %s = %d %s, want %d", target, rec.Code, rec.Body, want)
+		}
+		if want == http.StatusOK && rec.Body.String() != "audio/anne/1.wav" {
+			t.Errorf("GET %s = %q", target, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"anne-hub/pkg/health"
+
+	"github.com/labstack/echo/v4"
+)
+
+// healthTimeout bounds each readiness check, orchestrators usually give up
+// on a probe after a few seconds.
+const healthTimeout = 2 * time.Second
+
+// LivezHandler reports that the process is up and serving requests. It
+// checks no dependencies, so an outage of the database does not get the
+// container restarted.
+func LivezHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, health.Run(c.Request().Context(), healthTimeout, nil))
+}
+
+// ReadyzHandler runs the readiness checks and answers 503 unless all
+// required components are ok.
+func ReadyzHandler(checks []health.Check) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		report := health.Run(ctx, healthTimeout, checks)
+
+		status := http.StatusOK
+		if !report.Ready() {
+			status = http.StatusServiceUnavailable
+			for name, component := range report.Components {
+				if component.Status != health.StatusOK {
+					logger.WarnContext(ctx, "readiness check failed", "component", name, "error", component.Error)
+				}
+			}
+		}
+		return c.JSON(status, report)
+	}
+}
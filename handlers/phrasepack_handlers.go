@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"anne-hub/pkg/blob"
+	"anne-hub/pkg/logging"
+	"anne-hub/pkg/phrasepack"
+	"anne-hub/repository"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// PhrasePackHandlers serves the offline phrase packs of the wearable.
+type PhrasePackHandlers struct {
+	store repository.Store
+	packs *phrasepack.Builder
+	// blobs holds the built packs
+	blobs blob.Store
+}
+
+func NewPhrasePackHandlers(store repository.Store, packs *phrasepack.Builder, blobs blob.Store) *PhrasePackHandlers {
+	return &PhrasePackHandlers{store: store, packs: packs, blobs: blobs}
+}
+
+// PhrasePackResponse is the manifest of a user's current pack and where to
+// download it.
+type PhrasePackResponse struct {
+	phrasepack.Manifest
+	URL string `json:"url"`
+}
+
+// GetPhrasePackHandler returns the manifest of a user's current phrase pack,
+// building the pack if its version changed. The version doubles as ETag, so
+// devices polling with If-None-Match get a 304 until the pack changes.
+func (h *PhrasePackHandlers) GetPhrasePackHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid user ID format.",
+		})
+	}
+
+	language := c.QueryParam("language")
+	if language == "" {
+		language = phrasepack.DefaultLanguage
+	}
+	if !phrasepack.Supported(language) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Unsupported language.",
+		})
+	}
+
+	user, err := h.store.Users().Get(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "User not found.",
+			})
+		}
+		logger.ErrorContext(ctx, "failed to retrieve user", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to retrieve user.",
+		})
+	}
+
+	tasks, err := h.store.Tasks().ListOpenByUser(ctx, userID)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to retrieve tasks", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to retrieve tasks.",
+		})
+	}
+
+	m := h.packs.Manifest(user, tasks, language)
+	etag := `"` + m.Version + `"`
+	if c.Request().Header.Get("If-None-Match") == etag {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	if _, err := h.packs.Build(ctx, m); err != nil {
+		logger.ErrorContext(ctx, "failed to build phrase pack", "user_id", logging.PII(userID.String()), "language", language, "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to build phrase pack.",
+		})
+	}
+
+	c.Response().Header().Set("ETag", etag)
+	return c.JSON(http.StatusOK, PhrasePackResponse{
+		Manifest: m,
+		URL:      "/phrasepacks/" + userID.String() + "/" + language + "/" + m.Version + ".zip",
+	})
+}
+
+// DownloadPhrasePackHandler serves a built pack as a zip. Versions replaced
+// by a newer pack are gone, so devices should fetch the manifest first.
+func (h *PhrasePackHandlers) DownloadPhrasePackHandler(c echo.Context) error {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid user ID format.",
+		})
+	}
+
+	version, ok := strings.CutSuffix(c.Param("file"), ".zip")
+	language := c.Param("language")
+	if !ok || version == "" || strings.ContainsAny(version, "/.") || !phrasepack.Supported(language) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "File not found.",
+		})
+	}
+
+	c.Response().Header().Set("ETag", `"`+version+`"`)
+	return serveBlob(c, h.blobs, phrasepack.Key(userID, language, version))
+}
@@ -2,8 +2,8 @@ package handlers
 
 import (
 	"anne-hub/models"
-	"anne-hub/pkg/db"
-	"database/sql"
+	"anne-hub/repository"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
@@ -12,8 +12,17 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
+// TaskHandlers serves the task routes.
+type TaskHandlers struct {
+	store repository.Store
+}
+
+func NewTaskHandlers(store repository.Store) *TaskHandlers {
+	return &TaskHandlers{store: store}
+}
+
 // GetAllTasks retrieves all tasks from the database with pagination and error handling
-func GetAllTasks(c echo.Context) error {
+func (h *TaskHandlers) GetAllTasks(c echo.Context) error {
 	// Pagination parameters
 	page, err := strconv.Atoi(c.QueryParam("page"))
 	if err != nil || page < 1 {
@@ -25,14 +34,7 @@ func GetAllTasks(c echo.Context) error {
 	}
 	offset := (page - 1) * limit
 
-	query := `
-		SELECT id, user_id, title, description, due_date, completed, created_at
-		FROM tasks 
-		ORDER BY created_at DESC 
-		LIMIT $1 OFFSET $2
-	`
-
-	rows, err := db.DB.Queryx(query, limit, offset)
+	tasks, err := h.store.Tasks().List(c.Request().Context(), limit, offset)
 	if err != nil {
 		// Log the error for internal monitoring
 		c.Logger().Errorf("Error querying tasks: %v", err)
@@ -40,45 +42,12 @@ func GetAllTasks(c echo.Context) error {
 			"error": "Failed to retrieve tasks.",
 		})
 	}
-	defer rows.Close()
-
-	tasks := []models.Task{}
-	for rows.Next() {
-		task := models.Task{}
-
-		err := rows.Scan(
-			&task.ID,
-			&task.UserID,
-			&task.Title,
-			&task.Description,
-			&task.DueDate,
-			&task.Completed,
-			&task.CreatedAt,
-		)
-		if err != nil {
-			c.Logger().Errorf("Error scanning task: %v", err)
-			return c.JSON(http.StatusInternalServerError, map[string]string{
-				"error": "Failed to process tasks.",
-			})
-		}
-
-
-		tasks = append(tasks, task)
-	}
-
-	// Check for errors from iterating over rows
-	if err = rows.Err(); err != nil {
-		c.Logger().Errorf("Row iteration error: %v", err)
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Error retrieving tasks.",
-		})
-	}
 
 	return c.JSON(http.StatusOK, tasks)
 }
 
 // GetTaskByID retrieves a single task by its ID with error handling
-func GetTaskByID(c echo.Context) error {
+func (h *TaskHandlers) GetTaskByID(c echo.Context) error {
 	idParam := c.Param("id")
 	id, err := strconv.ParseInt(idParam, 10, 64)
 	if err != nil || id < 1 {
@@ -87,25 +56,9 @@ func GetTaskByID(c echo.Context) error {
 		})
 	}
 
-	var task models.Task
-
-	query := `
-		SELECT id, user_id, title, description, due_date, completed, created_at
-		FROM tasks 
-		WHERE id = $1
-	`
-
-	err = db.DB.QueryRowx(query, id).Scan(
-		&task.ID,
-		&task.UserID,
-		&task.Title,
-		&task.Description,
-		&task.DueDate,
-		&task.Completed,
-		&task.CreatedAt,
-	)
+	task, err := h.store.Tasks().Get(c.Request().Context(), id)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, repository.ErrNotFound) {
 			return c.JSON(http.StatusNotFound, map[string]string{
 				"error": "Task not found.",
 			})
@@ -119,85 +72,62 @@ func GetTaskByID(c echo.Context) error {
 	return c.JSON(http.StatusOK, task)
 }
 
-func GetAllTasksByUserID(c echo.Context) error {
-    userIDParam := c.Param("id")
-    userID, err := uuid.Parse(userIDParam)
-    if err != nil {
-        return c.JSON(http.StatusBadRequest, map[string]string{
-            "error": "Invalid user ID format",
-        })
-    }
-
-    query := `
-        SELECT id, user_id, title, description, due_date, completed, created_at
-        FROM tasks 
-        WHERE user_id = $1
-        ORDER BY created_at DESC 
-    `
+func (h *TaskHandlers) GetAllTasksByUserID(c echo.Context) error {
+	userIDParam := c.Param("id")
+	userID, err := uuid.Parse(userIDParam)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid user ID format",
+		})
+	}
 
-    tasks := []models.Task{}
-    err = db.DB.Select(&tasks, query, userID)
-    if err != nil {
-        c.Logger().Errorf("Error querying tasks: %v", err)
-        return c.JSON(http.StatusInternalServerError, map[string]string{
-            "error": "Failed to retrieve tasks",
-        })
-    }
-    return c.JSON(http.StatusOK, tasks)
+	tasks, err := h.store.Tasks().ListByUser(c.Request().Context(), userID)
+	if err != nil {
+		c.Logger().Errorf("Error querying tasks: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to retrieve tasks",
+		})
+	}
+	return c.JSON(http.StatusOK, tasks)
 }
 
 // CreateTaskHandler creates a new task with validation and error handling
-func CreateTaskHandler(c echo.Context) error {
-    task := new(models.Task)
-    if err := c.Bind(task); err != nil {
-        c.Logger().Warnf("Bind error: %v", err)
-        return c.JSON(http.StatusBadRequest, map[string]string{
-            "error": "Invalid request payload.",
-        })
-    }
+func (h *TaskHandlers) CreateTaskHandler(c echo.Context) error {
+	task := new(models.Task)
+	if err := c.Bind(task); err != nil {
+		c.Logger().Warnf("Bind error: %v", err)
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request payload.",
+		})
+	}
 
 	// Set default values if necessary
 	if task.CreatedAt.IsZero() {
 		task.CreatedAt = time.Now()
 	}
-    if task.UserID == uuid.Nil {
-        return c.JSON(http.StatusBadRequest, map[string]string{
-            "error": "UserID is required.",
-        })
-    }
-
-    query := `
-        INSERT INTO tasks (
-            user_id,
-            title,
-            description,
-            due_date,
-            completed,
-            created_at
-        ) VALUES ($1, $2, $3, $4, $5, $6)
-        RETURNING id
-    `
+	if task.UserID == uuid.Nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "UserID is required.",
+		})
+	}
 
-    err := db.DB.QueryRow(query,
-        task.UserID,
-        task.Title,
-        task.Description,
-        task.DueDate,
-        task.Completed,
-        task.CreatedAt,
-    ).Scan(&task.ID)
-    if err != nil {
-        c.Logger().Errorf("Error inserting task: %v", err)
-        return c.JSON(http.StatusInternalServerError, map[string]string{
-            "error": "Failed to create task.",
-        })
-    }
+	if err := h.store.Tasks().Create(c.Request().Context(), task); err != nil {
+		if errors.Is(err, repository.ErrInvalidReference) {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "User does not exist.",
+			})
+		}
+		c.Logger().Errorf("Error inserting task: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to create task.",
+		})
+	}
 
-    return c.JSON(http.StatusCreated, task)
+	return c.JSON(http.StatusCreated, task)
 }
 
 // UpdateTaskHandler updates an existing task by its ID with validation and error handling
-func UpdateTaskHandler(c echo.Context) error {
+func (h *TaskHandlers) UpdateTaskHandler(c echo.Context) error {
 	idParam := c.Param("id")
 	id, err := strconv.ParseInt(idParam, 10, 64)
 	if err != nil || id < 1 {
@@ -221,58 +151,33 @@ func UpdateTaskHandler(c echo.Context) error {
 		})
 	}
 
-	query := `
-		UPDATE tasks SET
-			user_id = $1,
-			title = $2,
-			description = $3,
-			due_date = $4,
-			completed = $5,
-		WHERE id = $7
-	`
-
-	res, err := db.DB.Exec(query,
-		task.UserID,
-		task.Title,
-		task.Description,
-		task.DueDate,
-		task.Completed,
-		id,
-	)
+	// Return the task as stored, read in the same transaction
+	ctx := c.Request().Context()
+	var updatedTask models.Task
+	err = h.store.InTx(ctx, func(tx repository.Store) error {
+		if err := tx.Tasks().Update(ctx, id, task); err != nil {
+			return err
+		}
+		updatedTask, err = tx.Tasks().Get(ctx, id)
+		return err
+	})
 	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "Task not found.",
+			})
+		}
 		c.Logger().Errorf("Error updating task: %v", err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to update task.",
 		})
 	}
 
-	rowsAffected, err := res.RowsAffected()
-	if err != nil {
-		c.Logger().Errorf("Error fetching update result: %v", err)
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to process update.",
-		})
-	}
-	if rowsAffected == 0 {
-		return c.JSON(http.StatusNotFound, map[string]string{
-			"error": "Task not found.",
-		})
-	}
-
-	// Fetch the updated task to return
-	updatedTask, err := fetchTaskByID(id)
-	if err != nil {
-		c.Logger().Errorf("Error fetching updated task: %v", err)
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Task updated but failed to retrieve.",
-		})
-	}
-
 	return c.JSON(http.StatusOK, updatedTask)
 }
 
 // DeleteTaskHandler deletes a task by its ID with comprehensive error handling
-func DeleteTaskHandler(c echo.Context) error {
+func (h *TaskHandlers) DeleteTaskHandler(c echo.Context) error {
 	idParam := c.Param("id")
 	id, err := strconv.ParseInt(idParam, 10, 64)
 	if err != nil || id < 1 {
@@ -281,58 +186,21 @@ func DeleteTaskHandler(c echo.Context) error {
 		})
 	}
 
-	query := `DELETE FROM tasks WHERE id = $1`
-
-	res, err := db.DB.Exec(query, id)
-	if err != nil {
+	if err := h.store.Tasks().Delete(c.Request().Context(), id); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "Task not found.",
+			})
+		}
 		c.Logger().Errorf("Error deleting task: %v", err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to delete task.",
 		})
 	}
 
-	rowsAffected, err := res.RowsAffected()
-	if err != nil {
-		c.Logger().Errorf("Error fetching delete result: %v", err)
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to process deletion.",
-		})
-	}
-	if rowsAffected == 0 {
-		return c.JSON(http.StatusNotFound, map[string]string{
-			"error": "Task not found.",
-		})
-	}
-
 	return c.NoContent(http.StatusNoContent)
 }
 
-// fetchTaskByID is a helper function to retrieve a task after update
-func fetchTaskByID(id int64) (*models.Task, error) {
-	var task models.Task
-
-	query := `
-		SELECT id, user_id, title, description, due_date, completed, created_at
-		FROM tasks 
-		WHERE id = $1
-	`
-
-	err := db.DB.QueryRowx(query, id).Scan(
-		&task.ID,
-		&task.UserID,
-		&task.Title,
-		&task.Description,
-		&task.DueDate,
-		&task.Completed,
-		&task.CreatedAt,
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	return &task, nil
-}
-
 // validateTaskInput performs basic validation on the Task input
 func validateTaskInput(task *models.Task) error {
 	if len(task.Title) == 0 {
@@ -1,52 +1,58 @@
 package handlers
 
 import (
-	"anne-hub/pkg/groq"
+	"anne-hub/pkg/logging"
 	"anne-hub/pkg/pcm"
 	"io"
-	"log"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
 )
 
-func TranscribeAudio(c echo.Context) error {
-	log.Println("TranscribeAudio handler called")
+func (h *ConversationHandlers) TranscribeAudio(c echo.Context) error {
+    ctx := c.Request().Context()
+
     // Read the PCM data from the request body
     pcmData, err := io.ReadAll(c.Request().Body)
     if err != nil {
-        log.Println("Failed to read request body:", err)
+        logger.WarnContext(ctx, "failed to read request body", "error", err)
         return c.JSON(http.StatusBadRequest, map[string]string{
             "error": "Failed to read request body",
         })
     }
     defer c.Request().Body.Close()
-    log.Println("Received audio data of size:", len(pcmData))
+    logger.DebugContext(ctx, "received audio", "bytes", len(pcmData))
+
+    format := pcm.M5Format
+    if spec := c.Request().Header.Get("X-Audio-Format"); spec != "" {
+        format, err = pcm.ParseFormat(spec)
+        if err != nil {
+            logger.WarnContext(ctx, "invalid X-Audio-Format header", "error", err)
+            return c.JSON(http.StatusBadRequest, map[string]string{
+                "error": "Invalid X-Audio-Format header: " + err.Error(),
+            })
+        }
+    }
 
     // Convert PCM to WAV
-    wavData, err := pcm.ToWAV(pcmData)
+    wavData, err := processPCMData(ctx, pcmData, format)
     if err != nil {
-        log.Println("Failed to convert PCM to WAV:", err)
         return c.JSON(http.StatusInternalServerError, map[string]string{
             "error": "Failed to convert PCM to WAV",
         })
     }
-    log.Println("PCM data converted to WAV format")
 
-    // Send the WAV data to Groq API
-    transcription, err := groq.GenerateWhisperTranscription(wavData, "en")
+    // Send the WAV data to the speech-to-text chain
+    transcription, _, err := h.deps.Pipeline.Transcribe(ctx, wavData, "en")
     if err != nil {
-        log.Println("Failed to get transcription:", err)
+        logger.ErrorContext(ctx, "transcription failed", "error", err)
         return c.JSON(http.StatusInternalServerError, map[string]string{
             "error": "Failed to get transcription: " + err.Error(),
         })
     }
-    log.Println("Received transcription from Groq API")
-
-	log.Println("Transcription:", transcription)
+    logger.InfoContext(ctx, "transcription received", "transcript", logging.Transcript(transcription))
 
     // Return the transcription
-    log.Println("Transcription sent to client")
     return c.JSON(http.StatusOK, map[string]string{
         "transcription": transcription,
     })
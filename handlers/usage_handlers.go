@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"anne-hub/models"
+	"anne-hub/repository"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// usageDays is how far back usage is summarized without a from date.
+const usageDays = 30
+
+// UsageHandlers serves the usage and estimated costs of the turns.
+type UsageHandlers struct {
+	store repository.Store
+}
+
+func NewUsageHandlers(store repository.Store) *UsageHandlers {
+	return &UsageHandlers{store: store}
+}
+
+// UsageResponse is the usage of a date range, by day, user, device or
+// provider, with its total.
+type UsageResponse struct {
+	By    string                `json:"by"`
+	From  string                `json:"from"`
+	To    string                `json:"to"`
+	Total models.UsageSummary   `json:"total"`
+	Rows  []models.UsageSummary `json:"rows"`
+}
+
+// GetUsageHandler summarizes the usage records of the UTC days from..to,
+// both inclusive, optionally of one user or device.
+func (h *UsageHandlers) GetUsageHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	q := repository.UsageQuery{GroupBy: c.QueryParam("by"), DeviceID: c.QueryParam("device_id")}
+	switch q.GroupBy {
+	case "":
+		q.GroupBy = repository.ByDay
+	case repository.ByDay, repository.ByUser, repository.ByDevice, repository.ByProvider:
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "by must be day, user, device or provider.",
+		})
+	}
+
+	if s := c.QueryParam("user_id"); s != "" {
+		userID, err := uuid.Parse(s)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid user ID format.",
+			})
+		}
+		q.UserID = userID
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	to, err := parseDay(c.QueryParam("to"), today)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid to date, expected YYYY-MM-DD.",
+		})
+	}
+	from, err := parseDay(c.QueryParam("from"), to.AddDate(0, 0, 1-usageDays))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid from date, expected YYYY-MM-DD.",
+		})
+	}
+	if from.After(to) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "from must not be after to.",
+		})
+	}
+	q.From, q.To = from, to.AddDate(0, 0, 1)
+
+	rows, err := h.store.UsageRecords().Summarize(ctx, q)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to summarize usage", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to retrieve usage.",
+		})
+	}
+
+	total, err := h.store.UsageRecords().Total(ctx, q)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to total usage", "error", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to retrieve usage.",
+		})
+	}
+
+	return c.JSON(http.StatusOK, UsageResponse{
+		By:    q.GroupBy,
+		From:  from.Format(time.DateOnly),
+		To:    to.Format(time.DateOnly),
+		Total: total,
+		Rows:  rows,
+	})
+}
+
+// parseDay parses a YYYY-MM-DD date as UTC, returning def for an empty
+// string.
+func parseDay(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
@@ -0,0 +1,96 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"anne-hub/handlers"
+	"anne-hub/models"
+	"anne-hub/pkg/config"
+	"anne-hub/repository"
+
+	"github.com/labstack/echo/v4"
+)
+
+// createUsage stores the records of two turns of the user, the first of
+// which fell back to Google for its speech.
+func createUsage(t *testing.T, store repository.Store, user models.User) {
+	t.Helper()
+	now := time.Now().UTC()
+	records := []models.UsageRecord{
+		{TurnID: "turn-1", Stage: "stt", Provider: "groq", AudioMS: 2000, CostUSD: 0.001},
+		{TurnID: "turn-1", Stage: "llm", Provider: "groq", PromptTokens: 100, CompletionTokens: 20, CostUSD: 0.002},
+		{TurnID: "turn-1", Stage: "tts", Provider: "google", Characters: 40, AudioMS: 3000, CostUSD: 0.003},
+		{TurnID: "turn-2", Stage: "stt", Provider: "groq", AudioMS: 1000, CostUSD: 0.001},
+	}
+	for i := range records {
+		records[i].CreatedAt, records[i].UserID, records[i].DeviceID = now, user.ID, "7"
+	}
+	if err := store.UsageRecords().Create(context.Background(), records); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// getUsage calls the usage handler with a query string.
+func getUsage(t *testing.T, store repository.Store, query string) handlers.UsageResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/usage?"+query, nil)
+	rec := httptest.NewRecorder()
+	if err := handlers.NewUsageHandlers(store).GetUsageHandler(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	var resp handlers.UsageResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("response %q: %v", rec.Body.String(), err)
+	}
+	return resp
+}
+
+func TestGetUsageHandlerTotals(t *testing.T) {
+	cfg := config.Defaults()
+	_, store := newTestHandlers(t, &cfg)
+	user := createUser(t, store)
+	createUsage(t, store, user)
+
+	resp := getUsage(t, store, "by=provider&user_id="+user.ID.String())
+
+	turns := map[string]int64{}
+	for _, row := range resp.Rows {
+		turns[row.Key] = row.Turns
+	}
+	if turns["groq"] != 2 || turns["google"] != 1 {
+		t.Errorf("turns by provider = %v, want groq 2 and google 1", turns)
+	}
+	total := resp.Total
+	if total.Turns != 2 {
+		t.Errorf("total turns = %d, want 2, the turn on both providers counted once", total.Turns)
+	}
+	if total.PromptTokens != 100 || total.Characters != 40 || total.STTSeconds != 3 || total.TTSSeconds != 3 {
+		t.Errorf("total = %+v", total)
+	}
+	if total.CostUSD < 0.00699 || total.CostUSD > 0.00701 {
+		t.Errorf("total cost = %f, want 0.007", total.CostUSD)
+	}
+}
+
+func TestUsageOutlivesUser(t *testing.T) {
+	cfg := config.Defaults()
+	_, store := newTestHandlers(t, &cfg)
+	user := createUser(t, store)
+	createUsage(t, store, user)
+
+	if err := store.Users().Delete(context.Background(), user.ID); err != nil {
+		t.Fatal(err)
+	}
+	resp := getUsage(t, store, "by=user")
+	if len(resp.Rows) != 1 || resp.Rows[0].Key != "" || resp.Rows[0].Turns != 2 {
+		t.Errorf("rows = %+v, want the deleted user's turns without a user id", resp.Rows)
+	}
+	if resp.Total.Turns != 2 {
+		t.Errorf("total turns = %d, want 2", resp.Total.Turns)
+	}
+}
@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"anne-hub/models"
-	"anne-hub/pkg/db"
-	"database/sql"
+	"anne-hub/pkg/audiostore"
+	"anne-hub/pkg/phrasepack"
+	"anne-hub/repository"
+	"errors"
 	"net/http"
 
 	"anne-hub/services"
@@ -12,65 +14,48 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
-// GetAllUsersHandler retrieves all users along with their interests from the database
-func GetAllUsersHandler(c echo.Context) error {
-    var users []models.UserData
-
-    query := "SELECT id, username, email, password_hash, created_at, age, country, city, first_name, last_name FROM users"
-
-    rows, err := db.DB.Query(query)
-    if err != nil {
-        return c.JSON(http.StatusInternalServerError, map[string]string{
-            "error": "Failed to fetch users: " + err.Error(),
-        })
-    }
-    defer rows.Close()
-
-    for rows.Next() {
-        var user models.User
-
-        err := rows.Scan(
-            &user.ID,
-            &user.Username,
-            &user.Email,
-            &user.PasswordHash,
-            &user.CreatedAt,
-            &user.Age,
-            &user.Country,
-            &user.City,
-            &user.FirstName,
-            &user.LastName,
-        )
-        if err != nil {
-            return c.JSON(http.StatusInternalServerError, map[string]string{
-                "error": "Failed to parse user data: " + err.Error(),
-            })
-        }
-
-        // Fetch interests for the current user
-        userData, err := services.FetchUserData(user.ID)
-        if err != nil {
-            // Log the error and skip adding interests
-            // Alternatively, you can return an error response
-            // depending on your application's requirements
-            continue
-        }
-
-        users = append(users, userData)
-    }
-
-    if err = rows.Err(); err != nil {
-        return c.JSON(http.StatusInternalServerError, map[string]string{
-            "error": "Error iterating over users: " + err.Error(),
-        })
-    }
-
-    return c.JSON(http.StatusOK, users)
+// UserHandlers serves the user routes.
+type UserHandlers struct {
+	store repository.Store
+	// Archived audio and phrase packs are deleted with their user
+	audio *audiostore.Store
+	packs *phrasepack.Builder
+}
+
+func NewUserHandlers(store repository.Store, audio *audiostore.Store, packs *phrasepack.Builder) *UserHandlers {
+	return &UserHandlers{store: store, audio: audio, packs: packs}
 }
 
+// GetAllUsersHandler retrieves all users along with their interests from the database
+func (h *UserHandlers) GetAllUsersHandler(c echo.Context) error {
+	var users []models.UserData
+
+	ctx := c.Request().Context()
+	list, err := h.store.Users().List(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to fetch users: " + err.Error(),
+		})
+	}
+
+	for _, user := range list {
+		// Fetch interests for the current user
+		userData, err := services.FetchUserData(ctx, h.store, user.ID)
+		if err != nil {
+			// Log the error and skip adding interests
+			// Alternatively, you can return an error response
+			// depending on your application's requirements
+			continue
+		}
+
+		users = append(users, userData)
+	}
+
+	return c.JSON(http.StatusOK, users)
+}
 
 // get user by id parameter
-func GetUserHandler(c echo.Context) error {
+func (h *UserHandlers) GetUserHandler(c echo.Context) error {
 	idParam := c.Param("id") // UUID from the URL path parameter
 
 	// Validate UUID format
@@ -81,23 +66,9 @@ func GetUserHandler(c echo.Context) error {
 		})
 	}
 
-	var user models.User
-
-	query := `
-		SELECT id, username, email, password_hash, created_at, age
-		FROM users
-		WHERE id = $1
-	`
-	err = db.DB.QueryRow(query, userID).Scan(
-		&user.ID,
-		&user.Username,
-		&user.Email,
-		&user.PasswordHash,
-		&user.CreatedAt,
-		&user.Age,
-	)
+	user, err := h.store.Users().Get(c.Request().Context(), userID)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, repository.ErrNotFound) {
 			return c.JSON(http.StatusNotFound, map[string]string{
 				"error": "User not found.",
 			})
@@ -107,101 +78,71 @@ func GetUserHandler(c echo.Context) error {
 		})
 	}
 
-
 	return c.JSON(http.StatusOK, user)
 }
 
-
 // CreateUserHandler creates a new user in the database
-func CreateUserHandler(c echo.Context) error {
-    user := new(models.User)
-
-    if err := c.Bind(user); err != nil {
-        return c.JSON(http.StatusBadRequest, map[string]string{
-            "error": "Invalid request body: " + err.Error(),
-        })
-    }
-
-    // Validate required fields
-    if user.Username == "" || user.Email == "" || user.PasswordHash == "" {
-        return c.JSON(http.StatusBadRequest, map[string]string{
-            "error": "Username, email, and password_hash are required fields.",
-        })
-    }
-
-    query := `
-        INSERT INTO users (id, username, email, password_hash, age, interests)
-        VALUES (gen_random_uuid(), $1, $2, $3, $4, $5)
-        RETURNING id, created_at
-    `
-    err := db.DB.QueryRow(query,
-        user.Username,
-        user.Email,
-        user.PasswordHash,
-        user.Age, 
-    ).Scan(&user.ID, &user.CreatedAt)
-    if err != nil {
-        return c.JSON(http.StatusInternalServerError, map[string]string{
-            "error": "Failed to create user: " + err.Error(),
-        })
-    }
-
-    return c.JSON(http.StatusCreated, user)
-}
+func (h *UserHandlers) CreateUserHandler(c echo.Context) error {
+	user := new(models.User)
 
+	if err := c.Bind(user); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body: " + err.Error(),
+		})
+	}
 
+	// Validate required fields
+	if user.Username == "" || user.Email == "" || user.PasswordHash == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Username, email, and password_hash are required fields.",
+		})
+	}
 
-// UpdateUserHandler updates an existing user in the database
-func UpdateUserHandler(c echo.Context) error {
-    idParam := c.Param("id") // UUID from the URL path parameter
-
-    // Validate UUID format
-    userID, err := uuid.Parse(idParam)
-    if err != nil {
-        return c.JSON(http.StatusBadRequest, map[string]string{
-            "error": "Invalid user ID format.",
-        })
-    }
-
-    user := new(models.User)
-
-    if err := c.Bind(user); err != nil {
-        return c.JSON(http.StatusBadRequest, map[string]string{
-            "error": "Invalid request body: " + err.Error(),
-        })
-    }
-
-
-    query := `
-        UPDATE users
-        SET username = $1, email = $2, password_hash = $3, age = $4
-        WHERE id = $6
-        RETURNING id, created_at
-    `
-    err = db.DB.QueryRow(query,
-        user.Username,
-        user.Email,
-        user.PasswordHash,
-        user.Age,
-        userID,
-    ).Scan(&user.ID, &user.CreatedAt)
-    if err != nil {
-        if err == sql.ErrNoRows {
-            return c.JSON(http.StatusNotFound, map[string]string{
-                "error": "User not found.",
-            })
-        }
-        return c.JSON(http.StatusInternalServerError, map[string]string{
-            "error": "Failed to update user: " + err.Error(),
-        })
-    }
-
-    return c.JSON(http.StatusOK, user)
+	if err := h.store.Users().Create(c.Request().Context(), user); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to create user: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusCreated, user)
 }
 
+// UpdateUserHandler updates an existing user in the database
+func (h *UserHandlers) UpdateUserHandler(c echo.Context) error {
+	idParam := c.Param("id") // UUID from the URL path parameter
+
+	// Validate UUID format
+	userID, err := uuid.Parse(idParam)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid user ID format.",
+		})
+	}
+
+	user := new(models.User)
+
+	if err := c.Bind(user); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body: " + err.Error(),
+		})
+	}
+
+	if err := h.store.Users().Update(c.Request().Context(), userID, user); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "User not found.",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to update user: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, user)
+}
 
 // delete user endpoint
-func DeleteUserHandler(c echo.Context) error {
+func (h *UserHandlers) DeleteUserHandler(c echo.Context) error {
 	idParam := c.Param("id") // UUID from the URL path parameter
 
 	// Validate UUID format
@@ -212,13 +153,8 @@ func DeleteUserHandler(c echo.Context) error {
 		})
 	}
 
-	query := `
-		DELETE FROM users
-		WHERE id = $1
-	`
-	_, err = db.DB.Exec(query, userID)
-	if err != nil {
-		if err == sql.ErrNoRows {
+	if err := h.store.Users().Delete(c.Request().Context(), userID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
 			return c.JSON(http.StatusNotFound, map[string]string{
 				"error": "User not found.",
 			})
@@ -228,9 +164,20 @@ func DeleteUserHandler(c echo.Context) error {
 		})
 	}
 
+	// Archived recordings are not covered by the database cascade
+	if err := h.audio.DeleteUser(c.Request().Context(), userID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "User deleted but failed to delete their audio: " + err.Error(),
+		})
+	}
+
+	if err := h.packs.DeleteUser(c.Request().Context(), userID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "User deleted but failed to delete their phrase packs: " + err.Error(),
+		})
+	}
+
 	return c.JSON(http.StatusOK, map[string]string{
 		"message": "User deleted successfully.",
 	})
 }
-
-
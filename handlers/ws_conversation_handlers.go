@@ -3,48 +3,41 @@ package handlers
 
 import (
 	"anne-hub/models"
-	"anne-hub/pkg/groq"
+	"anne-hub/pkg/accounting"
+	"anne-hub/pkg/audiofilters"
+	"anne-hub/pkg/audiostore"
+	"anne-hub/pkg/codec"
+	"anne-hub/pkg/emotion"
+	"anne-hub/pkg/logging"
+	"anne-hub/pkg/metrics"
 	"anne-hub/pkg/pcm"
 	"anne-hub/pkg/systemprompt"
-	"anne-hub/pkg/tts"
+	"anne-hub/pkg/tracing"
+	"anne-hub/repository"
 	"anne-hub/services"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"math/rand"
 	"net/http"
-	"os"
-	"os/exec"
-	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var lastEmotionSent string = "suspicious"
-var emotionChanged bool = false
-
-var allowedEmotions = map[string]bool{
-	"celebration": true,
-	"suspicious":  true,
-	"cute_smile":  true,
-	"curiosity":   true,
-	"confused":    true,
-	"sleep":       true,
-	"lucky_smile": true,
-	"surprised":   true,
-}
-
 var wsUpgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
-func playAudio(filePath string) error {
-	cmd := exec.Command("afplay", filePath)
-	return cmd.Run()
-}
+// responseAudioURLTTL is how long the device can fetch a reply's audio for.
+var responseAudioURLTTL = 10 * time.Minute
 
 type TaskCompletion struct {
 	Task      string `json:"task,omitempty"`
@@ -57,258 +50,694 @@ type LLMResponseJSONfromPrompt struct {
 	TaskCompletion TaskCompletion `json:"task_completion"`
 }
 
-var assistantResponseJSON string
+// voicePersona selects the audiofilters preset applied to Anne's replies.
+var voicePersona = audiofilters.DefaultPersona
+
+// wsSession is the state of one device connection.
+type wsSession struct {
+	h          *ConversationHandlers
+	conn       *websocket.Conn
+	headers    models.WSRequestHeaders
+	decoder    codec.Decoder
+	audioCodec string
+	pcmData    []byte
+	// receivedBytes counts the turn's audio as sent, before decoding
+	receivedBytes int
+	// tooLong is set once the turn exceeded the longest audio it may have
+	// and the rest of its audio is dropped
+	tooLong bool
+	// emotions tracks what the device shows, from the catalog of its firmware
+	emotions *emotion.Machine
+
+	// mu serializes the frames and emotions sent by the session and its
+	// running turn
+	mu sync.Mutex
+	// turn is the running turn, nil between turns
+	turn *wsTurn
+}
+
+// wsTurn is a turn running alongside the session, so that the device can
+// interrupt it.
+type wsTurn struct {
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+	// replied is set once the reply was sent, with the user it was for, so
+	// that the device can still interrupt its playback
+	replied bool
+	userID  uuid.UUID
+}
+
+// turnAudio is the audio of a turn, taken from the session at EOS.
+type turnAudio struct {
+	pcm []byte
+	// receivedBytes counts the audio as sent, before decoding
+	receivedBytes int
+	tooLong       bool
+}
+
+// errInterrupted cancels the turn the device interrupted, by CANCEL or by
+// starting the next turn.
+var errInterrupted = errors.New("turn interrupted")
+
+func (h *ConversationHandlers) WebSocketConversationHandler(c echo.Context) error {
+	ctx := logging.With(c.Request().Context(), "session_id", uuid.New().String())
 
-func WebSocketConversationHandler(c echo.Context) error {
 	conn, err := wsUpgrader.Upgrade(c.Response(), c.Request(), nil)
 	if err != nil {
-		log.Println("WebSocket upgrade error:", err)
+		logger.WarnContext(ctx, "websocket upgrade failed", "error", err)
 		return err
 	}
 	defer conn.Close()
+	logger.InfoContext(ctx, "websocket session started")
+
+	metrics.ActiveWSSessions.Inc()
+	defer metrics.ActiveWSSessions.Dec()
 
-	var headers models.WSRequestHeaders
-	var pcmData []byte
+	s := &wsSession{h: h, conn: conn}
 	headersReceived := false
 
-	for {
-		messageType, message, err := conn.ReadMessage()
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("Unexpected WebSocket error: %v", err)
-			} else {
-				log.Printf("WebSocket closed: %v", err)
-			}
-			break
-		}
+	// Messages are read while a turn is running, so that the turn's provider
+	// calls are cancelled as soon as the device disconnects or interrupts it
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	messages := make(chan wsMessage)
+	go s.read(ctx, cancel, messages)
+	defer s.interrupt(ctx, "")
 
+	for m := range messages {
+		messageType, message := m.kind, m.data
 		switch messageType {
 		case websocket.TextMessage:
 			msg := string(message)
-			log.Printf("Received text message: %s", msg)
 
 			if msg == "PING" {
-				conn.WriteMessage(websocket.TextMessage, []byte("PONG"))
+				s.write(ctx, []byte("PONG"))
 				break
 			}
 
 			if !headersReceived {
-				err := json.Unmarshal(message, &headers)
+				err := json.Unmarshal(message, &s.headers)
 				if err != nil {
-					log.Printf("Error parsing headers JSON: %v", err)
-					conn.WriteMessage(websocket.TextMessage, []byte("Invalid headers format."))
+					logger.WarnContext(ctx, "invalid headers message", "error", err)
+					s.sendError(ctx, models.ErrorBadRequest, "Invalid headers format.")
 					continue
 				}
 
-				log.Printf("Custom Headers Received - User ID: %s, Device ID: %s, Language: %s",
-					headers.XUserID, headers.XDeviceID, headers.XLanguage)
-
-				headersReceived = true
-				conn.WriteMessage(websocket.TextMessage, []byte("Headers received successfully."))
-				emotionChanged = false
-				continue
-			}
+				ctx = logging.With(ctx, "user_id", logging.PII(s.headers.XUserID), "device_id", logging.PII(s.headers.XDeviceID))
+				logger.InfoContext(ctx, "headers received", "language", s.headers.XLanguage)
 
-			if msg == "EOS" {
-				currentConversation, err := services.HandleProcessConversationInput(pcmData, headers)
-				if err != nil {
-					log.Printf("Error processing conversation: %v", err)
-					conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("Processing error: %s", err.Error())))
-					break
+				if code, err := s.authorize(ctx); err != nil {
+					logger.WarnContext(ctx, "device not authorized", "error", err)
+					s.sendError(ctx, code, err.Error())
+					s.headers = models.WSRequestHeaders{}
+					continue
 				}
 
-				wavData, err := processPCMData(currentConversation.RequestPCM)
-				if err != nil {
-					return err
+				format := pcm.M5Format
+				if s.headers.XAudioFormat != nil {
+					format = s.headers.XAudioFormat.WithDefaults()
+					if err := format.Validate(); err != nil {
+						logger.WarnContext(ctx, "invalid audio format in headers", "error", err)
+						s.sendError(ctx, models.ErrorBadRequest, "Invalid audio format: "+err.Error())
+						s.headers = models.WSRequestHeaders{}
+						continue
+					}
 				}
 
-				outFile, err := os.Create("m5audio.wav")
+				s.audioCodec = codec.Negotiate(s.headers.XAudioCodecs)
+				s.decoder, err = codec.NewDecoder(s.audioCodec, format, s.headers.XAudioBlockSize)
 				if err != nil {
-					return fmt.Errorf("failed to create output file: %w", err)
+					logger.WarnContext(ctx, "failed to create decoder", "codec", s.audioCodec, "error", err)
+					s.sendError(ctx, models.ErrorBadRequest, "Unsupported audio stream: "+err.Error())
+					s.headers = models.WSRequestHeaders{}
+					continue
 				}
+				logger.InfoContext(ctx, "audio stream negotiated", "format", format.String(), "codec", s.audioCodec)
 
-				_, err = outFile.Write(wavData)
-				if err != nil {
-					return fmt.Errorf("failed to write audio content to file: %w", err)
+				s.emotions = s.h.deps.Emotions.Machine(s.headers.XFirmwareVersion)
+				logger.InfoContext(ctx, "emotion catalog selected", "firmware", s.headers.XFirmwareVersion, "catalog", s.emotions.Catalog().Version)
+
+				headersReceived = true
+				s.write(ctx, []byte("Headers received successfully."))
+				if len(s.headers.XAudioCodecs) > 0 {
+					codecJSON, _ := json.Marshal(map[string]string{"type": "codec", "codec": s.audioCodec})
+					s.write(ctx, codecJSON)
 				}
+				s.showState(ctx, emotion.Idle)
+				continue
+			}
 
-				defer outFile.Close()
+			switch msg {
+			case "EOS":
+				// Speaking again before the answer interrupts it
+				s.interrupt(ctx, "eos")
+				audio := turnAudio{pcm: s.pcmData, receivedBytes: s.receivedBytes, tooLong: s.tooLong}
+				s.pcmData, s.receivedBytes, s.tooLong = nil, 0, false
+				s.startTurn(ctx, audio)
+			case "CANCEL":
+				interrupted := s.interrupt(ctx, "cancel")
+				logger.InfoContext(ctx, "turn cancelled by device", "interrupted", interrupted)
+				cancelledJSON, _ := json.Marshal(map[string]any{"type": "cancelled", "interrupted": interrupted})
+				s.write(ctx, cancelledJSON)
+				s.showState(ctx, emotion.Listening)
+			}
 
-				transcription, err := groq.GenerateWhisperTranscription(wavData, currentConversation.Language)
-				if err != nil {
-					log.Printf("Failed to get transcription: %v\n", err)
-					return c.JSON(http.StatusInternalServerError, map[string]string{
-						"error": "Failed to get transcription: " + err.Error(),
-					})
-				}
+		case websocket.BinaryMessage:
+			if !headersReceived {
+				logger.WarnContext(ctx, "binary data before headers, ignoring")
+				s.sendError(ctx, models.ErrorBadRequest, "Headers must be sent before PCM data.")
+				continue
+			}
+			if s.tooLong {
+				continue
+			}
 
-				transcription += "<for assistant: you must return as json as instructed in system prompt format: {\"message\": \"<your message>\", \"emotion\": \"<emotion>\", \"task_completion\": {\"task:\": \"<task_id>\", \"completed\": \"<value>\"}}"
-				transcription += ", if there was no task mentioned, add an empty task_completion object>"
+			decoded, err := s.decoder.Decode(message)
+			if err != nil {
+				logger.WarnContext(ctx, "failed to decode audio frame", "codec", s.audioCodec, "error", err)
+				s.sendError(ctx, models.ErrorBadRequest, "Audio decoding error.")
+				continue
+			}
 
-                log.Print("/----------------------------------------------------------------/")
-				log.Printf("Transcription received: %s\n", transcription)
-                log.Print("/----------------------------------------------------------------/")
+			logger.DebugContext(ctx, "audio frame", "codec", s.audioCodec, "bytes", len(message), "pcm_bytes", len(decoded))
+			if s.receivedBytes == 0 && !s.running() {
+				s.showState(ctx, emotion.Listening)
+			}
+			s.pcmData = append(s.pcmData, decoded...)
+			s.receivedBytes += len(message)
+			if s.decoder.Format().Duration(len(s.pcmData)) > s.h.maxTurn {
+				logger.WarnContext(ctx, "turn audio too long, dropping the rest", "max", s.h.maxTurn)
+				s.tooLong = true
+				s.pcmData = nil
+			}
 
-				lastConversation, conversationHistory, err := services.GetPreviousConversation(currentConversation.UserID, 15)
-				if err != nil {
-					log.Printf("Failed to query conversation: %v\n", err)
-					break
-				}
+		default:
+			logger.WarnContext(ctx, "unsupported message type", "type", messageType)
+			s.sendError(ctx, models.ErrorBadRequest, "Unsupported message type.")
+		}
+	}
 
-				// log.Printf("Last conversation: %v\n", lastConversation)
-				// log.Printf("Conversation history: %v\n", conversationHistory)
+	return nil
+}
 
-				systemPrompt := systemprompt.DynamicGeneration(currentConversation.UserID)
-				services.AppendMessageToConversationHistory(&conversationHistory, "user", transcription)
+type wsMessage struct {
+	kind int
+	data []byte
+}
 
-				llmResponse, err := groq.GenerateLLMResponseFromConversationData(conversationHistory, systemPrompt, currentConversation.Language)
-				if err != nil {
-					log.Printf("Error generating LLM response: %v", err)
-					break
-				}
+// read passes the device's messages on until the connection fails or ctx
+// is done, then cancels the session.
+func (s *wsSession) read(ctx context.Context, cancel context.CancelFunc, messages chan<- wsMessage) {
+	defer close(messages)
+	defer cancel()
 
-				if len(llmResponse.Choices) == 0 {
-					log.Println("No choices returned from LLM response")
-					break
-				}
+	for {
+		messageType, message, err := s.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				logger.WarnContext(ctx, "unexpected websocket error", "error", err)
+			} else {
+				logger.InfoContext(ctx, "websocket session closed", "reason", err)
+			}
+			return
+		}
+		select {
+		case messages <- wsMessage{kind: messageType, data: message}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
 
-				DirtyAssistantResponseJSON := llmResponse.Choices[0].Message.Content
-				log.Printf("/----------------------------------------------------------------/\n")
-				log.Printf("Assistant response JSON: %s\n", DirtyAssistantResponseJSON)
-				log.Printf("/----------------------------------------------------------------/\n")
-
-				if !strings.Contains(DirtyAssistantResponseJSON, "{") || !strings.Contains(DirtyAssistantResponseJSON, "}") {
-					log.Printf("\033[31mInvalid JSON response received: %s\033[0m\n", DirtyAssistantResponseJSON)
-					assistantResponseJSON := `{
-						"message": "I didn't quite understand that. Could you please try again?",
-						"emotion": "confused",
-						"task_completion": {}
-					}`
-					handleDefaultResponse(&conversationHistory, assistantResponseJSON, currentConversation, lastConversation)
-					break
-				}
+// startTurn answers audio in a turn of its own, which runs until it is
+// done, interrupted or the device disconnects.
+func (s *wsSession) startTurn(ctx context.Context, audio turnAudio) {
+	turnCtx, cancel := context.WithCancelCause(ctx)
+	turn := &wsTurn{cancel: cancel, done: make(chan struct{})}
+	s.turn = turn
+
+	go func() {
+		defer close(turn.done)
+		defer cancel(nil)
+
+		err := s.handleTurn(turnCtx, turn, audio)
+		switch {
+		case err == nil:
+		case errors.Is(context.Cause(turnCtx), errInterrupted):
+			logger.DebugContext(ctx, "interrupted turn stopped", "error", err)
+		default:
+			logger.InfoContext(ctx, "device disconnected during turn", "error", err)
+		}
+	}()
+}
 
-				assistantResponseJSON = DirtyAssistantResponseJSON[strings.Index(DirtyAssistantResponseJSON, "{"):strings.LastIndex(DirtyAssistantResponseJSON, "}")+1]
+// interrupt cancels the last turn, waiting for it to stop if it is still
+// running, and reports whether there was anything to interrupt. A turn
+// that is done can only be interrupted by CANCEL, while the device plays
+// its reply. An empty reason stops the turn along with the session, which
+// is not counted as an interruption.
+func (s *wsSession) interrupt(ctx context.Context, reason string) bool {
+	turn := s.turn
+	if turn == nil {
+		return false
+	}
+	s.turn = nil
 
-				var assistantResponse LLMResponseJSONfromPrompt
-				err = json.Unmarshal([]byte(assistantResponseJSON), &assistantResponse)
-				if err != nil {
-					log.Printf("\033[31mError unmarshalling assistant response JSON: %v\033[0m\n", err)
-					break
-				}
+	select {
+	case <-turn.done:
+		if reason != "cancel" || !turn.replied {
+			return false
+		}
+		markReplyInterrupted(ctx, s.h.store.Conversations(), turn.userID)
+	default:
+		// Under the lock, so that the turn sends no frame after this
+		s.mu.Lock()
+		if reason == "" {
+			turn.cancel(context.Canceled)
+			s.mu.Unlock()
+			<-turn.done
+			return true
+		}
+		turn.cancel(errInterrupted)
+		s.mu.Unlock()
+		<-turn.done
+	}
 
-				if strings.TrimSpace(assistantResponse.Message) == "" {
-					log.Println("\033[31mAssistant response message is empty\033[0m")
-					break
-				}
+	// The reply's emotion was cut short, the next state shows at once
+	if s.emotions != nil {
+		s.mu.Lock()
+		s.emotions.Release()
+		s.mu.Unlock()
+	}
 
-				if strings.TrimSpace(assistantResponse.Emotion) == "" {
-					log.Println("\033[31mAssistant response emotion is empty\033[0m")
-					assistantResponse.Emotion = "cute_smile"
-				}
+	metrics.TurnsInterrupted.WithLabelValues(reason).Inc()
+	logger.InfoContext(ctx, "turn interrupted by device", "reason", reason)
+	return true
+}
 
-				// validTaskIDs := extractValidTaskIDs(conversationHistory)
-
-				if !isValidFormat(assistantResponse) {
-					log.Printf("\033[31mInvalid JSON response format received: %s\033[0m\n", assistantResponseJSON)
-					assistantResponseJSON := `{
-						"message": "I didn't quite understand that. Could you please try again?",
-						"emotion": "confused",
-						"task_completion": {}
-					}`
-					handleDefaultResponse(&conversationHistory, assistantResponseJSON, currentConversation, lastConversation)
-					break
-				}
+// running reports whether a turn is being answered.
+func (s *wsSession) running() bool {
+	if s.turn == nil {
+		return false
+	}
+	select {
+	case <-s.turn.done:
+		return false
+	default:
+		return true
+	}
+}
 
-				services.AppendMessageToConversationHistory(&conversationHistory, "assistant", assistantResponse.Message)
+// handleTurn answers the audio received until an EOS. Each turn is its own
+// trace, whose id is sent to the device for bug reports. Failures are
+// reported to the device with an error frame; errors are only returned once
+// ctx is done.
+func (s *wsSession) handleTurn(ctx context.Context, turn *wsTurn, audio turnAudio) error {
+	eosAt := time.Now()
+	metrics.RequestAudioBytes.WithLabelValues("ws", s.audioCodec).Observe(float64(audio.receivedBytes))
+	defer s.showState(ctx, emotion.Idle)
 
-				convoJSON, err := json.Marshal(conversationHistory)
-				if err != nil {
-					log.Printf("\033[31mError marshalling ConversationHistory: %v\033[0m\n", err)
-					break
-				}
+	ctx, span := tracing.Start(ctx, "conversation.turn", trace.WithNewRoot())
+	defer span.End()
 
-				if lastConversation == nil {
-					if err := services.InsertNewConversation(currentConversation.UserID, convoJSON); err != nil {
-						log.Print("Failed inserting new Conversation")
-						break
-					}
-				} else {
-					if err := services.UpdateExistingConversation(lastConversation.ID, convoJSON); err != nil {
-						log.Print("Failed updating Conversation")
-						break
-					}
-				}
-				log.Printf("/----------------------------------------------------------------/\n")
-				log.Printf("Final assistant response to send: %s\n", assistantResponse.Message)
-				log.Printf("/----------------------------------------------------------------/\n")
+	traceJSON, _ := json.Marshal(map[string]string{"type": "trace", "trace_id": tracing.TraceID(ctx)})
+	s.write(ctx, traceJSON)
 
-				conn.WriteMessage(websocket.TextMessage, []byte(assistantResponse.Emotion))
+	if audio.tooLong {
+		s.sendError(ctx, models.ErrorTooLong, fmt.Sprintf("Turns must be shorter than %s.", s.h.maxTurn))
+		return nil
+	}
 
-                tts, err := tts.ElevenLabsTextToSpeech(assistantResponse.Message)
-                if err != nil {
-                    log.Print("Error converting text to speech:", err)
-                    break;
-                }
+	currentConversation, err := services.HandleProcessConversationInput(ctx, audio.pcm, s.headers)
+	if err != nil {
+		logger.WarnContext(ctx, "invalid conversation input", "error", err)
+		s.sendError(ctx, inputErrorCode(err), "Processing error: "+err.Error())
+		return nil
+	}
+	currentConversation.AudioFormat = s.decoder.Format()
+	currentConversation.AudioCodec = s.audioCodec
+	duration := currentConversation.AudioFormat.Duration(len(currentConversation.RequestPCM))
+	metrics.RequestAudioSeconds.WithLabelValues("ws").Observe(duration.Seconds())
+	if duration < minRequestDuration {
+		logger.InfoContext(ctx, "request audio too short", "duration", duration)
+		s.sendError(ctx, models.ErrorNoSpeech, "The request is too short.")
+		return nil
+	}
+	if frame, limited := s.h.rateLimited(ctx, currentConversation.UserID, s.headers.XDeviceID, duration, currentConversation.Language); limited {
+		s.sendFrame(ctx, frame)
+		return nil
+	}
 
+	wavData, err := processPCMData(ctx, currentConversation.RequestPCM, currentConversation.AudioFormat)
+	if err != nil {
+		s.sendError(ctx, models.ErrorBadRequest, "Failed to convert audio format.")
+		return nil
+	}
 
-              
-                audioDir := "./audio"
-                err = os.MkdirAll(audioDir, os.ModePerm)
-                if err != nil {
-                    log.Fatalf("Failed to create audio directory: %v", err)
-                }
+	turnID := audiostore.NewTurnID()
+	ctx = logging.With(ctx, "turn_id", turnID)
+	ctx, finishCassette := s.h.deps.Cassettes.Start(ctx, turnID)
+	defer finishCassette()
+	usage := s.h.deps.Accounting.Turn(turnID, currentConversation.UserID, s.headers.XDeviceID)
+	defer usage.Save(ctx)
+	span.SetAttributes(attribute.String("turn_id", turnID), attribute.String("user_id", currentConversation.UserID.String()))
+	requestAudio, err := s.h.deps.Audio.Save(ctx, currentConversation.UserID, turnID, audiostore.Request, wavData)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to archive request audio", "error", err)
+	}
 
-                rand.Seed(time.Now().UnixNano())
-                randomNumber := rand.Intn(1000000) // Random number up to 6 digits
-                fileName := fmt.Sprintf("audio_%d.wav", randomNumber) // Assuming the output format is .wav
-                filePath := filepath.Join(audioDir, fileName)
+	s.showState(ctx, emotion.Thinking)
+	var prosody *pcm.Prosody
+	if s.h.prosody {
+		prosody = analyzeProsody(ctx, currentConversation.RequestPCM, currentConversation.AudioFormat)
+	}
 
+	transcription, sttProvider, err := s.h.deps.Pipeline.Transcribe(ctx, wavData, currentConversation.Language)
+	if err != nil {
+		logger.ErrorContext(ctx, "transcription failed", "error", err)
+		span.SetStatus(codes.Error, "transcription failed")
+		if ctx.Err() != nil {
+			return err
+		}
+		s.sendError(ctx, models.ErrorSTTFailed, "Transcription failed.")
+		return nil
+	}
+	usage.STT(sttProvider, duration)
+	if strings.TrimSpace(transcription) == "" {
+		logger.InfoContext(ctx, "empty transcription")
+		s.sendError(ctx, models.ErrorNoSpeech, "No speech recognized.")
+		return nil
+	}
 
-                err = pcm.TTStoWav(tts, filePath)
-                if err != nil {
-                    log.Printf("Failed to convert TTS to WAV: %v", err)
-                    break
-                }
+	transcription += "<for assistant: you must return as json as instructed in system prompt format: {\"message\": \"<your message>\", \"emotion\": \"<emotion>\", \"task_completion\": {\"task:\": \"<task_id>\", \"completed\": \"<value>\"}}"
+	transcription += ", if there was no task mentioned, add an empty task_completion object>"
 
-                fmt.Printf("Audio file saved successfully at %s\n", filePath)
+	logger.InfoContext(ctx, "transcription received", "transcript", logging.Transcript(transcription))
 
-                err = playAudio(filePath)
-                if err != nil {
-                    log.Fatalf("Failed to play audio: %v", err)
-                }
+	lastConversation, conversationHistory, err := services.GetPreviousConversation(ctx, s.h.store.Conversations(), currentConversation.UserID, 15)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to query conversation", "error", err)
+		s.sendError(ctx, models.ErrorInternal, "Failed to load the conversation.")
+		return nil
+	}
 
-				emotionChanged = false
+	// log.Printf("Last conversation: %v\n", lastConversation)
+	// log.Printf("Conversation history: %v\n", conversationHistory)
 
-				assistantResponse = LLMResponseJSONfromPrompt{}
+	systemPrompt := systemprompt.DynamicGeneration(ctx, s.h.store, currentConversation.UserID, s.emotions.Catalog().Emotions)
+	if prosody != nil {
+		systemPrompt += systemprompt.VoiceCues(*prosody)
+	}
+	userMessage := services.AppendMessageToConversationHistory(&conversationHistory, "user", transcription)
+	userMessage.AudioCodec = currentConversation.AudioCodec
+	userMessage.RequestAudio = requestAudio
+	userMessage.STTProvider = sttProvider
+	userMessage.Prosody = prosody
+
+	// A turn the device interrupted is kept in the conversation up to where
+	// it was cut short, so the LLM knows its last answer was not heard
+	saved := false
+	defer func() {
+		if saved || !errors.Is(context.Cause(ctx), errInterrupted) {
+			return
+		}
+		recordInterruptedTurn(context.WithoutCancel(ctx), s.h.store.Conversations(), &conversationHistory, currentConversation.UserID, lastConversation)
+	}()
 
-				pcmData = nil
-			}
+	llmResponse, llmProvider, err := s.h.deps.Pipeline.Complete(ctx, conversationHistory, systemPrompt, currentConversation.Language)
+	if err != nil {
+		logger.ErrorContext(ctx, "llm request failed", "error", err)
+		if ctx.Err() != nil {
+			return err
+		}
+		s.sendError(ctx, models.ErrorLLMFailed, "Generating the answer failed.")
+		return nil
+	}
+	s.h.countTokens(ctx, currentConversation.UserID, llmResponse)
+	usage.LLM(llmProvider, llmResponse)
+
+	DirtyAssistantResponseJSON := llmResponse.Choices[0].Message.Content
+
+	if !strings.Contains(DirtyAssistantResponseJSON, "{") || !strings.Contains(DirtyAssistantResponseJSON, "}") {
+		logger.WarnContext(ctx, "llm response is not JSON", "response", logging.Transcript(DirtyAssistantResponseJSON))
+		metrics.LLMResponseFailures.WithLabelValues("not_json").Inc()
+		assistantResponseJSON := `{
+			"message": "I didn't quite understand that. Could you please try again?",
+			"emotion": "confused",
+			"task_completion": {}
+		}`
+		handleDefaultResponse(ctx, s.h.store.Conversations(), &conversationHistory, assistantResponseJSON, currentConversation, lastConversation)
+		s.sendError(ctx, models.ErrorLLMFailed, "The answer was not understood.")
+		return nil
+	}
 
-		case websocket.BinaryMessage:
-			if !headersReceived {
-				log.Println("Received binary data before headers. Ignoring.")
-				conn.WriteMessage(websocket.TextMessage, []byte("Headers must be sent before PCM data."))
-				continue
-			}
+	assistantResponseJSON := DirtyAssistantResponseJSON[strings.Index(DirtyAssistantResponseJSON, "{"):strings.LastIndex(DirtyAssistantResponseJSON, "}")+1]
 
-			log.Printf("Received %d bytes of PCM data", len(message))
-			pcmData = append(pcmData, message...)
+	var assistantResponse LLMResponseJSONfromPrompt
+	err = json.Unmarshal([]byte(assistantResponseJSON), &assistantResponse)
+	if err != nil {
+		logger.WarnContext(ctx, "failed to unmarshal llm response", "error", err)
+		metrics.LLMResponseFailures.WithLabelValues("unmarshal").Inc()
+		s.sendError(ctx, models.ErrorLLMFailed, "The answer was not understood.")
+		return nil
+	}
 
-		default:
-			log.Printf("Unsupported message type: %d", messageType)
-			conn.WriteMessage(websocket.TextMessage, []byte("Unsupported message type."))
+	if strings.TrimSpace(assistantResponse.Message) == "" {
+		logger.WarnContext(ctx, "llm response message is empty")
+		metrics.LLMResponseFailures.WithLabelValues("empty_message").Inc()
+		s.sendError(ctx, models.ErrorLLMFailed, "The answer was empty.")
+		return nil
+	}
+
+	// validTaskIDs := extractValidTaskIDs(conversationHistory)
+
+	if !isValidFormat(ctx, assistantResponse) {
+		logger.WarnContext(ctx, "llm response has an invalid format", "response", logging.Transcript(assistantResponseJSON))
+		metrics.LLMResponseFailures.WithLabelValues("invalid_format").Inc()
+		assistantResponseJSON := `{
+			"message": "I didn't quite understand that. Could you please try again?",
+			"emotion": "confused",
+			"task_completion": {}
+		}`
+		handleDefaultResponse(ctx, s.h.store.Conversations(), &conversationHistory, assistantResponseJSON, currentConversation, lastConversation)
+		s.sendError(ctx, models.ErrorLLMFailed, "The answer was not understood.")
+		return nil
+	}
+
+	assistantMessage := services.AppendMessageToConversationHistory(&conversationHistory, "assistant", assistantResponse.Message)
+	assistantMessage.LLMProvider = llmProvider
+
+	// Emotions outside the device's catalog are mapped to the nearest one
+	s.mu.Lock()
+	shownEmotion := s.emotions.Show(assistantResponse.Emotion)
+	s.mu.Unlock()
+	logger.InfoContext(ctx, "assistant response", "response", logging.Transcript(assistantResponse.Message), "emotion", shownEmotion, "llm_emotion", assistantResponse.Emotion)
+
+	s.write(ctx, []byte(shownEmotion))
+	metrics.Since(metrics.TurnToEmotion, eosAt)
+	span.AddEvent("emotion sent", trace.WithAttributes(attribute.String("emotion", shownEmotion)))
+
+	// A failed TTS still leaves the text reply worth keeping in the history
+	responseAudio, ttsProvider, err := s.h.synthesizeResponseAudio(ctx, usage, currentConversation.UserID, turnID, assistantResponse.Message, currentConversation.Language)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to synthesize response audio", "error", err)
+	}
+	assistantMessage.ResponseAudio = responseAudio
+	assistantMessage.TTSProvider = ttsProvider
+
+	convoJSON, err := json.Marshal(conversationHistory)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to marshal conversation history", "error", err)
+		s.sendError(ctx, models.ErrorInternal, "Failed to save the conversation.")
+		return nil
+	}
+
+	if lastConversation == nil {
+		err = services.InsertNewConversation(ctx, s.h.store.Conversations(), currentConversation.UserID, convoJSON)
+	} else {
+		err = services.UpdateExistingConversation(ctx, s.h.store.Conversations(), lastConversation.ID, convoJSON)
+	}
+	if err != nil {
+		s.sendError(ctx, models.ErrorInternal, "Failed to save the conversation.")
+		return nil
+	}
+	saved = true
+
+	// The device streams the reply from a short-lived signed URL
+	if responseAudio != "" {
+		audioURL, err := s.h.deps.Audio.URL(ctx, responseAudio, responseAudioURLTTL)
+		if err != nil {
+			logger.ErrorContext(ctx, "failed to sign response audio URL", "error", err)
+			s.sendError(ctx, models.ErrorInternal, "Failed to share the answer's audio.")
+			return nil
 		}
+		s.write(ctx, []byte(audioURL))
 	}
+	turn.replied, turn.userID = true, currentConversation.UserID
 
 	return nil
 }
 
-func handleDefaultResponse(conversationHistory *models.ConversationHistory, defaultJSON string, currentConversation models.AnneWearConversationRequest, lastConversation *models.Conversation) {
+// recordInterruptedTurn saves the conversation of an interrupted turn, with
+// its last message, the request or the unheard reply, marked as
+// interrupted.
+func recordInterruptedTurn(ctx context.Context, conversations repository.Conversations, conversationHistory *models.ConversationHistory, userID uuid.UUID, lastConversation *models.Conversation) {
+	conversationHistory.Messages[len(conversationHistory.Messages)-1].Interrupted = true
+	convoJSON, err := json.Marshal(conversationHistory)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to marshal conversation history", "error", err)
+		return
+	}
+
+	if lastConversation == nil {
+		err = services.InsertNewConversation(ctx, conversations, userID, convoJSON)
+	} else {
+		err = services.UpdateExistingConversation(ctx, conversations, lastConversation.ID, convoJSON)
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to record interrupted turn", "error", err)
+		return
+	}
+	logger.InfoContext(ctx, "interrupted turn recorded", "sender", conversationHistory.Messages[len(conversationHistory.Messages)-1].Sender)
+}
+
+// markReplyInterrupted marks the reply ending the user's conversation as
+// interrupted, when the device stopped playing it.
+func markReplyInterrupted(ctx context.Context, conversations repository.Conversations, userID uuid.UUID) {
+	lastConversation, conversationHistory, err := services.GetPreviousConversation(ctx, conversations, userID, 15)
+	if err != nil || lastConversation == nil {
+		return
+	}
+	messages := conversationHistory.Messages
+	if len(messages) == 0 || messages[len(messages)-1].Sender != "assistant" {
+		return
+	}
+	messages[len(messages)-1].Interrupted = true
+
+	convoJSON, err := json.Marshal(conversationHistory)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to marshal conversation history", "error", err)
+		return
+	}
+	if err := services.UpdateExistingConversation(ctx, conversations, lastConversation.ID, convoJSON); err != nil {
+		logger.ErrorContext(ctx, "failed to record interrupted reply", "error", err)
+	}
+}
+
+// inputErrorCode returns the error code for a failure of
+// services.HandleProcessConversationInput.
+func inputErrorCode(err error) models.ErrorCode {
+	switch {
+	case errors.Is(err, services.ErrNoAudio):
+		return models.ErrorNoSpeech
+	case errors.Is(err, services.ErrMissingHeaders), errors.Is(err, services.ErrInvalidUserID):
+		return models.ErrorUnauthorized
+	default:
+		return models.ErrorBadRequest
+	}
+}
+
+// authorize checks that the headers name a known user, returning the error
+// code to report otherwise.
+func (s *wsSession) authorize(ctx context.Context) (models.ErrorCode, error) {
+	userID, err := uuid.Parse(s.headers.XUserID)
+	if err != nil {
+		return models.ErrorUnauthorized, services.ErrInvalidUserID
+	}
+	if _, err := s.h.store.Users().Get(ctx, userID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return models.ErrorUnauthorized, errors.New("unknown user")
+		}
+		logger.ErrorContext(ctx, "failed to look up user", "error", err)
+		return models.ErrorInternal, errors.New("failed to look up user")
+	}
+	return "", nil
+}
+
+// sendError tells the device that processing failed.
+func (s *wsSession) sendError(ctx context.Context, code models.ErrorCode, message string) {
+	s.sendFrame(ctx, s.h.errorFrame(ctx, code, message, s.headers.XLanguage))
+}
+
+// sendFrame sends an error frame to the device, with its emotion from the
+// device's catalog.
+func (s *wsSession) sendFrame(ctx context.Context, frame models.ErrorFrame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+
+	metrics.WSErrors.WithLabelValues(string(frame.Code)).Inc()
+	if frame.Emotion != "" && s.emotions != nil {
+		frame.Emotion = s.emotions.Show(frame.Emotion)
+	}
+	frameJSON, _ := json.Marshal(frame)
+	s.conn.WriteMessage(websocket.TextMessage, frameJSON)
+}
+
+// showState sends the animation of a pipeline state, if the device's
+// catalog has one and it does not cut a reply's emotion short.
+func (s *wsSession) showState(ctx context.Context, state string) {
+	if s.emotions == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+
+	if animation, ok := s.emotions.State(state); ok {
+		s.conn.WriteMessage(websocket.TextMessage, []byte(animation))
+	}
+}
+
+// write sends a text frame to the device. Frames of a turn that was
+// interrupted, i.e. whose ctx is done, are dropped.
+func (s *wsSession) write(ctx context.Context, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	s.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// synthesizeResponseAudio renders the reply with the persona's voice and
+// archives it, returning the audio reference and the TTS provider that
+// spoke it. The synthesis is recorded in the turn's usage.
+func (h *ConversationHandlers) synthesizeResponseAudio(ctx context.Context, usage *accounting.Turn, userID uuid.UUID, turnID string, text string, language string) (string, string, error) {
+	speech, provider, err := h.deps.Pipeline.Synthesize(ctx, text, language)
+	if err != nil {
+		return "", "", fmt.Errorf("error converting text to speech: %w", err)
+	}
+	usage.TTS(provider, text, speech)
+
+	ttsAudio, err := audiofilters.ApplyPersona(voicePersona, speech.Audio, speech.Format)
+	if err != nil {
+		return "", provider, fmt.Errorf("failed to apply voice effects: %w", err)
+	}
+
+	ttsWAV, err := pcm.ToWAV(ttsAudio, speech.Format)
+	if err != nil {
+		return "", provider, fmt.Errorf("failed to convert TTS to WAV: %w", err)
+	}
+
+	ref, err := h.deps.Audio.Save(ctx, userID, turnID, audiostore.Response, ttsWAV)
+	if err != nil {
+		return "", provider, err
+	}
+
+	logger.DebugContext(ctx, "response audio saved", "ref", ref, "provider", provider)
+	return ref, provider, nil
+}
+
+func handleDefaultResponse(ctx context.Context, conversations repository.Conversations, conversationHistory *models.ConversationHistory, defaultJSON string, currentConversation models.AnneWearConversationRequest, lastConversation *models.Conversation) {
 	var defaultResponse LLMResponseJSONfromPrompt
 	err := json.Unmarshal([]byte(defaultJSON), &defaultResponse)
 	if err != nil {
-		log.Printf("\033[31mError unmarshalling default response JSON: %v\033[0m\n", err)
+		logger.ErrorContext(ctx, "failed to unmarshal default response", "error", err)
 		return
 	}
 
@@ -316,52 +745,43 @@ func handleDefaultResponse(conversationHistory *models.ConversationHistory, defa
 
 	convoJSON, err := json.Marshal(conversationHistory)
 	if err != nil {
-		log.Printf("\033[31mError marshalling ConversationHistory: %v\033[0m\n", err)
+		logger.ErrorContext(ctx, "failed to marshal conversation history", "error", err)
 		return
 	}
 
 	if lastConversation == nil {
-		if err := services.InsertNewConversation(currentConversation.UserID, convoJSON); err != nil {
-			log.Printf("\033[31mFailed inserting new Conversation: %v\033[0m\n", err)
+		if err := services.InsertNewConversation(ctx, conversations, currentConversation.UserID, convoJSON); err != nil {
 			return
 		}
 	} else {
-		if err := services.UpdateExistingConversation(lastConversation.ID, convoJSON); err != nil {
-			log.Printf("\033[31mFailed updating Conversation: %v\033[0m\n", err)
+		if err := services.UpdateExistingConversation(ctx, conversations, lastConversation.ID, convoJSON); err != nil {
 			return
 		}
 	}
 
-	log.Printf("/----------------------------------------------------------------/\n")
-	log.Printf("Final assistant response to send: %s\n", defaultResponse.Message)
-	log.Printf("/----------------------------------------------------------------/\n")
+	logger.InfoContext(ctx, "assistant response", "response", logging.Transcript(defaultResponse.Message), "default", true)
 }
 
-func isValidFormat(response LLMResponseJSONfromPrompt) bool {
-	if _, exists := allowedEmotions[response.Emotion]; !exists {
-		log.Printf("\033[31mEmotion '%s' is not allowed.\033[0m\n", response.Emotion)
-		return false
-	}
-
+func isValidFormat(ctx context.Context, response LLMResponseJSONfromPrompt) bool {
 	if response.TaskCompletion.Task == "" && response.TaskCompletion.Completed == "" {
 		return true
 	}
 
 	if response.TaskCompletion.Task != "" || response.TaskCompletion.Completed != "" {
 		if response.TaskCompletion.Task == "" || response.TaskCompletion.Completed == "" {
-			log.Printf("\033[31mBoth 'task' and 'completed' fields must be present if one is present.\033[0m\n")
+			logger.WarnContext(ctx, "task_completion needs both task and completed")
 			return false
 		}
 		completedLower := strings.ToLower(response.TaskCompletion.Completed)
 		if completedLower != "true" && completedLower != "false" {
-			log.Printf("\033[31m'completed' field must be either 'true' or 'false'. Received: '%s'\033[0m\n", response.TaskCompletion.Completed)
+			logger.WarnContext(ctx, "task_completion completed must be true or false", "completed", response.TaskCompletion.Completed)
 			return false
 		}
 
 		return true
 	}
 
-	log.Printf("\033[31mInvalid task_completion format.\033[0m\n")
+	logger.WarnContext(ctx, "invalid task_completion format")
 	return false
 }
 
@@ -0,0 +1,311 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"anne-hub/handlers"
+	"anne-hub/models"
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/fakeprovider"
+	"anne-hub/repository"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+// reply is a completion in the JSON format of the system prompt.
+func reply(message string) string {
+	return fmt.Sprintf(`{"message": %q, "emotion": "happy", "task_completion": {}}`, message)
+}
+
+// startWS serves the WebSocket conversation handler.
+func startWS(t *testing.T, h *handlers.ConversationHandlers) *httptest.Server {
+	t.Helper()
+	e := echo.New()
+	e.GET("/ws", h.WebSocketConversationHandler)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// dialWS opens a session of the user and sends its headers.
+func dialWS(t *testing.T, srv *httptest.Server, user models.User) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	headers, _ := json.Marshal(models.WSRequestHeaders{XUserID: user.ID.String(), XDeviceID: "7", XLanguage: "en"})
+	if err := conn.WriteMessage(websocket.TextMessage, headers); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, conn, func(frame string) bool { return frame == "Headers received successfully." })
+	return conn
+}
+
+// readUntil reads text frames until one matches, returning them all.
+func readUntil(t *testing.T, conn *websocket.Conn, match func(frame string) bool) []string {
+	t.Helper()
+	frames, err := readFrames(conn, match)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frames
+}
+
+func readFrames(conn *websocket.Conn, match func(frame string) bool) ([]string, error) {
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var frames []string
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return frames, fmt.Errorf("after frames %q: %w", frames, err)
+		}
+		frames = append(frames, string(data))
+		if match(string(data)) {
+			return frames, nil
+		}
+	}
+}
+
+// isAudioURL matches the frame with the signed URL of a reply's audio.
+func isAudioURL(frame string) bool {
+	return strings.Contains(frame, "/blobs/")
+}
+
+// sendTurn streams a second of speech and ends the turn.
+func sendTurn(conn *websocket.Conn) error {
+	if err := conn.WriteMessage(websocket.BinaryMessage, tone()); err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.TextMessage, []byte("EOS"))
+}
+
+// history returns the messages of the user's conversation.
+func history(t *testing.T, store repository.Store, user models.User) []models.Message {
+	t.Helper()
+	conversation, err := store.Conversations().Latest(context.Background(), user.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h models.ConversationHistory
+	if err := json.Unmarshal(conversation.ConversationHistory, &h); err != nil {
+		t.Fatal(err)
+	}
+	return h.Messages
+}
+
+func TestWebSocketConcurrentSessions(t *testing.T) {
+	fake := fakeprovider.Start()
+	defer fake.Close()
+	// Both sessions wait on the LLM at once
+	fake.Script(fakeprovider.GroqChat,
+		fakeprovider.Response{Text: reply("First reply."), Latency: fakeprovider.Duration(100 * time.Millisecond)},
+		fakeprovider.Response{Text: reply("Second reply."), Latency: fakeprovider.Duration(100 * time.Millisecond)},
+	)
+	cfg := config.Defaults()
+	fake.Configure(&cfg)
+	h, store := newTestHandlers(t, &cfg)
+	srv := startWS(t, h)
+
+	users := make([]models.User, 2)
+	for i := range users {
+		users[i] = models.User{Username: fmt.Sprintf("anne-ws-%d", i), Email: fmt.Sprintf("anne-ws-%d@example.com", i)}
+		if err := store.Users().Create(context.Background(), &users[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	errs := make([]error, len(users))
+	var wg sync.WaitGroup
+	for i, user := range users {
+		conn := dialWS(t, srv, user)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if errs[i] = sendTurn(conn); errs[i] == nil {
+				_, errs[i] = readFrames(conn, isAudioURL)
+			}
+		}()
+	}
+	wg.Wait()
+
+	replies := make([]string, len(users))
+	for i, user := range users {
+		messages := history(t, store, user)
+		if len(messages) != 2 || messages[1].Sender != "assistant" {
+			t.Fatalf("history of session %d (%v) = %+v, want a request and a reply", i, errs[i], messages)
+		}
+		replies[i] = messages[1].Content
+	}
+	if replies[0] == replies[1] || !strings.HasSuffix(replies[0], "reply.") || !strings.HasSuffix(replies[1], "reply.") {
+		t.Errorf("replies = %q, want each session's own", replies)
+	}
+}
+
+// waitForRequests waits until the fake provider received n requests of
+// endpoint.
+func waitForRequests(t *testing.T, fake *fakeprovider.TestServer, endpoint string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		count := 0
+		for _, req := range fake.Requests() {
+			if req.Endpoint == endpoint {
+				count++
+			}
+		}
+		if count >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests to %s, want %d", count, endpoint, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// isCancelled matches the frame answering a CANCEL.
+func isCancelled(frame string) bool {
+	return strings.Contains(frame, `"type":"cancelled"`)
+}
+
+func TestWebSocketBargeIn(t *testing.T) {
+	// The LLM takes longer than the test may; only cancelling its call
+	// lets a turn stop in time
+	slow := fakeprovider.Response{Text: reply("Unheard reply."), Latency: fakeprovider.Duration(30 * time.Second)}
+
+	for _, tc := range []struct {
+		name string
+		// interrupt interrupts the turn waiting on the LLM and reads the
+		// frames up to the interruption's answer
+		interrupt func(t *testing.T, conn *websocket.Conn) []string
+		// wantSenders and wantInterrupted describe the stored history
+		wantSenders     []string
+		wantInterrupted []bool
+		wantReply       string
+	}{
+		{
+			name: "cancel",
+			interrupt: func(t *testing.T, conn *websocket.Conn) []string {
+				if err := conn.WriteMessage(websocket.TextMessage, []byte("CANCEL")); err != nil {
+					t.Fatal(err)
+				}
+				frames := readUntil(t, conn, isCancelled)
+				if last := frames[len(frames)-1]; last != `{"interrupted":true,"type":"cancelled"}` {
+					t.Errorf("cancelled frame = %s", last)
+				}
+				return frames
+			},
+			wantSenders:     []string{"user"},
+			wantInterrupted: []bool{true},
+		},
+		{
+			name: "speaking again",
+			interrupt: func(t *testing.T, conn *websocket.Conn) []string {
+				if err := sendTurn(conn); err != nil {
+					t.Fatal(err)
+				}
+				return readUntil(t, conn, isAudioURL)
+			},
+			wantSenders:     []string{"user", "user", "assistant"},
+			wantInterrupted: []bool{true, false, false},
+			wantReply:       "Heard reply.",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := fakeprovider.Start()
+			defer fake.Close()
+			fake.Script(fakeprovider.GroqChat, slow, fakeprovider.Response{Text: reply("Heard reply.")})
+			cfg := config.Defaults()
+			fake.Configure(&cfg)
+			h, store := newTestHandlers(t, &cfg)
+			srv := startWS(t, h)
+
+			user := models.User{Username: "anne-barge-in", Email: "anne-barge-in@example.com"}
+			if err := store.Users().Create(context.Background(), &user); err != nil {
+				t.Fatal(err)
+			}
+			conn := dialWS(t, srv, user)
+			if err := sendTurn(conn); err != nil {
+				t.Fatal(err)
+			}
+			waitForRequests(t, fake, fakeprovider.GroqChat, 1)
+
+			start := time.Now()
+			frames := tc.interrupt(t, conn)
+			if d := time.Since(start); d > 10*time.Second {
+				t.Errorf("interrupted after %s", d)
+			}
+			// Only the reply of a turn that was not interrupted is sent
+			audio := 0
+			for _, frame := range frames {
+				if strings.Contains(frame, "Unheard") {
+					t.Errorf("frame of the interrupted reply: %s", frame)
+				}
+				if isAudioURL(frame) {
+					audio++
+				}
+			}
+			if (audio > 0) != (tc.wantReply != "") || audio > 1 {
+				t.Errorf("%d audio frames, want one for a reply %q", audio, tc.wantReply)
+			}
+
+			messages := history(t, store, user)
+			if len(messages) != len(tc.wantSenders) {
+				t.Fatalf("history = %+v, want senders %q", messages, tc.wantSenders)
+			}
+			for i, m := range messages {
+				if m.Sender != tc.wantSenders[i] || m.Interrupted != tc.wantInterrupted[i] {
+					t.Errorf("message %d = %s, interrupted %v, want %s, %v", i, m.Sender, m.Interrupted, tc.wantSenders[i], tc.wantInterrupted[i])
+				}
+			}
+			if last := messages[len(messages)-1]; tc.wantReply != "" && last.Content != tc.wantReply {
+				t.Errorf("reply = %q, want %q", last.Content, tc.wantReply)
+			}
+		})
+	}
+}
+
+func TestWebSocketCancelPlayback(t *testing.T) {
+	fake := fakeprovider.Start()
+	defer fake.Close()
+	fake.Script(fakeprovider.GroqChat, fakeprovider.Response{Text: reply("Long reply.")})
+	cfg := config.Defaults()
+	fake.Configure(&cfg)
+	h, store := newTestHandlers(t, &cfg)
+	srv := startWS(t, h)
+
+	user := models.User{Username: "anne-playback", Email: "anne-playback@example.com"}
+	if err := store.Users().Create(context.Background(), &user); err != nil {
+		t.Fatal(err)
+	}
+	conn := dialWS(t, srv, user)
+	if err := sendTurn(conn); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, conn, isAudioURL)
+
+	// The device stops playing the reply
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("CANCEL")); err != nil {
+		t.Fatal(err)
+	}
+	frames := readUntil(t, conn, isCancelled)
+	if last := frames[len(frames)-1]; last != `{"interrupted":true,"type":"cancelled"}` {
+		t.Errorf("cancelled frame = %s", last)
+	}
+
+	messages := history(t, store, user)
+	if len(messages) != 2 || messages[0].Interrupted || !messages[1].Interrupted {
+		t.Errorf("history = %+v, want the reply interrupted", messages)
+	}
+}
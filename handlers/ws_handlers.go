@@ -3,13 +3,13 @@ package handlers
 
 import (
 	"fmt"
-	"log"
 	"net/http"
 	"time"
 
-	"anne-hub/pkg/fs"
+	"anne-hub/pkg/logging"
 	"anne-hub/pkg/pcm"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
 )
@@ -20,11 +20,13 @@ var upgrader = websocket.Upgrader{
 }
 
 // WebSocketHandler handles WebSocket connections for PCM data collection.
-func WebSocketHandler(c echo.Context) error {
+func (h *FileHandlers) WebSocketHandler(c echo.Context) error {
+    ctx := logging.With(c.Request().Context(), "session_id", uuid.New().String())
+
     // Upgrade the HTTP connection to a WebSocket connection
     conn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
     if err != nil {
-        log.Println("WebSocket upgrade error:", err)
+        logger.WarnContext(ctx, "websocket upgrade failed", "error", err)
         return err
     }
     defer conn.Close()
@@ -35,65 +37,64 @@ func WebSocketHandler(c echo.Context) error {
         messageType, message, err := conn.ReadMessage()
         if err != nil {
             if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-                log.Printf("Unexpected WebSocket error: %v", err)
+                logger.WarnContext(ctx, "unexpected websocket error", "error", err)
             } else {
-                log.Printf("WebSocket closed: %v", err)
+                logger.InfoContext(ctx, "websocket session closed", "reason", err)
             }
             break
         }
 
         switch messageType {
         case websocket.BinaryMessage:
-            log.Printf("Received %d bytes of PCM data", len(message))
+            logger.DebugContext(ctx, "audio frame", "bytes", len(message))
             pcmData = append(pcmData, message...)
 
         case websocket.TextMessage:
             msg := string(message)
-            log.Printf("Received text message: %s", msg)
 
             if msg == "EOS" {
                 if len(pcmData) == 0 {
-                    log.Println("No PCM data received before EOS.")
+                    logger.WarnContext(ctx, "no audio before EOS")
                     conn.WriteMessage(websocket.TextMessage, []byte("No PCM data received."))
                     continue
                 }
 
-                log.Printf("Total PCM data size: %d bytes", len(pcmData))
+                logger.DebugContext(ctx, "recording complete", "bytes", len(pcmData))
 
                 // Convert the accumulated PCM data to WAV format
-                wavBytes, err := pcm.ToWAV(pcmData)
+                wavBytes, err := pcm.ToWAV(pcmData, pcm.M5Format)
                 if err != nil {
-                    log.Printf("Error converting PCM to WAV: %v", err)
+                    logger.ErrorContext(ctx, "failed to convert PCM to WAV", "error", err)
                     conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("Conversion error: %v", err)))
                     break
                 }
 				
 
-                // Generate a unique filename using the current timestamp
-                filename := fmt.Sprintf("recording_%d", time.Now().Unix())
-                err = fs.WriteWAVDataToFile(filename + ".wav", wavBytes)
+                // Generate a unique key using the current timestamp
+                filename := fmt.Sprintf("recordings/recording_%d", time.Now().Unix())
+                err = h.blobs.Put(ctx, filename+".wav", wavBytes, "audio/wav")
                 if err != nil {
-                    log.Printf("Error saving WAV file: %v", err)
+                    logger.ErrorContext(ctx, "failed to save recording", "key", filename+".wav", "error", err)
                     conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("File saving error: %v", err)))
                     break
                 }
 
-				err = fs.WritePCMDataToFile(filename + ".pcm", pcmData)
+				err = h.blobs.Put(ctx, filename+".pcm", pcmData, "application/octet-stream")
 				if err != nil {
-					log.Printf("Error saving raw PCM file: %v", err)
+					logger.ErrorContext(ctx, "failed to save raw recording", "key", filename+".pcm", "error", err)
 				}
 
-                log.Printf("WAV file saved successfully as %s", filename)
+                logger.InfoContext(ctx, "recording saved", "key", filename)
                 conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("WAV file saved as %s", filename)))
 
                 pcmData = nil
             } else {
-                log.Printf("Unknown text message received: %s", msg)
+                logger.WarnContext(ctx, "unknown text message", "message", msg)
                 conn.WriteMessage(websocket.TextMessage, []byte("Unknown command."))
             }
 
         default:
-            log.Printf("Unsupported message type: %d", messageType)
+            logger.WarnContext(ctx, "unsupported message type", "type", messageType)
             conn.WriteMessage(websocket.TextMessage, []byte("Unsupported message type."))
         }
     }
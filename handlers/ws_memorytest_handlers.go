@@ -3,10 +3,10 @@ package handlers
 
 import (
 	"anne-hub/models"
+	"anne-hub/pkg/logging"
 	"encoding/json"
-	"fmt"
-	"log"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
 )
@@ -36,9 +36,11 @@ type DataChunkHeader struct {
 
 // WebSocketConversationHandler handles WebSocket connections for PCM data collection.
 func WebSocketTestHandler(c echo.Context) error {
+    ctx := logging.With(c.Request().Context(), "session_id", uuid.New().String())
+
     conn, err := wsUpgrader.Upgrade(c.Response(), c.Request(), nil)
     if err != nil {
-        log.Println("WebSocket upgrade error:", err)
+        logger.WarnContext(ctx, "websocket upgrade failed", "error", err)
         return err
     }
     defer conn.Close()
@@ -52,9 +54,9 @@ func WebSocketTestHandler(c echo.Context) error {
         messageType, message, err := conn.ReadMessage()
         if err != nil {
             if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-                log.Printf("Unexpected WebSocket error: %v", err)
+                logger.WarnContext(ctx, "unexpected websocket error", "error", err)
             } else {
-                log.Printf("WebSocket closed: %v", err)
+                logger.InfoContext(ctx, "websocket session closed", "reason", err)
             }
             break
         }
@@ -62,19 +64,18 @@ func WebSocketTestHandler(c echo.Context) error {
         switch messageType {
         case websocket.TextMessage:
             msg := string(message)
-            log.Printf("Received text message: %s", msg)
 
             if !headersReceived {
                 // Attempt to parse the headers JSON
                 err := json.Unmarshal(message, &headers)
                 if err != nil {
-                    log.Printf("Error parsing headers JSON: %v", err)
+                    logger.WarnContext(ctx, "invalid headers message", "error", err)
                     conn.WriteMessage(websocket.TextMessage, []byte("Invalid headers format."))
                     continue
                 }
 
-                log.Printf("Custom Headers Received - User ID: %s, Device ID: %s, Language: %s",
-                    headers.XUserID, headers.XDeviceID, headers.XLanguage)
+                ctx = logging.With(ctx, "user_id", logging.PII(headers.XUserID), "device_id", logging.PII(headers.XDeviceID))
+                logger.InfoContext(ctx, "headers received", "language", headers.XLanguage)
 
                 headersReceived = true
                 conn.WriteMessage(websocket.TextMessage, []byte("Headers received successfully."))
@@ -103,10 +104,10 @@ func WebSocketTestHandler(c echo.Context) error {
 
 
 
-                fmt.Println("Sending WAV file link for Streaming to client")
+                logger.DebugContext(ctx, "sending test audio link")
                 err = conn.WriteMessage(websocket.TextMessage, []byte("/files/test_linear16.wav"))
                 if err != nil {
-                    log.Printf("Error sending PCM data: %v\n", err)
+                    logger.WarnContext(ctx, "failed to send test audio link", "error", err)
                 }
 
             //    chunkSize := 400 
@@ -148,23 +149,23 @@ func WebSocketTestHandler(c echo.Context) error {
                 pcmData = nil
             } else {
                 // Handle other text messages if necessary
-                log.Printf("Unknown text message received: %s", msg)
+                logger.WarnContext(ctx, "unknown text message", "message", msg)
                 conn.WriteMessage(websocket.TextMessage, []byte("Unknown command."))
             }
 
         case websocket.BinaryMessage:
             if !headersReceived {
-                log.Println("Received binary data before headers. Ignoring.")
+                logger.WarnContext(ctx, "binary data before headers, ignoring")
                 conn.WriteMessage(websocket.TextMessage, []byte("Headers must be sent before PCM data."))
                 continue
             }
 
             // Append binary PCM data to the buffer
-            log.Printf("Received %d bytes of PCM data", len(message))
+            logger.DebugContext(ctx, "audio frame", "bytes", len(message))
             pcmData = append(pcmData, message...)
 
         default:
-            log.Printf("Unsupported message type: %d", messageType)
+            logger.WarnContext(ctx, "unsupported message type", "type", messageType)
             conn.WriteMessage(websocket.TextMessage, []byte("Unsupported message type."))
         }
     }
@@ -1,15 +1,33 @@
 package main
 
 import (
+	"anne-hub/handlers"
 	"anne-hub/router"
 	"context"
+	"flag"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"time"
 
+	"anne-hub/pkg/accounting"
+	"anne-hub/pkg/apology"
+	"anne-hub/pkg/audiostore"
+	"anne-hub/pkg/blob"
+	"anne-hub/pkg/cassette"
+	"anne-hub/pkg/config"
 	"anne-hub/pkg/db"
+	"anne-hub/pkg/emotion"
+	"anne-hub/pkg/logging"
+	"anne-hub/pkg/phrasepack"
+	"anne-hub/pkg/pipeline"
+	"anne-hub/pkg/quota"
+	"anne-hub/pkg/tracing"
+	"anne-hub/pkg/ttscache"
+	"anne-hub/repository"
+	"anne-hub/repository/postgres"
+	"anne-hub/repository/sqlite"
 
 	"github.com/joho/godotenv"
 )
@@ -20,18 +38,65 @@ func main() {
         log.Println("No .env file found. Proceeding with environment variables.")
     }
 
+    configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+    flag.Parse()
 
+    cfg, err := config.Load(*configFile)
+    if err != nil {
+        log.Fatal(err)
+    }
+    logging.Setup(cfg.Log)
+
+    if flag.Arg(0) == "migrate" {
+        if err := runMigrate(cfg, flag.Args()[1:]); err != nil {
+            log.Fatal(err)
+        }
+        return
+    }
+    if flag.Arg(0) == "tts-cache" {
+        if err := runTTSCache(cfg, flag.Args()[1:]); err != nil {
+            log.Fatal(err)
+        }
+        return
+    }
 
+    shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+    if err != nil {
+        log.Fatal(err)
+    }
 
-    e := router.NewRouter()
+    db.SetupDatabase(cfg.Database)
+    var store repository.Store = postgres.New(db.DB)
+    if cfg.Database.Driver == "sqlite" {
+        store = sqlite.New(db.DB)
+    }
 
-    e.Static("/files", "./static")
+    blobs := blob.Setup(cfg.Storage)
+    p := pipeline.Setup(cfg, ttscache.Setup(cfg.TTSCache, blobs))
+    deps := handlers.Deps{
+        Pipeline:    p,
+        Blobs:       blobs,
+        Audio:       audiostore.Setup(cfg.Audio, blobs),
+        Emotions:    emotion.Setup(cfg.Emotion),
+        PhrasePacks: phrasepack.Setup(p, blobs),
+        Accounting:  accounting.New(cfg.Pricing, store, p),
+        Quota:       quota.Setup(cfg.Quota, store),
+        Apologies:   apology.Setup(cfg.Audio, p, blobs),
+        Cassettes:   cassette.Setup(cfg.Cassette),
+    }
 
-    db.SetupDatabase()
+    e := router.NewRouter(cfg, store, deps)
+
+    purgeCtx, stopPurge := context.WithCancel(context.Background())
+    defer stopPurge()
+    deps.Audio.StartPurgeJob(purgeCtx, cfg.Audio.PurgeInterval)
+    if deps.Quota != nil {
+        deps.Quota.StartPurgeJob(purgeCtx, time.Hour)
+    }
 
     // In main.go
     go func() {
-        if err := e.Start(":1323"); err != nil && err != http.ErrServerClosed {
+        if err := e.Start(cfg.Server.Addr); err != nil && err != http.ErrServerClosed {
             e.Logger.Fatal("Shutting down the server")
         }
     }()
@@ -46,4 +111,7 @@ func main() {
     if err := e.Shutdown(ctx); err != nil {
         e.Logger.Fatal(err)
     }
+    if err := shutdownTracing(ctx); err != nil {
+        log.Println("Failed to flush traces:", err)
+    }
 }
\ No newline at end of file
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/db"
+
+	"github.com/golang-migrate/migrate/v4"
+)
+
+const migrateUsage = `usage: anne-hub migrate <command>
+
+commands:
+  up [N]       apply all or N pending migrations
+  down [N]     roll back N migrations (default 1)
+  goto V       migrate up or down to version V
+  force V      set the version to V without migrating, to recover a dirty schema;
+               -1 if not even the first migration applied
+  version      print the current and latest version`
+
+// runMigrate runs the "anne-hub migrate" subcommand.
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	conn, err := db.Connect(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	m, err := db.NewMigrator(conn, cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	command, args := args[0], args[1:]
+	switch command {
+	case "up":
+		n, err := optionalCount(args, 0)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			err = m.Up()
+		} else {
+			err = m.Steps(n)
+		}
+		if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return err
+		}
+	case "down":
+		n, err := optionalCount(args, 1)
+		if err != nil {
+			return err
+		}
+		if err := m.Steps(-n); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return err
+		}
+	case "goto":
+		version, err := requiredVersion(args, 0)
+		if err != nil {
+			return err
+		}
+		if err := m.Migrate.Migrate(uint(version)); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return err
+		}
+	case "force":
+		// -1 marks the database as having no migrations applied
+		version, err := requiredVersion(args, -1)
+		if err != nil {
+			return err
+		}
+		if err := m.Force(version); err != nil {
+			return err
+		}
+	case "version":
+	default:
+		return fmt.Errorf("unknown migrate command %q\n\n%s", command, migrateUsage)
+	}
+
+	status, err := m.Status()
+	if err != nil {
+		return err
+	}
+	dirty := ""
+	if status.Dirty {
+		dirty = " (dirty)"
+	}
+	fmt.Printf("version %d%s, latest %d\n", status.Version, dirty, status.Latest)
+	return nil
+}
+
+func optionalCount(args []string, def int) (int, error) {
+	if len(args) == 0 {
+		return def, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid count %q, expected a positive number", args[0])
+	}
+	return n, nil
+}
+
+func requiredVersion(args []string, lowest int) (int, error) {
+	if len(args) == 0 {
+		return 0, errors.New("missing version\n\n" + migrateUsage)
+	}
+	version, err := strconv.Atoi(args[0])
+	if err != nil || version < lowest {
+		return 0, fmt.Errorf("invalid version %q", args[0])
+	}
+	return version, nil
+}
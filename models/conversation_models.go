@@ -3,6 +3,7 @@
 package models
 
 import (
+	"anne-hub/pkg/pcm"
 	"encoding/json"
 	"time"
 
@@ -14,6 +15,20 @@ type Message struct {
 	Sender    string `json:"sender"`
 	Content   string `json:"content"`
 	Timestamp string `json:"timestamp"`
+	// AudioCodec is the codec the device uploaded this turn's audio with
+	AudioCodec string `json:"audio_codec,omitempty"`
+	// Archived audio of this turn, references into pkg/audiostore
+	RequestAudio  string `json:"request_audio,omitempty"`
+	ResponseAudio string `json:"response_audio,omitempty"`
+	// Providers that served this turn, see pkg/pipeline
+	STTProvider string `json:"stt_provider,omitempty"`
+	LLMProvider string `json:"llm_provider,omitempty"`
+	TTSProvider string `json:"tts_provider,omitempty"`
+	// Prosody is how the user spoke this message, see pcm.AnalyzeProsody
+	Prosody *pcm.Prosody `json:"prosody,omitempty"`
+	// Interrupted is set on the last message of a turn the device cut
+	// short: a request left unanswered or a reply it did not hear out
+	Interrupted bool `json:"interrupted,omitempty"`
 }
 
 // ConversationHistory holds the conversation history as a list of messages.
@@ -26,6 +41,11 @@ type AnneWearConversationRequest struct {
 	UserID      uuid.UUID `json:"user_id"`
 	DeviceID    int       `json:"device_id"`
 	RequestPCM  []byte    `json:"request_pcm"`
+	// AudioFormat describes RequestPCM, zero fields default to pcm.M5Format
+	AudioFormat pcm.Format `json:"audio_format"`
+	// AudioCodec is how RequestPCM is compressed, empty means raw PCM
+	AudioCodec     string `json:"audio_codec,omitempty"`
+	AudioBlockSize int    `json:"audio_block_size,omitempty"`
 	Language    string    `json:"language,omitempty"`
 	RequestTime string    `json:"request_time"`
 }
@@ -0,0 +1,39 @@
+package models
+
+// ErrorCode tells the device why a message or turn failed. Codes are
+// stable, devices switch on them.
+type ErrorCode string
+
+const (
+	// The turn had no audio, or nothing was said
+	ErrorNoSpeech ErrorCode = "no_speech"
+	// Every speech-to-text provider failed
+	ErrorSTTFailed ErrorCode = "stt_failed"
+	// Every LLM provider failed, or the answer was unusable
+	ErrorLLMFailed ErrorCode = "llm_failed"
+	// The user is unknown or the headers do not identify one
+	ErrorUnauthorized ErrorCode = "unauthorized"
+	// The turn's audio exceeded the maximum duration
+	ErrorTooLong ErrorCode = "too_long"
+	// The user or device used up a quota of the user's plan
+	ErrorRateLimited ErrorCode = "rate_limited"
+	// The device sent something the hub does not understand
+	ErrorBadRequest ErrorCode = "bad_request"
+	// The hub failed, e.g. to store the conversation
+	ErrorInternal ErrorCode = "internal"
+)
+
+// ErrorFrame is sent to the device over the WebSocket when processing
+// fails, as {"type": "error", ...}.
+type ErrorFrame struct {
+	Type    string    `json:"type"`
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	// The apology Anne speaks, in the session's language
+	Text    string `json:"text,omitempty"`
+	Emotion string `json:"emotion,omitempty"`
+	// Signed URL of the spoken apology
+	AudioURL string `json:"audio_url,omitempty"`
+	// Seconds until a rate_limited device may try again
+	RetryAfter int `json:"retry_after,omitempty"`
+}
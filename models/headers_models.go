@@ -1,8 +1,18 @@
 package models
 
+import "anne-hub/pkg/pcm"
+
 // Define a struct to represent the custom headers
 type WSRequestHeaders struct {
     XUserID    string `json:"X-User-ID"`
     XDeviceID  string `json:"X-Device-ID"`
     XLanguage  string `json:"X-Language"`
+    // Optional, devices that omit it are assumed to stream pcm.M5Format
+    XAudioFormat *pcm.Format `json:"X-Audio-Format,omitempty"`
+    // Codecs the device can stream, the hub answers with the one it picked
+    XAudioCodecs []string `json:"X-Audio-Codecs,omitempty"`
+    // IMA-ADPCM block size, zero means one block per binary message
+    XAudioBlockSize int `json:"X-Audio-Block-Size,omitempty"`
+    // Optional, picks the emotion catalog of the device's firmware
+    XFirmwareVersion string `json:"X-Firmware-Version,omitempty"`
 }
\ No newline at end of file
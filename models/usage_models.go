@@ -0,0 +1,48 @@
+package models
+
+import (
+	"anne-hub/pkg/uuid"
+	"time"
+)
+
+// Usage is what a user or device consumed within one quota window.
+type Usage struct {
+	Turns   int64 `json:"turns" db:"turns"`
+	AudioMS int64 `json:"audio_ms" db:"audio_ms"`
+	Tokens  int64 `json:"tokens" db:"tokens"`
+}
+
+// UsageRecord is what one stage of a turn used, with its estimated cost.
+type UsageRecord struct {
+	ID        int64     `json:"id" db:"id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	TurnID    string    `json:"turn_id" db:"turn_id"`
+	UserID    uuid.UUID `json:"user_id" db:"user_id"`
+	DeviceID  string    `json:"device_id" db:"device_id"`
+	// "stt", "llm" or "tts"
+	Stage            string `json:"stage" db:"stage"`
+	Provider         string `json:"provider" db:"provider"`
+	Model            string `json:"model" db:"model"`
+	PromptTokens     int    `json:"prompt_tokens" db:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens" db:"completion_tokens"`
+	// Characters of text synthesized
+	Characters int `json:"characters" db:"characters"`
+	// Length of the transcribed or synthesized audio
+	AudioMS int64 `json:"audio_ms" db:"audio_ms"`
+	// Cached speech costs nothing
+	Cached  bool    `json:"cached" db:"cached"`
+	CostUSD float64 `json:"cost_usd" db:"cost_usd"`
+}
+
+// UsageSummary aggregates the usage records of one day, user, device or
+// provider.
+type UsageSummary struct {
+	Key              string  `json:"key" db:"key"`
+	Turns            int64   `json:"turns" db:"turns"`
+	PromptTokens     int64   `json:"prompt_tokens" db:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens" db:"completion_tokens"`
+	Characters       int64   `json:"characters" db:"characters"`
+	STTSeconds       float64 `json:"stt_seconds" db:"stt_seconds"`
+	TTSSeconds       float64 `json:"tts_seconds" db:"tts_seconds"`
+	CostUSD          float64 `json:"cost_usd" db:"cost_usd"`
+}
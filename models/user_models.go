@@ -3,16 +3,18 @@ package models
 import "anne-hub/pkg/uuid"
 
 type User struct {
-    ID           uuid.UUID `json:"id"`
-    Username     string `json:"username"`
-    FirstName    string `json:"first_name"`
-    LastName     string `json:"last_name"`
-    Email        string `json:"email"`
-    PasswordHash string `json:"password_hash"`
-    CreatedAt    string `json:"created_at"`
-    Age          int    `json:"age"`
-    Country      string `json:"country"`
-    City         string `json:"city"`
+    ID           uuid.UUID `json:"id" db:"id"`
+    Username     string `json:"username" db:"username"`
+    FirstName    string `json:"first_name" db:"first_name"`
+    LastName     string `json:"last_name" db:"last_name"`
+    Email        string `json:"email" db:"email"`
+    PasswordHash string `json:"password_hash" db:"password_hash"`
+    CreatedAt    string `json:"created_at" db:"created_at"`
+    Age          int    `json:"age" db:"age"`
+    Country      string `json:"country" db:"country"`
+    City         string `json:"city" db:"city"`
+    // Plan names the usage quotas of the user, empty for the default plan
+    Plan         string `json:"plan" db:"plan"`
 }
 
 type UserDetails struct {
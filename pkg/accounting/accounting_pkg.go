@@ -0,0 +1,137 @@
+// Package accounting records what each turn used, per stage: the provider
+// and model, tokens, synthesized characters and audio seconds, with a cost
+// estimated from the configured price table. Records are kept in the
+// database and summarized by day, user, device or provider.
+package accounting
+
+import (
+	"anne-hub/models"
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/logging"
+	"anne-hub/pkg/pipeline"
+	"anne-hub/repository"
+	"context"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+var logger = logging.For("accounting")
+
+// Accountant prices usage and stores it.
+type Accountant struct {
+	store    repository.Store
+	pipeline *pipeline.Pipeline
+	prices   config.PricingConfig
+	now      func() time.Time
+}
+
+// New creates an accountant pricing with cfg. p tells the models of the
+// providers.
+func New(cfg config.PricingConfig, store repository.Store, p *pipeline.Pipeline) *Accountant {
+	return &Accountant{store: store, pipeline: p, prices: cfg, now: time.Now}
+}
+
+// Turn collects the usage records of one turn until it is saved.
+type Turn struct {
+	a        *Accountant
+	id       string
+	userID   uuid.UUID
+	deviceID string
+	records  []models.UsageRecord
+}
+
+// Turn starts the records of a turn of a user's device.
+func (a *Accountant) Turn(turnID string, userID uuid.UUID, deviceID string) *Turn {
+	return &Turn{a: a, id: turnID, userID: userID, deviceID: deviceID}
+}
+
+// STT records a transcription of audio by provider.
+func (t *Turn) STT(provider string, audio time.Duration) {
+	rec := t.record(pipeline.StageSTT, provider, t.a.pipeline.Model(pipeline.StageSTT, provider))
+	rec.AudioMS = audio.Milliseconds()
+	rec.CostUSD = lookup(t.a.prices.STT, rec.Provider, rec.Model) * audio.Hours()
+	t.records = append(t.records, rec)
+}
+
+// LLM records a completion by provider, with the model and tokens it
+// reported.
+func (t *Turn) LLM(provider string, resp models.GroqLLMResponse) {
+	model := resp.Model
+	if model == "" {
+		model = t.a.pipeline.Model(pipeline.StageLLM, provider)
+	}
+	rec := t.record(pipeline.StageLLM, provider, model)
+	rec.PromptTokens = resp.Usage.PromptTokens
+	rec.CompletionTokens = resp.Usage.CompletionTokens
+	price := lookup(t.a.prices.LLM, rec.Provider, rec.Model)
+	rec.CostUSD = (float64(rec.PromptTokens)*price.Prompt + float64(rec.CompletionTokens)*price.Completion) / 1e6
+	t.records = append(t.records, rec)
+}
+
+// TTS records text spoken by provider. Speech from the cache costs
+// nothing.
+func (t *Turn) TTS(provider, text string, speech pipeline.Speech) {
+	rec := t.record(pipeline.StageTTS, provider, t.a.pipeline.Model(pipeline.StageTTS, provider))
+	rec.Characters = utf8.RuneCountInString(text)
+	rec.AudioMS = speech.Format.Duration(len(speech.Audio)).Milliseconds()
+	rec.Cached = speech.Cached
+	if !speech.Cached {
+		rec.CostUSD = lookup(t.a.prices.TTS, rec.Provider, rec.Model) * float64(rec.Characters) / 1e6
+	}
+	t.records = append(t.records, rec)
+}
+
+// record starts a record of a stage. Chain entries like "groq:<model>" are
+// recorded as the provider and its model.
+func (t *Turn) record(stage, provider, model string) models.UsageRecord {
+	provider, _, _ = strings.Cut(provider, ":")
+	return models.UsageRecord{
+		CreatedAt: t.a.now(),
+		TurnID:    t.id,
+		UserID:    t.userID,
+		DeviceID:  t.deviceID,
+		Stage:     stage,
+		Provider:  provider,
+		Model:     model,
+	}
+}
+
+// Records returns what the turn used so far.
+func (t *Turn) Records() []models.UsageRecord {
+	return t.records
+}
+
+// Save stores the turn's records. Turns cut short are saved with what they
+// used so far, and failing to account does not fail the turn, so errors are
+// only logged.
+func (t *Turn) Save(ctx context.Context) {
+	if len(t.records) == 0 {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	err := t.a.store.InTx(ctx, func(tx repository.Store) error {
+		return tx.UsageRecords().Create(ctx, t.records)
+	})
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to save usage", "turn_id", t.id, "error", err)
+		return
+	}
+
+	var cost float64
+	for _, rec := range t.records {
+		cost += rec.CostUSD
+	}
+	logger.DebugContext(ctx, "usage saved", "turn_id", t.id, "records", len(t.records), "cost_usd", cost)
+}
+
+// lookup returns the price of a provider's model, falling back to the
+// provider's price and then to zero.
+func lookup[V any](prices map[string]V, provider, model string) V {
+	if price, ok := prices[provider+"/"+model]; ok && model != "" {
+		return price
+	}
+	return prices[provider]
+}
@@ -0,0 +1,209 @@
+// Package apology renders the short messages Anne speaks when a turn fails,
+// one per error code and language. They are rendered once, through the TTS
+// chain and the voice persona, and kept in blob storage, so a device gets
+// something to play even while the providers are struggling.
+package apology
+
+import (
+	"anne-hub/models"
+	"anne-hub/pkg/audiofilters"
+	"anne-hub/pkg/blob"
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/logging"
+	"anne-hub/pkg/pcm"
+	"anne-hub/pkg/pipeline"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var logger = logging.For("apology")
+
+// prefix namespaces rendered apologies within the blob store.
+const prefix = "apologies"
+
+// DefaultLanguage is spoken for languages without a translation.
+const DefaultLanguage = "en"
+
+// Phrase is what Anne says and shows for an error code.
+type Phrase struct {
+	Emotion string
+	// Text by language
+	Text map[string]string
+}
+
+// Phrases holds the apology of every error code a device can hear.
+var Phrases = map[models.ErrorCode]Phrase{
+	models.ErrorNoSpeech: {
+		Emotion: "confused",
+		Text: map[string]string{
+			"en": "Sorry, I didn't hear anything. Could you say that again?",
+			"de": "Entschuldige, ich habe nichts gehört. Kannst du das noch einmal sagen?",
+		},
+	},
+	models.ErrorSTTFailed: {
+		Emotion: "confused",
+		Text: map[string]string{
+			"en": "Sorry, I couldn't understand you just now. Please try again in a moment.",
+			"de": "Entschuldige, ich konnte dich gerade nicht verstehen. Versuch es bitte gleich noch einmal.",
+		},
+	},
+	models.ErrorLLMFailed: {
+		Emotion: "sleep",
+		Text: map[string]string{
+			"en": "Sorry, my thoughts got tangled. Let's try that again in a moment.",
+			"de": "Entschuldige, ich bin gerade durcheinander. Lass es uns gleich noch einmal versuchen.",
+		},
+	},
+	models.ErrorTooLong: {
+		Emotion: "surprised",
+		Text: map[string]string{
+			"en": "That was a lot at once! Could you say it a bit shorter?",
+			"de": "Das war ganz schön viel auf einmal! Kannst du es etwas kürzer sagen?",
+		},
+	},
+	models.ErrorRateLimited: {
+		Emotion: "sleep",
+		Text: map[string]string{
+			"en": "Phew, we've talked a lot! Let's take a break and chat again a bit later.",
+			"de": "Puh, wir haben ganz schön viel geredet! Lass uns eine Pause machen und später weiterquatschen.",
+		},
+	},
+	models.ErrorUnauthorized: {
+		Emotion: "suspicious",
+		Text: map[string]string{
+			"en": "I don't know you yet. Please set me up in the app first.",
+			"de": "Ich kenne dich noch nicht. Bitte richte mich zuerst in der App ein.",
+		},
+	},
+	models.ErrorInternal: {
+		Emotion: "confused",
+		Text: map[string]string{
+			"en": "Sorry, something went wrong on my side. Please try again.",
+			"de": "Entschuldige, bei mir ist etwas schiefgelaufen. Bitte versuch es noch einmal.",
+		},
+	},
+}
+
+// Lookup returns the apology text and emotion for code in language, false
+// if the code has none.
+func Lookup(code models.ErrorCode, language string) (text, emotion string, ok bool) {
+	phrase, ok := Phrases[code]
+	if !ok {
+		return "", "", false
+	}
+	text, found := phrase.Text[language]
+	if !found {
+		text = phrase.Text[DefaultLanguage]
+	}
+	return text, phrase.Emotion, true
+}
+
+// Speaker renders apologies and hands out URLs to them.
+type Speaker struct {
+	pipeline *pipeline.Pipeline
+	blobs    blob.Store
+	persona  string
+
+	mu sync.Mutex
+	// Keys known to be in the blob store
+	rendered map[string]bool
+}
+
+// Setup creates the speaker, in Anne's voice, and renders every apology in
+// the background. It returns nil while spoken errors are disabled.
+func Setup(cfg config.AudioConfig, p *pipeline.Pipeline, blobs blob.Store) *Speaker {
+	if !cfg.SpokenErrors {
+		logger.Info("spoken errors disabled")
+		return nil
+	}
+	s := New(p, blobs, audiofilters.DefaultPersona)
+	go s.Prerender(context.Background())
+	return s
+}
+
+// New creates a speaker rendering with p in the voice persona and storing
+// the audio in blobs.
+func New(p *pipeline.Pipeline, blobs blob.Store, persona string) *Speaker {
+	return &Speaker{pipeline: p, blobs: blobs, persona: persona, rendered: map[string]bool{}}
+}
+
+// Prerender renders every phrase that is not in the blob store yet.
+func (s *Speaker) Prerender(ctx context.Context) {
+	count := 0
+	for code, phrase := range Phrases {
+		for language := range phrase.Text {
+			if _, err := s.render(ctx, code, language); err != nil {
+				logger.WarnContext(ctx, "failed to render apology", "code", code, "language", language, "error", err)
+				continue
+			}
+			count++
+		}
+	}
+	logger.InfoContext(ctx, "apologies ready", "count", count)
+}
+
+// URL returns a signed URL of the spoken apology for code, rendering it
+// first if needed.
+func (s *Speaker) URL(ctx context.Context, code models.ErrorCode, language string, ttl time.Duration) (string, error) {
+	key, err := s.render(ctx, code, language)
+	if err != nil {
+		return "", err
+	}
+	return s.blobs.SignedURL(ctx, key, ttl)
+}
+
+// render makes sure the apology is in the blob store and returns its key.
+// Keys include a hash of the text and persona, so edited phrases are
+// rendered again.
+func (s *Speaker) render(ctx context.Context, code models.ErrorCode, language string) (string, error) {
+	text, _, ok := Lookup(code, language)
+	if !ok {
+		return "", fmt.Errorf("no apology for %q", code)
+	}
+	if _, found := Phrases[code].Text[language]; !found {
+		language = DefaultLanguage
+	}
+	sum := sha256.Sum256([]byte(s.persona + "\x00" + text))
+	key := blob.Join(prefix, language, fmt.Sprintf("%s_%s.wav", code, hex.EncodeToString(sum[:4])))
+
+	s.mu.Lock()
+	done := s.rendered[key]
+	s.mu.Unlock()
+	if done {
+		return key, nil
+	}
+
+	// Rendered by an earlier run
+	_, err := s.blobs.Get(ctx, key)
+	if err != nil && !errors.Is(err, blob.ErrNotFound) {
+		return "", err
+	}
+	if errors.Is(err, blob.ErrNotFound) {
+		speech, provider, err := s.pipeline.Synthesize(ctx, text, language)
+		if err != nil {
+			return "", err
+		}
+		audio, err := audiofilters.ApplyPersona(s.persona, speech.Audio, speech.Format)
+		if err != nil {
+			return "", fmt.Errorf("failed to apply voice effects: %w", err)
+		}
+		wav, err := pcm.ToWAV(audio, speech.Format)
+		if err != nil {
+			return "", fmt.Errorf("failed to convert apology to WAV: %w", err)
+		}
+		if err := s.blobs.Put(ctx, key, wav, "audio/wav"); err != nil {
+			return "", err
+		}
+		logger.DebugContext(ctx, "apology rendered", "code", code, "language", language, "provider", provider)
+	}
+
+	s.mu.Lock()
+	s.rendered[key] = true
+	s.mu.Unlock()
+	return key, nil
+}
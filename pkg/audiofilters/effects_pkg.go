@@ -0,0 +1,209 @@
+package audiofilters
+
+import (
+	"math"
+	"time"
+
+	"anne-hub/pkg/pcm"
+)
+
+// Effect processes decoded audio in place or by replacing buf.Samples.
+type Effect interface {
+	Apply(buf *pcm.Buffer)
+}
+
+// Chain applies its effects in order.
+type Chain []Effect
+
+func (c Chain) Apply(buf *pcm.Buffer) {
+	for _, effect := range c {
+		effect.Apply(buf)
+	}
+}
+
+// Bitcrusher quantizes samples to the given bit depth. Mix blends the crushed
+// signal with the original (1 = fully crushed).
+type Bitcrusher struct {
+	Bits int
+	Mix  float64
+}
+
+func (b Bitcrusher) Apply(buf *pcm.Buffer) {
+	if b.Bits < 1 || b.Bits >= 32 {
+		return
+	}
+	levels := math.Exp2(float64(b.Bits - 1))
+	for i, s := range buf.Samples {
+		crushed := math.Round(s*levels) / levels
+		buf.Samples[i] = s + (crushed-s)*b.Mix
+	}
+}
+
+// SampleRateReducer holds every Factor-th frame for Factor frames, giving the
+// aliased sound of a lower sample rate without changing the buffer's rate.
+type SampleRateReducer struct {
+	Factor int
+}
+
+func (r SampleRateReducer) Apply(buf *pcm.Buffer) {
+	if r.Factor < 2 {
+		return
+	}
+	ch := buf.Channels
+	for frame := 0; frame < buf.Frames(); frame++ {
+		held := frame - frame%r.Factor
+		for c := 0; c < ch; c++ {
+			buf.Samples[frame*ch+c] = buf.Samples[held*ch+c]
+		}
+	}
+}
+
+// Tremolo modulates the amplitude with a sine LFO. Depth is in [0, 1].
+type Tremolo struct {
+	Rate  float64 // Hz
+	Depth float64
+}
+
+func (t Tremolo) Apply(buf *pcm.Buffer) {
+	if t.Rate <= 0 || t.Depth <= 0 {
+		return
+	}
+	ch := buf.Channels
+	for frame := 0; frame < buf.Frames(); frame++ {
+		phase := 2 * math.Pi * t.Rate * float64(frame) / float64(buf.SampleRate)
+		gain := 1 - t.Depth*(0.5+0.5*math.Sin(phase))
+		for c := 0; c < ch; c++ {
+			buf.Samples[frame*ch+c] *= gain
+		}
+	}
+}
+
+// PitchShift changes the pitch by the given number of semitones while keeping
+// the duration, using two crossfaded read heads sweeping over a short delay line.
+type PitchShift struct {
+	Semitones float64
+	Window    time.Duration // defaults to 40ms
+}
+
+func (p PitchShift) Apply(buf *pcm.Buffer) {
+	if p.Semitones == 0 || buf.Frames() == 0 {
+		return
+	}
+
+	window := p.Window
+	if window <= 0 {
+		window = 40 * time.Millisecond
+	}
+	size := float64(buf.SampleRate) * window.Seconds()
+	if size < 2 {
+		return
+	}
+
+	ratio := math.Exp2(p.Semitones / 12)
+	ch := buf.Channels
+	frames := buf.Frames()
+	out := make([]float64, len(buf.Samples))
+
+	for c := 0; c < ch; c++ {
+		var phase float64
+		for frame := 0; frame < frames; frame++ {
+			var sum float64
+			for head := 0; head < 2; head++ {
+				// Each head's delay sweeps through the window; the triangular
+				// gain hides the jump when it wraps around.
+				d := math.Mod(phase+float64(head)*size/2, size)
+				gain := 1 - math.Abs(2*d/size-1)
+				pos := float64(frame) - d
+				sum += gain * sampleAt(buf.Samples, ch, c, pos)
+			}
+			out[frame*ch+c] = sum
+
+			phase = math.Mod(phase+(1-ratio)+size, size)
+		}
+	}
+
+	buf.Samples = out
+}
+
+// sampleAt linearly interpolates channel c at a fractional frame position.
+func sampleAt(samples []float64, ch, c int, pos float64) float64 {
+	if pos < 0 {
+		return 0
+	}
+	i := int(pos)
+	frac := pos - float64(i)
+	frames := len(samples) / ch
+	if i >= frames-1 {
+		if i < frames {
+			return samples[i*ch+c]
+		}
+		return 0
+	}
+	a := samples[i*ch+c]
+	b := samples[(i+1)*ch+c]
+	return a + (b-a)*frac
+}
+
+// Trim cuts Start from the beginning and End from the end of the audio.
+type Trim struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+func (t Trim) Apply(buf *pcm.Buffer) {
+	frames := buf.Frames()
+	start := durationToFrames(t.Start, buf.SampleRate)
+	end := frames - durationToFrames(t.End, buf.SampleRate)
+	if start >= end {
+		// Never trim everything away, an empty reply is worse than an untrimmed one
+		return
+	}
+	buf.Samples = buf.Samples[start*buf.Channels : end*buf.Channels]
+}
+
+// Gain scales the signal by the given amount in decibels.
+type Gain struct {
+	DB float64
+}
+
+func (g Gain) Apply(buf *pcm.Buffer) {
+	factor := math.Pow(10, g.DB/20)
+	for i := range buf.Samples {
+		buf.Samples[i] *= factor
+	}
+}
+
+// Fade ramps the volume up over In at the start and down over Out at the end.
+type Fade struct {
+	In  time.Duration
+	Out time.Duration
+}
+
+func (f Fade) Apply(buf *pcm.Buffer) {
+	frames := buf.Frames()
+	ch := buf.Channels
+
+	in := min(durationToFrames(f.In, buf.SampleRate), frames)
+	for frame := 0; frame < in; frame++ {
+		gain := float64(frame) / float64(in)
+		for c := 0; c < ch; c++ {
+			buf.Samples[frame*ch+c] *= gain
+		}
+	}
+
+	out := min(durationToFrames(f.Out, buf.SampleRate), frames)
+	for i := 0; i < out; i++ {
+		frame := frames - 1 - i
+		gain := float64(i) / float64(out)
+		for c := 0; c < ch; c++ {
+			buf.Samples[frame*ch+c] *= gain
+		}
+	}
+}
+
+func durationToFrames(d time.Duration, sampleRate int) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(d.Seconds() * float64(sampleRate))
+}
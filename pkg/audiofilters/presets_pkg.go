@@ -0,0 +1,76 @@
+package audiofilters
+
+import (
+	"fmt"
+	"time"
+
+	"anne-hub/pkg/pcm"
+)
+
+// Presets are the named effect chains personas can use.
+var Presets = map[string]Chain{
+	// No processing at all
+	"none": {},
+	// Anne's robot voice, after the old ffmpeg filter
+	// "acrusher=samples=20:bits=8,atrim=start=0.5,apulsator=mode=sine:hz=3".
+	// The trim is left out since TTS replies start speaking right away.
+	"robot": {
+		SampleRateReducer{Factor: 2},
+		Bitcrusher{Bits: 8, Mix: 1},
+		Tremolo{Rate: 3, Depth: 0.25},
+		Fade{In: 10 * time.Millisecond, Out: 30 * time.Millisecond},
+	},
+	// Higher, lighter voice
+	"chipmunk": {
+		PitchShift{Semitones: 5},
+		Fade{In: 10 * time.Millisecond, Out: 30 * time.Millisecond},
+	},
+	// Lower, calmer voice, e.g. for bedtime
+	"sleepy": {
+		PitchShift{Semitones: -3},
+		Gain{DB: -4},
+		Fade{In: 50 * time.Millisecond, Out: 200 * time.Millisecond},
+	},
+}
+
+// DefaultPersona is the voice Anne speaks with.
+const DefaultPersona = "anne"
+
+// Personas maps each persona to the preset applied to its TTS output.
+// Personas that are not listed get their audio unchanged.
+var Personas = map[string]string{
+	"anne": "robot",
+}
+
+// ApplyPreset runs the named preset over raw audio and returns it in the same format.
+func ApplyPreset(name string, audio []byte, format pcm.Format) ([]byte, error) {
+	chain, ok := Presets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown voice preset %q", name)
+	}
+	if len(chain) == 0 || len(audio) == 0 {
+		return audio, nil
+	}
+
+	buf, err := pcm.Decode(audio, format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode audio for preset %q: %w", name, err)
+	}
+
+	chain.Apply(buf)
+
+	out, err := buf.Encode(format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audio for preset %q: %w", name, err)
+	}
+	return out, nil
+}
+
+// ApplyPersona runs the preset configured for persona over raw audio.
+func ApplyPersona(persona string, audio []byte, format pcm.Format) ([]byte, error) {
+	preset, ok := Personas[persona]
+	if !ok {
+		return audio, nil
+	}
+	return ApplyPreset(preset, audio, format)
+}
@@ -0,0 +1,155 @@
+package audiostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"anne-hub/pkg/blob"
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/logging"
+
+	"github.com/google/uuid"
+)
+
+// Kind tells request (device) audio apart from response (TTS) audio.
+type Kind string
+
+const (
+	Request  Kind = "request"
+	Response Kind = "response"
+)
+
+var logger = logging.For("audiostore")
+
+// prefix namespaces archived audio within the blob store.
+const prefix = "audio"
+
+// Store archives per-turn audio in blob storage, keyed as
+// audio/<user id>/<yyyy-mm-dd>/<turn id>_<kind>.wav.
+type Store struct {
+	Blobs blob.Store
+	// Retention is how long audio is kept, zero keeps it forever
+	Retention time.Duration
+}
+
+// Setup creates the store of the audio configuration on top of blobs.
+func Setup(cfg config.AudioConfig, blobs blob.Store) *Store {
+	s := New(blobs, time.Duration(cfg.RetentionDays)*24*time.Hour)
+
+	logger.Info("audio store ready", "retention_days", cfg.RetentionDays)
+	return s
+}
+
+// New creates a store on top of blobs.
+func New(blobs blob.Store, retention time.Duration) *Store {
+	return &Store{Blobs: blobs, Retention: retention}
+}
+
+// NewTurnID returns an id linking a turn's request and response audio.
+func NewTurnID() string {
+	return uuid.New().String()
+}
+
+// Save stores WAV audio for one turn and returns its reference, the blob
+// key that is stored on the conversation message.
+func (s *Store) Save(ctx context.Context, userID uuid.UUID, turnID string, kind Kind, wav []byte) (string, error) {
+	ref := blob.Join(
+		prefix,
+		userID.String(),
+		time.Now().UTC().Format("2006-01-02"),
+		fmt.Sprintf("%s_%s.wav", turnID, kind),
+	)
+
+	if err := s.Blobs.Put(ctx, ref, wav, "audio/wav"); err != nil {
+		return "", fmt.Errorf("failed to archive %s audio: %w", kind, err)
+	}
+	return ref, nil
+}
+
+// Load returns the audio stored under a reference returned by Save.
+func (s *Store) Load(ctx context.Context, ref string) ([]byte, error) {
+	return s.Blobs.Get(ctx, ref)
+}
+
+// URL returns a signed URL the device can download the audio from.
+func (s *Store) URL(ctx context.Context, ref string, ttl time.Duration) (string, error) {
+	return s.Blobs.SignedURL(ctx, ref, ttl)
+}
+
+// DeleteUser removes all audio archived for a user.
+func (s *Store) DeleteUser(ctx context.Context, userID uuid.UUID) error {
+	if err := s.Blobs.DeletePrefix(ctx, blob.Join(prefix, userID.String())+"/"); err != nil {
+		return fmt.Errorf("failed to delete audio of user %s: %w", userID, err)
+	}
+	return nil
+}
+
+// Purge deletes audio older than the retention period and returns the
+// number of files removed.
+func (s *Store) Purge(ctx context.Context, now time.Time) (int, error) {
+	if s.Retention <= 0 {
+		return 0, nil
+	}
+	cutoff := now.Add(-s.Retention)
+
+	objects, err := s.Blobs.List(ctx, prefix+"/")
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	var errs []error
+	for _, obj := range objects {
+		// Only touch archived turns, not files like audio/placeholder.md
+		if !isTurnAudio(obj.Key) || !obj.ModTime.Before(cutoff) {
+			continue
+		}
+		if err := s.Blobs.Delete(ctx, obj.Key); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed++
+	}
+
+	return removed, errors.Join(errs...)
+}
+
+// isTurnAudio reports whether key has the layout Save produces.
+func isTurnAudio(key string) bool {
+	parts := strings.Split(key, "/")
+	if len(parts) != 4 || parts[0] != prefix {
+		return false
+	}
+	_, err := uuid.Parse(parts[1])
+	return err == nil
+}
+
+// StartPurgeJob runs Purge every interval until ctx is cancelled.
+func (s *Store) StartPurgeJob(ctx context.Context, interval time.Duration) {
+	if s.Retention <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			removed, err := s.Purge(ctx, time.Now())
+			if err != nil {
+				logger.ErrorContext(ctx, "audio purge failed", "error", err)
+			} else if removed > 0 {
+				logger.InfoContext(ctx, "audio purge", "removed", removed)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,120 @@
+package audiostore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"anne-hub/pkg/blob"
+
+	"github.com/google/uuid"
+)
+
+func newStore(t *testing.T, retention time.Duration) *Store {
+	t.Helper()
+	blobs, err := blob.NewLocalStore(t.TempDir(), "", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(blobs, retention)
+}
+
+// age sets the modification time of a blob to now minus age.
+func age(t *testing.T, s *Store, key string, now time.Time, age time.Duration) {
+	t.Helper()
+	p := filepath.Join(s.Blobs.(*blob.LocalStore).Root, filepath.FromSlash(key))
+	if err := os.Chtimes(p, now.Add(-age), now.Add(-age)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(t *testing.T, s *Store, ref string) bool {
+	t.Helper()
+	_, err := s.Load(context.Background(), ref)
+	if err != nil && !errors.Is(err, blob.ErrNotFound) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestSaveLoad(t *testing.T) {
+	s := newStore(t, 0)
+	user := uuid.New()
+	turn := NewTurnID()
+
+	ref, err := s.Save(context.Background(), user, turn, Request, []byte("wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "audio/" + user.String() + "/" + time.Now().UTC().Format("2006-01-02") + "/" + turn + "_request.wav"; ref != want {
+		t.Errorf("ref = %s, want %s", ref, want)
+	}
+	if data, err := s.Load(context.Background(), ref); err != nil || string(data) != "wav" {
+		t.Errorf("Load = %q, %v", data, err)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	s := newStore(t, 24*time.Hour)
+	ctx := context.Background()
+	now := time.Now()
+	user := uuid.New()
+
+	old, err := s.Save(ctx, user, NewTurnID(), Request, []byte("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recent, err := s.Save(ctx, user, NewTurnID(), Response, []byte("recent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Files other than archived turns are kept, however old
+	other := "audio/placeholder.md"
+	if err := s.Blobs.Put(ctx, other, []byte("keep"), ""); err != nil {
+		t.Fatal(err)
+	}
+	age(t, s, old, now, 25*time.Hour)
+	age(t, s, recent, now, 23*time.Hour)
+	age(t, s, other, now, 100*time.Hour)
+
+	removed, err := s.Purge(ctx, now)
+	if err != nil || removed != 1 {
+		t.Errorf("Purge = %d, %v, want 1", removed, err)
+	}
+	for ref, want := range map[string]bool{old: false, recent: true, other: true} {
+		if got := exists(t, s, ref); got != want {
+			t.Errorf("%s exists %v, want %v", ref, got, want)
+		}
+	}
+
+	// Without a retention audio is kept forever
+	s.Retention = 0
+	if removed, err := s.Purge(ctx, now.Add(1000*time.Hour)); err != nil || removed != 0 {
+		t.Errorf("Purge without retention = %d, %v", removed, err)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	s := newStore(t, 0)
+	ctx := context.Background()
+	anne, bob := uuid.New(), uuid.New()
+
+	refs := map[uuid.UUID]string{}
+	for _, user := range []uuid.UUID{anne, bob} {
+		ref, err := s.Save(ctx, user, NewTurnID(), Request, []byte("wav"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs[user] = ref
+	}
+
+	if err := s.DeleteUser(ctx, anne); err != nil {
+		t.Fatal(err)
+	}
+	if exists(t, s, refs[anne]) || !exists(t, s, refs[bob]) {
+		t.Error("DeleteUser did not remove exactly the user's audio")
+	}
+}
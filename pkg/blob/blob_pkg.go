@@ -0,0 +1,112 @@
+package blob
+
+import (
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/logging"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+var logger = logging.For("blob")
+
+// ErrNotFound is returned when a key does not exist.
+var ErrNotFound = errors.New("blob not found")
+
+// Object describes a stored blob.
+type Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Store is a flat key/value store for binary data. Keys use forward slashes
+// and must not contain "..".
+type Store interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+	// DeletePrefix removes every blob whose key starts with prefix
+	DeletePrefix(ctx context.Context, prefix string) error
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// SignedURL returns a URL a client can fetch the blob from until ttl passes
+	SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// Setup creates the store of the configured backend, used for audio and
+// static assets. It exits if the store cannot be reached.
+func Setup(cfg config.StorageConfig) Store {
+	var store Store
+	var err error
+
+	switch cfg.Backend {
+	case "local":
+		store, err = NewLocalStore(cfg.Dir, cfg.PublicURL, cfg.SigningKey)
+	case "s3":
+		store, err = NewS3Store(S3Config{
+			Endpoint:  cfg.S3.Endpoint,
+			Bucket:    cfg.S3.Bucket,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+			Region:    cfg.S3.Region,
+			UseSSL:    cfg.S3.UseSSL,
+		})
+	default:
+		err = fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+
+	if err != nil {
+		logger.Error("failed to set up blob storage", "backend", cfg.Backend, "error", err)
+		os.Exit(1)
+	}
+	logger.Info("blob storage ready", "backend", cfg.Backend)
+	return store
+}
+
+// Join builds a key from parts.
+func Join(parts ...string) string {
+	return path.Join(parts...)
+}
+
+// ValidateKey rejects keys that could escape the store's namespace.
+func ValidateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == ".." {
+			return fmt.Errorf("invalid blob key %q", key)
+		}
+	}
+	return nil
+}
+
+// ContentType guesses a content type from the key's extension.
+func ContentType(key string) string {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".wav":
+		return "audio/wav"
+	case ".pcm", ".raw":
+		return "application/octet-stream"
+	case ".mp3":
+		return "audio/mpeg"
+	case ".json":
+		return "application/json"
+	case ".zip":
+		return "application/zip"
+	case ".html":
+		return "text/html; charset=utf-8"
+	case ".txt", ".md":
+		return "text/plain; charset=utf-8"
+	case ".png":
+		return "image/png"
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	default:
+		return "application/octet-stream"
+	}
+}
@@ -0,0 +1,176 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newLocalStore(t *testing.T) *LocalStore {
+	t.Helper()
+	s, err := NewLocalStore(filepath.Join(t.TempDir(), "data"), "http://hub.test/", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestValidateKey(t *testing.T) {
+	for key, valid := range map[string]bool{
+		"a":             true,
+		"audio/x/y.wav": true,
+		"a..b/c":        true,
+		"":              false,
+		"/etc/passwd":   false,
+		"..":            false,
+		"../a":          false,
+		"a/../../b":     false,
+		"a/..":          false,
+		"a//b":          false,
+		"a/./b":         false,
+		"a/":            false,
+	} {
+		if err := ValidateKey(key); (err == nil) != valid {
+			t.Errorf("ValidateKey(%q) = %v, want valid %v", key, err, valid)
+		}
+	}
+}
+
+func TestLocalStoreRejectsTraversal(t *testing.T) {
+	s := newLocalStore(t)
+	ctx := context.Background()
+	outside := filepath.Join(filepath.Dir(s.Root), "secret")
+	if err := os.WriteFile(outside, []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"../secret", "a/../../secret", "/secret", filepath.ToSlash(outside)} {
+		if err := s.Put(ctx, key, []byte("x"), ""); err == nil {
+			t.Errorf("Put(%q) succeeded", key)
+		}
+		if data, err := s.Get(ctx, key); err == nil {
+			t.Errorf("Get(%q) = %q", key, data)
+		}
+		if err := s.Delete(ctx, key); err == nil {
+			t.Errorf("Delete(%q) succeeded", key)
+		}
+		if _, err := s.SignedURL(ctx, key, time.Minute); err == nil {
+			t.Errorf("SignedURL(%q) succeeded", key)
+		}
+	}
+	if _, err := s.List(ctx, "../"); err == nil {
+		t.Error("List(../) succeeded")
+	}
+
+	if data, err := os.ReadFile(outside); err != nil || string(data) != "secret" {
+		t.Errorf("file outside the root = %q, %v", data, err)
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	s := newLocalStore(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"audio/a/1.wav", "audio/a/2.wav", "audio/b/1.wav", "static/x.png"} {
+		if err := s.Put(ctx, key, []byte(key), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, err := s.Get(ctx, "audio/a/2.wav"); err != nil || string(data) != "audio/a/2.wav" {
+		t.Errorf("Get = %q, %v", data, err)
+	}
+	if _, err := s.Get(ctx, "audio/c/1.wav"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a missing key: %v, want ErrNotFound", err)
+	}
+
+	keys := func(prefix string) string {
+		t.Helper()
+		objects, err := s.List(ctx, prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, obj := range objects {
+			keys = append(keys, obj.Key)
+		}
+		return strings.Join(keys, " ")
+	}
+	for prefix, want := range map[string]string{
+		"audio/":   "audio/a/1.wav audio/a/2.wav audio/b/1.wav",
+		"audio/a":  "audio/a/1.wav audio/a/2.wav",
+		"missing/": "",
+	} {
+		if got := keys(prefix); got != want {
+			t.Errorf("List(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+
+	if err := s.DeletePrefix(ctx, "audio/a/"); err != nil {
+		t.Fatal(err)
+	}
+	if got := keys(""); got != "audio/b/1.wav static/x.png" {
+		t.Errorf("after DeletePrefix: %q", got)
+	}
+	// Emptied directories are removed, the root is kept
+	if _, err := os.Stat(filepath.Join(s.Root, "audio", "a")); !os.IsNotExist(err) {
+		t.Errorf("emptied directory: %v", err)
+	}
+	if err := s.Delete(ctx, "static/x.png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "static/x.png"); err != nil {
+		t.Errorf("deleting a missing key: %v", err)
+	}
+	if _, err := os.Stat(s.Root); err != nil {
+		t.Errorf("root: %v", err)
+	}
+}
+
+func TestSignedURL(t *testing.T) {
+	s := newLocalStore(t)
+	ctx := context.Background()
+
+	signed, err := s.SignedURL(ctx, "audio/a/1.wav", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Host != "hub.test" || u.Path != "/blobs/audio/a/1.wav" {
+		t.Errorf("URL = %s", signed)
+	}
+	expires, sig := u.Query().Get("expires"), u.Query().Get("sig")
+	if err := s.Verify("audio/a/1.wav", expires, sig); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+
+	other, err := NewLocalStore(t.TempDir(), "", "other key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := s.SignedURL(ctx, "audio/a/1.wav", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eu, _ := url.Parse(expired)
+
+	for name, verify := range map[string]func() error{
+		"another key":     func() error { return s.Verify("audio/a/2.wav", expires, sig) },
+		"a later expiry":  func() error { return s.Verify("audio/a/1.wav", expires+"0", sig) },
+		"a bad expiry":    func() error { return s.Verify("audio/a/1.wav", "soon", sig) },
+		"a bad signature": func() error { return s.Verify("audio/a/1.wav", expires, sig[1:]) },
+		"another store":   func() error { return other.Verify("audio/a/1.wav", expires, sig) },
+		"an expired URL":  func() error { return s.Verify("audio/a/1.wav", eu.Query().Get("expires"), eu.Query().Get("sig")) },
+	} {
+		if err := verify(); err == nil {
+			t.Errorf("Verify accepted %s", name)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Package cassette records the provider calls of a conversation turn to a
+// file and replays them, so real sessions can be turned into deterministic
+// regression tests.
+package cassette
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Cassette holds the provider calls of one turn, in the order they were made.
+type Cassette struct {
+	TurnID       string        `json:"turn_id"`
+	RecordedAt   time.Time     `json:"recorded_at"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one request and the response or transport error it got.
+type Interaction struct {
+	Request  Request   `json:"request"`
+	Response *Response `json:"response,omitempty"`
+	// Error is set instead of Response when the request failed
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+type Request struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   Body        `json:"body"`
+}
+
+type Response struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   Body        `json:"body"`
+}
+
+// Body is written as text when it is valid UTF-8 and as base64 otherwise,
+// so JSON requests stay readable in the file and audio survives.
+type Body []byte
+
+type encodedBody struct {
+	Text   *string `json:"text,omitempty"`
+	Base64 *string `json:"base64,omitempty"`
+}
+
+func (b Body) MarshalJSON() ([]byte, error) {
+	s := string(b)
+	if utf8.Valid(b) {
+		return json.Marshal(encodedBody{Text: &s})
+	}
+	s = base64.StdEncoding.EncodeToString(b)
+	return json.Marshal(encodedBody{Base64: &s})
+}
+
+func (b *Body) UnmarshalJSON(data []byte) error {
+	var e encodedBody
+	if err := json.Unmarshal(data, &e); err != nil {
+		return err
+	}
+	switch {
+	case e.Text != nil:
+		*b = Body(*e.Text)
+	case e.Base64 != nil:
+		decoded, err := base64.StdEncoding.DecodeString(*e.Base64)
+		if err != nil {
+			return fmt.Errorf("invalid base64 body: %w", err)
+		}
+		*b = decoded
+	default:
+		*b = nil
+	}
+	return nil
+}
+
+// secretHeaders are never written to a cassette.
+var secretHeaders = []string{"Authorization", "Xi-Api-Key", "X-Goog-Api-Key", "Cookie", "Set-Cookie"}
+
+func scrub(h http.Header) http.Header {
+	h = h.Clone()
+	for _, k := range secretHeaders {
+		h.Del(k)
+	}
+	return h
+}
+
+// Load reads a cassette file.
+func Load(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid cassette %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// LoadAll reads path, a cassette file or a directory of them. A directory's
+// cassettes are returned in the order they were recorded.
+func LoadAll(path string) ([]*Cassette, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		c, err := Load(path)
+		if err != nil {
+			return nil, err
+		}
+		return []*Cassette{c}, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(path, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var cassettes []*Cassette
+	for _, f := range files {
+		c, err := Load(f)
+		if err != nil {
+			return nil, err
+		}
+		cassettes = append(cassettes, c)
+	}
+	sort.SliceStable(cassettes, func(i, j int) bool {
+		return cassettes[i].RecordedAt.Before(cassettes[j].RecordedAt)
+	})
+	return cassettes, nil
+}
+
+// Save writes the cassette to a file in dir named after the recording time
+// and turn, and returns its path.
+func (c *Cassette) Save(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	name := c.RecordedAt.UTC().Format("20060102T150405.000") + "-" + c.TurnID + ".json"
+	path := filepath.Join(dir, strings.ReplaceAll(name, string(filepath.Separator), "_"))
+	return path, os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,231 @@
+package cassette
+
+import (
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/logging"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var logger = logging.For("cassette")
+
+// Modes of a Recorder.
+const (
+	ModeOff    = "off"
+	ModeRecord = "record"
+	ModeReplay = "replay"
+)
+
+// Recorder starts the cassettes of turns according to its mode.
+type Recorder struct {
+	mode string
+	dir  string
+
+	// Recorded interactions still to replay, by request
+	mu      sync.Mutex
+	pending map[string][]Interaction
+}
+
+// New creates a recorder from the cassette section of the configuration.
+// In replay mode the cassettes are loaded right away.
+func New(cfg config.CassetteConfig) (*Recorder, error) {
+	r := &Recorder{mode: cfg.Mode, dir: cfg.Dir}
+	if cfg.Mode != ModeReplay {
+		return r, nil
+	}
+
+	cassettes, err := LoadAll(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	r.pending = map[string][]Interaction{}
+	for _, c := range cassettes {
+		for _, i := range c.Interactions {
+			key, err := requestKey(i.Request.Method, i.Request.URL)
+			if err != nil {
+				return nil, fmt.Errorf("cassette of turn %s: %w", c.TurnID, err)
+			}
+			r.pending[key] = append(r.pending[key], i)
+		}
+	}
+	return r, nil
+}
+
+// Setup creates the recorder of cfg. It exits if the cassettes to replay
+// cannot be loaded.
+func Setup(cfg config.CassetteConfig) *Recorder {
+	r, err := New(cfg)
+	if err != nil {
+		logger.Error("failed to set up cassettes", "mode", cfg.Mode, "dir", cfg.Dir, "error", err)
+		os.Exit(1)
+	}
+	if cfg.Mode != ModeOff {
+		logger.Info("cassettes enabled", "mode", cfg.Mode, "dir", cfg.Dir)
+	}
+	return r
+}
+
+type ctxKey struct{}
+
+// tape collects the interactions of one turn while recording.
+type tape struct {
+	recorder *Recorder
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// Start begins the cassette of a turn. Provider calls made with the returned
+// context go through the recorder; finish writes the recording to a file. A
+// nil recorder is off.
+func (r *Recorder) Start(ctx context.Context, turnID string) (_ context.Context, finish func()) {
+	if r == nil || r.mode == ModeOff {
+		return ctx, func() {}
+	}
+
+	t := &tape{recorder: r, cassette: Cassette{TurnID: turnID, RecordedAt: time.Now()}}
+	ctx = context.WithValue(ctx, ctxKey{}, t)
+	if r.mode != ModeRecord {
+		return ctx, func() {}
+	}
+	return ctx, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if len(t.cassette.Interactions) == 0 {
+			return
+		}
+		path, err := t.cassette.Save(r.dir)
+		if err != nil {
+			logger.ErrorContext(ctx, "failed to save cassette", "error", err)
+			return
+		}
+		logger.InfoContext(ctx, "cassette recorded", "path", path, "interactions", len(t.cassette.Interactions))
+	}
+}
+
+// Transport records or replays the requests made within a turn started by
+// Start, and passes all others on to Base.
+type Transport struct {
+	// Base defaults to http.DefaultTransport
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	tp, _ := req.Context().Value(ctxKey{}).(*tape)
+	switch {
+	case tp == nil:
+		return base.RoundTrip(req)
+	case tp.recorder.mode == ModeReplay:
+		return tp.recorder.replay(req)
+	default:
+		return tp.record(base, req)
+	}
+}
+
+func (t *tape) record(base http.RoundTripper, req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	i := Interaction{Request: Request{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Header: scrub(req.Header),
+		Body:   body,
+	}}
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	if err == nil {
+		var respBody []byte
+		respBody, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(respBody))
+		i.Response = &Response{Status: resp.StatusCode, Header: scrub(resp.Header), Body: respBody}
+	}
+	i.Duration = time.Since(start)
+	if err != nil {
+		i.Error = err.Error()
+	}
+
+	t.mu.Lock()
+	t.cassette.Interactions = append(t.cassette.Interactions, i)
+	t.mu.Unlock()
+	return resp, err
+}
+
+// replay answers a request with the next recorded interaction for the same
+// method and path. Hosts are ignored, so sessions recorded against the real
+// providers also replay with a base URL pointing somewhere else.
+func (r *Recorder) replay(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	key, _ := requestKey(req.Method, req.URL.String())
+
+	r.mu.Lock()
+	queue := r.pending[key]
+	if len(queue) == 0 {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("cassette: no recorded response left for %s", key)
+	}
+	i := queue[0]
+	r.pending[key] = queue[1:]
+	r.mu.Unlock()
+
+	logger.DebugContext(req.Context(), "replaying", "request", key)
+	if i.Error != "" {
+		return nil, errors.New(i.Error)
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(i.Response.Status) + " " + http.StatusText(i.Response.Status),
+		StatusCode:    i.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        i.Response.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(i.Response.Body)),
+		ContentLength: int64(len(i.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+// requestKey identifies the requests that replay the same recordings.
+func requestKey(method, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	return method + " " + u.RequestURI(), nil
+}
+
+// Remaining returns the number of recorded interactions not replayed yet.
+func (r *Recorder) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, queue := range r.pending {
+		n += len(queue)
+	}
+	return n
+}
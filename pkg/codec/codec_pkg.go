@@ -0,0 +1,175 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+
+	"anne-hub/pkg/pcm"
+)
+
+// Codec names as sent by devices in the hello message and X-Audio-Codec header.
+const (
+	PCM      = "pcm"
+	IMAADPCM = "ima_adpcm"
+	MuLaw    = "mulaw"
+	Opus     = "opus"
+)
+
+// Decoder turns compressed frames from one device stream into PCM. Decoders
+// may keep state between frames, so use one per stream.
+type Decoder interface {
+	// Decode decodes one frame (one WebSocket message) of compressed audio.
+	Decode(frame []byte) ([]byte, error)
+	// Format returns the PCM format Decode produces.
+	Format() pcm.Format
+}
+
+// Factory creates a decoder for a stream declared with the given format.
+// The format's rate and channel count describe the encoded audio; its bit
+// depth and encoding are ignored.
+type Factory func(format pcm.Format, blockSize int) (Decoder, error)
+
+var registry = map[string]Factory{}
+
+// preference is the order the hub picks codecs in when a device offers several.
+var preference = []string{Opus, IMAADPCM, MuLaw, PCM}
+
+func register(name string, factory Factory) {
+	registry[name] = factory
+}
+
+// Supported lists the codecs this build can decode, most preferred first.
+func Supported() []string {
+	var names []string
+	for _, name := range preference {
+		if name == PCM {
+			names = append(names, name)
+			continue
+		}
+		if _, ok := registry[name]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// IsSupported reports whether the named codec can be decoded.
+func IsSupported(name string) bool {
+	name = Normalize(name)
+	if name == PCM {
+		return true
+	}
+	_, ok := registry[name]
+	return ok
+}
+
+// Normalize maps aliases to canonical codec names. An empty name means PCM.
+func Normalize(name string) string {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "pcm", "raw", "linear16":
+		return PCM
+	case "ima_adpcm", "ima-adpcm", "adpcm":
+		return IMAADPCM
+	case "mulaw", "mu-law", "ulaw", "g711u", "pcmu":
+		return MuLaw
+	case "opus":
+		return Opus
+	default:
+		return strings.ToLower(strings.TrimSpace(name))
+	}
+}
+
+// Negotiate picks the hub's preferred codec among those the device offered.
+// It falls back to PCM, which every device can send.
+func Negotiate(offered []string) string {
+	offeredSet := map[string]bool{}
+	for _, name := range offered {
+		offeredSet[Normalize(name)] = true
+	}
+
+	for _, name := range Supported() {
+		if offeredSet[name] {
+			return name
+		}
+	}
+	return PCM
+}
+
+// NewDecoder creates a decoder for the named codec. blockSize is only used by
+// IMA-ADPCM; zero means every frame is exactly one block.
+func NewDecoder(name string, format pcm.Format, blockSize int) (Decoder, error) {
+	name = Normalize(name)
+	format = format.WithDefaults()
+
+	if name == PCM {
+		return passthrough{format: format}, nil
+	}
+
+	factory, ok := registry[name]
+	if !ok {
+		supported := Supported()
+		sort.Strings(supported)
+		return nil, fmt.Errorf("unsupported codec %q (supported: %s)", name, strings.Join(supported, ", "))
+	}
+	return factory(format, blockSize)
+}
+
+// DecodeBody decodes a complete upload, as received by the HTTP handlers.
+// Opus bodies are a sequence of packets, each prefixed with its length as a
+// big-endian uint16. Other codecs are decoded as one contiguous frame.
+func DecodeBody(name string, format pcm.Format, blockSize int, body []byte) ([]byte, pcm.Format, error) {
+	dec, err := NewDecoder(name, format, blockSize)
+	if err != nil {
+		return nil, pcm.Format{}, err
+	}
+
+	if Normalize(name) != Opus {
+		out, err := dec.Decode(body)
+		return out, dec.Format(), err
+	}
+
+	var out []byte
+	for len(body) > 0 {
+		if len(body) < 2 {
+			return nil, pcm.Format{}, fmt.Errorf("truncated opus packet length")
+		}
+		n := int(binary.BigEndian.Uint16(body))
+		body = body[2:]
+		if n > len(body) {
+			return nil, pcm.Format{}, fmt.Errorf("opus packet of %d bytes exceeds remaining body", n)
+		}
+		decoded, err := dec.Decode(body[:n])
+		if err != nil {
+			return nil, pcm.Format{}, err
+		}
+		out = append(out, decoded...)
+		body = body[n:]
+	}
+	return out, dec.Format(), nil
+}
+
+// passthrough is the decoder for uncompressed PCM.
+type passthrough struct {
+	format pcm.Format
+}
+
+func (p passthrough) Decode(frame []byte) ([]byte, error) {
+	return frame, nil
+}
+
+func (p passthrough) Format() pcm.Format {
+	return p.format
+}
+
+// int16Format returns the 16-bit little-endian PCM format decoders produce.
+func int16Format(format pcm.Format) pcm.Format {
+	return pcm.Format{
+		SampleRate: format.SampleRate,
+		BitDepth:   16,
+		Channels:   format.Channels,
+		Endianness: pcm.LittleEndian,
+		Encoding:   pcm.EncodingPCM,
+	}
+}
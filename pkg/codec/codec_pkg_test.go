@@ -0,0 +1,182 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"slices"
+	"testing"
+
+	"anne-hub/pkg/pcm"
+)
+
+func samples(data []byte) []int16 {
+	out := make([]int16, len(data)/2)
+	binary.Read(bytes.NewReader(data), binary.LittleEndian, out)
+	return out
+}
+
+func TestNormalize(t *testing.T) {
+	for name, want := range map[string]string{
+		"":          PCM,
+		" Linear16": PCM,
+		"IMA-ADPCM": IMAADPCM,
+		"pcmu":      MuLaw,
+		"G711U":     MuLaw,
+		"Opus":      Opus,
+		"FLAC":      "flac",
+	} {
+		if got := Normalize(name); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	if got := Negotiate([]string{"pcm", "ulaw", "adpcm"}); got != IMAADPCM {
+		t.Errorf("Negotiate = %q, want %q", got, IMAADPCM)
+	}
+	if got := Negotiate([]string{"mu-law", "pcm"}); got != MuLaw {
+		t.Errorf("Negotiate = %q, want %q", got, MuLaw)
+	}
+	if got := Negotiate([]string{"flac"}); got != PCM {
+		t.Errorf("Negotiate of unknown codecs = %q, want %q", got, PCM)
+	}
+	if supported := Supported(); supported[len(supported)-1] != PCM || !slices.Contains(supported, MuLaw) {
+		t.Errorf("Supported = %v", supported)
+	}
+}
+
+func TestNewDecoder(t *testing.T) {
+	stereo := pcm.Format{SampleRate: 16000, Channels: 2}
+	if _, err := NewDecoder(IMAADPCM, stereo, 0); err == nil {
+		t.Error("created an ima_adpcm decoder for stereo audio")
+	}
+	if _, err := NewDecoder(IMAADPCM, pcm.M5Format, 4); err == nil {
+		t.Error("created an ima_adpcm decoder for blocks without samples")
+	}
+	if _, err := NewDecoder("flac", pcm.M5Format, 0); err == nil {
+		t.Error("created a decoder for an unsupported codec")
+	}
+
+	dec, err := NewDecoder(MuLaw, pcm.Format{SampleRate: 8000, BitDepth: 8, Channels: 1}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := pcm.Format{SampleRate: 8000, BitDepth: 16, Channels: 1, Endianness: pcm.LittleEndian, Encoding: pcm.EncodingPCM}
+	if dec.Format() != want {
+		t.Errorf("mulaw decodes to %+v, want %+v", dec.Format(), want)
+	}
+}
+
+func TestMuLaw(t *testing.T) {
+	// Reference values of ITU-T G.711
+	out, _, err := DecodeBody("ulaw", pcm.M5Format, 0, []byte{0xff, 0x7f, 0x80, 0x00, 0xfe, 0x7e})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := samples(out), []int16{0, 0, 32124, -32124, 8, -8}; !slices.Equal(got, want) {
+		t.Errorf("decoded %v, want %v", got, want)
+	}
+}
+
+// encodeIMA encodes samples as one IMA-ADPCM block starting at the step
+// index, the way a device does.
+func encodeIMA(samples []int16, index int) []byte {
+	predictor := int(samples[0])
+	block := binary.LittleEndian.AppendUint16(nil, uint16(samples[0]))
+	block = append(block, byte(index), 0)
+
+	var nibbles []byte
+	for _, s := range samples[1:] {
+		step := imaStepTable[index]
+		diff := int(s) - predictor
+		var nibble byte
+		if diff < 0 {
+			nibble = 8
+			diff = -diff
+		}
+		for bit, mask := step, byte(4); mask > 0; bit, mask = bit>>1, mask>>1 {
+			if diff >= bit {
+				nibble |= mask
+				diff -= bit
+			}
+		}
+		predictor, index = imaStep(predictor, index, nibble)
+		nibbles = append(nibbles, nibble)
+	}
+	for i := 0; i < len(nibbles); i += 2 {
+		block = append(block, nibbles[i]|nibbles[i+1]<<4)
+	}
+	return block
+}
+
+func TestIMAADPCM(t *testing.T) {
+	t.Run("steps", func(t *testing.T) {
+		// Starting at 1000 with the smallest step, 7: a zero code moves by
+		// 7/8 (0), 7 up by 7/8+7/4+7/2+7 (11) and raises the step to 16,
+		// 15 moves down by 30 and raises it to 34, 0 moves by 34/8
+		block := []byte{0xe8, 0x03, 0, 0, 0x70, 0x0f}
+		out, _, err := DecodeBody(IMAADPCM, pcm.M5Format, 0, block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := samples(out), []int16{1000, 1000, 1011, 981, 985}; !slices.Equal(got, want) {
+			t.Errorf("decoded %v, want %v", got, want)
+		}
+	})
+
+	t.Run("sine", func(t *testing.T) {
+		in := make([]int16, 1017)
+		for i := range in {
+			in[i] = int16(10000 * math.Sin(2*math.Pi*440*float64(i)/16000))
+		}
+		block := encodeIMA(in, 50)
+		blocks := append(slices.Clone(block), block...)
+
+		out, format, err := DecodeBody(IMAADPCM, pcm.M5Format, len(block), blocks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format.BitDepth != 16 || format.SampleRate != 16000 {
+			t.Errorf("format = %+v", format)
+		}
+		got := samples(out)
+		if len(got) != 2*len(in) {
+			t.Fatalf("decoded %d samples, want %d", len(got), 2*len(in))
+		}
+		for i, s := range got {
+			if d := math.Abs(float64(s) - float64(in[i%len(in)])); d > 600 {
+				t.Fatalf("sample %d = %d, want about %d", i, s, in[i%len(in)])
+			}
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		dec, err := NewDecoder(IMAADPCM, pcm.M5Format, 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dec.Decode(make([]byte, 12)); err == nil {
+			t.Error("decoded a frame of one and a half blocks")
+		}
+		if _, err := dec.Decode([]byte{0, 0, 89, 0, 0, 0, 0, 0}); err == nil {
+			t.Error("decoded a block with an invalid step index")
+		}
+		if out, err := dec.Decode(nil); err != nil || out != nil {
+			t.Errorf("empty frame = %v, %v", out, err)
+		}
+	})
+}
+
+func TestDecodeBodyPCM(t *testing.T) {
+	body := []byte{1, 2, 3, 4}
+	format := pcm.Format{SampleRate: 44100, BitDepth: 24, Channels: 2}
+	out, got, err := DecodeBody("", format, 0, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, body) || got != format.WithDefaults() {
+		t.Errorf("pcm body changed to %v in %+v", out, got)
+	}
+}
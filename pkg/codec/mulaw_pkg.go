@@ -0,0 +1,46 @@
+package codec
+
+import (
+	"encoding/binary"
+
+	"anne-hub/pkg/pcm"
+)
+
+func init() {
+	register(MuLaw, func(format pcm.Format, _ int) (Decoder, error) {
+		return muLawDecoder{format: int16Format(format)}, nil
+	})
+}
+
+// muLawDecoder decodes G.711 mu-law, one byte per sample. It is stateless.
+type muLawDecoder struct {
+	format pcm.Format
+}
+
+func (d muLawDecoder) Format() pcm.Format {
+	return d.format
+}
+
+func (d muLawDecoder) Decode(frame []byte) ([]byte, error) {
+	out := make([]byte, 0, len(frame)*2)
+	for _, b := range frame {
+		out = binary.LittleEndian.AppendUint16(out, uint16(muLawToLinear(b)))
+	}
+	return out, nil
+}
+
+// muLawToLinear expands one mu-law byte to a 16-bit sample (ITU-T G.711).
+func muLawToLinear(b byte) int16 {
+	b = ^b
+	sign := b & 0x80
+	exponent := (b >> 4) & 0x07
+	mantissa := b & 0x0f
+
+	sample := ((int32(mantissa) << 3) + 0x84) << exponent
+	sample -= 0x84
+
+	if sign != 0 {
+		return int16(-sample)
+	}
+	return int16(sample)
+}
@@ -0,0 +1,69 @@
+//go:build opus
+
+package codec
+
+// Opus decoding binds libopus through cgo, so it is only compiled in with
+// `-tags opus` on a system with the opus headers (see Dockerfile).
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"anne-hub/pkg/pcm"
+
+	"gopkg.in/hraban/opus.v2"
+)
+
+func init() {
+	register(Opus, newOpusDecoder)
+}
+
+// opusDecoder decodes one Opus packet per frame.
+type opusDecoder struct {
+	format pcm.Format
+	dec    *opus.Decoder
+	buf    []int16
+}
+
+func newOpusDecoder(format pcm.Format, _ int) (Decoder, error) {
+	switch format.SampleRate {
+	case 8000, 12000, 16000, 24000, 48000:
+	default:
+		return nil, fmt.Errorf("opus does not support a sample rate of %d", format.SampleRate)
+	}
+	if format.Channels != 1 && format.Channels != 2 {
+		return nil, fmt.Errorf("opus only supports mono or stereo audio, got %d channels", format.Channels)
+	}
+
+	dec, err := opus.NewDecoder(format.SampleRate, format.Channels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create opus decoder: %w", err)
+	}
+
+	// Room for the longest packet Opus allows (120 ms)
+	bufSize := format.SampleRate * 120 / 1000 * format.Channels
+
+	return &opusDecoder{
+		format: int16Format(format),
+		dec:    dec,
+		buf:    make([]int16, bufSize),
+	}, nil
+}
+
+func (d *opusDecoder) Format() pcm.Format {
+	return d.format
+}
+
+func (d *opusDecoder) Decode(frame []byte) ([]byte, error) {
+	n, err := d.dec.Decode(frame, d.buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode opus packet: %w", err)
+	}
+
+	samples := d.buf[:n*d.format.Channels]
+	out := make([]byte, 0, len(samples)*2)
+	for _, s := range samples {
+		out = binary.LittleEndian.AppendUint16(out, uint16(s))
+	}
+	return out, nil
+}
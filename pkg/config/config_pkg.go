@@ -0,0 +1,738 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Config is the complete hub configuration. It is loaded once at startup by
+// Load and handed to the packages that need it.
+type Config struct {
+	Server     ServerConfig     `yaml:"server"`
+	Database   DatabaseConfig   `yaml:"database"`
+	Groq       GroqConfig       `yaml:"groq"`
+	ElevenLabs ElevenLabsConfig `yaml:"elevenlabs"`
+	Google     GoogleConfig     `yaml:"google"`
+	Local      LocalConfig      `yaml:"local"`
+	Pipeline   PipelineConfig   `yaml:"pipeline"`
+	Providers  ProvidersConfig  `yaml:"providers"`
+	Storage    StorageConfig    `yaml:"storage"`
+	Audio      AudioConfig      `yaml:"audio"`
+	TTSCache   TTSCacheConfig   `yaml:"tts_cache"`
+	Quota      QuotaConfig      `yaml:"quota"`
+	Pricing    PricingConfig    `yaml:"pricing"`
+	Emotion    EmotionConfig    `yaml:"emotion"`
+	Admin      AdminConfig      `yaml:"admin"`
+	Log        LogConfig        `yaml:"log"`
+	Tracing    TracingConfig    `yaml:"tracing"`
+	Cassette   CassetteConfig   `yaml:"cassette"`
+}
+
+type ServerConfig struct {
+	Addr string `yaml:"addr" env:"SERVER_ADDR"`
+}
+
+type DatabaseConfig struct {
+	// Driver is "postgres" or "sqlite"
+	Driver string `yaml:"driver" env:"DB_DRIVER"`
+	// Path is the database file of the sqlite driver
+	Path string `yaml:"path" env:"DB_PATH"`
+
+	// URL takes precedence over the individual connection fields
+	URL      string `yaml:"url" env:"DATABASE_URL" secret:"url"`
+	Host     string `yaml:"host" env:"DB_HOST"`
+	Port     int    `yaml:"port" env:"DB_PORT"`
+	User     string `yaml:"user" env:"DB_USER,DB_USERNAME"`
+	Password string `yaml:"password" env:"DB_PASS,DB_PASSWORD" secret:"true"`
+	Name     string `yaml:"name" env:"DB_NAME"`
+	SSLMode  string `yaml:"sslmode" env:"DB_SSLMODE"`
+	// Empty uses the migrations embedded in the binary
+	MigrationsPath string `yaml:"migrations_path" env:"MIGRATIONS_PATH"`
+	// Migrate is the startup policy: "auto" applies pending migrations,
+	// "require-current" refuses to start unless they are applied and
+	// "skip" leaves the schema alone
+	Migrate string `yaml:"migrate" env:"DB_MIGRATE"`
+}
+
+// DSN returns the connection string for lib/pq, or for go-sqlite3 with
+// the sqlite driver.
+func (d DatabaseConfig) DSN() string {
+	if d.Driver == "sqlite" {
+		// Foreign keys are off by default in SQLite, and the busy timeout
+		// lets concurrent writers wait for each other instead of failing
+		return "file:" + d.Path + "?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL"
+	}
+	if d.URL != "" {
+		return d.URL
+	}
+	return fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		dsnValue(d.Host), d.Port, dsnValue(d.User), dsnValue(d.Password), dsnValue(d.Name), dsnValue(d.SSLMode),
+	)
+}
+
+// dsnValue quotes a value of a key/value DSN, so that it may be empty or
+// contain spaces and quotes.
+func dsnValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	return "'" + strings.ReplaceAll(v, "'", `\'`) + "'"
+}
+
+type GroqConfig struct {
+	APIKey   string `yaml:"api_key" env:"GROQ_API_KEY" secret:"true"`
+	BaseURL  string `yaml:"base_url" env:"GROQ_BASE_URL"`
+	STTModel string `yaml:"stt_model" env:"GROQ_STT_MODEL"`
+	LLMModel string `yaml:"llm_model" env:"GROQ_LLM_MODEL"`
+	// Timeout bounds each call, retries included
+	Timeout time.Duration `yaml:"timeout" env:"GROQ_TIMEOUT"`
+}
+
+type ElevenLabsConfig struct {
+	APIKey  string        `yaml:"api_key" env:"ELEVENLABS_API_KEY" secret:"true"`
+	BaseURL string        `yaml:"base_url" env:"ELEVENLABS_BASE_URL"`
+	VoiceID string        `yaml:"voice_id" env:"ELEVENLABS_VOICE_ID"`
+	ModelID string        `yaml:"model_id" env:"ELEVENLABS_MODEL_ID"`
+	Timeout time.Duration `yaml:"timeout" env:"ELEVENLABS_TIMEOUT"`
+}
+
+type GoogleConfig struct {
+	// Empty uses Application Default Credentials
+	CredentialsFile string `yaml:"credentials_file" env:"GOOGLE_APPLICATION_CREDENTIALS"`
+	// Endpoint switches the client to the REST transport against this URL,
+	// e.g. the fake provider server. Empty uses the gRPC API.
+	Endpoint string `yaml:"endpoint" env:"GOOGLE_TTS_ENDPOINT"`
+	// Timeout bounds each call, retries included
+	Timeout time.Duration `yaml:"timeout" env:"GOOGLE_TTS_TIMEOUT"`
+}
+
+// LocalConfig is an OpenAI-compatible server used as the "local" provider,
+// e.g. Ollama, LocalAI or a whisper.cpp server.
+type LocalConfig struct {
+	BaseURL  string        `yaml:"base_url" env:"LOCAL_BASE_URL"`
+	APIKey   string        `yaml:"api_key" env:"LOCAL_API_KEY" secret:"true"`
+	STTModel string        `yaml:"stt_model" env:"LOCAL_STT_MODEL"`
+	LLMModel string        `yaml:"llm_model" env:"LOCAL_LLM_MODEL"`
+	TTSModel string        `yaml:"tts_model" env:"LOCAL_TTS_MODEL"`
+	TTSVoice string        `yaml:"tts_voice" env:"LOCAL_TTS_VOICE"`
+	Timeout  time.Duration `yaml:"timeout" env:"LOCAL_TIMEOUT"`
+}
+
+// PipelineConfig lists the providers of each stage of a turn, tried in
+// order until one succeeds. "groq:<model>" and "local:<model>" use another
+// LLM model of the same provider.
+type PipelineConfig struct {
+	STT []string `yaml:"stt" env:"STT_PROVIDERS"`
+	LLM []string `yaml:"llm" env:"LLM_PROVIDERS"`
+	TTS []string `yaml:"tts" env:"TTS_PROVIDERS"`
+}
+
+// Uses reports whether provider is part of any stage, with or without a
+// model.
+func (p PipelineConfig) Uses(provider string) bool {
+	for _, chain := range [][]string{p.STT, p.LLM, p.TTS} {
+		for _, name := range chain {
+			if name == provider || strings.HasPrefix(name, provider+":") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ProvidersConfig is how the provider HTTP clients retry failed
+// requests and when they stop calling a failing provider.
+type ProvidersConfig struct {
+	// Retries after the first attempt for 429, 5xx and network errors
+	MaxRetries int `yaml:"max_retries" env:"PROVIDER_MAX_RETRIES"`
+	// The backoff doubles from RetryBaseDelay up to RetryMaxDelay, with
+	// jitter. A longer Retry-After is not waited for.
+	RetryBaseDelay time.Duration `yaml:"retry_base_delay" env:"PROVIDER_RETRY_BASE_DELAY"`
+	RetryMaxDelay  time.Duration `yaml:"retry_max_delay" env:"PROVIDER_RETRY_MAX_DELAY"`
+	// Consecutive failed attempts that open a provider's circuit, zero
+	// disables the breaker
+	BreakerFailures int `yaml:"breaker_failures" env:"PROVIDER_BREAKER_FAILURES"`
+	// How long an open circuit rejects requests before letting one through
+	BreakerCooldown time.Duration `yaml:"breaker_cooldown" env:"PROVIDER_BREAKER_COOLDOWN"`
+}
+
+type StorageConfig struct {
+	// "local" or "s3"
+	Backend    string   `yaml:"backend" env:"STORAGE_BACKEND"`
+	Dir        string   `yaml:"dir" env:"STORAGE_DIR"`
+	PublicURL  string   `yaml:"public_url" env:"STORAGE_PUBLIC_URL"`
+	SigningKey string   `yaml:"signing_key" env:"STORAGE_SIGNING_KEY" secret:"true"`
+	S3         S3Config `yaml:"s3"`
+}
+
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint" env:"S3_ENDPOINT"`
+	Bucket    string `yaml:"bucket" env:"S3_BUCKET"`
+	AccessKey string `yaml:"access_key" env:"S3_ACCESS_KEY" secret:"true"`
+	SecretKey string `yaml:"secret_key" env:"S3_SECRET_KEY" secret:"true"`
+	Region    string `yaml:"region" env:"S3_REGION"`
+	UseSSL    bool   `yaml:"use_ssl" env:"S3_USE_SSL"`
+}
+
+type AudioConfig struct {
+	// Zero keeps audio forever
+	RetentionDays int           `yaml:"retention_days" env:"AUDIO_RETENTION_DAYS"`
+	PurgeInterval time.Duration `yaml:"purge_interval" env:"AUDIO_PURGE_INTERVAL"`
+	// Longer turns are answered with a too_long error frame
+	MaxTurnDuration time.Duration `yaml:"max_turn_duration" env:"AUDIO_MAX_TURN_DURATION"`
+	// Error frames carry a spoken apology, rendered once per language
+	SpokenErrors bool `yaml:"spoken_errors" env:"AUDIO_SPOKEN_ERRORS"`
+	// Prosody analyzes how requests are spoken, for the prompt and history
+	Prosody bool `yaml:"prosody" env:"AUDIO_PROSODY"`
+}
+
+type TTSCacheConfig struct {
+	// Synthesized speech is kept in blob storage and reused for the same
+	// text, voice and language
+	Enabled bool `yaml:"enabled" env:"TTS_CACHE_ENABLED"`
+	// Least recently used entries are evicted above MaxBytes
+	MaxBytes int `yaml:"max_bytes" env:"TTS_CACHE_MAX_BYTES"`
+}
+
+// QuotaConfig limits how much each user and device may talk to Anne, by the
+// plan of the user.
+type QuotaConfig struct {
+	Enabled bool `yaml:"enabled" env:"QUOTA_ENABLED"`
+	// DefaultPlan applies to users without a plan, or with an unknown one
+	DefaultPlan string `yaml:"default_plan" env:"QUOTA_DEFAULT_PLAN"`
+	// Plans by name, set in the config file
+	Plans map[string]PlanConfig `yaml:"plans"`
+}
+
+// PlanConfig holds the limits of a plan, zero leaves a limit off. Minutes
+// and days are UTC.
+type PlanConfig struct {
+	// Turns one device may start per minute
+	DeviceTurnsPerMinute int `yaml:"device_turns_per_minute"`
+	// Turns all devices of a user may start per minute
+	UserTurnsPerMinute int `yaml:"user_turns_per_minute"`
+	// Seconds of request audio per user and day
+	AudioSecondsPerDay int `yaml:"audio_seconds_per_day"`
+	// LLM tokens per user and day
+	TokensPerDay int `yaml:"tokens_per_day"`
+}
+
+// PricingConfig is the price table usage costs are estimated with, in USD.
+// Prices are keyed by provider, as named in the pipeline chains, or by
+// "provider/model" to price one model of a provider differently.
+type PricingConfig struct {
+	// Per hour of transcribed audio
+	STT map[string]float64 `yaml:"stt"`
+	// Per million prompt and completion tokens
+	LLM map[string]TokenPrice `yaml:"llm"`
+	// Per million synthesized characters
+	TTS map[string]float64 `yaml:"tts"`
+}
+
+type TokenPrice struct {
+	Prompt     float64 `yaml:"prompt"`
+	Completion float64 `yaml:"completion"`
+}
+
+// EmotionConfig holds the emotions devices can show, by firmware version,
+// and how replies switch between them.
+type EmotionConfig struct {
+	// MinHold is how long a reply's emotion is shown before the pipeline's
+	// idle, listening and thinking states may replace it
+	MinHold time.Duration `yaml:"min_hold" env:"EMOTION_MIN_HOLD"`
+	// Aliases map emotions the LLM may answer with to catalog emotions
+	Aliases map[string]string `yaml:"aliases"`
+	// Catalogs by the lowest firmware version they apply to, "default" for
+	// older devices and those not sending their version
+	Catalogs map[string]EmotionCatalog `yaml:"catalogs"`
+}
+
+type EmotionCatalog struct {
+	// Emotions the LLM may pick from
+	Emotions []string `yaml:"emotions"`
+	// Fallback is shown for emotions matching none, the first one if empty
+	Fallback string `yaml:"fallback"`
+	// Animations of the idle, listening and thinking states; states without
+	// one are not sent
+	States map[string]string `yaml:"states"`
+}
+
+type AdminConfig struct {
+	// Bearer token for the /admin routes, which are disabled while it is empty
+	Token string `yaml:"token" env:"ADMIN_TOKEN" secret:"true"`
+}
+
+type LogConfig struct {
+	// debug, info, warn or error
+	Level string `yaml:"level" env:"LOG_LEVEL"`
+	// Per-package overrides of Level, e.g. "groq=debug,db=warn" in the environment
+	Levels map[string]string `yaml:"levels" env:"LOG_LEVELS"`
+	// "text" or "json"
+	Format string `yaml:"format" env:"LOG_FORMAT"`
+	// How transcripts and LLM output are logged: "redact", "hash" or "full"
+	Transcripts string `yaml:"transcripts" env:"LOG_TRANSCRIPTS"`
+	// How names, emails and similar user details are logged: "redact", "hash" or "full"
+	PII string `yaml:"pii" env:"LOG_PII"`
+}
+
+type TracingConfig struct {
+	// OTLP/HTTP collector URL, e.g. http://localhost:4318. Empty disables export,
+	// trace ids are still generated for logs and devices.
+	Endpoint    string `yaml:"endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	ServiceName string `yaml:"service_name" env:"OTEL_SERVICE_NAME"`
+}
+
+type CassetteConfig struct {
+	// "off", "record" writes the provider calls of every turn to a file in
+	// Dir, "replay" answers them from the cassettes in Dir
+	Mode string `yaml:"mode" env:"CASSETTE_MODE"`
+	// Directory of the cassettes, or a single cassette file for replay
+	Dir string `yaml:"dir" env:"CASSETTE_DIR"`
+}
+
+// Defaults returns the configuration used for anything not set in the
+// config file or environment.
+func Defaults() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr: ":1323",
+		},
+		Database: DatabaseConfig{
+			Driver:  "postgres",
+			Path:    "anne-hub.db",
+			Port:    5432,
+			SSLMode: "disable",
+			Migrate: "auto",
+		},
+		Groq: GroqConfig{
+			BaseURL:  "https://api.groq.com/openai/v1",
+			STTModel: "whisper-large-v3-turbo",
+			LLMModel: "llama-3.1-70b-versatile",
+			Timeout:  30 * time.Second,
+		},
+		ElevenLabs: ElevenLabsConfig{
+			BaseURL: "https://api.elevenlabs.io/v1",
+			VoiceID: "cgSgspJ2msm6clMCkdW9",
+			ModelID: "eleven_monolingual_v1",
+			Timeout: 30 * time.Second,
+		},
+		Google: GoogleConfig{
+			Timeout: 30 * time.Second,
+		},
+		Local: LocalConfig{
+			BaseURL:  "http://localhost:11434/v1",
+			STTModel: "whisper-1",
+			LLMModel: "llama3.1:8b",
+			TTSModel: "tts-1",
+			TTSVoice: "alloy",
+			Timeout:  60 * time.Second,
+		},
+		Pipeline: PipelineConfig{
+			STT: []string{"groq"},
+			LLM: []string{"groq"},
+			TTS: []string{"elevenlabs"},
+		},
+		Providers: ProvidersConfig{
+			MaxRetries:      2,
+			RetryBaseDelay:  250 * time.Millisecond,
+			RetryMaxDelay:   5 * time.Second,
+			BreakerFailures: 5,
+			BreakerCooldown: 30 * time.Second,
+		},
+		Storage: StorageConfig{
+			Backend: "local",
+			Dir:     "data",
+			S3: S3Config{
+				UseSSL: true,
+			},
+		},
+		Audio: AudioConfig{
+			RetentionDays:   30,
+			PurgeInterval:   time.Hour,
+			MaxTurnDuration: time.Minute,
+			SpokenErrors:    true,
+			Prosody:         true,
+		},
+		TTSCache: TTSCacheConfig{
+			Enabled:  true,
+			MaxBytes: 256 << 20,
+		},
+		Quota: QuotaConfig{
+			Enabled:     true,
+			DefaultPlan: "standard",
+			Plans: map[string]PlanConfig{
+				"standard": {
+					DeviceTurnsPerMinute: 6,
+					UserTurnsPerMinute:   10,
+					AudioSecondsPerDay:   3600,
+					TokensPerDay:         200000,
+				},
+				"unlimited": {},
+			},
+		},
+		Pricing: PricingConfig{
+			STT: map[string]float64{
+				"groq":  0.04,
+				"local": 0,
+			},
+			LLM: map[string]TokenPrice{
+				"groq":                         {Prompt: 0.59, Completion: 0.79},
+				"groq/llama-3.1-8b-instant":    {Prompt: 0.05, Completion: 0.08},
+				"groq/llama-3.3-70b-versatile": {Prompt: 0.59, Completion: 0.79},
+				"local":                        {},
+			},
+			TTS: map[string]float64{
+				"elevenlabs": 300,
+				"google":     160,
+				"local":      0,
+			},
+		},
+		Emotion: EmotionConfig{
+			MinHold: 2 * time.Second,
+			Aliases: map[string]string{
+				"happy":       "cute_smile",
+				"smile":       "cute_smile",
+				"friendly":    "cute_smile",
+				"love":        "cute_smile",
+				"neutral":     "cute_smile",
+				"calm":        "cute_smile",
+				"joy":         "celebration",
+				"excited":     "celebration",
+				"proud":       "celebration",
+				"celebrate":   "celebration",
+				"playful":     "lucky_smile",
+				"lucky":       "lucky_smile",
+				"wink":        "lucky_smile",
+				"mischievous": "lucky_smile",
+				"curious":     "curiosity",
+				"interested":  "curiosity",
+				"thoughtful":  "curiosity",
+				"sleepy":      "sleep",
+				"tired":       "sleep",
+				"bored":       "sleep",
+				"surprise":    "surprised",
+				"amazed":      "surprised",
+				"shocked":     "surprised",
+				"skeptical":   "suspicious",
+				"doubtful":    "suspicious",
+				"puzzled":     "confused",
+				"unsure":      "confused",
+				"worried":     "confused",
+			},
+			Catalogs: map[string]EmotionCatalog{
+				"default": {
+					Emotions: []string{"celebration", "suspicious", "cute_smile", "curiosity", "confused", "sleep", "lucky_smile", "surprised"},
+					Fallback: "cute_smile",
+				},
+			},
+		},
+		Log: LogConfig{
+			Level:       "info",
+			Format:      "text",
+			Transcripts: "redact",
+			PII:         "redact",
+		},
+		Tracing: TracingConfig{
+			ServiceName: "anne-hub",
+		},
+		Cassette: CassetteConfig{
+			Mode: "off",
+			Dir:  "cassettes",
+		},
+	}
+}
+
+// Load builds the configuration from defaults, then the optional file at
+// path (YAML or TOML, by extension), then environment variables, and
+// validates the result.
+func Load(path string) (*Config, error) {
+	cfg := Defaults()
+
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := loadEnv(&cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.Database.MigrationsPath != "" {
+		abs, err := filepath.Abs(cfg.Database.MigrationsPath)
+		if err == nil {
+			cfg.Database.MigrationsPath = abs
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate reports every problem with the configuration at once.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Server.Addr == "" {
+		fail("server.addr (SERVER_ADDR) must not be empty")
+	}
+
+	switch {
+	case c.Database.Driver == "sqlite":
+		if c.Database.Path == "" {
+			fail("database.path (DB_PATH) is required for the sqlite driver")
+		}
+	case c.Database.Driver != "postgres":
+		fail("database.driver (DB_DRIVER) must be \"postgres\" or \"sqlite\", got %q", c.Database.Driver)
+	case c.Database.URL != "":
+		if _, err := url.Parse(c.Database.URL); err != nil {
+			fail("database.url (DATABASE_URL) is not a valid URL: %v", err)
+		}
+	default:
+		if c.Database.Host == "" {
+			fail("database.host (DB_HOST) is required unless DATABASE_URL is set")
+		}
+		if c.Database.Name == "" {
+			fail("database.name (DB_NAME) is required unless DATABASE_URL is set")
+		}
+		if c.Database.User == "" {
+			fail("database.user (DB_USER) is required unless DATABASE_URL is set")
+		}
+		if c.Database.Port <= 0 || c.Database.Port > 65535 {
+			fail("database.port (DB_PORT) must be between 1 and 65535, got %d", c.Database.Port)
+		}
+	}
+	if c.Database.MigrationsPath != "" {
+		if info, err := os.Stat(c.Database.MigrationsPath); err != nil || !info.IsDir() {
+			fail("database.migrations_path (MIGRATIONS_PATH) %q is not a directory", c.Database.MigrationsPath)
+		}
+	}
+	switch c.Database.Migrate {
+	case "auto", "require-current", "skip":
+	default:
+		fail("database.migrate (DB_MIGRATE) must be \"auto\", \"require-current\" or \"skip\", got %q", c.Database.Migrate)
+	}
+
+	if c.Groq.APIKey == "" && c.Pipeline.Uses("groq") {
+		fail("groq.api_key (GROQ_API_KEY) is required")
+	}
+	if _, err := url.ParseRequestURI(c.Groq.BaseURL); err != nil {
+		fail("groq.base_url (GROQ_BASE_URL) is not a valid URL: %q", c.Groq.BaseURL)
+	}
+	if c.Groq.Timeout <= 0 {
+		fail("groq.timeout (GROQ_TIMEOUT) must be positive")
+	}
+
+	if c.ElevenLabs.APIKey == "" && c.Pipeline.Uses("elevenlabs") {
+		fail("elevenlabs.api_key (ELEVENLABS_API_KEY) is required")
+	}
+	if _, err := url.ParseRequestURI(c.ElevenLabs.BaseURL); err != nil {
+		fail("elevenlabs.base_url (ELEVENLABS_BASE_URL) is not a valid URL: %q", c.ElevenLabs.BaseURL)
+	}
+	if c.ElevenLabs.Timeout <= 0 {
+		fail("elevenlabs.timeout (ELEVENLABS_TIMEOUT) must be positive")
+	}
+
+	if c.Google.Endpoint != "" {
+		if u, err := url.Parse(c.Google.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("google.endpoint (GOOGLE_TTS_ENDPOINT) must be an http(s) URL, got %q", c.Google.Endpoint)
+		}
+	}
+	if c.Google.Timeout <= 0 {
+		fail("google.timeout (GOOGLE_TTS_TIMEOUT) must be positive")
+	}
+
+	stages := []struct {
+		name, env string
+		chain     []string
+		known     []string
+	}{
+		{"stt", "STT_PROVIDERS", c.Pipeline.STT, []string{"groq", "local"}},
+		{"llm", "LLM_PROVIDERS", c.Pipeline.LLM, []string{"groq", "local"}},
+		{"tts", "TTS_PROVIDERS", c.Pipeline.TTS, []string{"elevenlabs", "google", "local"}},
+	}
+	for _, stage := range stages {
+		if len(stage.chain) == 0 {
+			fail("pipeline.%s (%s) must list at least one provider", stage.name, stage.env)
+		}
+		for _, name := range stage.chain {
+			provider, model, hasModel := strings.Cut(name, ":")
+			switch {
+			case !slices.Contains(stage.known, provider):
+				fail("pipeline.%s (%s) has unknown provider %q, expected one of %s", stage.name, stage.env, name, strings.Join(stage.known, ", "))
+			case hasModel && (stage.name != "llm" || model == ""):
+				fail("pipeline.%s (%s) provider %q: only LLM providers take a model", stage.name, stage.env, name)
+			}
+		}
+	}
+	if c.Pipeline.Uses("local") {
+		if _, err := url.ParseRequestURI(c.Local.BaseURL); err != nil {
+			fail("local.base_url (LOCAL_BASE_URL) is not a valid URL: %q", c.Local.BaseURL)
+		}
+		if c.Local.Timeout <= 0 {
+			fail("local.timeout (LOCAL_TIMEOUT) must be positive")
+		}
+	}
+
+	if c.Providers.MaxRetries < 0 {
+		fail("providers.max_retries (PROVIDER_MAX_RETRIES) must not be negative")
+	}
+	if c.Providers.RetryBaseDelay <= 0 || c.Providers.RetryMaxDelay < c.Providers.RetryBaseDelay {
+		fail("providers.retry_base_delay (PROVIDER_RETRY_BASE_DELAY) must be positive and at most providers.retry_max_delay (PROVIDER_RETRY_MAX_DELAY)")
+	}
+	if c.Providers.BreakerFailures < 0 {
+		fail("providers.breaker_failures (PROVIDER_BREAKER_FAILURES) must not be negative")
+	}
+	if c.Providers.BreakerFailures > 0 && c.Providers.BreakerCooldown <= 0 {
+		fail("providers.breaker_cooldown (PROVIDER_BREAKER_COOLDOWN) must be positive")
+	}
+
+	switch c.Storage.Backend {
+	case "local":
+		if c.Storage.Dir == "" {
+			fail("storage.dir (STORAGE_DIR) must not be empty")
+		}
+	case "s3":
+		if c.Storage.S3.Endpoint == "" {
+			fail("storage.s3.endpoint (S3_ENDPOINT) is required for the s3 backend")
+		}
+		if c.Storage.S3.Bucket == "" {
+			fail("storage.s3.bucket (S3_BUCKET) is required for the s3 backend")
+		}
+	default:
+		fail("storage.backend (STORAGE_BACKEND) must be \"local\" or \"s3\", got %q", c.Storage.Backend)
+	}
+
+	if c.Audio.RetentionDays < 0 {
+		fail("audio.retention_days (AUDIO_RETENTION_DAYS) must not be negative")
+	}
+	if c.Audio.PurgeInterval <= 0 {
+		fail("audio.purge_interval (AUDIO_PURGE_INTERVAL) must be positive")
+	}
+	if c.Audio.MaxTurnDuration <= 0 {
+		fail("audio.max_turn_duration (AUDIO_MAX_TURN_DURATION) must be positive")
+	}
+
+	if c.TTSCache.MaxBytes <= 0 {
+		fail("tts_cache.max_bytes (TTS_CACHE_MAX_BYTES) must be positive")
+	}
+
+	if c.Quota.Enabled {
+		if _, ok := c.Quota.Plans[c.Quota.DefaultPlan]; !ok {
+			fail("quota.default_plan (QUOTA_DEFAULT_PLAN) %q is not one of quota.plans", c.Quota.DefaultPlan)
+		}
+	}
+	for name, plan := range c.Quota.Plans {
+		if plan.DeviceTurnsPerMinute < 0 || plan.UserTurnsPerMinute < 0 || plan.AudioSecondsPerDay < 0 || plan.TokensPerDay < 0 {
+			fail("quota.plans.%s must not have negative limits", name)
+		}
+	}
+
+	for name, price := range c.Pricing.STT {
+		if price < 0 {
+			fail("pricing.stt.%s must not be negative", name)
+		}
+	}
+	for name, price := range c.Pricing.LLM {
+		if price.Prompt < 0 || price.Completion < 0 {
+			fail("pricing.llm.%s must not be negative", name)
+		}
+	}
+	for name, price := range c.Pricing.TTS {
+		if price < 0 {
+			fail("pricing.tts.%s must not be negative", name)
+		}
+	}
+
+	if c.Emotion.MinHold < 0 {
+		fail("emotion.min_hold (EMOTION_MIN_HOLD) must not be negative")
+	}
+	if _, ok := c.Emotion.Catalogs["default"]; !ok {
+		fail("emotion.catalogs must have a \"default\" catalog")
+	}
+	for version, catalog := range c.Emotion.Catalogs {
+		if version != "default" && !validVersion(version) {
+			fail("emotion.catalogs.%s: key must be \"default\" or a firmware version like 2.1", version)
+		}
+		if len(catalog.Emotions) == 0 {
+			fail("emotion.catalogs.%s.emotions must not be empty", version)
+		}
+		if catalog.Fallback != "" && !slices.Contains(catalog.Emotions, catalog.Fallback) {
+			fail("emotion.catalogs.%s.fallback %q is not one of its emotions", version, catalog.Fallback)
+		}
+		for state, animation := range catalog.States {
+			if state != "idle" && state != "listening" && state != "thinking" {
+				fail("emotion.catalogs.%s.states has unknown state %q, expected idle, listening or thinking", version, state)
+			}
+			if animation == "" {
+				fail("emotion.catalogs.%s.states.%s must not be empty", version, state)
+			}
+		}
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		fail("log.level (LOG_LEVEL) %q is not one of debug, info, warn, error", c.Log.Level)
+	}
+	for pkg, l := range c.Log.Levels {
+		if err := level.UnmarshalText([]byte(l)); err != nil {
+			fail("log.levels (LOG_LEVELS) %q for %s is not one of debug, info, warn, error", l, pkg)
+		}
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		fail("log.format (LOG_FORMAT) must be \"text\" or \"json\", got %q", c.Log.Format)
+	}
+	if !validRedaction(c.Log.Transcripts) {
+		fail("log.transcripts (LOG_TRANSCRIPTS) must be \"redact\", \"hash\" or \"full\", got %q", c.Log.Transcripts)
+	}
+	if !validRedaction(c.Log.PII) {
+		fail("log.pii (LOG_PII) must be \"redact\", \"hash\" or \"full\", got %q", c.Log.PII)
+	}
+
+	if c.Tracing.Endpoint != "" {
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("tracing.endpoint (OTEL_EXPORTER_OTLP_ENDPOINT) must be an http(s) URL, got %q", c.Tracing.Endpoint)
+		}
+	}
+
+	switch c.Cassette.Mode {
+	case "off":
+	case "record", "replay":
+		if c.Cassette.Dir == "" {
+			fail("cassette.dir (CASSETTE_DIR) is required to %s cassettes", c.Cassette.Mode)
+		}
+	default:
+		fail("cassette.mode (CASSETTE_MODE) must be \"off\", \"record\" or \"replay\", got %q", c.Cassette.Mode)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func validRedaction(policy string) bool {
+	return policy == "redact" || policy == "hash" || policy == "full"
+}
+
+// validVersion reports whether version is a firmware version like "2" or
+// "v2.1.3".
+func validVersion(version string) bool {
+	for _, part := range strings.Split(strings.TrimPrefix(version, "v"), ".") {
+		if part == "" || strings.Trim(part, "0123456789") != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,287 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv hides the variables of the configuration set where the tests
+// run, as empty ones are ignored.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	cfg := Defaults()
+	walk(reflect.ValueOf(&cfg).Elem(), func(field reflect.StructField, _ reflect.Value) error {
+		for _, name := range strings.Split(field.Tag.Get("env"), ",") {
+			if name != "" {
+				t.Setenv(name, "")
+			}
+		}
+		return nil
+	})
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const yamlConfig = `
+database:
+  driver: sqlite
+  path: /var/lib/anne/hub.db
+groq:
+  api_key: from-file
+  llm_model: llama-3.1-8b-instant
+  timeout: 10s
+elevenlabs:
+  api_key: from-file
+pipeline:
+  llm: [groq, "local:llama3.1:8b"]
+log:
+  levels:
+    handlers: debug
+`
+
+const tomlConfig = `
+[database]
+driver = "sqlite"
+path = "/var/lib/anne/hub.db"
+
+[groq]
+api_key = "from-file"
+llm_model = "llama-3.1-8b-instant"
+timeout = "10s"
+
+[elevenlabs]
+api_key = "from-file"
+
+[pipeline]
+llm = ["groq", "local:llama3.1:8b"]
+
+[log.levels]
+handlers = "debug"
+`
+
+func TestLoadFile(t *testing.T) {
+	for name, content := range map[string]string{"anne.yaml": yamlConfig, "anne.toml": tomlConfig} {
+		t.Run(name, func(t *testing.T) {
+			clearEnv(t)
+			cfg, err := Load(writeFile(t, name, content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Database.Driver != "sqlite" || cfg.Database.Path != "/var/lib/anne/hub.db" {
+				t.Errorf("database = %+v", cfg.Database)
+			}
+			if cfg.Groq.LLMModel != "llama-3.1-8b-instant" || cfg.Groq.Timeout != 10*time.Second {
+				t.Errorf("groq = %+v", cfg.Groq)
+			}
+			if !slices.Equal(cfg.Pipeline.LLM, []string{"groq", "local:llama3.1:8b"}) {
+				t.Errorf("pipeline.llm = %v", cfg.Pipeline.LLM)
+			}
+			if cfg.Log.Levels["handlers"] != "debug" {
+				t.Errorf("log.levels = %v", cfg.Log.Levels)
+			}
+			// Defaults stay for what the file does not set
+			if cfg.Groq.STTModel != "whisper-large-v3-turbo" || cfg.Server.Addr != ":1323" {
+				t.Errorf("defaults lost: groq.stt_model %q, server.addr %q", cfg.Groq.STTModel, cfg.Server.Addr)
+			}
+		})
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("GROQ_API_KEY", "from-env")
+	t.Setenv("ELEVENLABS_API_KEY", "from-env")
+	t.Setenv("GROQ_TIMEOUT", "45s")
+	t.Setenv("DB_HOST", "db")
+	t.Setenv("DB_NAME", "anne")
+	t.Setenv("DB_USERNAME", "anne")
+	t.Setenv("DB_PORT", "6543")
+	t.Setenv("TTS_PROVIDERS", "google, local,")
+	t.Setenv("LOG_LEVELS", "handlers=debug, db=warn")
+	t.Setenv("QUOTA_ENABLED", "false")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Groq.APIKey != "from-env" || cfg.Groq.Timeout != 45*time.Second {
+		t.Errorf("groq = %+v", cfg.Groq)
+	}
+	if cfg.Database.User != "anne" || cfg.Database.Port != 6543 {
+		t.Errorf("database = %+v", cfg.Database)
+	}
+	if !slices.Equal(cfg.Pipeline.TTS, []string{"google", "local"}) {
+		t.Errorf("pipeline.tts = %v", cfg.Pipeline.TTS)
+	}
+	if cfg.Log.Levels["handlers"] != "debug" || cfg.Log.Levels["db"] != "warn" {
+		t.Errorf("log.levels = %v", cfg.Log.Levels)
+	}
+	if cfg.Quota.Enabled {
+		t.Error("QUOTA_ENABLED=false left quotas enabled")
+	}
+
+	// The environment overrides the file
+	t.Setenv("GROQ_LLM_MODEL", "llama-3.3-70b-versatile")
+	cfg, err = Load(writeFile(t, "anne.yaml", yamlConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Groq.LLMModel != "llama-3.3-70b-versatile" || cfg.Groq.APIKey != "from-env" {
+		t.Errorf("groq = %+v, want the environment's model and key", cfg.Groq)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	clearEnv(t)
+	for name, path := range map[string]string{
+		"missing file":   filepath.Join(t.TempDir(), "missing.yaml"),
+		"unknown key":    writeFile(t, "anne.yaml", "groq:\n  api_kee: typo\n"),
+		"bad duration":   writeFile(t, "anne.yaml", "groq:\n  timeout: soon\n"),
+		"bad toml":       writeFile(t, "anne.toml", "[groq\n"),
+		"json extension": writeFile(t, "anne.json", "{}"),
+	} {
+		if _, err := Load(path); err == nil {
+			t.Errorf("%s: loaded", name)
+		}
+	}
+
+	t.Setenv("DB_PORT", "fifty")
+	if _, err := Load(""); err == nil || !strings.Contains(err.Error(), "DB_PORT") {
+		t.Errorf("invalid DB_PORT: %v, want an error naming it", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := Defaults()
+	valid.Database.Driver = "sqlite"
+	valid.Groq.APIKey = "key"
+	valid.ElevenLabs.APIKey = "key"
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("valid configuration: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		change func(*Config)
+		want   []string
+	}{
+		{"defaults need keys and a database", func(c *Config) { *c = Defaults() }, []string{"GROQ_API_KEY", "ELEVENLABS_API_KEY", "DB_HOST", "DB_NAME", "DB_USER"}},
+		{"postgres url", func(c *Config) { c.Database = Defaults().Database; c.Database.URL = "postgres://db/anne" }, nil},
+		{"unknown providers", func(c *Config) { c.Pipeline.STT = []string{"whisper"}; c.Pipeline.TTS = nil }, []string{"STT_PROVIDERS", "TTS_PROVIDERS"}},
+		{"model of a tts provider", func(c *Config) { c.Pipeline.TTS = []string{"google:wavenet"} }, []string{"only LLM providers take a model"}},
+		{"keys of unused providers", func(c *Config) {
+			c.Groq.APIKey = ""
+			c.Pipeline.STT = []string{"local"}
+			c.Pipeline.LLM = []string{"local"}
+		}, nil},
+		{"google timeout", func(c *Config) { c.Google.Timeout = 0 }, []string{"GOOGLE_TTS_TIMEOUT"}},
+		{"retry delays", func(c *Config) { c.Providers.RetryMaxDelay = time.Millisecond }, []string{"PROVIDER_RETRY_BASE_DELAY"}},
+		{"s3 without bucket", func(c *Config) { c.Storage.Backend = "s3" }, []string{"S3_ENDPOINT", "S3_BUCKET"}},
+		{"unknown default plan", func(c *Config) { c.Quota.DefaultPlan = "gold" }, []string{"QUOTA_DEFAULT_PLAN"}},
+		{"disabled quotas", func(c *Config) { c.Quota.Enabled = false; c.Quota.DefaultPlan = "gold" }, nil},
+		{"negative price", func(c *Config) { c.Pricing.TTS = map[string]float64{"google": -1} }, []string{"pricing.tts.google"}},
+		{"emotion catalogs", func(c *Config) {
+			c.Emotion.Catalogs = map[string]EmotionCatalog{
+				"2.x": {Emotions: []string{"cute_smile"}, Fallback: "angry", States: map[string]string{"sleeping": "sleep"}},
+			}
+		}, []string{"\"default\" catalog", "firmware version", "fallback \"angry\"", "unknown state \"sleeping\""}},
+		{"log levels", func(c *Config) { c.Log.Levels = map[string]string{"db": "loud"} }, []string{"LOG_LEVELS"}},
+		{"cassette mode", func(c *Config) { c.Cassette.Mode = "rewind" }, []string{"CASSETTE_MODE"}},
+		{"cassette dir", func(c *Config) { c.Cassette = CassetteConfig{Mode: "replay"} }, []string{"CASSETTE_DIR"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := valid
+			tc.change(&cfg)
+			err := cfg.Validate()
+			if len(tc.want) == 0 {
+				if err != nil {
+					t.Errorf("Validate: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Validate accepted the configuration")
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error does not mention %q:\n%v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Defaults()
+	cfg.Groq.APIKey = "gsk_secret"
+	cfg.Database.Password = "hunter2"
+	cfg.Storage.S3.AccessKey = "minio"
+	cfg.Database.URL = "postgres://anne:hunter2@db:5432/anne?sslmode=verify-full&sslpassword=hunter3"
+	cfg.Local.APIKey = ""
+
+	out := cfg.Redacted()
+	if out.Groq.APIKey != redacted || out.Database.Password != redacted || out.Storage.S3.AccessKey != redacted {
+		t.Errorf("secrets not masked: groq %q, database %q, s3 %q", out.Groq.APIKey, out.Database.Password, out.Storage.S3.AccessKey)
+	}
+	if out.Local.APIKey != "" {
+		t.Errorf("empty secret became %q", out.Local.APIKey)
+	}
+	if cfg.Groq.APIKey != "gsk_secret" {
+		t.Error("Redacted changed the configuration itself")
+	}
+	if strings.Contains(out.Database.URL, "hunter") || !strings.Contains(out.Database.URL, "anne:xxxxx@db:5432") {
+		t.Errorf("database url = %q", out.Database.URL)
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	for raw, want := range map[string]string{
+		"":                           "",
+		"postgres://db/anne":         "postgres://db/anne",
+		"postgres://anne@db/anne":    "postgres://anne@db/anne",
+		"postgres://anne:pw@db/anne": "postgres://anne:xxxxx@db/anne",
+		"postgres://db/anne?password=pw&sslmode=disable": "postgres://db/anne?password=xxxxx&sslmode=disable",
+		"host=db user=anne password=pw dbname=anne":      "host=db user=anne password=xxxxx dbname=anne",
+		"host=db password = 'p w\\' x' sslpassword=pw2":  "host=db password = xxxxx sslpassword=xxxxx",
+		"postgres://anne:pw@db:port/anne":                redacted,
+	} {
+		if got := redactURL(raw); got != want {
+			t.Errorf("redactURL(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestDSN(t *testing.T) {
+	d := DatabaseConfig{Host: "db", Port: 5432, User: "anne", Password: "pw", Name: "anne", SSLMode: "disable"}
+	if got, want := d.DSN(), "host='db' port=5432 user='anne' password='pw' dbname='anne' sslmode='disable'"; got != want {
+		t.Errorf("DSN = %q, want %q", got, want)
+	}
+	d.Password = `it's a \ secret`
+	if got, want := d.DSN(), `password='it\'s a \\ secret'`; !strings.Contains(got, want) {
+		t.Errorf("DSN = %q, want %q", got, want)
+	}
+	d.Password = ""
+	if got, want := d.DSN(), "password='' "; !strings.Contains(got, want) {
+		t.Errorf("DSN = %q, want %q", got, want)
+	}
+	d.URL = "postgres://db/anne"
+	if got := d.DSN(); got != d.URL {
+		t.Errorf("DSN = %q, want the URL", got)
+	}
+	d.Driver, d.Path = "sqlite", "hub.db"
+	if got := d.DSN(); !strings.HasPrefix(got, "file:hub.db?") || !strings.Contains(got, "_foreign_keys=on") {
+		t.Errorf("sqlite DSN = %q", got)
+	}
+}
@@ -0,0 +1,189 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// loadFile merges a YAML (.yaml, .yml) or TOML (.toml) file into cfg. Keys
+// are the yaml tag names in both formats.
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+	case ".toml":
+		// Decode generically and re-encode, so the yaml tags (and duration
+		// parsing) apply to both formats
+		var raw map[string]any
+		if err := toml.Unmarshal(data, &raw); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if data, err = yaml.Marshal(raw); err != nil {
+			return fmt.Errorf("failed to convert %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("unsupported config file extension %q, use .yaml, .yml or .toml", ext)
+	}
+
+	dec := yaml.NewDecoder(strings.NewReader(string(data)))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv overrides fields that have an env tag with the first of the
+// comma-separated variables that is set.
+func loadEnv(cfg *Config) error {
+	return walk(reflect.ValueOf(cfg).Elem(), func(field reflect.StructField, v reflect.Value) error {
+		tag := field.Tag.Get("env")
+		if tag == "" {
+			return nil
+		}
+		for _, name := range strings.Split(tag, ",") {
+			raw, ok := os.LookupEnv(name)
+			if !ok || raw == "" {
+				continue
+			}
+			if err := setValue(v, raw); err != nil {
+				return fmt.Errorf("invalid %s %q: %w", name, raw, err)
+			}
+			return nil
+		}
+		return nil
+	})
+}
+
+// walk calls fn for every leaf field of the struct v, depth first.
+func walk(v reflect.Value, fn func(reflect.StructField, reflect.Value) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field, value := t.Field(i), v.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			if err := walk(value, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(field, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setValue(v reflect.Value, raw string) error {
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String && v.Type().Elem().Kind() == reflect.String:
+		// key=value pairs separated by commas
+		m := reflect.MakeMap(v.Type())
+		for _, pair := range strings.Split(raw, ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || key == "" {
+				return fmt.Errorf("expected key=value, got %q", pair)
+			}
+			m.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(value))
+		}
+		v.Set(m)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		// values separated by commas
+		var list []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
+
+// redacted is what secrets are replaced with.
+const redacted = "[REDACTED]"
+
+// Redacted returns a copy of the configuration that is safe to print:
+// fields tagged secret are masked, and passwords are removed from URLs.
+func (c *Config) Redacted() Config {
+	out := *c
+	walk(reflect.ValueOf(&out).Elem(), func(field reflect.StructField, v reflect.Value) error {
+		switch field.Tag.Get("secret") {
+		case "true":
+			if v.String() != "" {
+				v.SetString(redacted)
+			}
+		case "url":
+			v.SetString(redactURL(v.String()))
+		}
+		return nil
+	})
+	return out
+}
+
+// redactURL masks the passwords of a database URL, in its userinfo and in
+// query parameters like sslpassword, or of a key/value DSN like
+// "host=db password=secret". What cannot be parsed is masked entirely.
+func redactURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	if !strings.Contains(raw, "://") {
+		return dsnPassword.ReplaceAllString(raw, "${1}xxxxx")
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redacted
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	query := u.Query()
+	for key := range query {
+		if strings.Contains(strings.ToLower(key), "password") {
+			query.Set(key, "xxxxx")
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// dsnPassword matches the password keys of a key/value DSN with their
+// values, which may be quoted.
+var dsnPassword = regexp.MustCompile(`(?i)(\b\w*password\s*=\s*)('(?:\\.|[^'])*'|\S*)`)
@@ -1,105 +1,59 @@
 package db
 
 import (
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/logging"
 	"fmt"
-	"log"
 	"os"
 
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 var DB *sqlx.DB
 
-// SetupDatabase initializes the database connection
-func SetupDatabase() {
-	dbURL := os.Getenv("DATABASE_URL")
-
-	var dsn string
-	if dbURL != "" {
-		dsn = dbURL
-	} else {
-		dbHost := os.Getenv("DB_HOST")         
-		dbPort := os.Getenv("DB_PORT") 
-  		dbUsername := os.Getenv("DB_USER")      // Ensure matching
-        dbPassword := os.Getenv("DB_PASS") 
-		dbName := os.Getenv("DB_NAME")
-		dbSSLMode := os.Getenv("DB_SSLMODE")
-
-		dsn = fmt.Sprintf(
-			"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-			dbHost, dbPort, dbUsername, dbPassword, dbName, dbSSLMode,
-		)
-	}
+var logger = logging.For("db")
 
+// SetupDatabase initializes the database connection and applies the
+// startup migration policy. It exits if the schema is not fit to serve.
+func SetupDatabase(cfg config.DatabaseConfig) {
 	var err error
-	DB, err = sqlx.Connect("postgres", dsn)
+	DB, err = Connect(cfg)
 	if err != nil {
-		log.Fatalf("Failed to connect to the database: %v", err)
-	}
-
-	// Verify the connection
-	if err = DB.Ping(); err != nil {
-		log.Fatalf("Database ping failed: %v", err)
+		logger.Error("failed to connect to the database", "error", err)
+		os.Exit(1)
 	}
 
-	log.Println("Database connection established successfully.")
-
-	applyMigrations(dsn)
-}
-
-
-func applyMigrations(dsn string) {
-    driver, err := postgres.WithInstance(DB.DB, &postgres.Config{})
-    if err != nil {
-        log.Fatalf("Could not create postgres driver: %v", err)
-    }
+	logger.Info("database connection established")
 
-    m, err := migrate.NewWithDatabaseInstance(
-        "file://db/migrations",
-        "postgres", driver)
-    if err != nil {
-        log.Printf("could not create migration instance: %v", err);
-    }
-
-    // Try applying migrations normally
-    err = m.Up()
-    if err != nil && err != migrate.ErrNoChange {
-        fmt.Printf("Could not apply migrations, skipping: %v\n", err)
-        // promptForForceMigration(m)
-    } else {
-        log.Println("Migrations applied successfully!")
-    }
+	if err := prepareSchema(cfg); err != nil {
+		logger.Error("refusing to start", "error", err)
+		os.Exit(1)
+	}
 }
-/*
-func promptForForceMigration(m *migrate.Migrate) {
-    reader := bufio.NewReader(os.Stdin)
-    fmt.Print("Would you like to force a migration version? (y/n): ")
-    answer, _ := reader.ReadString('\n')
-    answer = strings.TrimSpace(answer)
 
-    if strings.ToLower(answer) == "y" {
-        fmt.Print("Enter the version number to force: ")
-        versionStr, _ := reader.ReadString('\n')
-        versionStr = strings.TrimSpace(versionStr)
+// Connect opens a connection pool and verifies the database is reachable.
+func Connect(cfg config.DatabaseConfig) (*sqlx.DB, error) {
+	driverName := "postgres"
+	if cfg.Driver == "sqlite" {
+		driverName = "sqlite3"
+	}
 
-        version, err := strconv.Atoi(versionStr)
-        if err != nil {
-            fmt.Printf("Invalid version number: %v\n", err)
-            return
-        }
+	conn, err := sqlx.Connect(driverName, cfg.DSN())
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Driver == "sqlite" {
+		// SQLite allows one writer at a time, a single connection keeps
+		// concurrent transactions from failing with SQLITE_BUSY
+		conn.SetMaxOpenConns(1)
+	}
 
-        err = m.Force(version)
-        if err != nil {
-            log.Fatalf("Could not force migration to version %d: %v", version, err)
-        }
-        log.Printf("Forced migration to version %d", version)
-    } else {
-        log.Println("Migration not forced.")
-    }
+	// Verify the connection
+	if err = conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("database ping failed: %w", err)
+	}
+	return conn, nil
 }
-
-*/
\ No newline at end of file
@@ -0,0 +1,193 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"anne-hub/db/migrations"
+	"anne-hub/pkg/config"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jmoiron/sqlx"
+)
+
+// Startup migration policies, see config.DatabaseConfig.Migrate.
+const (
+	MigrateAuto           = "auto"
+	MigrateRequireCurrent = "require-current"
+	MigrateSkip           = "skip"
+)
+
+// latest is the newest migration available, set by SetupDatabase
+var latest uint
+
+// Migrator applies the schema migrations to a database.
+type Migrator struct {
+	*migrate.Migrate
+	// Latest is the newest migration available
+	Latest uint
+}
+
+// NewMigrator reads the migrations of the configured driver from
+// cfg.MigrationsPath, or from the ones embedded in the binary if it is
+// empty. Closing it leaves conn open, only the connection the migrations
+// ran on is returned to its pool.
+func NewMigrator(conn *sqlx.DB, cfg config.DatabaseConfig) (*Migrator, error) {
+	src, err := openSource(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not read migrations: %w", err)
+	}
+
+	last, err := lastVersion(src)
+	if err != nil {
+		src.Close()
+		return nil, fmt.Errorf("could not read migrations: %w", err)
+	}
+
+	driver, err := openDriver(conn, cfg.Driver)
+	if err != nil {
+		src.Close()
+		return nil, fmt.Errorf("could not create %s driver: %w", cfg.Driver, err)
+	}
+
+	m, err := migrate.NewWithInstance("migrations", src, cfg.Driver, driver)
+	if err != nil {
+		src.Close()
+		return nil, fmt.Errorf("could not create migration instance: %w", err)
+	}
+	m.Log = migrateLogger{}
+
+	return &Migrator{Migrate: m, Latest: last}, nil
+}
+
+// openDriver creates the migration driver of conn. The drivers close the
+// connection pool they were given, so they are only given one connection of
+// it, or wrapped to keep it open.
+func openDriver(conn *sqlx.DB, driverName string) (database.Driver, error) {
+	if driverName == "sqlite" {
+		driver, err := sqlite3.WithInstance(conn.DB, &sqlite3.Config{})
+		if err != nil {
+			return nil, err
+		}
+		return keepOpen{driver}, nil
+	}
+
+	ctx := context.Background()
+	c, err := conn.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	driver, err := postgres.WithConnection(ctx, c, &postgres.Config{})
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return driver, nil
+}
+
+// keepOpen is a migration driver whose Close leaves the database open.
+type keepOpen struct {
+	database.Driver
+}
+
+func (keepOpen) Close() error {
+	return nil
+}
+
+// Status returns the schema version of the database.
+func (m *Migrator) Status() (MigrationStatus, error) {
+	version, dirty, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return MigrationStatus{}, err
+	}
+	return MigrationStatus{Version: version, Dirty: dirty, Latest: m.Latest}, nil
+}
+
+func openSource(cfg config.DatabaseConfig) (source.Driver, error) {
+	if cfg.MigrationsPath != "" {
+		return (&file.File{}).Open("file://" + filepath.ToSlash(cfg.MigrationsPath))
+	}
+	if cfg.Driver == "sqlite" {
+		return iofs.New(migrations.SQLite, "sqlite")
+	}
+	return iofs.New(migrations.FS, ".")
+}
+
+// lastVersion returns the highest migration version of src, 0 without any.
+func lastVersion(src source.Driver) (uint, error) {
+	version, err := src.First()
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	for err == nil {
+		var next uint
+		next, err = src.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return version, nil
+		}
+		version = next
+	}
+	return 0, err
+}
+
+// prepareSchema applies the startup policy to the database in DB. A dirty
+// schema is refused under every policy, it needs fixing by hand and
+// "anne-hub migrate force".
+func prepareSchema(cfg config.DatabaseConfig) error {
+	m, err := NewMigrator(DB, cfg)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	latest = m.Latest
+
+	status, err := m.Status()
+	if err != nil {
+		return fmt.Errorf("could not read migration version: %w", err)
+	}
+	if status.Dirty {
+		return fmt.Errorf("schema is dirty at version %d, fix it and run \"anne-hub migrate force <version>\"", status.Version)
+	}
+
+	switch cfg.Migrate {
+	case MigrateAuto:
+		if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return fmt.Errorf("could not apply migrations: %w", err)
+		}
+		if status, err = m.Status(); err != nil {
+			return fmt.Errorf("could not read migration version: %w", err)
+		}
+	case MigrateRequireCurrent:
+		if !status.Current() {
+			return fmt.Errorf("schema is at version %d, expected %d, run \"anne-hub migrate up\"", status.Version, status.Latest)
+		}
+	case MigrateSkip:
+		if !status.Current() {
+			logger.Warn("schema is not current, migrations skipped", "version", status.Version, "latest", status.Latest)
+		}
+	}
+
+	logger.Info("schema ready", "version", status.Version, "latest", status.Latest, "policy", cfg.Migrate)
+	return nil
+}
+
+// migrateLogger reports what golang-migrate does through the db logger.
+type migrateLogger struct{}
+
+func (migrateLogger) Printf(format string, v ...any) {
+	logger.Info(strings.TrimSpace(fmt.Sprintf(format, v...)))
+}
+
+func (migrateLogger) Verbose() bool {
+	return false
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// MigrationStatus describes the schema version of the database.
+type MigrationStatus struct {
+	// Version is the last migration applied, 0 if none was
+	Version uint `json:"version"`
+	// Dirty is set when a migration failed halfway and needs fixing by hand
+	Dirty bool `json:"dirty"`
+	// Latest is the newest migration available
+	Latest uint `json:"latest"`
+}
+
+// Current reports whether the latest migration was applied cleanly.
+func (s MigrationStatus) Current() bool {
+	return !s.Dirty && s.Version == s.Latest
+}
+
+// Ping checks that the database is reachable.
+func Ping(ctx context.Context) error {
+	if DB == nil {
+		return errors.New("database not set up")
+	}
+	return DB.PingContext(ctx)
+}
+
+// Migrations returns the schema version recorded by golang-migrate and the
+// newest migration known to the binary.
+func Migrations(ctx context.Context) (MigrationStatus, error) {
+	status := MigrationStatus{Latest: latest}
+	if DB == nil {
+		return status, errors.New("database not set up")
+	}
+
+	err := DB.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&status.Version, &status.Dirty)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return status, fmt.Errorf("failed to read migration version: %w", err)
+	}
+	return status, nil
+}
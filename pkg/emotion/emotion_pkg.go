@@ -0,0 +1,281 @@
+// Package emotion picks the emotions a device shows. Catalogs of the
+// emotions a firmware can animate are set in the config, by firmware
+// version, so new animations need no code changes. The LLM's emotion is
+// mapped to the nearest one of the device's catalog, and a per-session
+// Machine drives the idle, listening and thinking states without flickering
+// over the reply's emotion.
+package emotion
+
+import (
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/logging"
+	"anne-hub/pkg/metrics"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var logger = logging.For("emotion")
+
+// States of the pipeline a device can show.
+const (
+	Idle      = "idle"
+	Listening = "listening"
+	Thinking  = "thinking"
+)
+
+// Match tells how an emotion was found in a catalog.
+type Match string
+
+const (
+	Exact    Match = "exact"
+	Alias    Match = "alias"
+	Nearest  Match = "nearest"
+	Fallback Match = "fallback"
+)
+
+// Catalog is what one firmware can show.
+type Catalog struct {
+	// Version is the lowest firmware version the catalog applies to,
+	// "default" for the catalog of older devices
+	Version  string
+	Emotions []string
+	Fallback string
+	States   map[string]string
+	aliases  map[string]string
+	version  []int
+}
+
+// Resolve maps an emotion of the LLM to the catalog: by name, by alias, to
+// the closest spelled emotion or one sharing a word with it, and else to
+// the catalog's fallback.
+func (c *Catalog) Resolve(name string) (string, Match) {
+	name = normalize(name)
+	if slices.Contains(c.Emotions, name) {
+		return name, Exact
+	}
+	if target, ok := c.aliases[name]; ok && slices.Contains(c.Emotions, target) {
+		return target, Alias
+	}
+	if e, ok := c.nearest(name); ok {
+		return e, Nearest
+	}
+	return c.Fallback, Fallback
+}
+
+// nearest returns the emotion within a few typos of name, or else the
+// first one sharing a word with it, give or take a few typos.
+func (c *Catalog) nearest(name string) (string, bool) {
+	if name == "" {
+		return "", false
+	}
+
+	best, bestDistance := "", -1
+	for _, e := range c.Emotions {
+		d := distance(name, e)
+		if d <= len(e)/3 && (bestDistance < 0 || d < bestDistance) {
+			best, bestDistance = e, d
+		}
+	}
+	if best != "" {
+		return best, true
+	}
+
+	words := strings.Split(name, "_")
+	for _, e := range c.Emotions {
+		for _, w := range strings.Split(e, "_") {
+			if len(w) < 3 {
+				continue
+			}
+			for _, word := range words {
+				if distance(word, w) <= len(w)/3 {
+					return e, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// Catalogs holds the catalog of every firmware version.
+type Catalogs struct {
+	// versioned is sorted by version, newest first
+	versioned []*Catalog
+	fallback  *Catalog
+	minHold   time.Duration
+}
+
+// Setup loads the catalogs of cfg.
+func Setup(cfg config.EmotionConfig) *Catalogs {
+	logger.Info("emotion catalogs loaded", "catalogs", len(cfg.Catalogs), "aliases", len(cfg.Aliases))
+	return New(cfg)
+}
+
+// New creates the catalogs of cfg, which must be valid.
+func New(cfg config.EmotionConfig) *Catalogs {
+	aliases := make(map[string]string, len(cfg.Aliases))
+	for alias, target := range cfg.Aliases {
+		aliases[normalize(alias)] = normalize(target)
+	}
+
+	c := &Catalogs{minHold: cfg.MinHold}
+	for key, cc := range cfg.Catalogs {
+		catalog := &Catalog{
+			Version:  key,
+			Emotions: cc.Emotions,
+			Fallback: cc.Fallback,
+			States:   cc.States,
+			aliases:  aliases,
+		}
+		if catalog.Fallback == "" {
+			catalog.Fallback = catalog.Emotions[0]
+		}
+		if key == "default" {
+			c.fallback = catalog
+			continue
+		}
+		version, ok := parseVersion(key)
+		if !ok {
+			logger.Warn("ignoring catalog with invalid firmware version", "version", key)
+			continue
+		}
+		catalog.version = version
+		c.versioned = append(c.versioned, catalog)
+	}
+	slices.SortFunc(c.versioned, func(a, b *Catalog) int {
+		return slices.Compare(b.version, a.version)
+	})
+	return c
+}
+
+// For returns the catalog of a firmware version: the one of the newest
+// version not newer than it, else the default catalog.
+func (c *Catalogs) For(firmware string) *Catalog {
+	if version, ok := parseVersion(firmware); ok {
+		for _, catalog := range c.versioned {
+			if slices.Compare(version, catalog.version) >= 0 {
+				return catalog
+			}
+		}
+	}
+	return c.fallback
+}
+
+// Machine tracks what one device shows and decides what to send it next.
+type Machine struct {
+	catalog *Catalog
+	minHold time.Duration
+	now     func() time.Time
+	shown   string
+	// held is set while shown is the emotion of a reply or error
+	held  bool
+	since time.Time
+}
+
+// Machine starts tracking a device with the given firmware version.
+func (c *Catalogs) Machine(firmware string) *Machine {
+	return &Machine{catalog: c.For(firmware), minHold: c.minHold, now: time.Now}
+}
+
+// Catalog returns the catalog of the device.
+func (m *Machine) Catalog() *Catalog {
+	return m.catalog
+}
+
+// Show maps the emotion of a reply or error to the catalog and returns the
+// one to send. An emotion matching nothing keeps the reply's emotion on
+// screen rather than jumping to the fallback.
+func (m *Machine) Show(name string) string {
+	e, match := m.catalog.Resolve(name)
+	metrics.EmotionMatches.WithLabelValues(string(match)).Inc()
+	if match != Exact {
+		logger.Debug("emotion mapped", "emotion", name, "to", e, "match", match)
+	}
+	if match == Fallback && m.held {
+		e = m.shown
+	}
+
+	m.shown, m.held, m.since = e, true, m.now()
+	return e
+}
+
+// State returns the animation of a pipeline state and whether to send it.
+// States without an animation in the catalog and the animation already
+// shown are skipped. A reply's emotion is shown for at least MinHold, and
+// is not replaced by Idle at all, as the device plays the reply after it.
+func (m *Machine) State(state string) (string, bool) {
+	animation, ok := m.catalog.States[state]
+	if !ok || animation == m.shown {
+		return "", false
+	}
+	if m.held && (state == Idle || m.now().Sub(m.since) < m.minHold) {
+		return "", false
+	}
+
+	m.shown, m.held, m.since = animation, false, m.now()
+	return animation, true
+}
+
+// Release stops holding the emotion on screen, as when the device
+// interrupts the reply it belongs to, so the next state is shown at once.
+func (m *Machine) Release() {
+	m.held = false
+}
+
+// normalize lowercases an emotion and joins its words with underscores,
+// as the LLM writes them in many ways.
+func normalize(name string) string {
+	name = strings.ToLower(strings.Trim(name, " \t\n\"'.!"))
+	return strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return r == ' ' || r == '-' || r == '_'
+	}), "_")
+}
+
+// parseVersion parses a firmware version like "2.1.3", "v2.1" or
+// "2.1.0-beta" into its numbers.
+func parseVersion(s string) ([]int, bool) {
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	if s == "" {
+		return nil, false
+	}
+
+	var version []int
+	for _, part := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		version = append(version, n)
+	}
+	// 2.0 and 2 are the same version
+	for len(version) > 1 && version[len(version)-1] == 0 {
+		version = version[:len(version)-1]
+	}
+	return version, true
+}
+
+// distance is the Levenshtein distance of a and b.
+func distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
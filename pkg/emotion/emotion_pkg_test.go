@@ -0,0 +1,125 @@
+package emotion
+
+import (
+	"testing"
+	"time"
+
+	"anne-hub/pkg/config"
+)
+
+func testCatalogs() *Catalogs {
+	cfg := config.Defaults().Emotion
+	cfg.Catalogs["2.1"] = config.EmotionCatalog{
+		Emotions: []string{"cute_smile", "big_laugh", "sleep"},
+		States:   map[string]string{Idle: "sleep", Listening: "ears_up", Thinking: "eyes_roll"},
+	}
+	cfg.Catalogs["3"] = config.EmotionCatalog{
+		Emotions: []string{"cute_smile"},
+	}
+	cfg.Catalogs["beta"] = config.EmotionCatalog{
+		Emotions: []string{"cute_smile"},
+	}
+	return New(cfg)
+}
+
+func TestResolve(t *testing.T) {
+	catalog := testCatalogs().For("")
+	for _, tc := range []struct {
+		name  string
+		want  string
+		match Match
+	}{
+		{"celebration", "celebration", Exact},
+		{" Cute Smile! ", "cute_smile", Exact},
+		{"lucky-smile", "lucky_smile", Exact},
+		{"Happy", "cute_smile", Alias},
+		{"excited", "celebration", Alias},
+		{"surprized", "surprised", Nearest},
+		{"curiousity", "curiosity", Nearest},
+		{"very_confuzed", "confused", Nearest},
+		{"angry", "cute_smile", Fallback},
+		{"", "cute_smile", Fallback},
+	} {
+		got, match := catalog.Resolve(tc.name)
+		if got != tc.want || match != tc.match {
+			t.Errorf("Resolve(%q) = %q, %s, want %q, %s", tc.name, got, match, tc.want, tc.match)
+		}
+	}
+}
+
+func TestResolveAliasOutsideCatalog(t *testing.T) {
+	// "excited" is an alias of celebration, which 2.1 cannot show
+	got, match := testCatalogs().For("2.1").Resolve("excited")
+	if got != "cute_smile" || match != Fallback {
+		t.Errorf("Resolve = %q, %s, want the fallback", got, match)
+	}
+}
+
+func TestFor(t *testing.T) {
+	c := testCatalogs()
+	for firmware, want := range map[string]string{
+		"":           "default",
+		"1.9":        "default",
+		"2.1":        "2.1",
+		"v2.1.0":     "2.1",
+		"2.5-beta":   "2.1",
+		"2.10":       "2.1",
+		"3.0.0":      "3",
+		"10":         "3",
+		"nightly":    "default",
+		"2.1.x":      "default",
+		"2.0.99+abc": "default",
+	} {
+		if got := c.For(firmware).Version; got != want {
+			t.Errorf("For(%q) = catalog %q, want %q", firmware, got, want)
+		}
+	}
+	if got := c.For("2.1").Fallback; got != "cute_smile" {
+		t.Errorf("fallback of a catalog without one = %q, want its first emotion", got)
+	}
+}
+
+func TestMachine(t *testing.T) {
+	now := time.Unix(0, 0)
+	m := testCatalogs().Machine("2.1")
+	m.now = func() time.Time { return now }
+
+	expect := func(state, want string) {
+		t.Helper()
+		got, ok := m.State(state)
+		if want == "" && ok {
+			t.Errorf("State(%s) sent %q, want nothing", state, got)
+		}
+		if want != "" && (!ok || got != want) {
+			t.Errorf("State(%s) = %q, %v, want %q", state, got, ok, want)
+		}
+	}
+
+	expect(Listening, "ears_up")
+	expect(Listening, "")
+	expect("speaking", "")
+	expect(Thinking, "eyes_roll")
+
+	if got := m.Show("Big Laugh"); got != "big_laugh" {
+		t.Errorf("Show = %q", got)
+	}
+	// The reply's emotion is held, and not replaced by Idle at all
+	now = now.Add(time.Second)
+	expect(Listening, "")
+	now = now.Add(2 * time.Second)
+	expect(Idle, "")
+	expect(Listening, "ears_up")
+
+	// Nothing matching keeps the reply's emotion on screen
+	m.Show("big_laugh")
+	if got := m.Show("angry"); got != "big_laugh" {
+		t.Errorf("Show of an unknown emotion = %q, want the held one", got)
+	}
+
+	// Released, the next state shows right away
+	m.Release()
+	expect(Idle, "sleep")
+	if got := m.Show("angry"); got != "cute_smile" {
+		t.Errorf("Show of an unknown emotion = %q, want the fallback", got)
+	}
+}
@@ -0,0 +1,134 @@
+package fakeprovider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultTranscript is the transcription returned unless scripted.
+const DefaultTranscript = "Hello Anne, how are you today?"
+
+// bearer returns the API key of a Groq request.
+func bearer(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+func groqError(w http.ResponseWriter, resp Response) {
+	writeJSON(w, resp.Status, map[string]any{
+		"error": map[string]string{
+			"message": resp.message(),
+			"type":    "fake_error",
+		},
+	})
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model    string        `json:"model"`
+	Messages []chatMessage `json:"messages"`
+}
+
+func (s *Server) groqChat(w http.ResponseWriter, r *http.Request) {
+	var body chatRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		groqError(w, Response{Status: http.StatusBadRequest, Text: err.Error()})
+		return
+	}
+	var prompt string
+	for _, m := range body.Messages {
+		if m.Role == "user" {
+			prompt = m.Content
+		}
+	}
+
+	resp := s.next(Request{Endpoint: GroqChat, APIKey: bearer(r), Model: body.Model, Text: prompt})
+	if !begin(w, r, resp) {
+		return
+	}
+	switch {
+	case resp.Body != "":
+		writeBody(w, resp, "application/json")
+		return
+	case resp.failed():
+		groqError(w, resp)
+		return
+	}
+
+	content := resp.Text
+	if content == "" {
+		content = "You said: " + prompt
+	}
+	promptTokens, completionTokens := tokens(body.Messages), len(strings.Fields(content))
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":      fmt.Sprintf("chatcmpl-fake-%d", time.Now().UnixNano()),
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   body.Model,
+		"choices": []map[string]any{{
+			"index":         0,
+			"message":       map[string]string{"role": "assistant", "content": content},
+			"logprobs":      nil,
+			"finish_reason": "stop",
+		}},
+		"usage": map[string]int{
+			"prompt_tokens":     promptTokens,
+			"completion_tokens": completionTokens,
+			"total_tokens":      promptTokens + completionTokens,
+		},
+	})
+}
+
+// tokens approximates the prompt size by counting words.
+func tokens(messages []chatMessage) int {
+	n := 0
+	for _, m := range messages {
+		n += len(strings.Fields(m.Content))
+	}
+	return n
+}
+
+func (s *Server) groqTranscription(w http.ResponseWriter, r *http.Request) {
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		groqError(w, Response{Status: http.StatusBadRequest, Text: "file is required"})
+		return
+	}
+	defer file.Close()
+	size, _ := io.Copy(io.Discard, file)
+
+	resp := s.next(Request{
+		Endpoint: GroqTranscription,
+		APIKey:   bearer(r),
+		Model:    r.FormValue("model"),
+		Language: r.FormValue("language"),
+		Bytes:    int(size),
+	})
+	if !begin(w, r, resp) {
+		return
+	}
+	switch {
+	case resp.Body != "":
+		writeBody(w, resp, "application/json")
+		return
+	case resp.failed():
+		groqError(w, resp)
+		return
+	}
+
+	text := resp.Text
+	if text == "" {
+		text = DefaultTranscript
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"text":   text,
+		"x_groq": map[string]string{"id": fmt.Sprintf("req_fake_%d", time.Now().UnixNano())},
+	})
+}
@@ -0,0 +1,268 @@
+// Package fakeprovider implements the parts of the Groq, ElevenLabs and
+// Google Text-to-Speech APIs the hub calls, so the voice pipeline can run
+// without credentials. Responses are scripted per endpoint, with optional
+// latency and error injection.
+package fakeprovider
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Endpoints that can be scripted.
+const (
+	GroqChat          = "groq.chat"
+	GroqTranscription = "groq.transcription"
+	ElevenLabsTTS     = "elevenlabs.tts"
+	GoogleTTS         = "google.tts"
+)
+
+// Path prefixes of the fake APIs. The real clients' base URLs are the
+// server URL followed by these.
+const (
+	GroqPath       = "/groq/openai/v1"
+	ElevenLabsPath = "/elevenlabs/v1"
+	GooglePath     = "/google"
+)
+
+// Endpoints lists every scriptable endpoint.
+var Endpoints = []string{GroqChat, GroqTranscription, ElevenLabsTTS, GoogleTTS}
+
+// Response is one scripted answer of an endpoint. The zero value is a
+// successful response with generated content.
+type Response struct {
+	// Status other than 0 or 200 answers with the provider's error format
+	Status int `json:"status,omitempty"`
+	// Text is the transcript, the completion or the error message
+	Text string `json:"text,omitempty"`
+	// Body replaces the whole response body
+	Body string `json:"body,omitempty"`
+	// Headers are added to the response, e.g. Retry-After
+	Headers map[string]string `json:"headers,omitempty"`
+	// Latency delays the response
+	Latency Duration `json:"latency,omitempty"`
+	// Hangup closes the connection without answering
+	Hangup bool `json:"hangup,omitempty"`
+}
+
+// Duration is a time.Duration written as "250ms" in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"250ms\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Request is a call received by the server.
+type Request struct {
+	Endpoint string    `json:"endpoint"`
+	Time     time.Time `json:"time"`
+	APIKey   string    `json:"api_key,omitempty"`
+	Model    string    `json:"model,omitempty"`
+	Language string    `json:"language,omitempty"`
+	// Text is the last user message or the text to synthesize
+	Text string `json:"text,omitempty"`
+	// Bytes is the size of the uploaded audio
+	Bytes int `json:"bytes,omitempty"`
+}
+
+// Server is the fake provider API. It is safe for concurrent use.
+type Server struct {
+	mu       sync.Mutex
+	scripts  map[string][]Response
+	defaults map[string]Response
+	requests []Request
+	mux      *http.ServeMux
+}
+
+// New creates a server answering every endpoint with generated content.
+func New() *Server {
+	s := &Server{
+		scripts:  map[string][]Response{},
+		defaults: map[string]Response{},
+		mux:      http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("POST "+GroqPath+"/chat/completions", s.groqChat)
+	s.mux.HandleFunc("POST "+GroqPath+"/audio/transcriptions", s.groqTranscription)
+	s.mux.HandleFunc("POST "+ElevenLabsPath+"/text-to-speech/{voice}", s.elevenLabsTTS)
+	s.mux.HandleFunc("POST "+GooglePath+"/v1/text:synthesize", s.googleTTS)
+	s.mux.HandleFunc("GET "+GooglePath+"/v1/voices", s.googleVoices)
+
+	s.mux.HandleFunc("POST /_fake/script/{endpoint}", s.controlScript)
+	s.mux.HandleFunc("PUT /_fake/default/{endpoint}", s.controlDefault)
+	s.mux.HandleFunc("GET /_fake/requests", s.controlRequests)
+	s.mux.HandleFunc("DELETE /_fake", s.controlReset)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Script queues responses for an endpoint. They are used in order, one per
+// request, before falling back to the endpoint's default.
+func (s *Server) Script(endpoint string, responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[endpoint] = append(s.scripts[endpoint], responses...)
+}
+
+// SetDefault sets the response used once an endpoint's script is empty,
+// e.g. to add latency to every call or to keep failing.
+func (s *Server) SetDefault(endpoint string, r Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defaults[endpoint] = r
+}
+
+// Requests returns the calls received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Reset drops all scripts, defaults and recorded requests.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts = map[string][]Response{}
+	s.defaults = map[string]Response{}
+	s.requests = nil
+}
+
+// next records a request and returns the response to give.
+func (s *Server) next(req Request) Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req.Time = time.Now()
+	s.requests = append(s.requests, req)
+
+	if queue := s.scripts[req.Endpoint]; len(queue) > 0 {
+		s.scripts[req.Endpoint] = queue[1:]
+		return queue[0]
+	}
+	return s.defaults[req.Endpoint]
+}
+
+// begin applies the latency, hangup and headers of a response. It reports
+// false when the handler must not write anything.
+func begin(w http.ResponseWriter, r *http.Request, resp Response) bool {
+	if resp.Latency > 0 {
+		select {
+		case <-time.After(time.Duration(resp.Latency)):
+		case <-r.Context().Done():
+			return false
+		}
+	}
+	if resp.Hangup {
+		hangup(w)
+		return false
+	}
+	for k, v := range resp.Headers {
+		w.Header().Set(k, v)
+	}
+	return true
+}
+
+func hangup(w http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		// Reset instead of a clean close, like a dropped connection
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// failed reports whether a response injects an error.
+func (r Response) failed() bool {
+	return r.Status != 0 && r.Status != http.StatusOK
+}
+
+// message is the error message of a failed response.
+func (r Response) message() string {
+	if r.Text != "" {
+		return r.Text
+	}
+	return http.StatusText(r.Status)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeBody writes a scripted body verbatim.
+func writeBody(w http.ResponseWriter, resp Response, contentType string) {
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(resp.Body))
+}
+
+func (s *Server) controlScript(w http.ResponseWriter, r *http.Request) {
+	endpoint := r.PathValue("endpoint")
+	if !slices.Contains(Endpoints, endpoint) {
+		http.Error(w, "unknown endpoint "+endpoint, http.StatusNotFound)
+		return
+	}
+	var responses []Response
+	if err := json.NewDecoder(r.Body).Decode(&responses); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.Script(endpoint, responses...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) controlDefault(w http.ResponseWriter, r *http.Request) {
+	endpoint := r.PathValue("endpoint")
+	if !slices.Contains(Endpoints, endpoint) {
+		http.Error(w, "unknown endpoint "+endpoint, http.StatusNotFound)
+		return
+	}
+	var resp Response
+	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.SetDefault(endpoint, resp)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) controlRequests(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Requests())
+}
+
+func (s *Server) controlReset(w http.ResponseWriter, r *http.Request) {
+	s.Reset()
+	w.WriteHeader(http.StatusNoContent)
+}
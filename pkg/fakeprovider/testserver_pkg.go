@@ -0,0 +1,45 @@
+package fakeprovider
+
+import (
+	"anne-hub/pkg/config"
+	"net/http/httptest"
+)
+
+// TestServer is a Server listening on a local port, for tests and local
+// runs of the hub.
+type TestServer struct {
+	*Server
+	// URL is the base URL of the server, without a trailing slash
+	URL string
+
+	http *httptest.Server
+}
+
+// Start starts a fake provider server. Close it when done.
+func Start() *TestServer {
+	s := New()
+	hs := httptest.NewServer(s)
+	return &TestServer{Server: s, URL: hs.URL, http: hs}
+}
+
+// Close shuts the server down.
+func (t *TestServer) Close() {
+	t.http.Close()
+}
+
+// Configure points the Groq, ElevenLabs and Google clients of cfg at the
+// server and fills in placeholder API keys.
+func (t *TestServer) Configure(cfg *config.Config) {
+	Configure(cfg, t.URL)
+}
+
+// Configure points the provider clients of cfg at a fake server running
+// at baseURL.
+func Configure(cfg *config.Config, baseURL string) {
+	cfg.Groq.BaseURL = baseURL + GroqPath
+	cfg.Groq.APIKey = "fake-groq-key"
+	cfg.ElevenLabs.BaseURL = baseURL + ElevenLabsPath
+	cfg.ElevenLabs.APIKey = "fake-elevenlabs-key"
+	cfg.Google.Endpoint = baseURL + GooglePath
+	cfg.Google.CredentialsFile = ""
+}
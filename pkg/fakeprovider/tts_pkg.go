@@ -0,0 +1,161 @@
+package fakeprovider
+
+import (
+	"anne-hub/pkg/pcm"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// elevenLabsFormat is the format of the "pcm_16000" output format.
+var elevenLabsFormat = pcm.Format{SampleRate: 16000, BitDepth: 16, Channels: 1, Endianness: pcm.LittleEndian, Encoding: pcm.EncodingPCM}
+
+// googleFormat is what Google returns for LINEAR16 without a sample rate,
+// inside a WAV header.
+var googleFormat = pcm.Format{SampleRate: 24000, BitDepth: 16, Channels: 1, Endianness: pcm.LittleEndian, Encoding: pcm.EncodingPCM}
+
+// tone generates a quiet beep that lasts roughly as long as reading text out
+// loud would.
+func tone(text string, f pcm.Format) []byte {
+	d := time.Duration(len(text)) * 60 * time.Millisecond
+	d = max(d, 300*time.Millisecond)
+	d = min(d, 10*time.Second)
+
+	n := int(d.Seconds() * float64(f.SampleRate))
+	b := &pcm.Buffer{SampleRate: f.SampleRate, Channels: 1, Samples: make([]float64, n)}
+	for i := range b.Samples {
+		b.Samples[i] = 0.1 * math.Sin(2*math.Pi*440*float64(i)/float64(f.SampleRate))
+	}
+	data, err := b.Encode(f)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func (s *Server) elevenLabsTTS(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Text    string `json:"text"`
+		ModelID string `json:"model_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		elevenLabsError(w, Response{Status: http.StatusBadRequest, Text: err.Error()})
+		return
+	}
+
+	resp := s.next(Request{Endpoint: ElevenLabsTTS, APIKey: r.Header.Get("xi-api-key"), Model: body.ModelID, Text: body.Text})
+	if !begin(w, r, resp) {
+		return
+	}
+	switch {
+	case resp.Body != "":
+		writeBody(w, resp, "audio/basic")
+		return
+	case resp.failed():
+		elevenLabsError(w, resp)
+		return
+	}
+
+	w.Header().Set("Content-Type", "audio/basic")
+	w.Write(tone(body.Text, elevenLabsFormat))
+}
+
+func elevenLabsError(w http.ResponseWriter, resp Response) {
+	writeJSON(w, resp.Status, map[string]any{
+		"detail": map[string]string{
+			"status":  "fake_error",
+			"message": resp.message(),
+		},
+	})
+}
+
+func (s *Server) googleTTS(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		googleError(w, Response{Status: http.StatusBadRequest, Text: err.Error()})
+		return
+	}
+	var body texttospeechpb.SynthesizeSpeechRequest
+	if err := protojson.Unmarshal(data, &body); err != nil {
+		googleError(w, Response{Status: http.StatusBadRequest, Text: err.Error()})
+		return
+	}
+	text := body.GetInput().GetText()
+
+	resp := s.next(Request{
+		Endpoint: GoogleTTS,
+		Model:    body.GetVoice().GetName(),
+		Language: body.GetVoice().GetLanguageCode(),
+		Text:     text,
+	})
+	if !begin(w, r, resp) {
+		return
+	}
+	switch {
+	case resp.Body != "":
+		writeBody(w, resp, "application/json")
+		return
+	case resp.failed():
+		googleError(w, resp)
+		return
+	}
+
+	wav, err := pcm.ToWAV(tone(text, googleFormat), googleFormat)
+	if err != nil {
+		googleError(w, Response{Status: http.StatusInternalServerError, Text: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"audioContent": base64.StdEncoding.EncodeToString(wav),
+	})
+}
+
+func (s *Server) googleVoices(w http.ResponseWriter, r *http.Request) {
+	voices := []map[string]any{
+		{"languageCodes": []string{"en-US"}, "name": "en-US-Journey-F", "ssmlGender": "FEMALE", "naturalSampleRateHertz": 24000},
+		{"languageCodes": []string{"de-DE"}, "name": "de-DE-Studio-B", "ssmlGender": "MALE", "naturalSampleRateHertz": 24000},
+	}
+	if code := r.URL.Query().Get("languageCode"); code != "" {
+		var matching []map[string]any
+		for _, v := range voices {
+			if v["languageCodes"].([]string)[0] == code {
+				matching = append(matching, v)
+			}
+		}
+		voices = matching
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"voices": voices})
+}
+
+// googleError answers in the format of Google API errors, which the REST
+// client turns into a googleapi.Error.
+func googleError(w http.ResponseWriter, resp Response) {
+	status := "UNKNOWN"
+	switch resp.Status {
+	case http.StatusBadRequest:
+		status = "INVALID_ARGUMENT"
+	case http.StatusUnauthorized:
+		status = "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		status = "PERMISSION_DENIED"
+	case http.StatusTooManyRequests:
+		status = "RESOURCE_EXHAUSTED"
+	case http.StatusInternalServerError:
+		status = "INTERNAL"
+	case http.StatusServiceUnavailable:
+		status = "UNAVAILABLE"
+	}
+	writeJSON(w, resp.Status, map[string]any{
+		"error": map[string]any{
+			"code":    resp.Status,
+			"message": resp.message(),
+			"status":  status,
+		},
+	})
+}
@@ -0,0 +1,101 @@
+package groq
+
+import (
+	"anne-hub/models"
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/httpclient"
+	"anne-hub/pkg/logging"
+	"anne-hub/pkg/metrics"
+	"anne-hub/pkg/tracing"
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var logger = logging.For("groq")
+
+// Client calls the Groq API, or another OpenAI-compatible API, with the
+// configured key, endpoint and models.
+type Client struct {
+	// provider labels the client's metrics and spans
+	provider string
+	apiKey   string
+	baseURL  string
+	sttModel string
+	llmModel string
+	timeout  time.Duration
+	http     *http.Client
+}
+
+// NewClient creates a client from the Groq section of the configuration,
+// retrying requests as set in providers.
+func NewClient(cfg config.GroqConfig, providers config.ProvidersConfig) *Client {
+	return NewCompatible("groq", cfg, providers)
+}
+
+// NewCompatible creates a client for another OpenAI-compatible API, such as
+// a local server, named provider in metrics, traces and fallback chains.
+func NewCompatible(provider string, cfg config.GroqConfig, providers config.ProvidersConfig) *Client {
+	return &Client{
+		provider: provider,
+		apiKey:   cfg.APIKey,
+		baseURL:  strings.TrimSuffix(cfg.BaseURL, "/"),
+		sttModel: cfg.STTModel,
+		llmModel: cfg.LLMModel,
+		timeout:  cfg.Timeout,
+		http:     httpclient.New(provider, providers),
+	}
+}
+
+// Name returns the provider the client calls.
+func (c *Client) Name() string {
+	return c.provider
+}
+
+// STTModel returns the model transcriptions are made with.
+func (c *Client) STTModel() string {
+	return c.sttModel
+}
+
+// LLMModel returns the model completions are made with.
+func (c *Client) LLMModel() string {
+	return c.llmModel
+}
+
+// WithLLMModel returns a copy of the client completing with another model.
+// The copy shares the HTTP client, and so the provider's circuit breaker.
+func (c *Client) WithLLMModel(model string) *Client {
+	copied := *c
+	copied.llmModel = model
+	return &copied
+}
+
+// startLLM starts the span and deadline of an LLM request. The returned
+// function ends them and records the latency.
+func (c *Client) startLLM(ctx context.Context) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "llm", trace.WithAttributes(
+		attribute.String("provider", c.provider),
+		attribute.String("model", c.llmModel),
+	))
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	return ctx, func(err error) {
+		cancel()
+		metrics.LLMDuration.WithLabelValues(c.provider, metrics.Outcome(err)).Observe(time.Since(start).Seconds())
+		tracing.End(span, err)
+	}
+}
+
+// recordUsage counts the tokens reported in a completion.
+func (c *Client) recordUsage(ctx context.Context, resp models.GroqLLMResponse) {
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int("tokens.prompt", resp.Usage.PromptTokens),
+		attribute.Int("tokens.completion", resp.Usage.CompletionTokens),
+	)
+	metrics.LLMTokens.WithLabelValues(c.provider, c.llmModel, "prompt").Add(float64(resp.Usage.PromptTokens))
+	metrics.LLMTokens.WithLabelValues(c.provider, c.llmModel, "completion").Add(float64(resp.Usage.CompletionTokens))
+}
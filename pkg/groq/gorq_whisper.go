@@ -2,14 +2,20 @@ package groq
 
 import (
 	"anne-hub/models"
+	"anne-hub/pkg/logging"
+	"anne-hub/pkg/metrics"
+	"anne-hub/pkg/tracing"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"mime/multipart"
 	"net/http"
-	"os"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 /*
@@ -21,13 +27,21 @@ curl https://api.groq.com/openai/v1/audio/transcriptions \
   -F response_format=json \
   -F language=en
 */
-func GenerateWhisperTranscription(wavData []byte, language string) (string, error) {
-    apiKey := os.Getenv("GROQ_API_KEY")
-    if apiKey == "" {
-        return "", fmt.Errorf("GROQ_API_KEY environment variable is not set")
-    }
+func (c *Client) GenerateWhisperTranscription(ctx context.Context, wavData []byte, language string) (_ string, err error) {
+    ctx, span := tracing.Start(ctx, "stt", trace.WithAttributes(
+        attribute.String("provider", c.provider),
+        attribute.String("model", c.sttModel),
+        attribute.Int("audio.bytes", len(wavData)),
+    ))
+    defer func(start time.Time) {
+        metrics.STTDuration.WithLabelValues(c.provider, metrics.Outcome(err)).Observe(time.Since(start).Seconds())
+        tracing.End(span, err)
+    }(time.Now())
+
+    ctx, cancel := context.WithTimeout(ctx, c.timeout)
+    defer cancel()
 
-    url := "https://api.groq.com/openai/v1/audio/transcriptions"
+    url := c.baseURL + "/audio/transcriptions"
 
     var b bytes.Buffer
     w := multipart.NewWriter(&b)
@@ -43,25 +57,24 @@ func GenerateWhisperTranscription(wavData []byte, language string) (string, erro
     }
 
     // Add other form fields
-    w.WriteField("model", "whisper-large-v3-turbo")
+    w.WriteField("model", c.sttModel)
     w.WriteField("temperature", "0")
     w.WriteField("response_format", "json")
     w.WriteField("language", language)
 
     w.Close()
 
-    req, err := http.NewRequest("POST", url, &b)
+    req, err := http.NewRequestWithContext(ctx, "POST", url, &b)
     if err != nil {
         return "", err
     }
 
-    req.Header.Set("Authorization", "Bearer "+apiKey)
+    req.Header.Set("Authorization", "Bearer "+c.apiKey)
     req.Header.Set("Content-Type", w.FormDataContentType())
 
-    client := &http.Client{}
-    resp, err := client.Do(req)
+    resp, err := c.http.Do(req)
     if err != nil {
-        return "", fmt.Errorf("error sending request to Groq API: %v", err)
+        return "", fmt.Errorf("error sending request to Groq API: %w", err)
     }
     defer resp.Body.Close()
 
@@ -70,7 +83,11 @@ func GenerateWhisperTranscription(wavData []byte, language string) (string, erro
         return "", fmt.Errorf("error reading response body: %v", err)
     }
 
-    log.Println("Groq API response:", string(body))
+    logger.DebugContext(ctx, "whisper response", "status", resp.StatusCode, "body", logging.Transcript(string(body)))
+
+    if resp.StatusCode != http.StatusOK {
+        return "", fmt.Errorf("%s API returned status %d: %s", c.provider, resp.StatusCode, string(body))
+    }
 
     // Parse the response
     var apiResp models.GroqWhisperResponse
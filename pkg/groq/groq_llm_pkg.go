@@ -2,20 +2,19 @@ package groq
 
 import (
 	"anne-hub/models"
+	"anne-hub/pkg/logging"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 )
 
 // GenerateGroqLLMResponse generates a response from the Groq LLM API
-func GenerateLLMResponse(userPrompt string, systemPrompt string, language string) (models.GroqLLMResponse, error) {
-	apiKey := os.Getenv("GROQ_API_KEY")
-	if apiKey == "" {
-		return models.GroqLLMResponse{}, fmt.Errorf("GROQ_API_KEY environment variable not set")
-	}
+func (c *Client) GenerateLLMResponse(ctx context.Context, userPrompt string, systemPrompt string, language string) (_ models.GroqLLMResponse, err error) {
+	ctx, finish := c.startLLM(ctx)
+	defer func() { finish(err) }()
 
 	if language == "german" {
 		userPrompt += " Answer in German please"
@@ -23,29 +22,28 @@ func GenerateLLMResponse(userPrompt string, systemPrompt string, language string
 		userPrompt += " Answer in English please"
 	}
 
-	url := "https://api.groq.com/openai/v1/chat/completions"
+	url := c.baseURL + "/chat/completions"
 	payload := map[string]interface{}{
 		"messages": []map[string]string{
 			{"role": "system", "content": systemPrompt},
 			{"role": "user", "content": userPrompt},
 		},
-		"model": "llama-3.1-70b-versatile",
+		"model": c.llmModel,
 	}
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		return models.GroqLLMResponse{}, fmt.Errorf("error encoding request content: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return models.GroqLLMResponse{}, fmt.Errorf("error creating request: %w", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := c.http.Do(req)
 	if err != nil {
 		return models.GroqLLMResponse{}, fmt.Errorf("error sending request to Groq API: %w", err)
 	}
@@ -69,6 +67,7 @@ func GenerateLLMResponse(userPrompt string, systemPrompt string, language string
 		return models.GroqLLMResponse{}, fmt.Errorf("no valid response received from Groq API")
 	}
 
+	c.recordUsage(ctx, apiResp)
 	return apiResp, nil
 }
 
@@ -76,11 +75,9 @@ func GenerateLLMResponse(userPrompt string, systemPrompt string, language string
 // GenerateGroqLLMResponse generates a response from the Groq LLM API using structured conversation data
 
 // GenerateGroqLLMResponseFromConversationData generates a response from the Groq LLM API using structured conversation data
-func GenerateLLMResponseFromConversationData(conversation models.ConversationHistory, systemPrompt string, language string) (models.GroqLLMResponse, error) {
-	apiKey := os.Getenv("GROQ_API_KEY")
-	if apiKey == "" {
-		return models.GroqLLMResponse{}, fmt.Errorf("GROQ_API_KEY environment variable not set")
-	}
+func (c *Client) GenerateLLMResponseFromConversationData(ctx context.Context, conversation models.ConversationHistory, systemPrompt string, language string) (_ models.GroqLLMResponse, err error) {
+	ctx, finish := c.startLLM(ctx)
+	defer func() { finish(err) }()
 
 	if language == "german" {
 		systemPrompt += " Bitte antworte auf Deutsch."
@@ -88,7 +85,7 @@ func GenerateLLMResponseFromConversationData(conversation models.ConversationHis
 		systemPrompt += " Please respond in English."
 	}
 
-	url := "https://api.groq.com/openai/v1/chat/completions"
+	url := c.baseURL + "/chat/completions"
 
 	messages := []map[string]string{
 		{"role": "system", "content": systemPrompt},
@@ -107,7 +104,7 @@ func GenerateLLMResponseFromConversationData(conversation models.ConversationHis
 
 	payload := map[string]interface{}{
 		"messages": messages,
-		"model":    "llama-3.1-70b-versatile",
+		"model":    c.llmModel,
 	}
 
 	jsonData, err := json.Marshal(payload)
@@ -115,16 +112,15 @@ func GenerateLLMResponseFromConversationData(conversation models.ConversationHis
 		return models.GroqLLMResponse{}, fmt.Errorf("error encoding request content: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return models.GroqLLMResponse{}, fmt.Errorf("error creating request: %w", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := c.http.Do(req)
 	if err != nil {
 		return models.GroqLLMResponse{}, fmt.Errorf("error sending request to Groq API: %w", err)
 	}
@@ -148,5 +144,8 @@ func GenerateLLMResponseFromConversationData(conversation models.ConversationHis
 		return models.GroqLLMResponse{}, fmt.Errorf("no valid response received from Groq API")
 	}
 
+	logger.DebugContext(ctx, "llm response", "model", c.llmModel, "content", logging.Transcript(apiResp.Choices[0].Message.Content))
+
+	c.recordUsage(ctx, apiResp)
 	return apiResp, nil
 }
\ No newline at end of file
@@ -0,0 +1,78 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/db"
+)
+
+// Readiness returns the checks deciding whether the hub can serve
+// conversations: the database, its schema version and the credentials of
+// the providers in the pipeline's fallback chains.
+func Readiness(cfg *config.Config) []Check {
+	checks := []Check{
+		{Name: "database", Run: database},
+		{Name: "migrations", Run: migrations},
+	}
+	if cfg.Pipeline.Uses("groq") {
+		checks = append(checks, Check{Name: "groq", Run: apiKey(cfg.Groq.APIKey)})
+	}
+	if cfg.Pipeline.Uses("elevenlabs") {
+		checks = append(checks, Check{Name: "elevenlabs", Run: apiKey(cfg.ElevenLabs.APIKey)})
+	}
+	if cfg.Pipeline.Uses("google") {
+		// Application Default Credentials can only be verified by a call
+		checks = append(checks, Check{Name: "google", Optional: true, Run: googleCredentials(cfg.Google)})
+	}
+	return checks
+}
+
+func database(ctx context.Context) (map[string]any, error) {
+	return nil, db.Ping(ctx)
+}
+
+func migrations(ctx context.Context) (map[string]any, error) {
+	status, err := db.Migrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	details := map[string]any{
+		"version": status.Version,
+		"latest":  status.Latest,
+		"dirty":   status.Dirty,
+	}
+	switch {
+	case status.Dirty:
+		return details, fmt.Errorf("migration %d failed and left the schema dirty", status.Version)
+	case status.Version != status.Latest:
+		return details, fmt.Errorf("schema is at version %d, expected %d", status.Version, status.Latest)
+	}
+	return details, nil
+}
+
+func apiKey(key string) func(context.Context) (map[string]any, error) {
+	return func(context.Context) (map[string]any, error) {
+		if key == "" {
+			return nil, errors.New("API key not configured")
+		}
+		return nil, nil
+	}
+}
+
+func googleCredentials(cfg config.GoogleConfig) func(context.Context) (map[string]any, error) {
+	return func(context.Context) (map[string]any, error) {
+		if cfg.CredentialsFile == "" {
+			return map[string]any{"credentials": "application default"}, nil
+		}
+		details := map[string]any{"credentials": "file"}
+		if _, err := os.Stat(cfg.CredentialsFile); err != nil {
+			return details, fmt.Errorf("credentials file not readable: %w", err)
+		}
+		return details, nil
+	}
+}
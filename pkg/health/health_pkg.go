@@ -0,0 +1,94 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Component statuses, and the overall status of a Report.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+	// StatusDegraded means only optional components failed
+	StatusDegraded = "degraded"
+)
+
+// Check is one component of a readiness report.
+type Check struct {
+	Name string
+	// Optional components are reported but do not make the service unready
+	Optional bool
+	// Run returns details worth showing in the report, and an error if the
+	// component is not usable.
+	Run func(ctx context.Context) (map[string]any, error)
+}
+
+// Component is the result of a Check.
+type Component struct {
+	Status     string         `json:"status"`
+	Optional   bool           `json:"optional,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Details    map[string]any `json:"details,omitempty"`
+	DurationMS int64          `json:"duration_ms"`
+}
+
+// Report is the per-component result of running the checks.
+type Report struct {
+	Status     string               `json:"status"`
+	Components map[string]Component `json:"components"`
+}
+
+// Ready reports whether all required components are ok.
+func (r Report) Ready() bool {
+	return r.Status != StatusFail
+}
+
+// Run runs the checks concurrently, each limited to timeout.
+func Run(ctx context.Context, timeout time.Duration, checks []Check) Report {
+	report := Report{Status: StatusOK, Components: make(map[string]Component, len(checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check Check) {
+			defer wg.Done()
+			component := run(ctx, timeout, check)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Components[check.Name] = component
+			if component.Status == StatusOK {
+				return
+			}
+			if !check.Optional {
+				report.Status = StatusFail
+			} else if report.Status == StatusOK {
+				report.Status = StatusDegraded
+			}
+		}(check)
+	}
+	wg.Wait()
+
+	return report
+}
+
+func run(ctx context.Context, timeout time.Duration, check Check) Component {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	details, err := check.Run(ctx)
+	component := Component{
+		Status:     StatusOK,
+		Optional:   check.Optional,
+		Details:    details,
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		component.Status = StatusFail
+		component.Error = err.Error()
+	}
+	return component
+}
@@ -0,0 +1,120 @@
+package httpclient
+
+import (
+	"anne-hub/pkg/metrics"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the provider while its circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+type breakerState int
+
+const (
+	closed breakerState = iota
+	open
+	// One request is let through to probe whether the provider recovered
+	halfOpen
+)
+
+// outcome of an attempt as far as the breaker is concerned.
+type outcome int
+
+const (
+	succeeded outcome = iota
+	failed
+	// The caller gave up, which says nothing about the provider
+	abandoned
+)
+
+// Breaker stops requests to a provider after a number of consecutive
+// failures, until a cooldown has passed and a probe request succeeds.
+type Breaker struct {
+	provider  string
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewBreaker creates a closed breaker. A zero threshold never opens it.
+func NewBreaker(provider string, threshold int, cooldown time.Duration) *Breaker {
+	metrics.ProviderCircuitOpen.WithLabelValues(provider).Set(0)
+	return &Breaker{provider: provider, threshold: threshold, cooldown: cooldown}
+}
+
+// Open reports whether the breaker currently rejects requests.
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == open && time.Since(b.openedAt) < b.cooldown
+}
+
+// allow reports whether a request may be sent.
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case open:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = halfOpen
+		b.probing = true
+		logger.Info("circuit half-open, probing provider", "provider", b.provider)
+	case halfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// record updates the breaker with the outcome of an allowed request.
+func (b *Breaker) record(o outcome) {
+	if b.threshold == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == halfOpen {
+		b.probing = false
+		switch o {
+		case succeeded:
+			b.state = closed
+			b.failures = 0
+			metrics.ProviderCircuitOpen.WithLabelValues(b.provider).Set(0)
+			logger.Info("circuit closed, provider recovered", "provider", b.provider)
+		case failed:
+			b.trip()
+		}
+		return
+	}
+
+	switch o {
+	case succeeded:
+		b.failures = 0
+	case failed:
+		b.failures++
+		if b.state == closed && b.failures >= b.threshold {
+			b.trip()
+		}
+	}
+}
+
+func (b *Breaker) trip() {
+	b.state = open
+	b.openedAt = time.Now()
+	metrics.ProviderCircuitOpen.WithLabelValues(b.provider).Set(1)
+	logger.Warn("circuit opened, rejecting provider requests", "provider", b.provider, "failures", b.failures, "cooldown", b.cooldown)
+}
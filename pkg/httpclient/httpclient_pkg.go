@@ -0,0 +1,158 @@
+// Package httpclient builds the HTTP clients used to call the speech and LLM
+// providers: requests failing with 429, 5xx or a network error are retried
+// with jittered backoff, honoring Retry-After, and a circuit breaker per
+// provider stops calling one that keeps failing. Deadlines come from the
+// request context, so work stops as soon as the caller gives up.
+package httpclient
+
+import (
+	"anne-hub/pkg/cassette"
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/logging"
+	"anne-hub/pkg/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var logger = logging.For("httpclient")
+
+// base is shared by all provider clients. There is no overall timeout here,
+// calls are bounded by their context.
+var base = &http.Transport{
+	Proxy:                 http.ProxyFromEnvironment,
+	DialContext:           (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConnsPerHost:   8,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+// New returns the client for a provider. Requests go through the cassette
+// recorder below the retries, so every attempt is recorded and replayed.
+func New(provider string, cfg config.ProvidersConfig) *http.Client {
+	return &http.Client{Transport: &Transport{
+		Provider: provider,
+		Config:   cfg,
+		Breaker:  NewBreaker(provider, cfg.BreakerFailures, cfg.BreakerCooldown),
+		Base:     &cassette.Transport{Base: base},
+	}}
+}
+
+// Transport retries requests of one provider and guards them with its
+// breaker.
+type Transport struct {
+	Provider string
+	Config   config.ProvidersConfig
+	Breaker  *Breaker
+	Base     http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	// Without GetBody a consumed body cannot be sent again
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+
+	for attempt := 0; ; attempt++ {
+		if err := t.Breaker.allow(); err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, fmt.Errorf("%s: %w", t.Provider, err)
+		}
+
+		r := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				t.Breaker.record(abandoned)
+				return nil, err
+			}
+			r = req.Clone(ctx)
+			r.Body = body
+		}
+
+		resp, err := t.Base.RoundTrip(r)
+		reason, o := classify(ctx, resp, err)
+		t.Breaker.record(o)
+		if reason == "" || !replayable || attempt >= t.Config.MaxRetries {
+			return resp, err
+		}
+
+		delay, ok := t.delay(attempt, resp)
+		if deadline, has := ctx.Deadline(); !ok || (has && time.Until(deadline) < delay) {
+			// Waiting would outlast the call, let the caller see the failure
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		logger.WarnContext(ctx, "retrying provider request", "provider", t.Provider, "reason", reason, "attempt", attempt+1, "delay", delay)
+		metrics.ProviderRetries.WithLabelValues(t.Provider, reason).Inc()
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// classify returns why an attempt should be retried, empty if it should
+// not, and its outcome for the breaker.
+func classify(ctx context.Context, resp *http.Response, err error) (string, outcome) {
+	if err != nil {
+		if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+			return "", abandoned
+		}
+		return "network", failed
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return "status_" + strconv.Itoa(resp.StatusCode), failed
+	}
+	return "", succeeded
+}
+
+// delay returns how long to wait before the next attempt. It reports false
+// when the provider asked for a longer pause than RetryMaxDelay.
+func (t *Transport) delay(attempt int, resp *http.Response) (time.Duration, bool) {
+	if resp != nil {
+		if after, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			return after, after <= t.Config.RetryMaxDelay
+		}
+	}
+
+	// Exponential backoff with the upper half jittered, so retries of
+	// concurrent sessions spread out
+	d := t.Config.RetryBaseDelay << attempt
+	if d <= 0 || d > t.Config.RetryMaxDelay {
+		d = t.Config.RetryMaxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1)), true
+}
+
+// retryAfter parses a Retry-After header, in seconds or as an HTTP date.
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,282 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"anne-hub/pkg/config"
+)
+
+// reply is one scripted response of the test provider.
+type reply struct {
+	status     int
+	retryAfter string
+}
+
+// provider answers with the scripted replies in order, repeating the last
+// one, and records the body of every attempt. A hanging provider never
+// answers.
+type provider struct {
+	mu      sync.Mutex
+	replies []reply
+	hang    bool
+	bodies  []string
+}
+
+func (p *provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	p.mu.Lock()
+	p.bodies = append(p.bodies, string(body))
+	rep := p.replies[min(len(p.bodies), len(p.replies))-1]
+	p.mu.Unlock()
+
+	if p.hang {
+		<-r.Context().Done()
+		return
+	}
+
+	if rep.retryAfter != "" {
+		w.Header().Set("Retry-After", rep.retryAfter)
+	}
+	w.WriteHeader(rep.status)
+}
+
+func (p *provider) attempts() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.bodies...)
+}
+
+func testConfig() config.ProvidersConfig {
+	return config.ProvidersConfig{
+		MaxRetries:     2,
+		RetryBaseDelay: time.Millisecond,
+		RetryMaxDelay:  20 * time.Millisecond,
+	}
+}
+
+func post(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader("hello"))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return resp, err
+}
+
+func TestTransportRetries(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		replies      []reply
+		wantStatus   int
+		wantAttempts int
+	}{
+		{"success", []reply{{status: 200}}, 200, 1},
+		{"5xx then success", []reply{{status: 503}, {status: 500}, {status: 200}}, 200, 3},
+		{"5xx until retries run out", []reply{{status: 502}}, 502, 3},
+		{"429 with Retry-After", []reply{{status: 429, retryAfter: "0"}, {status: 200}}, 200, 2},
+		{"429 asking for too long", []reply{{status: 429, retryAfter: "60"}, {status: 200}}, 429, 1},
+		{"4xx", []reply{{status: 400}, {status: 200}}, 400, 1},
+		{"401", []reply{{status: 401}, {status: 200}}, 401, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &provider{replies: tc.replies}
+			srv := httptest.NewServer(p)
+			defer srv.Close()
+
+			resp, err := post(context.Background(), New("test", testConfig()), srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tc.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tc.wantStatus)
+			}
+			attempts := p.attempts()
+			if len(attempts) != tc.wantAttempts {
+				t.Errorf("%d attempts, want %d", len(attempts), tc.wantAttempts)
+			}
+			for i, body := range attempts {
+				if body != "hello" {
+					t.Errorf("attempt %d sent %q, want the request body again", i, body)
+				}
+			}
+		})
+	}
+}
+
+func TestTransportNetworkError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	cfg := testConfig()
+	cfg.BreakerFailures = 10
+	client := New("test", cfg)
+	if _, err := post(context.Background(), client, url); err == nil {
+		t.Fatal("request to a closed server succeeded")
+	}
+	// The first attempt and both retries failed
+	if b := client.Transport.(*Transport).Breaker; b.failures != 3 {
+		t.Errorf("breaker counted %d failures, want 3", b.failures)
+	}
+}
+
+func TestTransportCancellation(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		provider *provider
+		// cancel is called with the request's cancel function
+		cancel       func(context.CancelFunc)
+		timeout      time.Duration
+		wantFailures int
+	}{
+		{
+			name:     "during the request",
+			provider: &provider{replies: []reply{{status: 200}}, hang: true},
+			timeout:  50 * time.Millisecond,
+			// Giving up says nothing about the provider
+			wantFailures: 0,
+		},
+		{
+			name:         "during the backoff",
+			provider:     &provider{replies: []reply{{status: 503}}},
+			cancel:       func(cancel context.CancelFunc) { time.AfterFunc(50*time.Millisecond, cancel) },
+			wantFailures: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(tc.provider)
+			defer srv.Close()
+
+			cfg := testConfig()
+			cfg.RetryBaseDelay, cfg.RetryMaxDelay = time.Hour, time.Hour
+			cfg.BreakerFailures = 10
+			client := New("test", cfg)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			if tc.timeout > 0 {
+				ctx, cancel = context.WithTimeout(context.Background(), tc.timeout)
+			}
+			defer cancel()
+			if tc.cancel != nil {
+				tc.cancel(cancel)
+			}
+
+			start := time.Now()
+			_, err := post(ctx, client, srv.URL)
+			if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("err = %v, want the context's", err)
+			}
+			if d := time.Since(start); d > 5*time.Second {
+				t.Errorf("returned after %s", d)
+			}
+			if n := len(tc.provider.attempts()); n != 1 {
+				t.Errorf("%d attempts, want 1", n)
+			}
+			if b := client.Transport.(*Transport).Breaker; b.failures != tc.wantFailures {
+				t.Errorf("breaker counted %d failures, want %d", b.failures, tc.wantFailures)
+			}
+		})
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	p := &provider{replies: []reply{{status: 500}}}
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+
+	cfg := config.ProvidersConfig{BreakerFailures: 2, BreakerCooldown: 50 * time.Millisecond}
+	client := New("test", cfg)
+	b := client.Transport.(*Transport).Breaker
+	ctx := context.Background()
+
+	expect := func(wantAttempts int, wantState breakerState, wantOpenErr bool) {
+		t.Helper()
+		_, err := post(ctx, client, srv.URL)
+		if got := errors.Is(err, ErrCircuitOpen); got != wantOpenErr {
+			t.Errorf("err = %v, want circuit open %v", err, wantOpenErr)
+		}
+		if n := len(p.attempts()); n != wantAttempts {
+			t.Errorf("%d attempts, want %d", n, wantAttempts)
+		}
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.state != wantState {
+			t.Errorf("state = %d, want %d", b.state, wantState)
+		}
+	}
+
+	// Closed until the second consecutive failure
+	expect(1, closed, false)
+	expect(2, open, false)
+	if !b.Open() {
+		t.Error("Open = false after the circuit opened")
+	}
+
+	// Open, the provider is not called
+	expect(2, open, true)
+
+	// After the cooldown one probe is let through; it fails and reopens
+	time.Sleep(60 * time.Millisecond)
+	expect(3, open, false)
+	expect(3, open, true)
+
+	// A successful probe closes the circuit
+	time.Sleep(60 * time.Millisecond)
+	p.mu.Lock()
+	p.replies = []reply{{status: 200}}
+	p.mu.Unlock()
+	expect(4, closed, false)
+	expect(5, closed, false)
+	if b.Open() {
+		t.Error("Open = true after the circuit closed")
+	}
+}
+
+func TestBreakerHalfOpenProbe(t *testing.T) {
+	b := NewBreaker("test", 1, time.Millisecond)
+	b.record(failed)
+	time.Sleep(5 * time.Millisecond)
+
+	// Only one request probes the provider
+	if err := b.allow(); err != nil {
+		t.Fatalf("probe rejected: %v", err)
+	}
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("second request during the probe: %v, want ErrCircuitOpen", err)
+	}
+	// An abandoned probe lets the next request probe
+	b.record(abandoned)
+	if err := b.allow(); err != nil {
+		t.Errorf("request after an abandoned probe: %v", err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for v, want := range map[string]time.Duration{
+		"0":  0,
+		"3":  3 * time.Second,
+		"-1": -1,
+		"":   -1,
+		"x":  -1,
+	} {
+		got, ok := retryAfter(v)
+		if (want < 0) == ok || (ok && got != want) {
+			t.Errorf("retryAfter(%q) = %s, %v", v, got, ok)
+		}
+	}
+	at := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if got, ok := retryAfter(at); !ok || got < 59*time.Minute {
+		t.Errorf("retryAfter of a date an hour ahead = %s, %v", got, ok)
+	}
+}
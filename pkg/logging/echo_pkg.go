@@ -0,0 +1,49 @@
+package logging
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// RequestIDHeader carries the request id. An incoming value is kept so ids
+// can be correlated across services, otherwise a new one is generated.
+const RequestIDHeader = "X-Request-ID"
+
+var httpLogger = For("http")
+
+// Middleware tags every request with a request id, stores it in the
+// request context for the handlers' log lines, and logs the request once
+// it completes.
+func Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+
+			requestID := req.Header.Get(RequestIDHeader)
+			if requestID == "" || len(requestID) > 128 {
+				requestID = uuid.New().String()
+			}
+			c.Response().Header().Set(RequestIDHeader, requestID)
+
+			ctx := With(req.Context(), "request_id", requestID)
+			c.SetRequest(req.WithContext(ctx))
+
+			start := time.Now()
+			err := next(c)
+			if err != nil {
+				// Let echo write the error response so the status is known
+				c.Error(err)
+			}
+
+			httpLogger.InfoContext(ctx, "request",
+				"method", req.Method,
+				"route", c.Path(),
+				"status", c.Response().Status,
+				"duration", time.Since(start),
+			)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"sync/atomic"
+
+	"anne-hub/pkg/config"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// state is what Setup configures. Loggers from For look it up on every
+// call, so they can be created in package variables before Setup runs.
+type state struct {
+	base        slog.Handler
+	level       slog.Level
+	levels      map[string]slog.Level
+	transcripts string
+	pii         string
+}
+
+var current atomic.Pointer[state]
+
+func init() {
+	current.Store(&state{
+		base:        slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		level:       slog.LevelInfo,
+		transcripts: "redact",
+		pii:         "redact",
+	})
+}
+
+// Setup applies the log configuration and routes the standard library
+// logger through slog.
+func Setup(cfg config.LogConfig) {
+	setup(cfg, os.Stderr)
+}
+
+func setup(cfg config.LogConfig, w io.Writer) {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+
+	var base slog.Handler
+	if cfg.Format == "json" {
+		base = slog.NewJSONHandler(w, opts)
+	} else {
+		base = slog.NewTextHandler(w, opts)
+	}
+
+	s := &state{
+		base:        base,
+		levels:      make(map[string]slog.Level),
+		transcripts: cfg.Transcripts,
+		pii:         cfg.PII,
+	}
+	// Levels were checked by config.Validate
+	s.level.UnmarshalText([]byte(cfg.Level))
+	for pkg, l := range cfg.Levels {
+		var level slog.Level
+		level.UnmarshalText([]byte(l))
+		s.levels[pkg] = level
+	}
+	current.Store(s)
+
+	slog.SetDefault(For("std"))
+}
+
+// For returns the logger of a package. Its level is the package's entry in
+// the configured levels, falling back to the global level.
+func For(pkg string) *slog.Logger {
+	return slog.New(&handler{pkg: pkg})
+}
+
+type ctxKey struct{}
+
+// With returns a context whose attributes are added to every line logged
+// with it, e.g. request, session, user and device ids.
+func With(ctx context.Context, args ...any) context.Context {
+	prev, _ := ctx.Value(ctxKey{}).([]slog.Attr)
+	attrs := make([]slog.Attr, len(prev), len(prev)+len(args))
+	copy(attrs, prev)
+
+	r := slog.Record{}
+	r.Add(args...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	return context.WithValue(ctx, ctxKey{}, attrs)
+}
+
+// handler adds the package name and context attributes, and filters by the
+// package's level, before handing records to the configured handler.
+type handler struct {
+	pkg string
+	// ops replays WithAttrs and WithGroup calls onto the current base handler
+	ops []func(slog.Handler) slog.Handler
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	s := current.Load()
+	min, ok := s.levels[h.pkg]
+	if !ok {
+		min = s.level
+	}
+	return level >= min
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	base := current.Load().base.WithAttrs([]slog.Attr{slog.String("pkg", h.pkg)})
+	if attrs, ok := ctx.Value(ctxKey{}).([]slog.Attr); ok && len(attrs) > 0 {
+		base = base.WithAttrs(attrs)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		base = base.WithAttrs([]slog.Attr{slog.String("trace_id", sc.TraceID().String())})
+	}
+	for _, op := range h.ops {
+		base = op(base)
+	}
+	return base.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(base slog.Handler) slog.Handler { return base.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.with(func(base slog.Handler) slog.Handler { return base.WithGroup(name) })
+}
+
+func (h *handler) with(op func(slog.Handler) slog.Handler) slog.Handler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &handler{pkg: h.pkg, ops: append(ops, op)}
+}
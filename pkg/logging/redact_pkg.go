@@ -0,0 +1,42 @@
+package logging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+)
+
+// Transcript wraps user speech or LLM output so it is logged according to
+// the transcript policy.
+func Transcript(s string) slog.LogValuer {
+	return sensitive{value: s, policy: func(st *state) string { return st.transcripts }}
+}
+
+// PII wraps names, emails and similar user details so they are logged
+// according to the PII policy.
+func PII(s string) slog.LogValuer {
+	return sensitive{value: s, policy: func(st *state) string { return st.pii }}
+}
+
+type sensitive struct {
+	value  string
+	policy func(*state) string
+}
+
+// LogValue applies the policy when the line is written:
+//
+//	redact: only the length is logged
+//	hash:   a short hash, so equal values can be correlated
+//	full:   the value itself
+func (s sensitive) LogValue() slog.Value {
+	switch s.policy(current.Load()) {
+	case "full":
+		return slog.StringValue(s.value)
+	case "hash":
+		sum := sha256.Sum256([]byte(s.value))
+		return slog.StringValue("sha256:" + hex.EncodeToString(sum[:6]))
+	default:
+		return slog.StringValue(fmt.Sprintf("[redacted %d chars]", len(s.value)))
+	}
+}
@@ -0,0 +1,169 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Outcome labels for provider calls.
+const (
+	OK    = "ok"
+	Error = "error"
+)
+
+// latencyBuckets cover fast local work up to slow provider calls.
+var latencyBuckets = []float64{.05, .1, .25, .5, 1, 2, 4, 8, 16, 32}
+
+var (
+	// RequestAudioBytes is the size of the audio a device sent for one turn,
+	// as received (before codec decoding).
+	RequestAudioBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "anne_request_audio_bytes",
+		Help:    "Size of the audio received for one turn, before decoding.",
+		Buckets: prometheus.ExponentialBuckets(4096, 2, 10),
+	}, []string{"transport", "codec"})
+
+	// RequestAudioSeconds is the length of the decoded request audio.
+	RequestAudioSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "anne_request_audio_seconds",
+		Help:    "Duration of the audio received for one turn.",
+		Buckets: []float64{.5, 1, 2, 4, 8, 16, 32},
+	}, []string{"transport"})
+
+	STTDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "anne_stt_duration_seconds",
+		Help:    "Latency of speech-to-text requests.",
+		Buckets: latencyBuckets,
+	}, []string{"provider", "outcome"})
+
+	LLMDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "anne_llm_duration_seconds",
+		Help:    "Latency of LLM completion requests.",
+		Buckets: latencyBuckets,
+	}, []string{"provider", "outcome"})
+
+	TTSDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "anne_tts_duration_seconds",
+		Help:    "Latency of text-to-speech requests.",
+		Buckets: latencyBuckets,
+	}, []string{"provider", "outcome"})
+
+	// LLMTokens counts tokens reported in the LLM responses' usage, by type
+	// ("prompt" or "completion").
+	LLMTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anne_llm_tokens_total",
+		Help: "Tokens used by LLM requests.",
+	}, []string{"provider", "model", "type"})
+
+	// LLMResponseFailures counts LLM replies the WebSocket handler could not
+	// use, by reason.
+	LLMResponseFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anne_llm_response_parse_failures_total",
+		Help: "LLM responses that were not valid JSON in the expected format.",
+	}, []string{"reason"})
+
+	// TurnToEmotion is the time from a device's EOS to the emotion frame,
+	// i.e. how long the user waits for the first reaction.
+	TurnToEmotion = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "anne_turn_eos_to_emotion_seconds",
+		Help:    "Time from end of speech to the emotion frame being sent.",
+		Buckets: latencyBuckets,
+	})
+
+	// EmotionMatches counts the emotions of replies and error frames by how
+	// they matched the device's catalog ("exact", "alias", "nearest" or
+	// "fallback").
+	EmotionMatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anne_emotion_matches_total",
+		Help: "Emotions sent to devices by how they matched the emotion catalog.",
+	}, []string{"match"})
+
+	// ProviderRetries counts provider requests sent again, by the reason the
+	// previous attempt failed ("status_429", "status_503", "network", ...).
+	ProviderRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anne_provider_retries_total",
+		Help: "Provider HTTP requests retried.",
+	}, []string{"provider", "reason"})
+
+	// ProviderCircuitOpen is 1 while a provider's circuit breaker rejects
+	// requests.
+	ProviderCircuitOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "anne_provider_circuit_open",
+		Help: "Whether the provider's circuit breaker is open.",
+	}, []string{"provider"})
+
+	// PipelineServed counts pipeline stages by the provider that served
+	// them, "none" when the whole fallback chain failed.
+	PipelineServed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anne_pipeline_served_total",
+		Help: "Pipeline stages served, by provider.",
+	}, []string{"stage", "provider"})
+
+	// TTSCacheRequests counts TTS cache lookups, result is "hit" or "miss".
+	TTSCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anne_tts_cache_requests_total",
+		Help: "TTS cache lookups.",
+	}, []string{"result"})
+
+	TTSCacheBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "anne_tts_cache_bytes",
+		Help: "Size of the audio in the TTS cache.",
+	})
+
+	TTSCacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "anne_tts_cache_evictions_total",
+		Help: "TTS cache entries evicted to stay within the size limit.",
+	})
+
+	// WSErrors counts error frames sent to devices, by code.
+	WSErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anne_websocket_errors_total",
+		Help: "Error frames sent over WebSocket sessions.",
+	}, []string{"code"})
+
+	// TurnsInterrupted counts turns the device cut short, by how ("cancel"
+	// or "eos" when it spoke again).
+	TurnsInterrupted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anne_turns_interrupted_total",
+		Help: "WebSocket turns interrupted by the device.",
+	}, []string{"reason"})
+
+	// QuotaRejections counts turns refused for exceeding a plan limit.
+	QuotaRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "anne_quota_rejections_total",
+		Help: "Turns refused because a usage quota was exceeded.",
+	}, []string{"limit"})
+
+	ActiveWSSessions = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "anne_websocket_sessions_active",
+		Help: "Open WebSocket sessions.",
+	})
+
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "anne_http_request_duration_seconds",
+		Help:    "Latency of HTTP handlers by route.",
+		Buckets: latencyBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+// Outcome returns the outcome label for err.
+func Outcome(err error) string {
+	if err != nil {
+		return Error
+	}
+	return OK
+}
+
+// Since observes the time elapsed since start in seconds.
+func Since(o prometheus.Observer, start time.Time) {
+	o.Observe(time.Since(start).Seconds())
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
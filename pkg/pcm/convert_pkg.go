@@ -0,0 +1,257 @@
+package pcm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Buffer holds decoded audio as interleaved samples normalized to [-1, 1].
+type Buffer struct {
+	SampleRate int
+	Channels   int
+	Samples    []float64
+}
+
+// Frames returns the number of samples per channel.
+func (b *Buffer) Frames() int {
+	if b.Channels == 0 {
+		return 0
+	}
+	return len(b.Samples) / b.Channels
+}
+
+// Decode converts raw bytes in the given format into a Buffer.
+// Trailing bytes that do not make up a whole frame are dropped.
+func Decode(data []byte, f Format) (*Buffer, error) {
+	f = f.WithDefaults()
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+
+	order := byteOrder(f.Endianness)
+	size := f.BytesPerSample()
+	n := (len(data) / f.FrameSize()) * f.Channels
+	samples := make([]float64, n)
+
+	for i := 0; i < n; i++ {
+		b := data[i*size : (i+1)*size]
+		switch {
+		case f.Encoding == EncodingFloat && size == 4:
+			samples[i] = float64(math.Float32frombits(order.Uint32(b)))
+		case f.Encoding == EncodingFloat && size == 8:
+			samples[i] = math.Float64frombits(order.Uint64(b))
+		case size == 1:
+			samples[i] = (float64(b[0]) - 128) / 128
+		case size == 2:
+			samples[i] = float64(int16(order.Uint16(b))) / (1 << 15)
+		case size == 3:
+			samples[i] = float64(int24(b, f.Endianness)) / (1 << 23)
+		case size == 4:
+			samples[i] = float64(int32(order.Uint32(b))) / (1 << 31)
+		}
+	}
+
+	return &Buffer{SampleRate: f.SampleRate, Channels: f.Channels, Samples: samples}, nil
+}
+
+// Encode converts the buffer into raw bytes in the given format, resampling
+// and remixing as needed.
+func (b *Buffer) Encode(f Format) ([]byte, error) {
+	f = f.WithDefaults()
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+
+	samples := Remix(b.Samples, b.Channels, f.Channels)
+	samples = Resample(samples, f.Channels, b.SampleRate, f.SampleRate)
+
+	order := byteOrder(f.Endianness)
+	size := f.BytesPerSample()
+	out := make([]byte, len(samples)*size)
+
+	for i, s := range samples {
+		s = clamp(s)
+		o := out[i*size : (i+1)*size]
+		switch {
+		case f.Encoding == EncodingFloat && size == 4:
+			order.PutUint32(o, math.Float32bits(float32(s)))
+		case f.Encoding == EncodingFloat && size == 8:
+			order.PutUint64(o, math.Float64bits(s))
+		case size == 1:
+			o[0] = uint8(quantize(s, 1<<7) + 128)
+		case size == 2:
+			order.PutUint16(o, uint16(int16(quantize(s, 1<<15))))
+		case size == 3:
+			putInt24(o, int32(quantize(s, 1<<23)), f.Endianness)
+		case size == 4:
+			order.PutUint32(o, uint32(int32(quantize(s, 1<<31))))
+		}
+	}
+
+	return out, nil
+}
+
+// Convert decodes raw audio in one format and re-encodes it in another.
+func Convert(data []byte, from, to Format) ([]byte, error) {
+	from = from.WithDefaults()
+	to = to.WithDefaults()
+	if from == to {
+		return data, nil
+	}
+
+	buf, err := Decode(data, from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s audio: %w", from, err)
+	}
+
+	out, err := buf.Encode(to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s audio: %w", to, err)
+	}
+	return out, nil
+}
+
+// Remix changes the channel count of interleaved samples. Downmixing averages
+// all channels; upmixing copies a mono signal (or the first channels) across.
+func Remix(samples []float64, from, to int) []float64 {
+	if from == to || from == 0 || to == 0 {
+		return samples
+	}
+
+	frames := len(samples) / from
+	out := make([]float64, frames*to)
+
+	for i := 0; i < frames; i++ {
+		frame := samples[i*from : (i+1)*from]
+		if to == 1 {
+			var sum float64
+			for _, s := range frame {
+				sum += s
+			}
+			out[i] = sum / float64(from)
+			continue
+		}
+		for c := 0; c < to; c++ {
+			if from == 1 {
+				out[i*to+c] = frame[0]
+			} else if c < from {
+				out[i*to+c] = frame[c]
+			}
+		}
+	}
+
+	return out
+}
+
+// Resample changes the sample rate of interleaved samples using linear
+// interpolation. Downsampling first applies a moving-average low-pass filter
+// to keep aliasing down.
+func Resample(samples []float64, channels, fromRate, toRate int) []float64 {
+	if fromRate == toRate || fromRate == 0 || toRate == 0 || channels == 0 {
+		return samples
+	}
+
+	if toRate < fromRate {
+		samples = lowPass(samples, channels, fromRate/toRate)
+	}
+
+	frames := len(samples) / channels
+	outFrames := int(int64(frames) * int64(toRate) / int64(fromRate))
+	out := make([]float64, outFrames*channels)
+	ratio := float64(fromRate) / float64(toRate)
+
+	for i := 0; i < outFrames; i++ {
+		pos := float64(i) * ratio
+		idx := int(pos)
+		frac := pos - float64(idx)
+		next := idx + 1
+		if next >= frames {
+			next = frames - 1
+		}
+		for c := 0; c < channels; c++ {
+			a := samples[idx*channels+c]
+			b := samples[next*channels+c]
+			out[i*channels+c] = a + (b-a)*frac
+		}
+	}
+
+	return out
+}
+
+// lowPass runs a moving average of the given width over each channel.
+func lowPass(samples []float64, channels, width int) []float64 {
+	if width < 2 {
+		return samples
+	}
+
+	frames := len(samples) / channels
+	out := make([]float64, len(samples))
+
+	for c := 0; c < channels; c++ {
+		var sum float64
+		for i := 0; i < frames; i++ {
+			sum += samples[i*channels+c]
+			if i >= width {
+				sum -= samples[(i-width)*channels+c]
+			}
+			n := width
+			if i+1 < width {
+				n = i + 1
+			}
+			out[i*channels+c] = sum / float64(n)
+		}
+	}
+
+	return out
+}
+
+func byteOrder(e Endianness) binary.ByteOrder {
+	if e == BigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+func int24(b []byte, e Endianness) int32 {
+	var v int32
+	if e == BigEndian {
+		v = int32(b[0])<<16 | int32(b[1])<<8 | int32(b[2])
+	} else {
+		v = int32(b[2])<<16 | int32(b[1])<<8 | int32(b[0])
+	}
+	if v&0x800000 != 0 {
+		v |= ^0xffffff
+	}
+	return v
+}
+
+func putInt24(b []byte, v int32, e Endianness) {
+	if e == BigEndian {
+		b[0], b[1], b[2] = byte(v>>16), byte(v>>8), byte(v)
+	} else {
+		b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+	}
+}
+
+func clamp(s float64) float64 {
+	if s > 1 {
+		return 1
+	}
+	if s < -1 {
+		return -1
+	}
+	return s
+}
+
+// quantize scales a normalized sample to an integer range of [-scale, scale-1].
+func quantize(s float64, scale int64) int64 {
+	v := int64(math.Round(s * float64(scale)))
+	if v > scale-1 {
+		v = scale - 1
+	}
+	if v < -scale {
+		v = -scale
+	}
+	return v
+}
@@ -0,0 +1,187 @@
+package pcm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// sine returns frames of a sine of the given frequency at amplitude 0.5,
+// the same on every channel.
+func sine(freq float64, rate, channels, frames int) []float64 {
+	samples := make([]float64, frames*channels)
+	for i := 0; i < frames; i++ {
+		for c := 0; c < channels; c++ {
+			samples[i*channels+c] = 0.5 * math.Sin(2*math.Pi*freq*float64(i)/float64(rate))
+		}
+	}
+	return samples
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	in := sine(440, 16000, 1, 1600)
+	source := Buffer{SampleRate: 16000, Channels: 1, Samples: in}
+	data, err := source.Encode(M5Format)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	formats := []Format{
+		{SampleRate: 16000, BitDepth: 8, Channels: 1},
+		{SampleRate: 16000, BitDepth: 16, Channels: 1, Endianness: BigEndian},
+		{SampleRate: 16000, BitDepth: 24, Channels: 1},
+		{SampleRate: 16000, BitDepth: 24, Channels: 1, Endianness: BigEndian},
+		{SampleRate: 16000, BitDepth: 32, Channels: 1},
+		{SampleRate: 16000, BitDepth: 32, Channels: 1, Encoding: EncodingFloat},
+		{SampleRate: 16000, BitDepth: 64, Channels: 1, Encoding: EncodingFloat, Endianness: BigEndian},
+		{SampleRate: 16000, BitDepth: 16, Channels: 2},
+	}
+	for _, f := range formats {
+		t.Run(f.WithDefaults().String(), func(t *testing.T) {
+			converted, err := Convert(data, M5Format, f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := len(in) * f.WithDefaults().FrameSize(); len(converted) != want {
+				t.Fatalf("converted to %d bytes, want %d", len(converted), want)
+			}
+			back, err := Convert(converted, f, M5Format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := Decode(back, M5Format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// 8 bits lose the most precision
+			tolerance := 1.0 / (1 << 7)
+			for i, s := range out.Samples {
+				if math.Abs(s-in[i]) > tolerance {
+					t.Fatalf("sample %d = %f after the round trip, want %f", i, s, in[i])
+				}
+			}
+		})
+	}
+}
+
+func TestConvertSameFormat(t *testing.T) {
+	data := []byte{1, 2, 3, 4}
+	out, err := Convert(data, Format{}, M5Format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Errorf("Convert changed audio already in the target format: %v", out)
+	}
+}
+
+func TestDecode24BitNegative(t *testing.T) {
+	f := Format{SampleRate: 16000, BitDepth: 24, Channels: 1}
+	for _, tc := range []struct {
+		endianness Endianness
+		data       []byte
+	}{
+		{LittleEndian, []byte{0x00, 0x00, 0xc0}},
+		{BigEndian, []byte{0xc0, 0x00, 0x00}},
+	} {
+		f.Endianness = tc.endianness
+		buf, err := Decode(tc.data, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(buf.Samples) != 1 || buf.Samples[0] != -0.5 {
+			t.Errorf("%s: samples = %v, want [-0.5]", tc.endianness, buf.Samples)
+		}
+	}
+}
+
+func TestDecodeDropsPartialFrames(t *testing.T) {
+	var data bytes.Buffer
+	binary.Write(&data, binary.LittleEndian, []int16{1 << 14, -1 << 14, 1 << 14})
+	buf, err := Decode(data.Bytes(), Format{SampleRate: 16000, BitDepth: 16, Channels: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.Frames() != 1 || len(buf.Samples) != 2 {
+		t.Errorf("decoded %d frames of %v, want 1", buf.Frames(), buf.Samples)
+	}
+}
+
+func TestRemix(t *testing.T) {
+	if got := Remix([]float64{0.2, 0.4, -1, 0}, 2, 1); !equal(got, []float64{0.3, -0.5}) {
+		t.Errorf("stereo to mono = %v", got)
+	}
+	if got := Remix([]float64{0.2, -0.5}, 1, 2); !equal(got, []float64{0.2, 0.2, -0.5, -0.5}) {
+		t.Errorf("mono to stereo = %v", got)
+	}
+	if got := Remix([]float64{0.1, 0.2, 0.3, 0.4}, 2, 3); !equal(got, []float64{0.1, 0.2, 0, 0.3, 0.4, 0}) {
+		t.Errorf("stereo to 3 channels = %v", got)
+	}
+}
+
+func TestResample(t *testing.T) {
+	t.Run("upsample", func(t *testing.T) {
+		got := Resample([]float64{0, 1, 0, -1}, 1, 8000, 16000)
+		if want := []float64{0, 0.5, 1, 0.5, 0, -0.5, -1, -1}; !equal(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("downsample", func(t *testing.T) {
+		in := sine(200, 48000, 2, 4800)
+		out := Resample(in, 2, 48000, 16000)
+		if len(out) != 1600*2 {
+			t.Fatalf("got %d samples, want %d", len(out), 1600*2)
+		}
+		// The low-pass filter barely touches 200 Hz, it only delays the
+		// signal by one input sample
+		for i := 2; i < len(out); i++ {
+			want := 0.5 * math.Sin(2*math.Pi*200*float64(3*(i/2)-1)/48000)
+			if math.Abs(out[i]-want) > 0.01 {
+				t.Fatalf("sample %d = %f, want %f", i, out[i], want)
+			}
+		}
+	})
+
+	t.Run("same rate", func(t *testing.T) {
+		in := []float64{0.1, 0.2}
+		if got := Resample(in, 1, 16000, 16000); !equal(got, in) {
+			t.Errorf("got %v", got)
+		}
+	})
+}
+
+func TestParseFormat(t *testing.T) {
+	f, err := ParseFormat("rate=44100; bits=24; channels=2; endian=BE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Format{SampleRate: 44100, BitDepth: 24, Channels: 2, Endianness: BigEndian, Encoding: EncodingPCM}
+	if f != want {
+		t.Errorf("got %+v, want %+v", f, want)
+	}
+
+	if f, err := ParseFormat(""); err != nil || f != M5Format {
+		t.Errorf("empty spec = %+v, %v, want M5Format", f, err)
+	}
+
+	for _, spec := range []string{"rate", "rate=fast", "volume=11", "bits=12", "encoding=float;bits=16"} {
+		if _, err := ParseFormat(spec); err == nil {
+			t.Errorf("ParseFormat(%q) succeeded", spec)
+		}
+	}
+}
+
+func equal(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,172 @@
+package pcm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Encoding describes how individual samples are stored.
+type Encoding string
+
+const (
+	// EncodingPCM is linear integer PCM. 8-bit samples are unsigned (as in
+	// WAV files), every other bit depth is signed two's complement.
+	EncodingPCM Encoding = "pcm"
+	// EncodingFloat is IEEE 754 floating point in the range [-1, 1].
+	EncodingFloat Encoding = "float"
+)
+
+// Endianness describes the byte order of multi-byte samples.
+type Endianness string
+
+const (
+	LittleEndian Endianness = "le"
+	BigEndian    Endianness = "be"
+)
+
+// Format describes a raw audio stream.
+type Format struct {
+	SampleRate int        `json:"sample_rate"`
+	BitDepth   int        `json:"bit_depth"`
+	Channels   int        `json:"channels"`
+	Endianness Endianness `json:"endianness,omitempty"`
+	Encoding   Encoding   `json:"encoding,omitempty"`
+}
+
+// M5Format is what the M5 based wearable streams: 16 kHz, 16-bit, mono, little-endian.
+var M5Format = Format{
+	SampleRate: 16000,
+	BitDepth:   16,
+	Channels:   1,
+	Endianness: LittleEndian,
+	Encoding:   EncodingPCM,
+}
+
+// SpeechFormat is the format all request audio is normalized to before STT.
+var SpeechFormat = M5Format
+
+// WithDefaults fills unset fields from M5Format.
+func (f Format) WithDefaults() Format {
+	if f.SampleRate == 0 {
+		f.SampleRate = M5Format.SampleRate
+	}
+	if f.BitDepth == 0 {
+		f.BitDepth = M5Format.BitDepth
+	}
+	if f.Channels == 0 {
+		f.Channels = M5Format.Channels
+	}
+	if f.Endianness == "" {
+		f.Endianness = LittleEndian
+	}
+	if f.Encoding == "" {
+		f.Encoding = EncodingPCM
+	}
+	return f
+}
+
+// Validate checks that the format can be decoded by this package.
+func (f Format) Validate() error {
+	if f.SampleRate < 1000 || f.SampleRate > 384000 {
+		return fmt.Errorf("unsupported sample rate: %d", f.SampleRate)
+	}
+	if f.Channels < 1 || f.Channels > 8 {
+		return fmt.Errorf("unsupported channel count: %d", f.Channels)
+	}
+	if f.Endianness != LittleEndian && f.Endianness != BigEndian {
+		return fmt.Errorf("unsupported endianness: %q", f.Endianness)
+	}
+
+	switch f.Encoding {
+	case EncodingPCM:
+		switch f.BitDepth {
+		case 8, 16, 24, 32:
+		default:
+			return fmt.Errorf("unsupported PCM bit depth: %d", f.BitDepth)
+		}
+	case EncodingFloat:
+		if f.BitDepth != 32 && f.BitDepth != 64 {
+			return fmt.Errorf("unsupported float bit depth: %d", f.BitDepth)
+		}
+	default:
+		return fmt.Errorf("unsupported encoding: %q", f.Encoding)
+	}
+
+	return nil
+}
+
+// BytesPerSample returns the size of a single sample of one channel.
+func (f Format) BytesPerSample() int {
+	return f.BitDepth / 8
+}
+
+// FrameSize returns the size of one sample across all channels.
+func (f Format) FrameSize() int {
+	return f.BytesPerSample() * f.Channels
+}
+
+// ByteRate returns the number of bytes per second of audio.
+func (f Format) ByteRate() int {
+	return f.FrameSize() * f.SampleRate
+}
+
+// Duration returns how long n bytes of audio in this format play for.
+func (f Format) Duration(n int) time.Duration {
+	if f.ByteRate() == 0 {
+		return 0
+	}
+	return time.Duration(int64(n) * int64(time.Second) / int64(f.ByteRate()))
+}
+
+// String renders the format in the same key=value form ParseFormat accepts.
+func (f Format) String() string {
+	return fmt.Sprintf("rate=%d;bits=%d;channels=%d;endian=%s;encoding=%s",
+		f.SampleRate, f.BitDepth, f.Channels, f.Endianness, f.Encoding)
+}
+
+// ParseFormat parses a format spec like "rate=44100;bits=24;channels=2".
+// Missing keys fall back to M5Format.
+func ParseFormat(spec string) (Format, error) {
+	var f Format
+
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return Format{}, fmt.Errorf("invalid format parameter %q", part)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.ToLower(strings.TrimSpace(value))
+
+		var err error
+		switch key {
+		case "rate":
+			f.SampleRate, err = strconv.Atoi(value)
+		case "bits":
+			f.BitDepth, err = strconv.Atoi(value)
+		case "channels":
+			f.Channels, err = strconv.Atoi(value)
+		case "endian":
+			f.Endianness = Endianness(value)
+		case "encoding":
+			f.Encoding = Encoding(value)
+		default:
+			return Format{}, fmt.Errorf("unknown format parameter %q", key)
+		}
+		if err != nil {
+			return Format{}, fmt.Errorf("invalid value for %s: %w", key, err)
+		}
+	}
+
+	f = f.WithDefaults()
+	if err := f.Validate(); err != nil {
+		return Format{}, err
+	}
+	return f, nil
+}
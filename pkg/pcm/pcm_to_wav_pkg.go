@@ -5,107 +5,59 @@ import (
 	"encoding/binary"
 	"fmt"
 	"os"
-
-	"github.com/go-audio/audio"
-	"github.com/go-audio/wav"
 )
 
-func TTStoWav(tts []byte, filePath string) error {
+// TTStoWav wraps raw TTS audio in the given format into a WAV file at filePath.
+func TTStoWav(tts []byte, filePath string, format Format) error {
     if len(tts) == 0 {
         return fmt.Errorf("input TTS data is empty")
     }
 
-    // Define WAV parameters matching the PCM data
-    sampleRate := 16000 // 16 kHz
-    bitsPerSample := 16
-    numChannels := 1 // Mono
-
-    // Create a new WAV file
-    outFile, err := os.Create(filePath)
+    wavData, err := ToWAV(tts, format)
     if err != nil {
-        return fmt.Errorf("failed to create WAV file: %w", err)
-    }
-    defer outFile.Close()
-
-    // Initialize WAV encoder
-    encoder := wav.NewEncoder(outFile, sampleRate, bitsPerSample, numChannels, 1)
-    defer encoder.Close()
-
-    // Create PCM buffer
-    pcmBuffer := &audio.IntBuffer{
-        Format: &audio.Format{
-            SampleRate:  sampleRate,
-            NumChannels: numChannels,
-        },
-        Data: make([]int, len(tts)/2), // 2 bytes per sample for 16-bit audio
-    }
-
-    // Convert PCM bytes to samples
-    for i := 0; i < len(tts)-1; i += 2 {
-        // Little-endian conversion
-        sample := int(int16(tts[i]) | int16(tts[i+1])<<8)
-        pcmBuffer.Data[i/2] = sample
+        return err
     }
 
-    // Write PCM data to WAV
-    if err := encoder.Write(pcmBuffer); err != nil {
-        return fmt.Errorf("failed to write PCM data to WAV: %w", err)
-    }
-
-    // Finalize the WAV file
-    if err := encoder.Close(); err != nil {
-        return fmt.Errorf("failed to finalize WAV file: %w", err)
+    if err := os.WriteFile(filePath, wavData, 0o644); err != nil {
+        return fmt.Errorf("failed to write WAV file: %w", err)
     }
 
     return nil
 }
 
-func ToWAV(pcmData []byte) ([]byte, error) {
-    var wavData bytes.Buffer
-
-    // WAV file parameters
-    numChannels := uint16(1)       // Mono
-    sampleRate := uint32(16000)    // 16 kHz
-    bitsPerSample := uint16(16)    // 16-bit samples
-    byteRate := sampleRate * uint32(numChannels) * uint32(bitsPerSample/8)
-    blockAlign := numChannels * bitsPerSample / 8
-    dataSize := uint32(len(pcmData))
-
-    // Write RIFF header
-    wavData.WriteString("RIFF")
-    binary.Write(&wavData, binary.LittleEndian, uint32(36+dataSize))
-    wavData.WriteString("WAVE")
-
-    // Write fmt subchunk
-    wavData.WriteString("fmt ")
-    binary.Write(&wavData, binary.LittleEndian, uint32(16))          // Subchunk1Size
-    binary.Write(&wavData, binary.LittleEndian, uint16(1))           // AudioFormat (PCM)
-    binary.Write(&wavData, binary.LittleEndian, numChannels)
-    binary.Write(&wavData, binary.LittleEndian, sampleRate)
-    binary.Write(&wavData, binary.LittleEndian, byteRate)
-    binary.Write(&wavData, binary.LittleEndian, blockAlign)
-    binary.Write(&wavData, binary.LittleEndian, bitsPerSample)
-
-    // Write data subchunk
-    wavData.WriteString("data")
-    binary.Write(&wavData, binary.LittleEndian, dataSize)
-    wavData.Write(pcmData)
+// ToWAV wraps raw audio described by format into a WAV container.
+// WAV requires little-endian samples, so big-endian input is converted first.
+func ToWAV(pcmData []byte, format Format) ([]byte, error) {
+    format = format.WithDefaults()
+    if err := format.Validate(); err != nil {
+        return nil, err
+    }
 
-    return wavData.Bytes(), nil
-}
+    if format.Endianness == BigEndian {
+        le := format
+        le.Endianness = LittleEndian
+        converted, err := Convert(pcmData, format, le)
+        if err != nil {
+            return nil, err
+        }
+        pcmData, format = converted, le
+    }
 
+    // Drop any partial trailing frame
+    pcmData = pcmData[:len(pcmData)-len(pcmData)%format.FrameSize()]
 
-// ToWAV converts PCM data to WAV format.
-// It assumes PCM data is 16-bit mono at 16 kHz. Adjust parameters as needed.
-func M5ToWAVFile(pcmData []byte) ([]byte, error) {
     var wavData bytes.Buffer
 
     // WAV file parameters
-    numChannels := uint16(1)       // Mono
-    sampleRate := uint32(16000)    // 16 kHz
-    bitsPerSample := uint16(16)    // 16-bit samples
-    byteRate := sampleRate * uint32(numChannels) * uint32(bitsPerSample/8)
-    blockAlign := numChannels * bitsPerSample / 8
+    audioFormat := uint16(1) // PCM
+    if format.Encoding == EncodingFloat {
+        audioFormat = 3 // IEEE float
+    }
+    numChannels := uint16(format.Channels)
+    sampleRate := uint32(format.SampleRate)
+    bitsPerSample := uint16(format.BitDepth)
+    byteRate := uint32(format.ByteRate())
+    blockAlign := uint16(format.FrameSize())
     dataSize := uint32(len(pcmData))
 
     // Write RIFF header
@@ -116,7 +68,7 @@ func M5ToWAVFile(pcmData []byte) ([]byte, error) {
     // Write fmt subchunk
     wavData.WriteString("fmt ")
     binary.Write(&wavData, binary.LittleEndian, uint32(16))          // Subchunk1Size
-    binary.Write(&wavData, binary.LittleEndian, uint16(1))           // AudioFormat (PCM)
+    binary.Write(&wavData, binary.LittleEndian, audioFormat)
     binary.Write(&wavData, binary.LittleEndian, numChannels)
     binary.Write(&wavData, binary.LittleEndian, sampleRate)
     binary.Write(&wavData, binary.LittleEndian, byteRate)
@@ -131,4 +83,59 @@ func M5ToWAVFile(pcmData []byte) ([]byte, error) {
     return wavData.Bytes(), nil
 }
 
+// FromWAV returns the samples and format of a PCM or IEEE float WAV file.
+func FromWAV(wav []byte) ([]byte, Format, error) {
+    if len(wav) < 12 || string(wav[0:4]) != "RIFF" || string(wav[8:12]) != "WAVE" {
+        return nil, Format{}, fmt.Errorf("not a WAV file")
+    }
 
+    var format Format
+    haveFormat := false
+    for rest := wav[12:]; len(rest) >= 8; {
+        id, size := string(rest[0:4]), int(binary.LittleEndian.Uint32(rest[4:8]))
+        body := rest[8:]
+        if size > len(body) {
+            // Streamed WAVs may leave the size unset, take what is there
+            size = len(body)
+        }
+
+        switch id {
+        case "fmt ":
+            if size < 16 {
+                return nil, Format{}, fmt.Errorf("WAV fmt chunk too short")
+            }
+            encoding := EncodingPCM
+            switch binary.LittleEndian.Uint16(body[0:2]) {
+            case 1:
+            case 3:
+                encoding = EncodingFloat
+            default:
+                return nil, Format{}, fmt.Errorf("unsupported WAV encoding %d", binary.LittleEndian.Uint16(body[0:2]))
+            }
+            format = Format{
+                SampleRate: int(binary.LittleEndian.Uint32(body[4:8])),
+                BitDepth:   int(binary.LittleEndian.Uint16(body[14:16])),
+                Channels:   int(binary.LittleEndian.Uint16(body[2:4])),
+                Endianness: LittleEndian,
+                Encoding:   encoding,
+            }
+            if err := format.Validate(); err != nil {
+                return nil, Format{}, err
+            }
+            haveFormat = true
+        case "data":
+            if !haveFormat {
+                return nil, Format{}, fmt.Errorf("WAV data chunk before fmt chunk")
+            }
+            return body[:size], format, nil
+        }
+
+        // Chunks are padded to an even size
+        next := 8 + size + size%2
+        if next > len(rest) {
+            break
+        }
+        rest = rest[next:]
+    }
+    return nil, Format{}, fmt.Errorf("WAV file has no data chunk")
+}
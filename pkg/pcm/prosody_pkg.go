@@ -0,0 +1,356 @@
+package pcm
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Prosody describes how a request was spoken, beyond its words.
+type Prosody struct {
+	// LoudnessDB is the RMS level of the speech in dBFS
+	LoudnessDB float64 `json:"loudness_db"`
+	// PitchHz is the median fundamental frequency of the voiced speech,
+	// zero when no voiced speech was found
+	PitchHz float64 `json:"pitch_hz"`
+	// PitchRange is the spread of the pitch contour in semitones, between
+	// its 10th and 90th percentile
+	PitchRange float64 `json:"pitch_range"`
+	// PitchSlope is the trend of the pitch contour in semitones per second,
+	// positive when rising
+	PitchSlope float64 `json:"pitch_slope"`
+	// SpeakingRate is in syllables per second of speech, estimated from
+	// the peaks of its loudness
+	SpeakingRate float64 `json:"speaking_rate"`
+	// PauseRatio is the share of pauses between the first and last speech
+	PauseRatio float64 `json:"pause_ratio"`
+	// Arousal (calm to excited) and Valence (negative to positive) are
+	// coarse estimates in [-1, 1] from the features above
+	Arousal float64 `json:"arousal"`
+	Valence float64 `json:"valence"`
+	// Mood names the arousal/valence quadrant
+	Mood string `json:"mood"`
+}
+
+// Moods of a Prosody.
+const (
+	MoodNeutral = "neutral"
+	MoodExcited = "excited"
+	MoodUpset   = "upset"
+	MoodCalm    = "calm"
+	MoodLow     = "low"
+)
+
+const (
+	// prosodyRate is the sample rate audio is analyzed at; it keeps
+	// children's pitch while making the pitch search cheap
+	prosodyRate = 8000
+	// Frames of the analysis, in samples at prosodyRate
+	prosodyHop    = prosodyRate / 100 // 10 ms
+	prosodyWindow = prosodyRate / 25  // 40 ms
+	// Pitch range searched, covering adults and children
+	minPitchHz = 75
+	maxPitchHz = 600
+	// Normalized autocorrelation above which a frame counts as voiced
+	voicedCorrelation = 0.6
+	// Silences shorter than this are part of words, not pauses
+	minPauseFrames = 15
+)
+
+// Reference values of the children's speech the estimates are centered
+// on, and how far from them a feature counts as extreme.
+const (
+	refLoudnessDB   = -26
+	refPitchHz      = 260
+	refPitchRange   = 5
+	refSpeakingRate = 4
+	refPauseRatio   = 0.2
+)
+
+// AnalyzeProsody computes the prosodic features of speech in the given
+// format. Audio without speech returns a zero Prosody.
+func AnalyzeProsody(data []byte, f Format) (Prosody, error) {
+	buf, err := Decode(data, f)
+	if err != nil {
+		return Prosody{}, fmt.Errorf("failed to decode %s audio: %w", f, err)
+	}
+	samples := Remix(buf.Samples, buf.Channels, 1)
+	samples = Resample(samples, 1, buf.SampleRate, prosodyRate)
+
+	var p Prosody
+	frames := (len(samples) - prosodyWindow) / prosodyHop
+	if frames <= 0 {
+		return p, nil
+	}
+
+	// Frames well above the noise floor are speech. Audio that is speech
+	// throughout has no floor, so the threshold stays well below the peak.
+	rms := make([]float64, frames)
+	for i := range rms {
+		rms[i] = rootMeanSquare(samples[i*prosodyHop : i*prosodyHop+prosodyWindow])
+	}
+	sorted := slices.Clone(rms)
+	slices.Sort(sorted)
+	peak := sorted[len(sorted)-1]
+	threshold := max(min(percentile(sorted, 0.1)*3, peak*0.25), peak*0.03, 1e-3)
+	speech := make([]bool, frames)
+	first, last, speechFrames := -1, -1, 0
+	var energy float64
+	for i, r := range rms {
+		if r < threshold {
+			continue
+		}
+		speech[i] = true
+		speechFrames++
+		energy += r * r
+		if first < 0 {
+			first = i
+		}
+		last = i
+	}
+	if speechFrames == 0 {
+		return p, nil
+	}
+	p.LoudnessDB = 20 * math.Log10(math.Sqrt(energy/float64(speechFrames)))
+
+	p.PauseRatio = pauseRatio(speech[first : last+1])
+	speechSeconds := float64(speechFrames*prosodyHop) / prosodyRate
+	p.SpeakingRate = float64(syllables(rms, speech)) / speechSeconds
+
+	var pitch, times []float64
+	for i := range rms {
+		if !speech[i] {
+			continue
+		}
+		if hz, ok := framePitch(samples[i*prosodyHop : i*prosodyHop+prosodyWindow]); ok {
+			pitch = append(pitch, hz)
+			times = append(times, float64(i*prosodyHop)/prosodyRate)
+		}
+	}
+	if len(pitch) > 0 {
+		sortedPitch := slices.Clone(pitch)
+		slices.Sort(sortedPitch)
+		p.PitchHz = percentile(sortedPitch, 0.5)
+		p.PitchRange = semitones(percentile(sortedPitch, 0.9), percentile(sortedPitch, 0.1))
+		contour := make([]float64, len(pitch))
+		for i, hz := range pitch {
+			contour[i] = semitones(hz, p.PitchHz)
+		}
+		p.PitchSlope = slope(times, contour)
+	}
+
+	p.estimate()
+	p.round()
+	return p, nil
+}
+
+// Cues describes the notable features in words, like "loud" or "rising
+// intonation", leaving out those close to the reference speech.
+func (p Prosody) Cues() []string {
+	var cues []string
+	switch {
+	case p.LoudnessDB > refLoudnessDB+6:
+		cues = append(cues, "loud")
+	case p.LoudnessDB < refLoudnessDB-10:
+		cues = append(cues, "quiet")
+	}
+	if p.PitchHz > 0 {
+		switch {
+		case semitones(p.PitchHz, refPitchHz) > 3:
+			cues = append(cues, "high-pitched")
+		case semitones(p.PitchHz, refPitchHz) < -5:
+			cues = append(cues, "low-pitched")
+		}
+		switch {
+		case p.PitchRange > 2*refPitchRange:
+			cues = append(cues, "lively intonation")
+		case p.PitchRange < refPitchRange/3.0:
+			cues = append(cues, "flat intonation")
+		}
+		switch {
+		case p.PitchSlope > 1:
+			cues = append(cues, "rising intonation")
+		case p.PitchSlope < -1:
+			cues = append(cues, "falling intonation")
+		}
+	}
+	switch {
+	case p.SpeakingRate > refSpeakingRate+1.5:
+		cues = append(cues, "fast")
+	case p.SpeakingRate > 0 && p.SpeakingRate < refSpeakingRate-1.5:
+		cues = append(cues, "slow")
+	}
+	if p.PauseRatio > 2*refPauseRatio {
+		cues = append(cues, "many pauses")
+	}
+	return cues
+}
+
+// estimate derives arousal, valence and mood from the features. Loud, high,
+// varied and fast speech is aroused; a rising, varied contour and fluent
+// speech lean positive, a falling, flat contour with long pauses negative.
+// This is a rough heuristic without a per-child baseline, good enough to
+// hint at a mood but not to diagnose one.
+func (p *Prosody) estimate() {
+	loudness := scale(p.LoudnessDB-refLoudnessDB, 10)
+	speakingRate := scale(p.SpeakingRate-refSpeakingRate, 2)
+	fluency := scale(refPauseRatio-p.PauseRatio, refPauseRatio)
+	var pitch, pitchRange, pitchSlope float64
+	if p.PitchHz > 0 {
+		pitch = scale(semitones(p.PitchHz, refPitchHz), 6)
+		pitchRange = scale(p.PitchRange-refPitchRange, refPitchRange)
+		pitchSlope = scale(p.PitchSlope, 3)
+	}
+
+	p.Arousal = clamp(0.35*loudness + 0.2*pitch + 0.2*pitchRange + 0.25*speakingRate)
+	p.Valence = clamp(0.4*pitchSlope + 0.3*pitchRange + 0.3*fluency)
+
+	switch {
+	case p.Arousal > 0.3 && p.Valence >= 0:
+		p.Mood = MoodExcited
+	case p.Arousal > 0.3:
+		p.Mood = MoodUpset
+	case p.Arousal < -0.3 && p.Valence < -0.2:
+		p.Mood = MoodLow
+	case p.Arousal < -0.3:
+		p.Mood = MoodCalm
+	default:
+		p.Mood = MoodNeutral
+	}
+}
+
+// round keeps two decimals, as the features are estimates.
+func (p *Prosody) round() {
+	for _, v := range []*float64{&p.LoudnessDB, &p.PitchHz, &p.PitchRange, &p.PitchSlope, &p.SpeakingRate, &p.PauseRatio, &p.Arousal, &p.Valence} {
+		*v = math.Round(*v*100) / 100
+	}
+}
+
+// framePitch finds the fundamental frequency of a frame by normalized
+// autocorrelation, reporting false for unvoiced frames.
+func framePitch(frame []float64) (float64, bool) {
+	minLag, maxLag := prosodyRate/maxPitchHz, prosodyRate/minPitchHz
+	n := len(frame) - maxLag
+	if n <= 0 {
+		return 0, false
+	}
+
+	correlations := make([]float64, maxLag+1)
+	best := 0.0
+	for lag := minLag; lag <= maxLag; lag++ {
+		var xy, xx, yy float64
+		for i := 0; i < n; i++ {
+			x, y := frame[i], frame[i+lag]
+			xy += x * y
+			xx += x * x
+			yy += y * y
+		}
+		if xx > 0 && yy > 0 {
+			correlations[lag] = xy / math.Sqrt(xx*yy)
+		}
+		best = max(best, correlations[lag])
+	}
+	if best < voicedCorrelation {
+		return 0, false
+	}
+
+	// The shortest peak close to the best avoids picking a multiple of the
+	// period, which would halve the pitch
+	for lag := minLag + 1; lag < maxLag; lag++ {
+		c := correlations[lag]
+		if c >= 0.9*best && c >= correlations[lag-1] && c >= correlations[lag+1] {
+			return float64(prosodyRate) / float64(lag), true
+		}
+	}
+	return 0, false
+}
+
+// syllables counts the loudness peaks of the speech frames that stand out
+// from the dip before them, at most one per 100 ms.
+func syllables(rms []float64, speech []bool) int {
+	const (
+		neighbourhood = 5 // frames on each side a peak is the maximum of
+		minDipDB      = 3
+		minGap        = 10 // frames between peaks
+	)
+
+	count, lastPeak := 0, -minGap
+	dip := math.Inf(1)
+	for i, r := range rms {
+		db := 20 * math.Log10(max(r, 1e-9))
+		dip = min(dip, db)
+		if !speech[i] || i-lastPeak < minGap {
+			continue
+		}
+		peak := true
+		for j := max(0, i-neighbourhood); j <= min(len(rms)-1, i+neighbourhood); j++ {
+			if rms[j] > r {
+				peak = false
+				break
+			}
+		}
+		if peak && db-dip >= minDipDB {
+			count++
+			lastPeak = i
+			dip = db
+		}
+	}
+	return count
+}
+
+// pauseRatio returns the share of frames in silences long enough to be
+// pauses.
+func pauseRatio(speech []bool) float64 {
+	paused, run := 0, 0
+	for _, s := range speech {
+		if !s {
+			run++
+			continue
+		}
+		if run >= minPauseFrames {
+			paused += run
+		}
+		run = 0
+	}
+	return float64(paused) / float64(len(speech))
+}
+
+func rootMeanSquare(samples []float64) float64 {
+	var sum float64
+	for _, s := range samples {
+		sum += s * s
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
+
+// percentile returns the value at q in [0, 1] of sorted values.
+func percentile(sorted []float64, q float64) float64 {
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+// semitones returns the interval from b up to a.
+func semitones(a, b float64) float64 {
+	return 12 * math.Log2(a/b)
+}
+
+// slope fits a line through the points and returns its slope.
+func slope(x, y []float64) float64 {
+	n := float64(len(x))
+	var sx, sy, sxx, sxy float64
+	for i := range x {
+		sx += x[i]
+		sy += y[i]
+		sxx += x[i] * x[i]
+		sxy += x[i] * y[i]
+	}
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / d
+}
+
+// scale maps v to [-1, 1], reaching the ends at ±extreme.
+func scale(v, extreme float64) float64 {
+	return clamp(v / extreme)
+}
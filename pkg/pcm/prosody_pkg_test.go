@@ -0,0 +1,180 @@
+package pcm
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+// voice encodes a tone gliding from one pitch to another at amplitude amp,
+// switched on for the given seconds of every period, in the M5 format.
+func voice(t *testing.T, fromHz, toHz, amp, seconds, on, period float64) []byte {
+	t.Helper()
+	frames := int(seconds * 16000)
+	samples := make([]float64, frames)
+	phase := 0.0
+	for i := range samples {
+		at := float64(i) / 16000
+		phase += 2 * math.Pi * (fromHz + (toHz-fromHz)*at/seconds) / 16000
+		if math.Mod(at, period) < on {
+			samples[i] = amp * math.Sin(phase)
+		}
+	}
+	buf := Buffer{SampleRate: 16000, Channels: 1, Samples: samples}
+	data, err := buf.Encode(M5Format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestAnalyzeProsodySilence(t *testing.T) {
+	p, err := AnalyzeProsody(make([]byte, 32000), M5Format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != (Prosody{}) {
+		t.Errorf("prosody of silence = %+v, want zero", p)
+	}
+
+	p, err = AnalyzeProsody(make([]byte, 100), M5Format)
+	if err != nil || p != (Prosody{}) {
+		t.Errorf("prosody of 3 ms = %+v, %v, want zero", p, err)
+	}
+}
+
+func TestAnalyzeProsodySteadyTone(t *testing.T) {
+	p, err := AnalyzeProsody(voice(t, 250, 250, 0.05, 2, 2, 2), M5Format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(p.PitchHz-250) > 5 {
+		t.Errorf("pitch = %.2f Hz, want 250", p.PitchHz)
+	}
+	if p.PitchRange > 0.5 || math.Abs(p.PitchSlope) > 0.5 {
+		t.Errorf("range %.2f and slope %.2f of a steady tone, want about 0", p.PitchRange, p.PitchSlope)
+	}
+	// An amplitude of 0.05 is 0.035 RMS
+	if math.Abs(p.LoudnessDB-(-29)) > 0.5 {
+		t.Errorf("loudness = %.2f dB, want -29", p.LoudnessDB)
+	}
+	if p.PauseRatio != 0 {
+		t.Errorf("pause ratio = %.2f, want 0", p.PauseRatio)
+	}
+	if !slices.Contains(p.Cues(), "flat intonation") {
+		t.Errorf("cues = %v, want flat intonation", p.Cues())
+	}
+}
+
+func TestAnalyzeProsodyRisingPitch(t *testing.T) {
+	p, err := AnalyzeProsody(voice(t, 200, 400, 0.1, 2, 2, 2), M5Format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An octave in two seconds
+	if p.PitchSlope < 4 || p.PitchSlope > 8 {
+		t.Errorf("slope = %.2f semitones per second, want about 6", p.PitchSlope)
+	}
+	if p.PitchRange < 7 {
+		t.Errorf("range = %.2f semitones, want most of an octave", p.PitchRange)
+	}
+	if !slices.Contains(p.Cues(), "rising intonation") {
+		t.Errorf("cues = %v, want rising intonation", p.Cues())
+	}
+}
+
+func TestAnalyzeProsodyRhythm(t *testing.T) {
+	// Syllables of 150 ms every 250 ms, 4 per second
+	p, err := AnalyzeProsody(voice(t, 260, 260, 0.05, 3, 0.15, 0.25), M5Format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The rate is per second of speech, not of the audio
+	if p.SpeakingRate < 4 || p.SpeakingRate > 7 {
+		t.Errorf("speaking rate = %.2f, want 4 to 7", p.SpeakingRate)
+	}
+	if p.PauseRatio != 0 {
+		t.Errorf("pause ratio = %.2f, gaps between syllables are no pauses", p.PauseRatio)
+	}
+
+	// Words of 200 ms with 400 ms pauses
+	p, err = AnalyzeProsody(voice(t, 260, 260, 0.05, 3, 0.2, 0.6), M5Format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.PauseRatio < 0.5 {
+		t.Errorf("pause ratio = %.2f, want well over half", p.PauseRatio)
+	}
+	if !slices.Contains(p.Cues(), "many pauses") {
+		t.Errorf("cues = %v, want many pauses", p.Cues())
+	}
+}
+
+func TestAnalyzeProsodyFormats(t *testing.T) {
+	data := voice(t, 300, 300, 0.1, 1, 1, 1)
+	stereo := Format{SampleRate: 44100, BitDepth: 24, Channels: 2}
+	converted, err := Convert(data, M5Format, stereo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := AnalyzeProsody(converted, stereo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(p.PitchHz-300) > 10 {
+		t.Errorf("pitch of 44.1 kHz stereo = %.2f Hz, want 300", p.PitchHz)
+	}
+
+	if _, err := AnalyzeProsody(data, Format{BitDepth: 12}); err == nil {
+		t.Error("analyzed audio of an invalid format")
+	}
+}
+
+func TestProsodyEstimate(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		p    Prosody
+		mood string
+		cues []string
+	}{
+		{
+			name: "reference",
+			p:    Prosody{LoudnessDB: refLoudnessDB, PitchHz: refPitchHz, PitchRange: refPitchRange, SpeakingRate: refSpeakingRate, PauseRatio: refPauseRatio},
+			mood: MoodNeutral,
+		},
+		{
+			name: "excited",
+			p:    Prosody{LoudnessDB: -14, PitchHz: 380, PitchRange: 12, PitchSlope: 2, SpeakingRate: 6, PauseRatio: 0.05},
+			mood: MoodExcited,
+			cues: []string{"loud", "high-pitched", "lively intonation", "rising intonation", "fast"},
+		},
+		{
+			name: "upset",
+			p:    Prosody{LoudnessDB: -14, PitchHz: 330, PitchRange: 6, PitchSlope: -3, SpeakingRate: 6, PauseRatio: 0.5},
+			mood: MoodUpset,
+			cues: []string{"loud", "high-pitched", "falling intonation", "fast", "many pauses"},
+		},
+		{
+			name: "low",
+			p:    Prosody{LoudnessDB: -40, PitchHz: 180, PitchRange: 1, PitchSlope: -2, SpeakingRate: 2, PauseRatio: 0.5},
+			mood: MoodLow,
+			cues: []string{"quiet", "low-pitched", "flat intonation", "falling intonation", "slow", "many pauses"},
+		},
+		{
+			name: "calm",
+			p:    Prosody{LoudnessDB: -36, PitchHz: 240, PitchRange: 5, SpeakingRate: 2.4, PauseRatio: 0.1},
+			mood: MoodCalm,
+			cues: []string{"slow"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.p.estimate()
+			if tc.p.Mood != tc.mood {
+				t.Errorf("mood = %s (arousal %.2f, valence %.2f), want %s", tc.p.Mood, tc.p.Arousal, tc.p.Valence, tc.mood)
+			}
+			if cues := tc.p.Cues(); !slices.Equal(cues, tc.cues) {
+				t.Errorf("cues = %v, want %v", cues, tc.cues)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+// Package phrasepack builds the offline phrase pack of a user: greetings
+// with the child's name, what to say when the hub cannot be reached,
+// bedtime lines and reminders of open tasks, spoken in Anne's voice. The
+// wearable downloads the pack as a zip and plays from it while the hub or
+// Wi-Fi is down.
+//
+// A pack's version is a hash of its texts and voice, so it changes exactly
+// when the pack would sound different. Built packs are kept in blob storage
+// as phrasepacks/<user id>/<language>/<version>.zip.
+package phrasepack
+
+import (
+	"anne-hub/models"
+	"anne-hub/pkg/audiofilters"
+	"anne-hub/pkg/blob"
+	"anne-hub/pkg/logging"
+	"anne-hub/pkg/pcm"
+	"anne-hub/pkg/pipeline"
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var logger = logging.For("phrasepack")
+
+// prefix namespaces built packs within the blob store.
+const prefix = "phrasepacks"
+
+// Phrase categories, so firmware knows when to play a phrase.
+const (
+	Greeting     = "greeting"
+	Unreachable  = "unreachable"
+	Bedtime      = "bedtime"
+	TaskReminder = "task_reminder"
+)
+
+// DefaultLanguage is used when a device does not ask for a language.
+const DefaultLanguage = "en"
+
+// maxTaskReminders caps the reminders in a pack, soonest due first.
+const maxTaskReminders = 10
+
+// templates holds the fixed lines per language; {name} is replaced by the
+// child's name.
+var templates = map[string]map[string][]string{
+	"en": {
+		Greeting: {
+			"Good morning, {name}!",
+			"Hi {name}, it's me, Anne!",
+			"Hello {name}, I'm happy to see you!",
+		},
+		Unreachable: {
+			"I can't hear you right now, {name}. Let's try again in a little while.",
+			"My connection is taking a nap. Try again later, {name}!",
+		},
+		Bedtime: {
+			"Good night, {name}. Sleep tight!",
+			"Time for bed, {name}. Sweet dreams!",
+		},
+	},
+	"de": {
+		Greeting: {
+			"Guten Morgen, {name}!",
+			"Hallo {name}, ich bin's, Anne!",
+			"Hallo {name}, schön dich zu sehen!",
+		},
+		Unreachable: {
+			"Ich kann dich gerade nicht hören, {name}. Versuchen wir es gleich noch einmal.",
+			"Meine Verbindung macht gerade ein Nickerchen. Versuch es später noch einmal, {name}!",
+		},
+		Bedtime: {
+			"Gute Nacht, {name}. Schlaf gut!",
+			"Zeit fürs Bett, {name}. Träum was Schönes!",
+		},
+	},
+}
+
+// reminders are the task reminder lines per language, %s is the task title.
+var reminders = map[string]string{
+	"en": "{name}, don't forget: %s!",
+	"de": "{name}, denk daran: %s!",
+}
+
+// Supported reports whether a pack can be built for language.
+func Supported(language string) bool {
+	_, ok := templates[language]
+	return ok
+}
+
+// Phrase is one line of a pack.
+type Phrase struct {
+	ID       string `json:"id"`
+	Category string `json:"category"`
+	Text     string `json:"text"`
+	// File is the WAV file within the zip
+	File string `json:"file"`
+	// TaskID links a reminder to its task
+	TaskID int64 `json:"task_id,omitempty"`
+}
+
+// Manifest describes a pack. It is stored in the zip as manifest.json and
+// served on its own, so devices can check for a new version cheaply.
+type Manifest struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Language string    `json:"language"`
+	Version  string    `json:"version"`
+	Phrases  []Phrase  `json:"phrases"`
+}
+
+// Phrases returns the lines of a user's pack. tasks are the user's open
+// tasks, reminded of soonest due first.
+func Phrases(user models.User, tasks []models.Task, language string) []Phrase {
+	name := user.FirstName
+	if name == "" {
+		name = user.Username
+	}
+	fill := func(line string) string {
+		return strings.TrimSpace(strings.ReplaceAll(line, "{name}", name))
+	}
+
+	var phrases []Phrase
+	add := func(category, text string, taskID int64) {
+		id := fmt.Sprintf("%s_%d", category, len(phrases)+1)
+		phrases = append(phrases, Phrase{ID: id, Category: category, Text: text, File: id + ".wav", TaskID: taskID})
+	}
+	for _, category := range []string{Greeting, Unreachable, Bedtime} {
+		for _, line := range templates[language][category] {
+			add(category, fill(line), 0)
+		}
+	}
+
+	tasks = slices.Clone(tasks)
+	slices.SortStableFunc(tasks, func(a, b models.Task) int {
+		return a.DueDate.Compare(b.DueDate)
+	})
+	for i, task := range tasks {
+		if i == maxTaskReminders {
+			break
+		}
+		add(TaskReminder, fill(fmt.Sprintf(reminders[language], task.Title)), task.ID)
+	}
+	return phrases
+}
+
+// Builder renders packs with a pipeline and keeps them in blob storage.
+type Builder struct {
+	pipeline *pipeline.Pipeline
+	blobs    blob.Store
+	persona  string
+}
+
+// Setup creates the builder of the packs, in Anne's voice.
+func Setup(p *pipeline.Pipeline, blobs blob.Store) *Builder {
+	return New(p, blobs, audiofilters.DefaultPersona)
+}
+
+// New creates a builder rendering with p in the voice persona and storing
+// packs in blobs.
+func New(p *pipeline.Pipeline, blobs blob.Store, persona string) *Builder {
+	return &Builder{pipeline: p, blobs: blobs, persona: persona}
+}
+
+// Manifest returns the manifest of the user's current pack, without
+// building it.
+func (b *Builder) Manifest(user models.User, tasks []models.Task, language string) Manifest {
+	m := Manifest{UserID: user.ID, Language: language, Phrases: Phrases(user, tasks, language)}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", b.persona, b.voice(language))
+	for _, p := range m.Phrases {
+		fmt.Fprintf(h, "%s\x00%s\x00%d\x00", p.ID, p.Text, p.TaskID)
+	}
+	m.Version = hex.EncodeToString(h.Sum(nil)[:6])
+	return m
+}
+
+// voice identifies the primary TTS voice of language, so a new voice makes
+// a new version.
+func (b *Builder) voice(language string) string {
+	if len(b.pipeline.TTS) == 0 {
+		return ""
+	}
+	return b.pipeline.TTS[0].Voice(language)
+}
+
+// Key returns the blob key of a pack version.
+func Key(userID uuid.UUID, language, version string) string {
+	return blob.Join(prefix, userID.String(), language, version+".zip")
+}
+
+// Build makes sure the pack of m is in the blob store, rendering it if
+// needed, and returns its key. Older versions of the user's pack in that
+// language are deleted.
+func (b *Builder) Build(ctx context.Context, m Manifest) (string, error) {
+	key := Key(m.UserID, m.Language, m.Version)
+	_, err := b.blobs.Get(ctx, key)
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, blob.ErrNotFound) {
+		return "", err
+	}
+
+	start := time.Now()
+	pack, err := b.render(ctx, m)
+	if err != nil {
+		return "", err
+	}
+
+	dir := blob.Join(prefix, m.UserID.String(), m.Language) + "/"
+	if err := b.blobs.DeletePrefix(ctx, dir); err != nil {
+		logger.WarnContext(ctx, "failed to delete old phrase packs", "error", err)
+	}
+	if err := b.blobs.Put(ctx, key, pack, "application/zip"); err != nil {
+		return "", err
+	}
+
+	logger.InfoContext(ctx, "phrase pack built", "version", m.Version, "language", m.Language,
+		"phrases", len(m.Phrases), "bytes", len(pack), "duration", time.Since(start))
+	return key, nil
+}
+
+// render synthesizes every phrase and zips them with the manifest.
+func (b *Builder) render(ctx context.Context, m Manifest) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	for _, p := range m.Phrases {
+		wav, err := b.speak(ctx, p.Text, m.Language)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render phrase %s: %w", p.ID, err)
+		}
+		// WAV barely compresses, store it as is
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: p.File, Method: zip.Store})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(wav); err != nil {
+			return nil, err
+		}
+	}
+
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	w, err := zw.Create("manifest.json")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(manifest); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (b *Builder) speak(ctx context.Context, text, language string) ([]byte, error) {
+	speech, _, err := b.pipeline.Synthesize(ctx, text, language)
+	if err != nil {
+		return nil, err
+	}
+	audio, err := audiofilters.ApplyPersona(b.persona, speech.Audio, speech.Format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply voice effects: %w", err)
+	}
+	return pcm.ToWAV(audio, speech.Format)
+}
+
+// DeleteUser removes every pack of a user.
+func (b *Builder) DeleteUser(ctx context.Context, userID uuid.UUID) error {
+	if err := b.blobs.DeletePrefix(ctx, blob.Join(prefix, userID.String())+"/"); err != nil {
+		return fmt.Errorf("failed to delete phrase packs of user %s: %w", userID, err)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+// Package pipeline runs the speech-to-text, LLM and text-to-speech stages of
+// a turn over ordered chains of providers. When a provider fails the next
+// one is tried, and the name of the one that served is returned so it can be
+// recorded with the turn.
+package pipeline
+
+import (
+	"anne-hub/models"
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/logging"
+	"anne-hub/pkg/metrics"
+	"anne-hub/pkg/pcm"
+	"anne-hub/pkg/ttscache"
+	"context"
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var logger = logging.For("pipeline")
+
+// Stages of a turn, as used in logs and metrics.
+const (
+	StageSTT = "stt"
+	StageLLM = "llm"
+	StageTTS = "tts"
+)
+
+// STT transcribes WAV audio.
+type STT interface {
+	Name() string
+	Transcribe(ctx context.Context, wav []byte, language string) (string, error)
+}
+
+// LLM answers a conversation.
+type LLM interface {
+	Name() string
+	Complete(ctx context.Context, history models.ConversationHistory, systemPrompt, language string) (models.GroqLLMResponse, error)
+}
+
+// TTS speaks a reply.
+type TTS interface {
+	Name() string
+	// Voice identifies the voice and output format used for language, as
+	// part of the cache key
+	Voice(language string) string
+	Synthesize(ctx context.Context, text, language string) (Speech, error)
+}
+
+// Speech is raw synthesized audio. Providers return different formats.
+type Speech struct {
+	Audio  []byte
+	Format pcm.Format
+	// Cached is set when the speech came from the cache, not the provider
+	Cached bool
+}
+
+// Pipeline holds the provider chain of every stage, in fallback order.
+type Pipeline struct {
+	STT []STT
+	LLM []LLM
+	TTS []TTS
+	// Cache of synthesized speech, nil to always synthesize
+	Cache *ttscache.Cache
+}
+
+// Setup creates the pipeline of the configured chains, caching speech in
+// cache unless it is nil.
+func Setup(cfg *config.Config, cache *ttscache.Cache) *Pipeline {
+	p := New(cfg)
+	p.Cache = cache
+	logger.Info("pipeline ready", "stt", cfg.Pipeline.STT, "llm", cfg.Pipeline.LLM, "tts", cfg.Pipeline.TTS)
+	return p
+}
+
+// Transcribe returns the transcript and the provider that made it.
+func (p *Pipeline) Transcribe(ctx context.Context, wav []byte, language string) (string, string, error) {
+	return run(ctx, StageSTT, p.STT, func(s STT) (string, error) {
+		return s.Transcribe(ctx, wav, language)
+	})
+}
+
+// Complete returns the LLM's answer and the provider that gave it.
+func (p *Pipeline) Complete(ctx context.Context, history models.ConversationHistory, systemPrompt, language string) (models.GroqLLMResponse, string, error) {
+	return run(ctx, StageLLM, p.LLM, func(l LLM) (models.GroqLLMResponse, error) {
+		resp, err := l.Complete(ctx, history, systemPrompt, language)
+		if err == nil && len(resp.Choices) == 0 {
+			err = errors.New("no choices returned")
+		}
+		return resp, err
+	})
+}
+
+// Synthesize returns the spoken text and the provider that spoke it.
+// Speech already in the cache for a provider's voice is not synthesized
+// again.
+func (p *Pipeline) Synthesize(ctx context.Context, text, language string) (Speech, string, error) {
+	return run(ctx, StageTTS, p.TTS, func(t TTS) (Speech, error) {
+		var key string
+		if p.Cache != nil {
+			key = ttscache.Key(t.Voice(language), language, text)
+			if audio, format, ok := p.Cache.Get(ctx, key); ok {
+				trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("tts.cached", true))
+				return Speech{Audio: audio, Format: format, Cached: true}, nil
+			}
+		}
+
+		speech, err := t.Synthesize(ctx, text, language)
+		if err == nil && len(speech.Audio) == 0 {
+			err = errors.New("no audio returned")
+		}
+		if err == nil && p.Cache != nil {
+			if err := p.Cache.Put(ctx, key, speech.Audio, speech.Format); err != nil {
+				logger.WarnContext(ctx, "failed to cache speech", "provider", t.Name(), "error", err)
+			}
+		}
+		return speech, err
+	})
+}
+
+// Model returns the model the named provider of a stage uses, empty if the
+// provider does not tell.
+func (p *Pipeline) Model(stage, provider string) string {
+	switch stage {
+	case StageSTT:
+		return model(p.STT, provider)
+	case StageLLM:
+		return model(p.LLM, provider)
+	case StageTTS:
+		return model(p.TTS, provider)
+	}
+	return ""
+}
+
+func model[P interface{ Name() string }](chain []P, name string) string {
+	for _, provider := range chain {
+		if m, ok := any(provider).(interface{ Model() string }); ok && provider.Name() == name {
+			return m.Model()
+		}
+	}
+	return ""
+}
+
+// run calls the providers of a chain in order until one succeeds. It stops
+// early once ctx is done, as every following provider would fail too.
+func run[P interface{ Name() string }, R any](ctx context.Context, stage string, chain []P, call func(P) (R, error)) (R, string, error) {
+	var errs []error
+	for i, provider := range chain {
+		result, err := call(provider)
+		if err == nil {
+			if i > 0 {
+				logger.InfoContext(ctx, "served by fallback provider", "stage", stage, "provider", provider.Name())
+			}
+			metrics.PipelineServed.WithLabelValues(stage, provider.Name()).Inc()
+			trace.SpanFromContext(ctx).SetAttributes(attribute.String(stage+".provider", provider.Name()))
+			return result, provider.Name(), nil
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", provider.Name(), err))
+		if ctx.Err() != nil {
+			break
+		}
+		if i+1 < len(chain) {
+			logger.WarnContext(ctx, "provider failed, falling back", "stage", stage, "provider", provider.Name(), "next", chain[i+1].Name(), "error", err)
+		}
+	}
+
+	var zero R
+	metrics.PipelineServed.WithLabelValues(stage, "none").Inc()
+	return zero, "", fmt.Errorf("all %s providers failed: %w", stage, errors.Join(errs...))
+}
@@ -0,0 +1,139 @@
+package pipeline
+
+import (
+	"anne-hub/models"
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/groq"
+	"anne-hub/pkg/pcm"
+	"anne-hub/pkg/tts"
+	"context"
+	"strings"
+)
+
+// New builds the chains of cfg.Pipeline. The chains are validated with the
+// configuration, so every name is known here.
+func New(cfg *config.Config) *Pipeline {
+	p := &Pipeline{}
+
+	groqClient := groq.NewClient(cfg.Groq, cfg.Providers)
+	var localClient *groq.Client
+	var localTTS *tts.Local
+	if cfg.Pipeline.Uses("local") {
+		localClient = groq.NewCompatible("local", config.GroqConfig{
+			APIKey:   cfg.Local.APIKey,
+			BaseURL:  cfg.Local.BaseURL,
+			STTModel: cfg.Local.STTModel,
+			LLMModel: cfg.Local.LLMModel,
+			Timeout:  cfg.Local.Timeout,
+		}, cfg.Providers)
+		localTTS = tts.NewLocal(cfg.Local, cfg.Providers)
+	}
+	clients := map[string]*groq.Client{"groq": groqClient, "local": localClient}
+
+	for _, name := range cfg.Pipeline.STT {
+		p.STT = append(p.STT, whisper{clients[name]})
+	}
+	for _, name := range cfg.Pipeline.LLM {
+		provider, model, _ := strings.Cut(name, ":")
+		client := clients[provider]
+		if model != "" {
+			client = client.WithLLMModel(model)
+		}
+		p.LLM = append(p.LLM, chat{name: name, client: client})
+	}
+	for _, name := range cfg.Pipeline.TTS {
+		switch name {
+		case "elevenlabs":
+			p.TTS = append(p.TTS, elevenLabs{tts.NewElevenLabs(cfg.ElevenLabs, cfg.Providers)})
+		case "google":
+			p.TTS = append(p.TTS, google{tts.NewGoogle(cfg.Google, cfg.Providers, cfg.Cassette)})
+		case "local":
+			p.TTS = append(p.TTS, local{localTTS})
+		}
+	}
+	return p
+}
+
+// whisper transcribes with the Groq or a compatible API.
+type whisper struct {
+	*groq.Client
+}
+
+func (w whisper) Model() string {
+	return w.STTModel()
+}
+
+func (w whisper) Transcribe(ctx context.Context, wav []byte, language string) (string, error) {
+	return w.GenerateWhisperTranscription(ctx, wav, language)
+}
+
+// chat completes with the Groq or a compatible API. Its name includes the
+// model when it is not the provider's default.
+type chat struct {
+	name   string
+	client *groq.Client
+}
+
+func (c chat) Name() string {
+	return c.name
+}
+
+func (c chat) Model() string {
+	return c.client.LLMModel()
+}
+
+func (c chat) Complete(ctx context.Context, history models.ConversationHistory, systemPrompt, language string) (models.GroqLLMResponse, error) {
+	return c.client.GenerateLLMResponseFromConversationData(ctx, history, systemPrompt, language)
+}
+
+type elevenLabs struct {
+	*tts.ElevenLabs
+}
+
+func (elevenLabs) Name() string {
+	return "elevenlabs"
+}
+
+func (e elevenLabs) Voice(string) string {
+	return e.ElevenLabs.Voice()
+}
+
+func (e elevenLabs) Synthesize(ctx context.Context, text, language string) (Speech, error) {
+	audio, err := e.TextToSpeech(ctx, text)
+	return Speech{Audio: audio, Format: tts.ElevenLabsFormat}, err
+}
+
+type google struct {
+	*tts.Google
+}
+
+func (google) Name() string {
+	return "google"
+}
+
+// Synthesize unwraps the WAV file Google returns for LINEAR16.
+func (g google) Synthesize(ctx context.Context, text, language string) (Speech, error) {
+	wav, err := g.TextToSpeech(ctx, text, language)
+	if err != nil {
+		return Speech{}, err
+	}
+	audio, format, err := pcm.FromWAV(wav)
+	return Speech{Audio: audio, Format: format}, err
+}
+
+type local struct {
+	*tts.Local
+}
+
+func (local) Name() string {
+	return "local"
+}
+
+func (l local) Voice(string) string {
+	return l.Local.Voice()
+}
+
+func (l local) Synthesize(ctx context.Context, text, language string) (Speech, error) {
+	audio, err := l.TextToSpeech(ctx, text)
+	return Speech{Audio: audio, Format: tts.LocalFormat}, err
+}
@@ -0,0 +1,187 @@
+// Package quota enforces the usage limits of a user's plan: turns per
+// minute per device and per user, and request audio and LLM tokens per
+// user and day. Counters are kept in the database, in windows starting at
+// the full UTC minute or day, so they survive restarts and are shared
+// between hub instances.
+package quota
+
+import (
+	"anne-hub/models"
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/logging"
+	"anne-hub/pkg/metrics"
+	"anne-hub/repository"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var logger = logging.For("quota")
+
+// Periods of the counter windows.
+const (
+	Minute = "minute"
+	Day    = "day"
+)
+
+// Limits of a plan, as reported in errors and metrics.
+const (
+	DeviceTurnsPerMinute = "device_turns_per_minute"
+	UserTurnsPerMinute   = "user_turns_per_minute"
+	AudioSecondsPerDay   = "audio_seconds_per_day"
+	TokensPerDay         = "tokens_per_day"
+)
+
+// retention is how long windows are kept before the purge job deletes them.
+const retention = 2 * 24 * time.Hour
+
+// ExceededError is returned for a turn over a limit of the user's plan.
+type ExceededError struct {
+	Plan  string
+	Limit string
+	// RetryAfter is how long until the limit's window ends
+	RetryAfter time.Duration
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s quota of plan %s exceeded", e.Limit, e.Plan)
+}
+
+// Limiter checks and counts turns against the plans of their users.
+type Limiter struct {
+	store       repository.Store
+	plans       map[string]config.PlanConfig
+	defaultPlan string
+	now         func() time.Time
+}
+
+// Setup creates the limiter of cfg, counting in store. It returns nil while
+// quotas are disabled.
+func Setup(cfg config.QuotaConfig, store repository.Store) *Limiter {
+	if !cfg.Enabled {
+		logger.Info("quotas disabled")
+		return nil
+	}
+	logger.Info("quotas enabled", "default_plan", cfg.DefaultPlan, "plans", len(cfg.Plans))
+	return New(cfg, store)
+}
+
+// New creates a limiter enforcing the plans of cfg.
+func New(cfg config.QuotaConfig, store repository.Store) *Limiter {
+	return &Limiter{store: store, plans: cfg.Plans, defaultPlan: cfg.DefaultPlan, now: time.Now}
+}
+
+// Plan returns the name and limits of a user's plan. Users without a plan,
+// or with one that is not configured, get the default plan.
+func (l *Limiter) Plan(user models.User) (string, config.PlanConfig) {
+	if plan, ok := l.plans[user.Plan]; ok {
+		return user.Plan, plan
+	}
+	if user.Plan != "" {
+		logger.Warn("unknown plan, using the default", "plan", user.Plan, "default_plan", l.defaultPlan)
+	}
+	return l.defaultPlan, l.plans[l.defaultPlan]
+}
+
+// Allow checks a new turn of a user's device, with audio of the given
+// length, against the user's plan. Allowed turns are counted; a turn over a
+// limit returns an *ExceededError and is not. The turn is counted first and
+// checked against the counts that returns, in one transaction that is
+// rolled back for a refused turn, so concurrent turns cannot all pass.
+func (l *Limiter) Allow(ctx context.Context, userID uuid.UUID, deviceID string, audio time.Duration) error {
+	user, err := l.store.Users().Get(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to look up user: %w", err)
+	}
+	name, plan := l.Plan(user)
+
+	now := l.now().UTC()
+	minute, day := now.Truncate(time.Minute), now.Truncate(24*time.Hour)
+	userSubject, deviceSubject := "user:"+userID.String(), "device:"+deviceID
+
+	exceeded := func(limit string, windowStart time.Time, period time.Duration) error {
+		return &ExceededError{Plan: name, Limit: limit, RetryAfter: windowStart.Add(period).Sub(now)}
+	}
+
+	err = l.store.InTx(ctx, func(tx repository.Store) error {
+		turn := models.Usage{Turns: 1}
+		u, err := tx.Usage().Add(ctx, deviceSubject, Minute, minute, turn)
+		if err != nil {
+			return err
+		}
+		if plan.DeviceTurnsPerMinute > 0 && u.Turns > int64(plan.DeviceTurnsPerMinute) {
+			return exceeded(DeviceTurnsPerMinute, minute, time.Minute)
+		}
+
+		u, err = tx.Usage().Add(ctx, userSubject, Minute, minute, turn)
+		if err != nil {
+			return err
+		}
+		if plan.UserTurnsPerMinute > 0 && u.Turns > int64(plan.UserTurnsPerMinute) {
+			return exceeded(UserTurnsPerMinute, minute, time.Minute)
+		}
+
+		// A turn is refused once the day's usage before it reached a limit,
+		// so the turn crossing it is still answered
+		u, err = tx.Usage().Add(ctx, userSubject, Day, day, models.Usage{Turns: 1, AudioMS: audio.Milliseconds()})
+		if err != nil {
+			return err
+		}
+		if plan.AudioSecondsPerDay > 0 && u.AudioMS-audio.Milliseconds() >= int64(plan.AudioSecondsPerDay)*1000 {
+			return exceeded(AudioSecondsPerDay, day, 24*time.Hour)
+		}
+		if plan.TokensPerDay > 0 && u.Tokens >= int64(plan.TokensPerDay) {
+			return exceeded(TokensPerDay, day, 24*time.Hour)
+		}
+		return nil
+	})
+
+	var e *ExceededError
+	if errors.As(err, &e) {
+		metrics.QuotaRejections.WithLabelValues(e.Limit).Inc()
+	}
+	return err
+}
+
+// AddTokens counts the LLM tokens of a user's turn against the user's
+// daily limit. Tokens are only known once the LLM answered, so the turn
+// that crosses the limit is answered and the next one is refused.
+func (l *Limiter) AddTokens(ctx context.Context, userID uuid.UUID, tokens int) error {
+	if tokens <= 0 {
+		return nil
+	}
+	day := l.now().UTC().Truncate(24 * time.Hour)
+	_, err := l.store.Usage().Add(ctx, "user:"+userID.String(), Day, day, models.Usage{Tokens: int64(tokens)})
+	return err
+}
+
+// Purge deletes the windows that ended long enough before now.
+func (l *Limiter) Purge(ctx context.Context, now time.Time) (int64, error) {
+	return l.store.Usage().DeleteBefore(ctx, now.Add(-retention))
+}
+
+// StartPurgeJob runs Purge every interval until ctx is cancelled.
+func (l *Limiter) StartPurgeJob(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			removed, err := l.Purge(ctx, time.Now())
+			if err != nil {
+				logger.ErrorContext(ctx, "usage purge failed", "error", err)
+			} else if removed > 0 {
+				logger.InfoContext(ctx, "usage purge", "removed", removed)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
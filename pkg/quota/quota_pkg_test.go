@@ -0,0 +1,206 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"anne-hub/models"
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/db"
+	"anne-hub/repository"
+	"anne-hub/repository/sqlite"
+
+	"github.com/golang-migrate/migrate/v4"
+)
+
+// now is half a minute into a minute, 14 hours into a day.
+var now = time.Date(2026, 3, 2, 14, 0, 30, 0, time.UTC)
+
+func newLimiter(t *testing.T, plan config.PlanConfig) (*Limiter, repository.Store) {
+	t.Helper()
+	cfg := config.DatabaseConfig{Driver: "sqlite", Path: filepath.Join(t.TempDir(), "anne-hub.db")}
+	conn, err := db.Connect(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	m, err := db.NewMigrator(conn, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		t.Fatal(err)
+	}
+	store := sqlite.New(conn)
+
+	l := New(config.QuotaConfig{DefaultPlan: "test", Plans: map[string]config.PlanConfig{"test": plan}}, store)
+	l.now = func() time.Time { return now }
+	return l, store
+}
+
+func newUser(t *testing.T, store repository.Store, name string) models.User {
+	t.Helper()
+	user := models.User{Username: name, Email: name + "@example.com"}
+	if err := store.Users().Create(context.Background(), &user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// exceeded returns the limit err reports and how long until it lifts, or
+// fails if err is not an *ExceededError.
+func exceeded(t *testing.T, err error) (string, time.Duration) {
+	t.Helper()
+	var e *ExceededError
+	if !errors.As(err, &e) {
+		t.Fatalf("err = %v, want an ExceededError", err)
+	}
+	if e.Plan != "test" {
+		t.Errorf("plan = %q", e.Plan)
+	}
+	return e.Limit, e.RetryAfter
+}
+
+func turns(t *testing.T, store repository.Store, subject, period string, windowStart time.Time) int64 {
+	t.Helper()
+	u, err := store.Usage().Get(context.Background(), subject, period, windowStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Turns
+}
+
+func TestDeviceTurnsPerMinute(t *testing.T) {
+	l, store := newLimiter(t, config.PlanConfig{DeviceTurnsPerMinute: 2})
+	user := newUser(t, store, "anne")
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := l.Allow(ctx, user.ID, "7", time.Second); err != nil {
+			t.Fatalf("turn %d: %v", i, err)
+		}
+	}
+	limit, retryAfter := exceeded(t, l.Allow(ctx, user.ID, "7", time.Second))
+	if limit != DeviceTurnsPerMinute || retryAfter != 30*time.Second {
+		t.Errorf("exceeded %s, retry after %s", limit, retryAfter)
+	}
+
+	// The refused turn was rolled back
+	minute := now.Truncate(time.Minute)
+	if n := turns(t, store, "device:7", Minute, minute); n != 2 {
+		t.Errorf("device counted %d turns, want 2", n)
+	}
+	if n := turns(t, store, "user:"+user.ID.String(), Day, now.Truncate(24*time.Hour)); n != 2 {
+		t.Errorf("user counted %d turns in the day, want 2", n)
+	}
+
+	// Other devices have their own limit, and the next minute starts over
+	if err := l.Allow(ctx, user.ID, "8", time.Second); err != nil {
+		t.Errorf("turn of another device: %v", err)
+	}
+	l.now = func() time.Time { return now.Add(30 * time.Second) }
+	if err := l.Allow(ctx, user.ID, "7", time.Second); err != nil {
+		t.Errorf("turn in the next minute: %v", err)
+	}
+}
+
+func TestUserTurnsPerMinute(t *testing.T) {
+	l, store := newLimiter(t, config.PlanConfig{DeviceTurnsPerMinute: 2, UserTurnsPerMinute: 3})
+	anne, bob := newUser(t, store, "anne"), newUser(t, store, "bob")
+	ctx := context.Background()
+
+	for i, device := range []string{"1", "2", "3"} {
+		if err := l.Allow(ctx, anne.ID, device, time.Second); err != nil {
+			t.Fatalf("turn %d: %v", i, err)
+		}
+	}
+	limit, retryAfter := exceeded(t, l.Allow(ctx, anne.ID, "4", time.Second))
+	if limit != UserTurnsPerMinute || retryAfter != 30*time.Second {
+		t.Errorf("exceeded %s, retry after %s", limit, retryAfter)
+	}
+	if n := turns(t, store, "device:4", Minute, now.Truncate(time.Minute)); n != 0 {
+		t.Errorf("refused device counted %d turns, want 0", n)
+	}
+
+	if err := l.Allow(ctx, bob.ID, "5", time.Second); err != nil {
+		t.Errorf("turn of another user: %v", err)
+	}
+}
+
+func TestDailyLimits(t *testing.T) {
+	untilMidnight := 10*time.Hour - 30*time.Second
+
+	t.Run("audio", func(t *testing.T) {
+		l, store := newLimiter(t, config.PlanConfig{AudioSecondsPerDay: 10})
+		user := newUser(t, store, "anne")
+		ctx := context.Background()
+
+		// The turn crossing the limit is still answered
+		for _, audio := range []time.Duration{8 * time.Second, 5 * time.Second} {
+			if err := l.Allow(ctx, user.ID, "7", audio); err != nil {
+				t.Fatalf("turn of %s: %v", audio, err)
+			}
+		}
+		limit, retryAfter := exceeded(t, l.Allow(ctx, user.ID, "7", time.Second))
+		if limit != AudioSecondsPerDay || retryAfter != untilMidnight {
+			t.Errorf("exceeded %s, retry after %s", limit, retryAfter)
+		}
+	})
+
+	t.Run("tokens", func(t *testing.T) {
+		l, store := newLimiter(t, config.PlanConfig{TokensPerDay: 100})
+		user := newUser(t, store, "anne")
+		ctx := context.Background()
+
+		if err := l.Allow(ctx, user.ID, "7", time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.AddTokens(ctx, user.ID, 100); err != nil {
+			t.Fatal(err)
+		}
+		limit, retryAfter := exceeded(t, l.Allow(ctx, user.ID, "7", time.Second))
+		if limit != TokensPerDay || retryAfter != untilMidnight {
+			t.Errorf("exceeded %s, retry after %s", limit, retryAfter)
+		}
+
+		l.now = func() time.Time { return now.Add(untilMidnight) }
+		if err := l.Allow(ctx, user.ID, "7", time.Second); err != nil {
+			t.Errorf("turn on the next day: %v", err)
+		}
+	})
+}
+
+func TestPlan(t *testing.T) {
+	l := New(config.QuotaConfig{DefaultPlan: "free", Plans: map[string]config.PlanConfig{
+		"free": {UserTurnsPerMinute: 1},
+		"pro":  {UserTurnsPerMinute: 10},
+	}}, nil)
+
+	for plan, want := range map[string]string{"pro": "pro", "": "free", "gold": "free"} {
+		name, limits := l.Plan(models.User{Plan: plan})
+		if name != want || limits.UserTurnsPerMinute != l.plans[want].UserTurnsPerMinute {
+			t.Errorf("Plan(%q) = %s, %+v, want %s", plan, name, limits, want)
+		}
+	}
+}
+
+func TestPurge(t *testing.T) {
+	l, store := newLimiter(t, config.PlanConfig{})
+	user := newUser(t, store, "anne")
+	ctx := context.Background()
+
+	if err := l.Allow(ctx, user.ID, "7", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// Windows are kept for two days after they start
+	if removed, err := l.Purge(ctx, now.Add(24*time.Hour)); err != nil || removed != 0 {
+		t.Errorf("Purge a day later = %d, %v, want 0", removed, err)
+	}
+	if removed, err := l.Purge(ctx, now.Add(48*time.Hour)); err != nil || removed != 3 {
+		t.Errorf("Purge two days later = %d, %v, want the 3 windows of the turn", removed, err)
+	}
+}
@@ -1,22 +1,34 @@
 package systemprompt
 
 import (
+	"anne-hub/pkg/logging"
+	"anne-hub/pkg/pcm"
+	"anne-hub/pkg/tracing"
 	"anne-hub/pkg/uuid"
+	"anne-hub/repository"
 	"anne-hub/services"
+	"context"
 	"fmt"
-	"log"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 )
 
-func DynamicGeneration(userID uuid.UUID) string {
-	log.Printf("Building system prompt for user ID: %s\n", userID.String())
+var logger = logging.For("systemprompt")
+
+// DynamicGeneration builds the system prompt for a user, offering the LLM
+// the emotions the user's device can show.
+func DynamicGeneration(ctx context.Context, store repository.Store, userID uuid.UUID, emotions []string) string {
+	ctx, span := tracing.Start(ctx, "systemprompt.DynamicGeneration")
+	defer span.End()
+
+	logger.DebugContext(ctx, "building system prompt", "user_id", logging.PII(userID.String()))
 
 	// Fetch user data
-	userData, err := services.FetchUserData(userID)
+	userData, err := services.FetchUserData(ctx, store, userID)
 	if err != nil {
-		log.Printf("Error fetching user data: %v\n", err)
+		logger.ErrorContext(ctx, "failed to fetch user data", "user_id", logging.PII(userID.String()), "error", err)
 		return fmt.Sprintf("Error: %v", err)
 	}
 
@@ -36,8 +48,10 @@ func DynamicGeneration(userID uuid.UUID) string {
 	sb.WriteString("Respond in JSON format following: {\"message\": \"<your message>\", \"emotion\": \"<emotion>\", \"task_completion\": {\"task:\": \"<task_id>\", \"completed\": \"<value>\"}}\n")
 	sb.WriteString("Put your response for the user into 'message'\n")
 	sb.WriteString("Choose from one of these emotions that fits to the user prompt, fit the emotional style of your response to it:")
-	sb.WriteString("celebration, suspicious, cute_smile, curiosity, confused, sleep, lucky_smile, surprised, put it then into the <emotion> field\n")
-	sb.WriteString("When you get asked to sleep, YOU MUST SLEEP as an emotion.\n")
+	sb.WriteString(strings.Join(emotions, ", ") + ", put it then into the <emotion> field\n")
+	if slices.Contains(emotions, "sleep") {
+		sb.WriteString("When you get asked to sleep, YOU MUST SLEEP as an emotion.\n")
+	}
 	sb.WriteString("If there is a task or activity mentioned that is similar to the task list and a change in completion, add 'task_completion' object with 'task_id' and 'completed' fields, otherwise add a emty 'task_completion' object\n")
 	sb.WriteString("example: 'i completed my math homework', in this case you add the 'task_completion' object with the fitting 'task_id' and either 'true' or 'false' in the 'completed' field\n")
 	sb.WriteString("Take the task_id from the task object in the conversation history that fits to the task the user mentioned.\n")
@@ -140,3 +154,18 @@ func DynamicGeneration(userID uuid.UUID) string {
 	// log.Printf("System prompt constructed: %s\n", prompt)
 	return prompt
 }
+
+// VoiceCues tells the LLM how the user sounded in their last message, as
+// estimated from the audio by pcm.AnalyzeProsody. It is appended to the
+// prompt of DynamicGeneration.
+func VoiceCues(p pcm.Prosody) string {
+	var sb strings.Builder
+	sb.WriteString("\n\nHow the user sounded in their last message, estimated from their voice and possibly wrong: ")
+	sb.WriteString(p.Mood)
+	if cues := p.Cues(); len(cues) > 0 {
+		sb.WriteString(" (" + strings.Join(cues, ", ") + ")")
+	}
+	sb.WriteString(fmt.Sprintf(", arousal %.1f and valence %.1f on a scale from -1 to 1. ", p.Arousal, p.Valence))
+	sb.WriteString("Let it guide your tone and emotion, e.g. comfort a kid who sounds upset or low and share the joy of an excited one, but never mention that you listen to how they sound.\n")
+	return sb.String()
+}
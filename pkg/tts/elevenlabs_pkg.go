@@ -1,52 +1,106 @@
 package tts
 
 import (
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/httpclient"
+	"anne-hub/pkg/metrics"
+	"anne-hub/pkg/pcm"
+	"anne-hub/pkg/tracing"
+	"bytes"
 	"context"
-	"log"
-	"os"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
 	"time"
 
-	"github.com/haguro/elevenlabs-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func ElevenLabsTextToSpeech(text string) ([]byte, error) {
+// ElevenLabsFormat is the raw audio format returned for the "pcm_16000" output format.
+var ElevenLabsFormat = pcm.Format{
+	SampleRate: 16000,
+	BitDepth:   16,
+	Channels:   1,
+	Endianness: pcm.LittleEndian,
+	Encoding:   pcm.EncodingPCM,
+}
 
-	env := os.Getenv("ELEVENLABS_API_KEY")
+// ElevenLabs synthesizes speech with the configured voice and model.
+type ElevenLabs struct {
+	cfg     config.ElevenLabsConfig
+	baseURL string
+	http    *http.Client
+}
 
-	// Create a new client
-	client := elevenlabs.NewClient(context.Background(), env, 30*time.Second)
+// NewElevenLabs creates a client from the ElevenLabs section of the
+// configuration, retrying requests as set in providers.
+func NewElevenLabs(cfg config.ElevenLabsConfig, providers config.ProvidersConfig) *ElevenLabs {
+	return &ElevenLabs{
+		cfg:     cfg,
+		baseURL: strings.TrimSuffix(cfg.BaseURL, "/"),
+		http:    httpclient.New("elevenlabs", providers),
+	}
+}
+
+// Voice identifies the voice, model and output format the client speaks
+// with.
+func (e *ElevenLabs) Voice() string {
+	return "elevenlabs/" + e.cfg.VoiceID + "/" + e.cfg.ModelID + "/pcm_16000"
+}
+
+// Model returns the ElevenLabs model the client speaks with.
+func (e *ElevenLabs) Model() string {
+	return e.cfg.ModelID
+}
+
+func (e *ElevenLabs) TextToSpeech(ctx context.Context, text string) (_ []byte, err error) {
+	ctx, span := tracing.Start(ctx, "tts", trace.WithAttributes(
+		attribute.String("provider", "elevenlabs"),
+		attribute.Int("text.length", len(text)),
+	))
+	defer func(start time.Time) {
+		metrics.TTSDuration.WithLabelValues("elevenlabs", metrics.Outcome(err)).Observe(time.Since(start).Seconds())
+		tracing.End(span, err)
+	}(time.Now())
 
-	// Create a TextToSpeechRequest
-	ttsReq := elevenlabs.TextToSpeechRequest{
-	Text:    text,
-	ModelID: "eleven_monolingual_v1",
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.Timeout)
+	defer cancel()
+
+	body, err := json.Marshal(map[string]string{
+		"text":     text,
+		"model_id": e.cfg.ModelID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding request content: %w", err)
 	}
 
-	// Call the TextToSpeech method on the client, using the "Adam"'s voice ID.
-	audio, err := client.TextToSpeech("cgSgspJ2msm6clMCkdW9", ttsReq, elevenlabs.OutputFormat("pcm_16000"))
+	// Synthesize with the configured voice ID, as raw 16 kHz PCM
+	url := fmt.Sprintf("%s/text-to-speech/%s?output_format=pcm_16000", e.baseURL, e.cfg.VoiceID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
 	if err != nil {
-		log.Fatal(err)
-		return nil, err
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Accept", "*/*")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("xi-api-key", e.cfg.APIKey)
+
+	resp, err := e.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("elevenlabs text to speech failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	audio, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("elevenlabs text to speech failed: status %d: %s", resp.StatusCode, string(audio))
 	}
 
-	// // Create a TextToSpeechRequest
-	// ttsReq := elevenlabs.TextToSpeechRequest{
-	// Text:    text,
-	// ModelID: "eleven_monolingual_v1",
-	// }
-
-	// // Call the TextToSpeech method on the client, using the "Adam"'s voice ID.
-	// audio, err := client.TextToSpeech("cgSgspJ2msm6clMCkdW9", ttsReq, elevenlabs.OutputFormat("pcm_16000"))
-	// if err != nil {
-	// 	log.Fatal(err)
-	// 	return nil, err
-	// }
-
-	// // Write the audio file bytes to disk
-	// if err := os.WriteFile("adam.mp3", audio, 0644); err != nil {
-	// log.Fatal(err)
-	// }
-
-	log.Println("Successfully generated PCM audio data")
-	return audio, err
+	logger.DebugContext(ctx, "generated speech", "provider", "elevenlabs", "bytes", len(audio))
+	return audio, nil
 }
\ No newline at end of file
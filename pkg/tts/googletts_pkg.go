@@ -1,21 +1,129 @@
 package tts
 
 import (
+	"anne-hub/pkg/cassette"
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/httpclient"
+	"anne-hub/pkg/metrics"
+	"anne-hub/pkg/tracing"
 	"context"
 	"fmt"
-	"log"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	texttospeech "cloud.google.com/go/texttospeech/apiv1"
 	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
+	"github.com/googleapis/gax-go/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-// GoogleTextToSpeech converts the given text to speech, saves to the specified filePath
-func GoogleTextToSpeechFile(text, filePath string, language string) error {
+// Google synthesizes speech with Google Cloud Text-to-Speech.
+type Google struct {
+	opts    []option.ClientOption
+	timeout time.Duration
+	// http is set for REST, so that synthesis is retried, guarded by the
+	// breaker and recorded like the other providers
+	http *http.Client
+	// retry is used over gRPC instead
+	retry gax.CallOption
+}
+
+// NewGoogle creates a client from the Google section of the configuration.
+// Without a credentials file Application Default Credentials are used,
+// except for plain http endpoints, which are local fakes. While cassettes
+// are on, requests go over REST through the provider transport, and replays
+// need no credentials.
+func NewGoogle(cfg config.GoogleConfig, providers config.ProvidersConfig, cassettes config.CassetteConfig) *Google {
+	g := &Google{timeout: cfg.Timeout}
+	rest := false
+	if cfg.CredentialsFile != "" {
+		g.opts = append(g.opts, option.WithCredentialsFile(cfg.CredentialsFile))
+	}
+	if cfg.Endpoint != "" {
+		rest = true
+		g.opts = append(g.opts, option.WithEndpoint(cfg.Endpoint))
+		if cfg.CredentialsFile == "" && strings.HasPrefix(cfg.Endpoint, "http://") {
+			g.opts = append(g.opts, option.WithoutAuthentication())
+		}
+	}
+	if cassettes.Mode != cassette.ModeOff {
+		rest = true
+		if cassettes.Mode == cassette.ModeReplay {
+			g.opts = append(g.opts, option.WithoutAuthentication())
+		}
+	}
+	if rest {
+		g.http = httpclient.New("google", providers)
+	} else {
+		g.retry = gax.WithRetry(func() gax.Retryer {
+			return &grpcRetryer{
+				backoff:    gax.Backoff{Initial: providers.RetryBaseDelay, Max: providers.RetryMaxDelay, Multiplier: 2},
+				maxRetries: providers.MaxRetries,
+			}
+		})
+	}
+	return g
+}
+
+// grpcRetryer retries gRPC calls failing with a transient status, within
+// the limits of the HTTP providers.
+type grpcRetryer struct {
+	backoff    gax.Backoff
+	maxRetries int
+	attempt    int
+}
+
+func (r *grpcRetryer) Retry(err error) (time.Duration, bool) {
+	code := status.Code(err)
+	switch code {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Internal:
+	default:
+		return 0, false
+	}
+	if r.attempt >= r.maxRetries {
+		return 0, false
+	}
+	r.attempt++
+	metrics.ProviderRetries.WithLabelValues("google", "grpc_"+strings.ToLower(code.String())).Inc()
+	return r.backoff.Pause(), true
+}
+
+func (g *Google) newClient(ctx context.Context) (*texttospeech.Client, error) {
+	if g.http != nil {
+		// WithHTTPClient skips authentication, so it is added to the
+		// transport here
+		transport, err := htransport.NewTransport(ctx, g.http.Transport, g.opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up credentials: %w", err)
+		}
+		opts := append(g.opts[:len(g.opts):len(g.opts)], option.WithHTTPClient(&http.Client{Transport: transport}))
+		return texttospeech.NewRESTClient(ctx, opts...)
+	}
+	return texttospeech.NewClient(ctx, g.opts...)
+}
+
+// callOptions returns the retries of a gRPC call, REST calls are retried
+// by their transport.
+func (g *Google) callOptions() []gax.CallOption {
+	if g.retry == nil {
+		return nil
+	}
+	return []gax.CallOption{g.retry}
+}
 
-	ctx := context.Background()
+// TextToSpeechFile converts the given text to speech, saves to the specified filePath
+func (g *Google) TextToSpeechFile(ctx context.Context, text, filePath string, language string) error {
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
 
-	client, err := texttospeech.NewClient(ctx)
+	client, err := g.newClient(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to create TTS client: %w", err)
 	}
@@ -47,7 +155,7 @@ func GoogleTextToSpeechFile(text, filePath string, language string) error {
 	}
 
 	// log.Printf("SynthesizeSpeechRequest: %+v\n", req)
-	response, err := client.SynthesizeSpeech(ctx, req)
+	response, err := client.SynthesizeSpeech(ctx, req, g.callOptions()...)
 	if err != nil {
 		return fmt.Errorf("failed to synthesize speech: %w", err)
 	}
@@ -67,31 +175,27 @@ func GoogleTextToSpeechFile(text, filePath string, language string) error {
 	return nil
 }
 
-// GoogleTextToSpeech converts the given text to speech, saves to the specified filePath
-func GoogleTextToSpeech(text, language string) ([]byte, error) {
+// TextToSpeech converts the given text to speech and returns the LINEAR16 audio
+func (g *Google) TextToSpeech(ctx context.Context, text, language string) (_ []byte, err error) {
+	ctx, span := tracing.Start(ctx, "tts", trace.WithAttributes(
+		attribute.String("provider", "google"),
+		attribute.Int("text.length", len(text)),
+	))
+	defer func(start time.Time) {
+		metrics.TTSDuration.WithLabelValues("google", metrics.Outcome(err)).Observe(time.Since(start).Seconds())
+		tracing.End(span, err)
+	}(time.Now())
 
-	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
 
-	client, err := texttospeech.NewClient(ctx)
+	client, err := g.newClient(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create TTS client: %w", err)
 	}
 	defer client.Close()
 
-	var TTSCode string
-	var TTSName string
-
-	if language == "de" {
-		TTSCode = "de-DE"
-		TTSName = "de-DE-Studio-B"
-	} else if language == "en" {
-		TTSCode = "en-US"
-		TTSName = "en-US-Journey-F"
-	} else {
-		log.Print("No language Code added, defaulting")
-		TTSCode = "en-US"
-		TTSName = "en-US-Journey-F"
-	}
+	TTSCode, TTSName := googleVoice(language)
 	// Build the request without effects profile
 	req := &texttospeechpb.SynthesizeSpeechRequest{
 		Input: &texttospeechpb.SynthesisInput{
@@ -108,7 +212,7 @@ func GoogleTextToSpeech(text, language string) ([]byte, error) {
 
 	// log.Printf("SynthesizeSpeechRequest: %+v\n", req)
 
-	response, err := client.SynthesizeSpeech(ctx, req)
+	response, err := client.SynthesizeSpeech(ctx, req, g.callOptions()...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to synthesize speech: %w", err)
 	}
@@ -116,11 +220,29 @@ func GoogleTextToSpeech(text, language string) ([]byte, error) {
 	return response.AudioContent, nil
 }
 
+// Voice identifies the voice used for language and the output format.
+func (g *Google) Voice(language string) string {
+	_, name := googleVoice(language)
+	return "google/" + name + "/linear16"
+}
+
+// googleVoice returns the language code and voice name for a conversation
+// language, en-US for unknown ones.
+func googleVoice(language string) (string, string) {
+	switch language {
+	case "de":
+		return "de-DE", "de-DE-Studio-B"
+	default:
+		return "en-US", "en-US-Journey-F"
+	}
+}
+
 // ListVoices lists available voices for a given language code
-func ListVoices(languageCode string) ([]*texttospeechpb.Voice, error) {
-	ctx := context.Background()
+func (g *Google) ListVoices(ctx context.Context, languageCode string) ([]*texttospeechpb.Voice, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
 
-	client, err := texttospeech.NewClient(ctx)
+	client, err := g.newClient(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create TTS client: %w", err)
 	}
@@ -130,7 +252,7 @@ func ListVoices(languageCode string) ([]*texttospeechpb.Voice, error) {
 		LanguageCode: languageCode,
 	}
 
-	resp, err := client.ListVoices(ctx, req)
+	resp, err := client.ListVoices(ctx, req, g.callOptions()...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list voices: %w", err)
 	}
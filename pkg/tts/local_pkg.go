@@ -0,0 +1,109 @@
+package tts
+
+import (
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/httpclient"
+	"anne-hub/pkg/metrics"
+	"anne-hub/pkg/pcm"
+	"anne-hub/pkg/tracing"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// LocalFormat is the raw audio returned for the "pcm" response format of
+// the OpenAI speech API.
+var LocalFormat = pcm.Format{
+	SampleRate: 24000,
+	BitDepth:   16,
+	Channels:   1,
+	Endianness: pcm.LittleEndian,
+	Encoding:   pcm.EncodingPCM,
+}
+
+// Local synthesizes speech with an OpenAI-compatible server, such as
+// LocalAI or Kokoro.
+type Local struct {
+	cfg     config.LocalConfig
+	baseURL string
+	http    *http.Client
+}
+
+// NewLocal creates a client from the local section of the configuration,
+// retrying requests as set in providers.
+func NewLocal(cfg config.LocalConfig, providers config.ProvidersConfig) *Local {
+	return &Local{
+		cfg:     cfg,
+		baseURL: strings.TrimSuffix(cfg.BaseURL, "/"),
+		http:    httpclient.New("local", providers),
+	}
+}
+
+// Voice identifies the model and voice the client speaks with.
+func (l *Local) Voice() string {
+	return "local/" + l.cfg.TTSModel + "/" + l.cfg.TTSVoice + "/pcm"
+}
+
+// Model returns the TTS model the client speaks with.
+func (l *Local) Model() string {
+	return l.cfg.TTSModel
+}
+
+// TextToSpeech returns the text spoken in LocalFormat.
+func (l *Local) TextToSpeech(ctx context.Context, text string) (_ []byte, err error) {
+	ctx, span := tracing.Start(ctx, "tts", trace.WithAttributes(
+		attribute.String("provider", "local"),
+		attribute.Int("text.length", len(text)),
+	))
+	defer func(start time.Time) {
+		metrics.TTSDuration.WithLabelValues("local", metrics.Outcome(err)).Observe(time.Since(start).Seconds())
+		tracing.End(span, err)
+	}(time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, l.cfg.Timeout)
+	defer cancel()
+
+	body, err := json.Marshal(map[string]string{
+		"model":           l.cfg.TTSModel,
+		"voice":           l.cfg.TTSVoice,
+		"input":           text,
+		"response_format": "pcm",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding request content: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.baseURL+"/audio/speech", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if l.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+l.cfg.APIKey)
+	}
+
+	resp, err := l.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("local text to speech failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	audio, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("local text to speech failed: status %d: %s", resp.StatusCode, string(audio))
+	}
+
+	logger.DebugContext(ctx, "generated speech", "provider", "local", "bytes", len(audio))
+	return audio, nil
+}
@@ -0,0 +1,7 @@
+package tts
+
+import (
+	"anne-hub/pkg/logging"
+)
+
+var logger = logging.For("tts")
@@ -0,0 +1,224 @@
+// Package ttscache keeps synthesized speech in blob storage, keyed by the
+// normalized text, the voice profile and output format of the provider and
+// the language, so repeated phrases are not synthesized again. The least
+// recently used entries are evicted once the cache grows past its size
+// limit.
+package ttscache
+
+import (
+	"anne-hub/pkg/blob"
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/logging"
+	"anne-hub/pkg/metrics"
+	"anne-hub/pkg/pcm"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var logger = logging.For("ttscache")
+
+// prefix namespaces cached speech within the blob store.
+const prefix = "ttscache"
+
+// Cache is an LRU cache of synthesized speech. Its index lives in memory and
+// is rebuilt from the blob store at startup, oldest writes first.
+type Cache struct {
+	blobs    blob.Store
+	maxBytes int64
+
+	mu      sync.Mutex
+	lru     *list.List // of *entry, most recently used first
+	entries map[string]*list.Element
+	size    int64
+}
+
+type entry struct {
+	key  string
+	size int64
+}
+
+// Setup creates the cache on top of store and loads its index. It returns
+// nil while caching is disabled.
+func Setup(cfg config.TTSCacheConfig, store blob.Store) *Cache {
+	if !cfg.Enabled {
+		logger.Info("tts cache disabled")
+		return nil
+	}
+
+	c := New(store, int64(cfg.MaxBytes))
+	if err := c.Load(context.Background()); err != nil {
+		logger.Error("failed to load tts cache", "error", err)
+		os.Exit(1)
+	}
+	return c
+}
+
+// New creates an empty cache storing up to maxBytes of audio in blobs.
+func New(blobs blob.Store, maxBytes int64) *Cache {
+	return &Cache{
+		blobs:    blobs,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+// Load indexes the entries already in the blob store, evicting the oldest
+// if they exceed the size limit.
+func (c *Cache) Load(ctx context.Context) error {
+	objects, err := c.blobs.List(ctx, prefix+"/")
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(objects, func(a, b blob.Object) int {
+		return a.ModTime.Compare(b.ModTime)
+	})
+
+	c.mu.Lock()
+	for _, obj := range objects {
+		c.add(obj.Key, obj.Size)
+	}
+	victims := c.evict()
+	entries, size := c.lru.Len(), c.size
+	c.mu.Unlock()
+
+	c.remove(ctx, victims)
+	logger.InfoContext(ctx, "tts cache ready", "entries", entries, "bytes", size, "max_bytes", c.maxBytes)
+	return nil
+}
+
+// Normalize returns text as it is keyed: trimmed, with runs of whitespace
+// collapsed, so formatting differences of the same reply still hit.
+func Normalize(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// Key returns the blob key of text spoken by voice in language. voice
+// identifies the provider's voice and output format.
+func Key(voice, language, text string) string {
+	sum := sha256.Sum256([]byte(voice + "\x00" + language + "\x00" + Normalize(text)))
+	name := hex.EncodeToString(sum[:16])
+	return blob.Join(prefix, name[:2], name+".wav")
+}
+
+// Get returns the cached audio and its format for key.
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, pcm.Format, bool) {
+	c.mu.Lock()
+	el, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(el)
+	}
+	c.mu.Unlock()
+
+	if ok {
+		audio, format, err := c.read(ctx, key)
+		if err == nil {
+			metrics.TTSCacheRequests.WithLabelValues("hit").Inc()
+			return audio, format, true
+		}
+		if !errors.Is(err, blob.ErrNotFound) {
+			logger.WarnContext(ctx, "failed to read cached speech", "key", key, "error", err)
+		}
+		c.forget(key)
+	}
+
+	metrics.TTSCacheRequests.WithLabelValues("miss").Inc()
+	return nil, pcm.Format{}, false
+}
+
+func (c *Cache) read(ctx context.Context, key string) ([]byte, pcm.Format, error) {
+	wav, err := c.blobs.Get(ctx, key)
+	if err != nil {
+		return nil, pcm.Format{}, err
+	}
+	return pcm.FromWAV(wav)
+}
+
+// Put stores audio under key, evicting the least recently used entries
+// when the cache grows past its size limit.
+func (c *Cache) Put(ctx context.Context, key string, audio []byte, format pcm.Format) error {
+	wav, err := pcm.ToWAV(audio, format)
+	if err != nil {
+		return err
+	}
+	if int64(len(wav)) > c.maxBytes {
+		return nil
+	}
+	if err := c.blobs.Put(ctx, key, wav, "audio/wav"); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.add(key, int64(len(wav)))
+	victims := c.evict()
+	c.mu.Unlock()
+
+	c.remove(ctx, victims)
+	return nil
+}
+
+// Len returns the number of cached entries and their size in bytes.
+func (c *Cache) Len() (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len(), c.size
+}
+
+// add records key as most recently used. c.mu must be held.
+func (c *Cache) add(key string, size int64) {
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*entry)
+		c.size += size - e.size
+		e.size = size
+		c.lru.MoveToFront(el)
+	} else {
+		c.entries[key] = c.lru.PushFront(&entry{key: key, size: size})
+		c.size += size
+	}
+	metrics.TTSCacheBytes.Set(float64(c.size))
+}
+
+// evict drops the least recently used entries from the index until the
+// cache fits, returning their keys. c.mu must be held.
+func (c *Cache) evict() []string {
+	var victims []string
+	for c.size > c.maxBytes && c.lru.Len() > 0 {
+		e := c.lru.Remove(c.lru.Back()).(*entry)
+		delete(c.entries, e.key)
+		c.size -= e.size
+		victims = append(victims, e.key)
+	}
+	metrics.TTSCacheBytes.Set(float64(c.size))
+	metrics.TTSCacheEvictions.Add(float64(len(victims)))
+	return victims
+}
+
+// forget drops key from the index, e.g. when its blob went missing.
+func (c *Cache) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.size -= c.lru.Remove(el).(*entry).size
+		delete(c.entries, key)
+		metrics.TTSCacheBytes.Set(float64(c.size))
+	}
+}
+
+// remove deletes evicted entries from the blob store.
+func (c *Cache) remove(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := c.blobs.Delete(ctx, key); err != nil && !errors.Is(err, blob.ErrNotFound) {
+			logger.WarnContext(ctx, "failed to delete evicted speech", "key", key, "error", err)
+		}
+	}
+	if len(keys) > 0 {
+		logger.DebugContext(ctx, "evicted cached speech", "count", len(keys))
+	}
+}
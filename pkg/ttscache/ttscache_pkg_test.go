@@ -0,0 +1,157 @@
+package ttscache
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"anne-hub/pkg/blob"
+	"anne-hub/pkg/pcm"
+)
+
+// speech is the audio of every test entry; as a WAV file it takes
+// entrySize bytes.
+var speech = bytes.Repeat([]byte{1, 2}, 50)
+
+const entrySize = 44 + 100
+
+func newStore(t *testing.T) *blob.LocalStore {
+	t.Helper()
+	store, err := blob.NewLocalStore(t.TempDir(), "", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func put(t *testing.T, c *Cache, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		if err := c.Put(context.Background(), key, speech, pcm.M5Format); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// cached reports which of keys are indexed and still have their blob.
+func cached(t *testing.T, c *Cache, store blob.Store, keys ...string) []bool {
+	t.Helper()
+	got := make([]bool, len(keys))
+	for i, key := range keys {
+		c.mu.Lock()
+		_, indexed := c.entries[key]
+		c.mu.Unlock()
+		_, err := store.Get(context.Background(), key)
+		if indexed != (err == nil) {
+			t.Errorf("%s: indexed %v, blob error %v", key, indexed, err)
+		}
+		got[i] = indexed
+	}
+	return got
+}
+
+func TestGetPut(t *testing.T) {
+	c := New(newStore(t), 10*entrySize)
+	key := Key("voice", "en", "Hello  there. ")
+	if key != Key("voice", "en", "Hello there.") {
+		t.Error("whitespace changes the key")
+	}
+	if key == Key("voice", "nl", "Hello there.") {
+		t.Error("the language does not change the key")
+	}
+
+	if _, _, ok := c.Get(context.Background(), key); ok {
+		t.Fatal("hit on an empty cache")
+	}
+	put(t, c, key)
+	audio, format, ok := c.Get(context.Background(), key)
+	if !ok || !bytes.Equal(audio, speech) || format.SampleRate != pcm.M5Format.SampleRate {
+		t.Errorf("Get = %d bytes, %+v, %v", len(audio), format, ok)
+	}
+	if n, size := c.Len(); n != 1 || size != entrySize {
+		t.Errorf("Len = %d, %d", n, size)
+	}
+}
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+	store := newStore(t)
+	c := New(store, 3*entrySize)
+	put(t, c, "ttscache/a.wav", "ttscache/b.wav", "ttscache/c.wav")
+
+	// Reading a makes b the least recently used
+	if _, _, ok := c.Get(context.Background(), "ttscache/a.wav"); !ok {
+		t.Fatal("miss on a")
+	}
+	put(t, c, "ttscache/d.wav")
+
+	got := cached(t, c, store, "ttscache/a.wav", "ttscache/b.wav", "ttscache/c.wav", "ttscache/d.wav")
+	if want := []bool{true, false, true, true}; !slices.Equal(got, want) {
+		t.Errorf("cached a, b, c, d = %v, want %v", got, want)
+	}
+	if n, size := c.Len(); n != 3 || size != 3*entrySize {
+		t.Errorf("Len = %d, %d", n, size)
+	}
+}
+
+func TestPutSkipsOversizedAudio(t *testing.T) {
+	store := newStore(t)
+	c := New(store, entrySize-1)
+	put(t, c, "ttscache/a.wav")
+
+	if got := cached(t, c, store, "ttscache/a.wav"); got[0] {
+		t.Error("oversized audio was cached")
+	}
+	if n, size := c.Len(); n != 0 || size != 0 {
+		t.Errorf("Len = %d, %d", n, size)
+	}
+}
+
+func TestGetForgetsMissingBlob(t *testing.T) {
+	store := newStore(t)
+	c := New(store, 10*entrySize)
+	put(t, c, "ttscache/a.wav", "ttscache/b.wav")
+
+	if err := store.Delete(context.Background(), "ttscache/a.wav"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := c.Get(context.Background(), "ttscache/a.wav"); ok {
+		t.Error("hit on a deleted blob")
+	}
+	if n, size := c.Len(); n != 1 || size != entrySize {
+		t.Errorf("Len = %d, %d, want b only", n, size)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	store := newStore(t)
+	put(t, New(store, 10*entrySize), "ttscache/a.wav", "ttscache/b.wav", "ttscache/c.wav")
+
+	// Oldest first: b, c, a
+	now := time.Now()
+	for key, age := range map[string]time.Duration{"b": 3 * time.Hour, "c": 2 * time.Hour, "a": time.Hour} {
+		p := filepath.Join(store.Root, "ttscache", key+".wav")
+		if err := os.Chtimes(p, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := New(store, 2*entrySize)
+	if err := c.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got := cached(t, c, store, "ttscache/a.wav", "ttscache/b.wav", "ttscache/c.wav")
+	if want := []bool{true, false, true}; !slices.Equal(got, want) {
+		t.Errorf("cached a, b, c = %v, want %v", got, want)
+	}
+
+	// c is now the least recently used
+	put(t, c, "ttscache/d.wav")
+	got = cached(t, c, store, "ttscache/a.wav", "ttscache/c.wav", "ttscache/d.wav")
+	if want := []bool{true, false, true}; !slices.Equal(got, want) {
+		t.Errorf("cached a, c, d = %v, want %v", got, want)
+	}
+}
@@ -6,6 +6,11 @@ import (
 
 type UUID = uuid.UUID
 
+// New returns a random UUID.
+func New() UUID {
+	return uuid.New()
+}
+
 func CreateUUID() string {
 	uuid := uuid.New()
 	return uuid.String()
@@ -0,0 +1,47 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"anne-hub/models"
+	"anne-hub/pkg/uuid"
+	"anne-hub/repository/sqlstore"
+)
+
+type conversations struct{ s *sqlstore.Store }
+
+func (r conversations) Latest(ctx context.Context, userID uuid.UUID, window time.Duration) (models.Conversation, error) {
+	query := `
+		SELECT id, user_id, conversation_history, created_at
+		FROM conversations
+		WHERE user_id = ?
+		  AND created_at >= NOW() - ? * INTERVAL '1 second'
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+	var conversation models.Conversation
+	err := r.s.Get(ctx, "SELECT", "conversations", &conversation, query, userID, window.Seconds())
+	return conversation, err
+}
+
+func (r conversations) Create(ctx context.Context, userID uuid.UUID, history json.RawMessage) (int64, error) {
+	query := `
+		INSERT INTO conversations (user_id, conversation_history)
+		VALUES (?, ?)
+		RETURNING id
+	`
+	var id int64
+	err := r.s.Get(ctx, "INSERT", "conversations", &id, query, userID, []byte(history))
+	return id, err
+}
+
+func (r conversations) UpdateHistory(ctx context.Context, id int64, history json.RawMessage) error {
+	query := `
+		UPDATE conversations
+		SET conversation_history = ?, updated_at = NOW()
+		WHERE id = ?
+	`
+	return r.s.ExecOne(ctx, "UPDATE", "conversations", query, []byte(history), id)
+}
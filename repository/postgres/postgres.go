@@ -0,0 +1,39 @@
+// Package postgres implements the repositories on PostgreSQL.
+package postgres
+
+import (
+	"errors"
+	"fmt"
+
+	"anne-hub/repository"
+	"anne-hub/repository/sqlstore"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var dialect = &sqlstore.Dialect{
+	Name:     "postgresql",
+	BindType: sqlx.DOLLAR,
+	MapError: mapError,
+	UsageKeys: map[string]string{
+		repository.ByDay:      `to_char(created_at, 'YYYY-MM-DD')`,
+		repository.ByUser:     `COALESCE(user_id::text, '')`,
+		repository.ByDevice:   `device_id`,
+		repository.ByProvider: `provider`,
+	},
+	Conversations: func(s *sqlstore.Store) repository.Conversations { return conversations{s} },
+}
+
+// New returns a Store using db.
+func New(db *sqlx.DB) *sqlstore.Store {
+	return sqlstore.New(db, dialect)
+}
+
+func mapError(err error) error {
+	var pgErr *pq.Error
+	if errors.As(err, &pgErr) && pgErr.Code.Name() == "foreign_key_violation" {
+		return fmt.Errorf("%w: %s", repository.ErrInvalidReference, pgErr.Message)
+	}
+	return err
+}
@@ -0,0 +1,138 @@
+// Package repository defines the storage interfaces the handlers and
+// services work against. Backends live in subpackages.
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"anne-hub/models"
+	"anne-hub/pkg/uuid"
+)
+
+var (
+	// ErrNotFound is returned when the requested row does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidReference is returned when a row refers to a missing one,
+	// e.g. a conversation for an unknown user.
+	ErrInvalidReference = errors.New("invalid reference")
+)
+
+// Store bundles the repositories of one backend.
+type Store interface {
+	Users() Users
+	Tasks() Tasks
+	Interests() Interests
+	Devices() Devices
+	CompanionApps() CompanionApps
+	Conversations() Conversations
+	Usage() Usage
+	UsageRecords() UsageRecords
+
+	// InTx runs fn with a Store bound to a transaction, which is committed
+	// if fn returns nil and rolled back otherwise.
+	InTx(ctx context.Context, fn func(tx Store) error) error
+}
+
+type Users interface {
+	List(ctx context.Context) ([]models.User, error)
+	Get(ctx context.Context, id uuid.UUID) (models.User, error)
+	// Create fills in the generated ID and CreatedAt
+	Create(ctx context.Context, user *models.User) error
+	// Update stores the fields of user under id and fills in ID and CreatedAt
+	Update(ctx context.Context, id uuid.UUID, user *models.User) error
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+type Tasks interface {
+	List(ctx context.Context, limit, offset int) ([]models.Task, error)
+	Get(ctx context.Context, id int64) (models.Task, error)
+	ListByUser(ctx context.Context, userID uuid.UUID) ([]models.Task, error)
+	ListOpenByUser(ctx context.Context, userID uuid.UUID) ([]models.Task, error)
+	// Create fills in the generated ID
+	Create(ctx context.Context, task *models.Task) error
+	Update(ctx context.Context, id int64, task *models.Task) error
+	Delete(ctx context.Context, id int64) error
+}
+
+type Interests interface {
+	List(ctx context.Context, limit, offset int) ([]models.Interest, error)
+	Get(ctx context.Context, id int64) (models.Interest, error)
+	ListByUser(ctx context.Context, userID uuid.UUID) ([]models.Interest, error)
+	// Create fills in the generated ID
+	Create(ctx context.Context, interest *models.Interest) error
+	Update(ctx context.Context, id int64, interest *models.Interest) error
+	Delete(ctx context.Context, id int64) error
+}
+
+type Devices interface {
+	Get(ctx context.Context, id int64) (models.Device, error)
+	ListByUser(ctx context.Context, userID uuid.UUID) ([]models.Device, error)
+	// Create fills in the generated ID and CreatedAt
+	Create(ctx context.Context, device *models.Device) error
+	// MarkSynced records that the device synced at t
+	MarkSynced(ctx context.Context, id int64, t time.Time) error
+	Delete(ctx context.Context, id int64) error
+}
+
+type CompanionApps interface {
+	Get(ctx context.Context, id int64) (models.CompanionApp, error)
+	ListByUser(ctx context.Context, userID uuid.UUID) ([]models.CompanionApp, error)
+	// Create fills in the generated ID and CreatedAt
+	Create(ctx context.Context, app *models.CompanionApp) error
+	UpdateSettings(ctx context.Context, id int64, settings map[string]any) error
+	Delete(ctx context.Context, id int64) error
+}
+
+type Conversations interface {
+	// Latest returns the user's newest conversation started within window,
+	// as seen by the database clock, or ErrNotFound.
+	Latest(ctx context.Context, userID uuid.UUID, window time.Duration) (models.Conversation, error)
+	// Create stores a new conversation and returns its ID.
+	Create(ctx context.Context, userID uuid.UUID, history json.RawMessage) (int64, error)
+	UpdateHistory(ctx context.Context, id int64, history json.RawMessage) error
+}
+
+// Usage keeps the counters of the usage quotas. subject identifies a user or
+// device and period the length of the window starting at windowStart.
+type Usage interface {
+	// Get returns the counters of a window, zero if nothing was recorded.
+	Get(ctx context.Context, subject, period string, windowStart time.Time) (models.Usage, error)
+	// Add adds delta to the counters of a window and returns the new totals.
+	Add(ctx context.Context, subject, period string, windowStart time.Time, delta models.Usage) (models.Usage, error)
+	// DeleteBefore removes the windows that started before t.
+	DeleteBefore(ctx context.Context, t time.Time) (int64, error)
+}
+
+// Groupings of usage summaries.
+const (
+	ByDay      = "day"
+	ByUser     = "user"
+	ByDevice   = "device"
+	ByProvider = "provider"
+)
+
+// UsageQuery selects the usage records to summarize. Zero fields do not
+// filter.
+type UsageQuery struct {
+	// GroupBy is ByDay, ByUser, ByDevice or ByProvider
+	GroupBy  string
+	UserID   uuid.UUID
+	DeviceID string
+	// From is inclusive, To exclusive; days are UTC
+	From, To time.Time
+}
+
+// UsageRecords keeps what each turn used and cost.
+type UsageRecords interface {
+	// Create stores the records of a turn.
+	Create(ctx context.Context, records []models.UsageRecord) error
+	// Summarize aggregates the records matching q by q.GroupBy, ordered by
+	// key.
+	Summarize(ctx context.Context, q UsageQuery) ([]models.UsageSummary, error)
+	// Total aggregates all records matching q, ignoring q.GroupBy, so that
+	// a turn on several rows of a summary is counted once.
+	Total(ctx context.Context, q UsageQuery) (models.UsageSummary, error)
+}
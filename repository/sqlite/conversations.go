@@ -0,0 +1,65 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"anne-hub/models"
+	"anne-hub/pkg/uuid"
+	"anne-hub/repository/sqlstore"
+)
+
+type conversations struct{ s *sqlstore.Store }
+
+// conversationRow holds the history as stored, a JSON text column
+type conversationRow struct {
+	ID        int64     `db:"id"`
+	UserID    uuid.UUID `db:"user_id"`
+	History   []byte    `db:"conversation_history"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+func (r conversations) Latest(ctx context.Context, userID uuid.UUID, window time.Duration) (models.Conversation, error) {
+	// created_at holds CURRENT_TIMESTAMP, which datetime() matches
+	query := `
+		SELECT id, user_id, conversation_history, created_at
+		FROM conversations
+		WHERE user_id = ?
+		  AND created_at >= datetime('now', ?)
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+	var row conversationRow
+	modifier := fmt.Sprintf("-%d seconds", int64(window.Seconds()))
+	if err := r.s.Get(ctx, "SELECT", "conversations", &row, query, userID, modifier); err != nil {
+		return models.Conversation{}, err
+	}
+	return models.Conversation{
+		ID:                  row.ID,
+		UserID:              row.UserID,
+		CreatedAt:           row.CreatedAt,
+		ConversationHistory: json.RawMessage(row.History),
+	}, nil
+}
+
+func (r conversations) Create(ctx context.Context, userID uuid.UUID, history json.RawMessage) (int64, error) {
+	query := `
+		INSERT INTO conversations (user_id, conversation_history)
+		VALUES (?, ?)
+		RETURNING id
+	`
+	var id int64
+	err := r.s.Get(ctx, "INSERT", "conversations", &id, query, userID, string(history))
+	return id, err
+}
+
+func (r conversations) UpdateHistory(ctx context.Context, id int64, history json.RawMessage) error {
+	query := `
+		UPDATE conversations
+		SET conversation_history = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	return r.s.ExecOne(ctx, "UPDATE", "conversations", query, string(history), id)
+}
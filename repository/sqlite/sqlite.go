@@ -0,0 +1,41 @@
+// Package sqlite implements the repositories on SQLite, for single-box and
+// offline deployments.
+package sqlite
+
+import (
+	"errors"
+	"fmt"
+
+	"anne-hub/repository"
+	"anne-hub/repository/sqlstore"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+)
+
+var dialect = &sqlstore.Dialect{
+	Name:     "sqlite",
+	BindType: sqlx.QUESTION,
+	MapError: mapError,
+	// Times are stored as text starting with the date
+	UsageKeys: map[string]string{
+		repository.ByDay:      `substr(created_at, 1, 10)`,
+		repository.ByUser:     `COALESCE(user_id, '')`,
+		repository.ByDevice:   `device_id`,
+		repository.ByProvider: `provider`,
+	},
+	Conversations: func(s *sqlstore.Store) repository.Conversations { return conversations{s} },
+}
+
+// New returns a Store using db, a database file.
+func New(db *sqlx.DB) *sqlstore.Store {
+	return sqlstore.New(db, dialect)
+}
+
+func mapError(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+		return fmt.Errorf("%w: %s", repository.ErrInvalidReference, sqliteErr.Error())
+	}
+	return err
+}
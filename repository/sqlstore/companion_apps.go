@@ -0,0 +1,96 @@
+package sqlstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"anne-hub/models"
+	"anne-hub/pkg/uuid"
+)
+
+type companionApps struct{ s *Store }
+
+const companionAppColumns = `id, settings, created_at, user_id`
+
+// companionAppRow holds the settings as stored, JSONB on PostgreSQL and
+// JSON text on SQLite
+type companionAppRow struct {
+	ID        int64     `db:"id"`
+	Settings  []byte    `db:"settings"`
+	CreatedAt time.Time `db:"created_at"`
+	UserID    uuid.UUID `db:"user_id"`
+}
+
+func (row companionAppRow) model() (models.CompanionApp, error) {
+	app := models.CompanionApp{ID: row.ID, CreatedAt: row.CreatedAt, UserID: row.UserID}
+	if len(row.Settings) > 0 {
+		if err := json.Unmarshal(row.Settings, &app.Settings); err != nil {
+			return app, fmt.Errorf("invalid settings of companion app %d: %w", row.ID, err)
+		}
+	}
+	return app, nil
+}
+
+func (r companionApps) Get(ctx context.Context, id int64) (models.CompanionApp, error) {
+	var row companionAppRow
+	if err := r.s.Get(ctx, "SELECT", "companion_apps", &row, `SELECT `+companionAppColumns+` FROM companion_apps WHERE id = ?`, id); err != nil {
+		return models.CompanionApp{}, err
+	}
+	return row.model()
+}
+
+func (r companionApps) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.CompanionApp, error) {
+	query := `
+		SELECT ` + companionAppColumns + `
+		FROM companion_apps
+		WHERE user_id = ?
+		ORDER BY created_at
+	`
+	var rows []companionAppRow
+	if err := r.s.Select(ctx, "SELECT", "companion_apps", &rows, query, userID); err != nil {
+		return nil, err
+	}
+
+	list := make([]models.CompanionApp, 0, len(rows))
+	for _, row := range rows {
+		app, err := row.model()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, app)
+	}
+	return list, nil
+}
+
+func (r companionApps) Create(ctx context.Context, app *models.CompanionApp) error {
+	settings, err := json.Marshal(app.Settings)
+	if err != nil {
+		return fmt.Errorf("invalid companion app settings: %w", err)
+	}
+
+	query := `
+		INSERT INTO companion_apps (user_id, settings)
+		VALUES (?, ?)
+		RETURNING id, created_at
+	`
+	var row companionAppRow
+	if err := r.s.Get(ctx, "INSERT", "companion_apps", &row, query, app.UserID, string(settings)); err != nil {
+		return err
+	}
+	app.ID, app.CreatedAt = row.ID, row.CreatedAt
+	return nil
+}
+
+func (r companionApps) UpdateSettings(ctx context.Context, id int64, settings map[string]any) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("invalid companion app settings: %w", err)
+	}
+	return r.s.ExecOne(ctx, "UPDATE", "companion_apps", `UPDATE companion_apps SET settings = ? WHERE id = ?`, string(data), id)
+}
+
+func (r companionApps) Delete(ctx context.Context, id int64) error {
+	return r.s.ExecOne(ctx, "DELETE", "companion_apps", `DELETE FROM companion_apps WHERE id = ?`, id)
+}
@@ -0,0 +1,52 @@
+package sqlstore
+
+import (
+	"context"
+	"time"
+
+	"anne-hub/models"
+	"anne-hub/pkg/uuid"
+)
+
+type devices struct{ s *Store }
+
+const deviceColumns = `id, user_id, device_name, last_synced, companion_app_id, created_at`
+
+func (r devices) Get(ctx context.Context, id int64) (models.Device, error) {
+	var device models.Device
+	err := r.s.Get(ctx, "SELECT", "devices", &device, `SELECT `+deviceColumns+` FROM devices WHERE id = ?`, id)
+	return device, err
+}
+
+func (r devices) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.Device, error) {
+	query := `
+		SELECT ` + deviceColumns + `
+		FROM devices
+		WHERE user_id = ?
+		ORDER BY created_at
+	`
+	list := []models.Device{}
+	err := r.s.Select(ctx, "SELECT", "devices", &list, query, userID)
+	return list, err
+}
+
+func (r devices) Create(ctx context.Context, device *models.Device) error {
+	query := `
+		INSERT INTO devices (user_id, device_name, companion_app_id)
+		VALUES (?, ?, ?)
+		RETURNING id, created_at
+	`
+	return r.s.Get(ctx, "INSERT", "devices", device, query,
+		device.UserID,
+		device.DeviceName,
+		device.CompanionAppID,
+	)
+}
+
+func (r devices) MarkSynced(ctx context.Context, id int64, t time.Time) error {
+	return r.s.ExecOne(ctx, "UPDATE", "devices", `UPDATE devices SET last_synced = ? WHERE id = ?`, t, id)
+}
+
+func (r devices) Delete(ctx context.Context, id int64) error {
+	return r.s.ExecOne(ctx, "DELETE", "devices", `DELETE FROM devices WHERE id = ?`, id)
+}
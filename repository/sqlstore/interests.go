@@ -0,0 +1,85 @@
+package sqlstore
+
+import (
+	"context"
+
+	"anne-hub/models"
+	"anne-hub/pkg/uuid"
+)
+
+type interests struct{ s *Store }
+
+const interestColumns = `id, user_id, created_at, updated_at, name, description, level, level_accuracy`
+
+func (r interests) List(ctx context.Context, limit, offset int) ([]models.Interest, error) {
+	query := `
+		SELECT ` + interestColumns + `
+		FROM interests
+		ORDER BY created_at DESC
+		LIMIT ? OFFSET ?
+	`
+	list := []models.Interest{}
+	err := r.s.Select(ctx, "SELECT", "interests", &list, query, limit, offset)
+	return list, err
+}
+
+func (r interests) Get(ctx context.Context, id int64) (models.Interest, error) {
+	var interest models.Interest
+	err := r.s.Get(ctx, "SELECT", "interests", &interest, `SELECT `+interestColumns+` FROM interests WHERE id = ?`, id)
+	return interest, err
+}
+
+func (r interests) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.Interest, error) {
+	query := `
+		SELECT ` + interestColumns + `
+		FROM interests
+		WHERE user_id = ?
+		ORDER BY created_at DESC
+	`
+	list := []models.Interest{}
+	err := r.s.Select(ctx, "SELECT", "interests", &list, query, userID)
+	return list, err
+}
+
+func (r interests) Create(ctx context.Context, interest *models.Interest) error {
+	query := `
+		INSERT INTO interests (user_id, created_at, updated_at, name, description, level, level_accuracy)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`
+	return r.s.Get(ctx, "INSERT", "interests", &interest.ID, query,
+		interest.UserID,
+		interest.CreatedAt,
+		interest.UpdatedAt,
+		interest.Name,
+		interest.Description,
+		interest.Level,
+		interest.LevelAccuracy,
+	)
+}
+
+func (r interests) Update(ctx context.Context, id int64, interest *models.Interest) error {
+	query := `
+		UPDATE interests SET
+			user_id = ?,
+			updated_at = ?,
+			name = ?,
+			description = ?,
+			level = ?,
+			level_accuracy = ?
+		WHERE id = ?
+	`
+	return r.s.ExecOne(ctx, "UPDATE", "interests", query,
+		interest.UserID,
+		interest.UpdatedAt,
+		interest.Name,
+		interest.Description,
+		interest.Level,
+		interest.LevelAccuracy,
+		id,
+	)
+}
+
+func (r interests) Delete(ctx context.Context, id int64) error {
+	return r.s.ExecOne(ctx, "DELETE", "interests", `DELETE FROM interests WHERE id = ?`, id)
+}
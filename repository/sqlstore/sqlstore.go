@@ -0,0 +1,134 @@
+// Package sqlstore implements the repositories on a SQL database. Queries
+// are written with ? placeholders and rebound for the driver; what differs
+// between databases is supplied by the backend's Dialect.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"anne-hub/pkg/tracing"
+	"anne-hub/repository"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Dialect is what a backend adds to the shared queries.
+type Dialect struct {
+	// Name is the database system of the query spans, e.g. "postgresql"
+	Name string
+	// BindType is the driver's placeholder style, e.g. sqlx.DOLLAR
+	BindType int
+	// MapError translates the driver's errors, e.g. a foreign key violation
+	// to repository.ErrInvalidReference
+	MapError func(error) error
+	// UsageKeys are the grouping expressions of usage summaries
+	UsageKeys map[string]string
+	// Conversations implements the conversation queries, which use the
+	// database's date arithmetic
+	Conversations func(s *Store) repository.Conversations
+}
+
+// Store implements repository.Store on a connection pool or, inside InTx,
+// on a transaction.
+type Store struct {
+	dialect *Dialect
+	// db is nil inside a transaction
+	db *sqlx.DB
+	q  sqlx.ExtContext
+}
+
+// New returns a Store using db.
+func New(db *sqlx.DB, dialect *Dialect) *Store {
+	return &Store{dialect: dialect, db: db, q: db}
+}
+
+func (s *Store) Users() repository.Users                 { return users{s} }
+func (s *Store) Tasks() repository.Tasks                 { return tasks{s} }
+func (s *Store) Interests() repository.Interests         { return interests{s} }
+func (s *Store) Devices() repository.Devices             { return devices{s} }
+func (s *Store) CompanionApps() repository.CompanionApps { return companionApps{s} }
+func (s *Store) Conversations() repository.Conversations { return s.dialect.Conversations(s) }
+func (s *Store) Usage() repository.Usage                 { return usage{s} }
+func (s *Store) UsageRecords() repository.UsageRecords   { return usageRecords{s} }
+
+// InTx runs fn in a transaction. Called inside one, fn joins it.
+func (s *Store) InTx(ctx context.Context, fn func(tx repository.Store) error) (err error) {
+	if s.db == nil {
+		return fn(s)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err = fn(&Store{dialect: s.dialect, q: tx}); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Get runs a query returning one row and scans it into dest. Every query
+// gets a span named after its operation and table, e.g. "SELECT users".
+func (s *Store) Get(ctx context.Context, operation, table string, dest any, query string, args ...any) error {
+	query = sqlx.Rebind(s.dialect.BindType, query)
+	ctx, span := tracing.StartQuery(ctx, s.dialect.Name, operation, table, query)
+	err := sqlx.GetContext(ctx, s.q, dest, query, args...)
+	tracing.EndQuery(span, err)
+	return s.mapError(err)
+}
+
+// Select runs a query and scans all rows into the slice dest.
+func (s *Store) Select(ctx context.Context, operation, table string, dest any, query string, args ...any) error {
+	query = sqlx.Rebind(s.dialect.BindType, query)
+	ctx, span := tracing.StartQuery(ctx, s.dialect.Name, operation, table, query)
+	err := sqlx.SelectContext(ctx, s.q, dest, query, args...)
+	tracing.EndQuery(span, err)
+	return s.mapError(err)
+}
+
+// Exec runs a statement and returns the number of rows it affected.
+func (s *Store) Exec(ctx context.Context, operation, table string, query string, args ...any) (int64, error) {
+	query = sqlx.Rebind(s.dialect.BindType, query)
+	ctx, span := tracing.StartQuery(ctx, s.dialect.Name, operation, table, query)
+	res, err := s.q.ExecContext(ctx, query, args...)
+	tracing.EndQuery(span, err)
+	if err != nil {
+		return 0, s.mapError(err)
+	}
+	return res.RowsAffected()
+}
+
+// ExecOne runs a statement expected to affect one row, ErrNotFound if it
+// affected none.
+func (s *Store) ExecOne(ctx context.Context, operation, table string, query string, args ...any) error {
+	n, err := s.Exec(ctx, operation, table, query, args...)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) mapError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.ErrNotFound
+	}
+	if err != nil && s.dialect.MapError != nil {
+		return s.dialect.MapError(err)
+	}
+	return err
+}
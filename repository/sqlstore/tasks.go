@@ -0,0 +1,93 @@
+package sqlstore
+
+import (
+	"context"
+
+	"anne-hub/models"
+	"anne-hub/pkg/uuid"
+)
+
+type tasks struct{ s *Store }
+
+const taskColumns = `id, user_id, title, COALESCE(description, '') AS description, due_date, completed, created_at`
+
+func (r tasks) List(ctx context.Context, limit, offset int) ([]models.Task, error) {
+	query := `
+		SELECT ` + taskColumns + `
+		FROM tasks
+		ORDER BY created_at DESC
+		LIMIT ? OFFSET ?
+	`
+	list := []models.Task{}
+	err := r.s.Select(ctx, "SELECT", "tasks", &list, query, limit, offset)
+	return list, err
+}
+
+func (r tasks) Get(ctx context.Context, id int64) (models.Task, error) {
+	var task models.Task
+	err := r.s.Get(ctx, "SELECT", "tasks", &task, `SELECT `+taskColumns+` FROM tasks WHERE id = ?`, id)
+	return task, err
+}
+
+func (r tasks) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.Task, error) {
+	query := `
+		SELECT ` + taskColumns + `
+		FROM tasks
+		WHERE user_id = ?
+		ORDER BY created_at DESC
+	`
+	list := []models.Task{}
+	err := r.s.Select(ctx, "SELECT", "tasks", &list, query, userID)
+	return list, err
+}
+
+func (r tasks) ListOpenByUser(ctx context.Context, userID uuid.UUID) ([]models.Task, error) {
+	query := `
+		SELECT ` + taskColumns + `
+		FROM tasks
+		WHERE user_id = ? AND completed = false
+	`
+	list := []models.Task{}
+	err := r.s.Select(ctx, "SELECT", "tasks", &list, query, userID)
+	return list, err
+}
+
+func (r tasks) Create(ctx context.Context, task *models.Task) error {
+	query := `
+		INSERT INTO tasks (user_id, title, description, due_date, completed, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`
+	return r.s.Get(ctx, "INSERT", "tasks", &task.ID, query,
+		task.UserID,
+		task.Title,
+		task.Description,
+		task.DueDate,
+		task.Completed,
+		task.CreatedAt,
+	)
+}
+
+func (r tasks) Update(ctx context.Context, id int64, task *models.Task) error {
+	query := `
+		UPDATE tasks SET
+			user_id = ?,
+			title = ?,
+			description = ?,
+			due_date = ?,
+			completed = ?
+		WHERE id = ?
+	`
+	return r.s.ExecOne(ctx, "UPDATE", "tasks", query,
+		task.UserID,
+		task.Title,
+		task.Description,
+		task.DueDate,
+		task.Completed,
+		id,
+	)
+}
+
+func (r tasks) Delete(ctx context.Context, id int64) error {
+	return r.s.ExecOne(ctx, "DELETE", "tasks", `DELETE FROM tasks WHERE id = ?`, id)
+}
@@ -0,0 +1,52 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"anne-hub/models"
+	"anne-hub/repository"
+)
+
+type usage struct{ s *Store }
+
+func (r usage) Get(ctx context.Context, subject, period string, windowStart time.Time) (models.Usage, error) {
+	query := `
+		SELECT turns, audio_ms, tokens
+		FROM usage_counters
+		WHERE subject = ? AND period = ? AND window_start = ?
+	`
+	var u models.Usage
+	err := r.s.Get(ctx, "SELECT", "usage_counters", &u, query, subject, period, windowStart.UTC())
+	if errors.Is(err, repository.ErrNotFound) {
+		return models.Usage{}, nil
+	}
+	return u, err
+}
+
+func (r usage) Add(ctx context.Context, subject, period string, windowStart time.Time, delta models.Usage) (models.Usage, error) {
+	query := `
+		INSERT INTO usage_counters (subject, period, window_start, turns, audio_ms, tokens)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (subject, period, window_start) DO UPDATE
+		SET turns = usage_counters.turns + EXCLUDED.turns,
+		    audio_ms = usage_counters.audio_ms + EXCLUDED.audio_ms,
+		    tokens = usage_counters.tokens + EXCLUDED.tokens
+		RETURNING turns, audio_ms, tokens
+	`
+	var u models.Usage
+	err := r.s.Get(ctx, "INSERT", "usage_counters", &u, query,
+		subject,
+		period,
+		windowStart.UTC(),
+		delta.Turns,
+		delta.AudioMS,
+		delta.Tokens,
+	)
+	return u, err
+}
+
+func (r usage) DeleteBefore(ctx context.Context, t time.Time) (int64, error) {
+	return r.s.Exec(ctx, "DELETE", "usage_counters", `DELETE FROM usage_counters WHERE window_start < ?`, t.UTC())
+}
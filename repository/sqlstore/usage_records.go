@@ -0,0 +1,112 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"anne-hub/models"
+	"anne-hub/pkg/uuid"
+	"anne-hub/repository"
+)
+
+type usageRecords struct{ s *Store }
+
+func (r usageRecords) Create(ctx context.Context, records []models.UsageRecord) error {
+	query := `
+		INSERT INTO usage_records (created_at, turn_id, user_id, device_id, stage, provider, model,
+			prompt_tokens, completion_tokens, characters, audio_ms, cached, cost_usd)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`
+	for i := range records {
+		rec := &records[i]
+		err := r.s.Get(ctx, "INSERT", "usage_records", &rec.ID, query,
+			rec.CreatedAt.UTC(),
+			rec.TurnID,
+			rec.UserID,
+			rec.DeviceID,
+			rec.Stage,
+			rec.Provider,
+			rec.Model,
+			rec.PromptTokens,
+			rec.CompletionTokens,
+			rec.Characters,
+			rec.AudioMS,
+			rec.Cached,
+			rec.CostUSD,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r usageRecords) Summarize(ctx context.Context, q repository.UsageQuery) ([]models.UsageSummary, error) {
+	key, ok := r.s.dialect.UsageKeys[q.GroupBy]
+	if !ok {
+		return nil, fmt.Errorf("unknown usage grouping %q", q.GroupBy)
+	}
+
+	where, args := usageFilter(q)
+	query := `
+		SELECT ` + key + ` AS key,` + usageColumns + `
+		FROM usage_records
+		WHERE ` + where + `
+		GROUP BY 1
+		ORDER BY 1
+	`
+	list := []models.UsageSummary{}
+	err := r.s.Select(ctx, "SELECT", "usage_records", &list, query, args...)
+	return list, err
+}
+
+func (r usageRecords) Total(ctx context.Context, q repository.UsageQuery) (models.UsageSummary, error) {
+	where, args := usageFilter(q)
+	query := `
+		SELECT '' AS key,` + usageColumns + `
+		FROM usage_records
+		WHERE ` + where + `
+	`
+	var total models.UsageSummary
+	err := r.s.Get(ctx, "SELECT", "usage_records", &total, query, args...)
+	return total, err
+}
+
+// usageColumns aggregate the usage records of a summary.
+const usageColumns = `
+			COUNT(DISTINCT turn_id) AS turns,
+			COALESCE(SUM(prompt_tokens), 0) AS prompt_tokens,
+			COALESCE(SUM(completion_tokens), 0) AS completion_tokens,
+			COALESCE(SUM(characters), 0) AS characters,
+			COALESCE(SUM(CASE WHEN stage = 'stt' THEN audio_ms END), 0) / 1000.0 AS stt_seconds,
+			COALESCE(SUM(CASE WHEN stage = 'tts' THEN audio_ms END), 0) / 1000.0 AS tts_seconds,
+			COALESCE(SUM(cost_usd), 0) AS cost_usd`
+
+// usageFilter returns the condition selecting the records of q and its
+// arguments.
+func usageFilter(q repository.UsageQuery) (string, []any) {
+	var where []string
+	var args []any
+	filter := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, cond)
+	}
+	if !q.From.IsZero() {
+		filter("created_at >= ?", q.From.UTC())
+	}
+	if !q.To.IsZero() {
+		filter("created_at < ?", q.To.UTC())
+	}
+	if q.UserID != (uuid.UUID{}) {
+		filter("user_id = ?", q.UserID)
+	}
+	if q.DeviceID != "" {
+		filter("device_id = ?", q.DeviceID)
+	}
+	if len(where) == 0 {
+		return "1 = 1", nil
+	}
+	return strings.Join(where, " AND "), args
+}
@@ -0,0 +1,65 @@
+package sqlstore
+
+import (
+	"context"
+
+	"anne-hub/models"
+	"anne-hub/pkg/uuid"
+)
+
+type users struct{ s *Store }
+
+// userColumns reads the optional profile fields as zero values, rows
+// created before they existed leave them NULL.
+const userColumns = `id, username, email, password_hash, created_at,
+	COALESCE(age, 0) AS age, COALESCE(country, '') AS country, COALESCE(city, '') AS city,
+	COALESCE(first_name, '') AS first_name, COALESCE(last_name, '') AS last_name, plan`
+
+func (r users) List(ctx context.Context) ([]models.User, error) {
+	list := []models.User{}
+	err := r.s.Select(ctx, "SELECT", "users", &list, `SELECT `+userColumns+` FROM users`)
+	return list, err
+}
+
+func (r users) Get(ctx context.Context, id uuid.UUID) (models.User, error) {
+	var user models.User
+	err := r.s.Get(ctx, "SELECT", "users", &user, `SELECT `+userColumns+` FROM users WHERE id = ?`, id)
+	return user, err
+}
+
+func (r users) Create(ctx context.Context, user *models.User) error {
+	query := `
+		INSERT INTO users (id, username, email, password_hash, age, plan)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id, created_at
+	`
+	return r.s.Get(ctx, "INSERT", "users", user, query,
+		uuid.New(),
+		user.Username,
+		user.Email,
+		user.PasswordHash,
+		user.Age,
+		user.Plan,
+	)
+}
+
+func (r users) Update(ctx context.Context, id uuid.UUID, user *models.User) error {
+	query := `
+		UPDATE users
+		SET username = ?, email = ?, password_hash = ?, age = ?, plan = ?
+		WHERE id = ?
+		RETURNING id, created_at
+	`
+	return r.s.Get(ctx, "UPDATE", "users", user, query,
+		user.Username,
+		user.Email,
+		user.PasswordHash,
+		user.Age,
+		user.Plan,
+		id,
+	)
+}
+
+func (r users) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.s.ExecOne(ctx, "DELETE", "users", `DELETE FROM users WHERE id = ?`, id)
+}
@@ -2,49 +2,82 @@ package router
 
 import (
 	"anne-hub/handlers"
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/health"
+	"anne-hub/pkg/logging"
+	"anne-hub/pkg/metrics"
+	"anne-hub/pkg/tracing"
+	"anne-hub/repository"
 
 	"github.com/labstack/echo/v4"
 )
 
-func NewRouter() *echo.Echo {
+func NewRouter(cfg *config.Config, store repository.Store, deps handlers.Deps) *echo.Echo {
 	e := echo.New()
+	e.Use(tracing.Middleware())
+	e.Use(logging.Middleware())
+	e.Use(metrics.Middleware())
 
 
 	// General routes
 	e.GET("/ok", handlers.OkHandler)
+	e.GET("/livez", handlers.LivezHandler)
+	e.GET("/readyz", handlers.ReadyzHandler(health.Readiness(cfg)))
 	e.GET("/gh-actions-test", handlers.GitHubActionsTestHandler)
 	e.GET("/uuid", handlers.UUIDHandler)
+	e.GET("/metrics", echo.WrapHandler(metrics.Handler()))
 
 
 	// Task routes
-	e.GET("/tasks", handlers.GetAllTasks)
-	// e.GET("/tasks/:id", handlers.GetTaskByID)
-	e.GET("/tasks/:id", handlers.GetAllTasksByUserID)
-	e.POST("/tasks", handlers.CreateTaskHandler)
-	e.PUT("/tasks/:id", handlers.UpdateTaskHandler)
-	e.DELETE("/tasks/:id", handlers.DeleteTaskHandler)
+	tasks := handlers.NewTaskHandlers(store)
+	e.GET("/tasks", tasks.GetAllTasks)
+	// e.GET("/tasks/:id", tasks.GetTaskByID)
+	e.GET("/tasks/:id", tasks.GetAllTasksByUserID)
+	e.POST("/tasks", tasks.CreateTaskHandler)
+	e.PUT("/tasks/:id", tasks.UpdateTaskHandler)
+	e.DELETE("/tasks/:id", tasks.DeleteTaskHandler)
 
 	// Interest routes
-	e.GET("/interests", handlers.GetAllInterests)
-	e.GET("/interests/:id", handlers.GetInterestByID)
-	e.POST("/interests", handlers.CreateInterestHandler)
-	e.PUT("/interests/:id", handlers.UpdateInterestHandler)
-	e.DELETE("/interests/:id", handlers.DeleteInterestHandler)
+	interests := handlers.NewInterestHandlers(store)
+	e.GET("/interests", interests.GetAllInterests)
+	e.GET("/interests/:id", interests.GetInterestByID)
+	e.POST("/interests", interests.CreateInterestHandler)
+	e.PUT("/interests/:id", interests.UpdateInterestHandler)
+	e.DELETE("/interests/:id", interests.DeleteInterestHandler)
 
 
 	// User routes
-	e.GET("/users", handlers.GetAllUsersHandler)        
-	e.GET("/users/:id", handlers.GetUserHandler)           // Fetch a specific user by ID
-	e.POST("/users", handlers.CreateUserHandler)          // Create a new user
-	e.PUT("/users/:id", handlers.UpdateUserHandler)       // Update a specific user by ID
-	e.DELETE("/users/:id", handlers.DeleteUserHandler)    // Delete a specific user by ID
+	users := handlers.NewUserHandlers(store, deps.Audio, deps.PhrasePacks)
+	e.GET("/users", users.GetAllUsersHandler)        
+	e.GET("/users/:id", users.GetUserHandler)           // Fetch a specific user by ID
+	e.POST("/users", users.CreateUserHandler)          // Create a new user
+	e.PUT("/users/:id", users.UpdateUserHandler)       // Update a specific user by ID
+	e.DELETE("/users/:id", users.DeleteUserHandler)    // Delete a specific user by ID
 
 	// Conversation routes
-	e.POST("/ConversationHandler", handlers.ConversationHandler)
-	e.POST("/transcribe", handlers.TranscribeAudio)
+	conversations := handlers.NewConversationHandlers(store, deps, cfg.Audio)
+	e.POST("/ConversationHandler", conversations.ConversationHandler)
+	e.POST("/transcribe", conversations.TranscribeAudio)
 
     // e.GET("/ws", handlers.WebSocketTestHandler)
-    e.GET("/ws", handlers.WebSocketConversationHandler)
+    e.GET("/ws", conversations.WebSocketConversationHandler)
+
+	// File routes
+	files := handlers.NewFileHandlers(deps.Blobs)
+	e.GET("/files/*", files.StaticFilesHandler)
+	e.GET("/blobs/*", files.SignedBlobHandler)
+
+	// Phrase pack routes, downloaded by the wearable for offline use
+	phrasePacks := handlers.NewPhrasePackHandlers(store, deps.PhrasePacks, deps.Blobs)
+	e.GET("/phrasepacks/:user_id", phrasePacks.GetPhrasePackHandler)
+	e.GET("/phrasepacks/:user_id/:language/:file", phrasePacks.DownloadPhrasePackHandler)
+
+	// Admin routes
+	admin := e.Group("/admin", handlers.AdminAuth(cfg.Admin.Token))
+	admin.GET("/config", handlers.ConfigHandler(cfg))
+
+	usage := handlers.NewUsageHandlers(store)
+	admin.GET("/usage", usage.GetUsageHandler)
 
 
 	return e
@@ -2,113 +2,44 @@ package services
 
 import (
 	"anne-hub/models"
-	"anne-hub/pkg/db"
+	"anne-hub/pkg/logging"
+	"anne-hub/pkg/tracing"
 	"anne-hub/pkg/uuid"
-	"database/sql"
+	"anne-hub/repository"
+	"context"
+	"errors"
 	"fmt"
-	"log"
 )
 
-func FetchUserData(userID uuid.UUID) (models.UserData, error) {
-    var user models.User
-    query := "SELECT id, username, email, password_hash, created_at, age, country, city, first_name, last_name FROM users WHERE id = $1"
+func FetchUserData(ctx context.Context, store repository.Store, userID uuid.UUID) (models.UserData, error) {
+    ctx, span := tracing.Start(ctx, "services.FetchUserData")
+    defer span.End()
 
     // Fetch user details
-    err := db.DB.QueryRow(query, userID).Scan(
-        &user.ID,
-        &user.Username,
-        &user.Email,
-        &user.PasswordHash,
-        &user.CreatedAt,
-        &user.Age,
-        &user.Country,
-        &user.City,
-        &user.FirstName,
-        &user.LastName,
-    )
+    user, err := store.Users().Get(ctx, userID)
     if err != nil {
-        if err == sql.ErrNoRows {
-            log.Printf("No user found with ID: %s", userID)
+        if errors.Is(err, repository.ErrNotFound) {
+            logger.WarnContext(ctx, "user not found", "user_id", logging.PII(userID.String()))
             return models.UserData{}, fmt.Errorf("user with ID %s not found", userID)
         }
-        log.Printf("Error fetching user data: %v", err)
+        logger.ErrorContext(ctx, "failed to fetch user", "user_id", logging.PII(userID.String()), "error", err)
         return models.UserData{}, fmt.Errorf("error fetching user data: %v", err)
     }
 
     // Fetch user interests
-    interestsQuery := `
-        SELECT id, user_id, created_at, updated_at, name, description, level, level_accuracy
-        FROM interests
-        WHERE user_id = $1
-    `
-    rows, err := db.DB.Query(interestsQuery, userID)
+    interests, err := store.Interests().ListByUser(ctx, userID)
     if err != nil {
-        log.Printf("Error fetching user interests: %v", err)
+        logger.ErrorContext(ctx, "failed to fetch interests", "user_id", logging.PII(userID.String()), "error", err)
         return models.UserData{}, fmt.Errorf("error fetching user interests: %v", err)
     }
-    defer rows.Close()
-	
-    var interests []models.Interest
 
-
-    for rows.Next() {
-        var interest models.Interest
-        err := rows.Scan(
-            &interest.ID,
-            &interest.UserID,
-            &interest.CreatedAt,
-            &interest.UpdatedAt,
-            &interest.Name,
-            &interest.Description,
-            &interest.Level,
-            &interest.LevelAccuracy,
-        )
-        if err != nil {
-            log.Printf("Error scanning interest row: %v", err)
-            continue
-        }
-        interests = append(interests, interest)
-    }
-
-	taskQuery := `
-    select id, user_id, title, description, due_date, completed, created_at
-    from tasks
-    where user_id = $1 and completed = false`
-    rows, err = db.DB.Query(taskQuery, userID)
+    tasks, err := store.Tasks().ListOpenByUser(ctx, userID)
     if err != nil {
-        log.Printf("Error fetching user tasks: %v", err)
+        logger.ErrorContext(ctx, "failed to fetch tasks", "user_id", logging.PII(userID.String()), "error", err)
         return models.UserData{}, fmt.Errorf("error fetching user tasks: %v", err)
     }
 
-    var tasks []models.Task
-
-    for rows.Next() {
-        var task models.Task
-        err := rows.Scan(
-            &task.ID,
-            &task.UserID,
-            &task.Title,
-            &task.Description,
-            &task.DueDate,
-            &task.Completed,
-            &task.CreatedAt,
-        )
-        if err != nil {
-            log.Printf("Error scanning task row: %v", err)
-            continue
-        }
-        tasks = append(tasks, task)
-    }
-
-    if rows.Err() != nil {
-        log.Printf("Error iterating over rows: %v", rows.Err())
-        return models.UserData{}, fmt.Errorf("error iterating over rows: %v", rows.Err())
-    }
-
-    fmt.Println("tasks:", tasks)
-
-
-
+    logger.DebugContext(ctx, "user data fetched", "user_id", logging.PII(userID.String()), "interests", len(interests), "tasks", len(tasks))
 
     // Convert models.User to models.UserDetails
     userDetails := models.UserDetails{
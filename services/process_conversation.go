@@ -2,53 +2,76 @@ package services
 
 import (
 	"anne-hub/models"
-	"anne-hub/pkg/db"
-	"database/sql"
+	"anne-hub/pkg/logging"
+	"anne-hub/pkg/pcm"
+	"anne-hub/pkg/tracing"
+	"anne-hub/repository"
+	"context"
 	"encoding/json"
 	"errors"
-	"log"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"github.com/lib/pq"
 )
 
-func HandleProcessConversationInput(
+var logger = logging.For("services")
+
+// Errors of HandleProcessConversationInput, telling the device what to fix.
+var (
+	ErrNoAudio            = errors.New("no PCM data received")
+	ErrMissingHeaders     = errors.New("missing required headers")
+	ErrInvalidLanguage    = errors.New("invalid language")
+	ErrInvalidUserID      = errors.New("invalid user ID")
+	ErrInvalidDeviceID    = errors.New("invalid device ID")
+	ErrInvalidAudioFormat = errors.New("invalid audio format")
+)
+
+func HandleProcessConversationInput(ctx context.Context,
 	pcmData []byte, headers models.WSRequestHeaders) (models.AnneWearConversationRequest, error) {
 
 	if len(pcmData) == 0 {
-		return models.AnneWearConversationRequest{}, errors.New("no PCM data received")
+		return models.AnneWearConversationRequest{}, ErrNoAudio
 	}
 
 	if headers.XUserID == "" || headers.XDeviceID == "" || headers.XLanguage == "" {
-		log.Println("Missing required headers:", headers.XUserID, headers.XDeviceID, headers.XLanguage)
-		return models.AnneWearConversationRequest{}, errors.New("missing required headers")
+		logger.WarnContext(ctx, "missing required headers", "user_id", logging.PII(headers.XUserID), "device_id", logging.PII(headers.XDeviceID), "language", headers.XLanguage)
+		return models.AnneWearConversationRequest{}, ErrMissingHeaders
 	}
 
 	if headers.XLanguage != "en" && headers.XLanguage != "de" {
-		log.Println("Invalid language:", headers.XLanguage)
-		return models.AnneWearConversationRequest{}, errors.New("invalid language")
+		logger.WarnContext(ctx, "invalid language", "language", headers.XLanguage)
+		return models.AnneWearConversationRequest{}, ErrInvalidLanguage
 	}
 
 	userID, err := uuid.Parse(headers.XUserID)
 	if err != nil {
-		log.Println("Invalid user ID:", headers.XUserID)
-		return models.AnneWearConversationRequest{}, errors.New("invalid user ID")
+		logger.WarnContext(ctx, "invalid user id", "user_id", logging.PII(headers.XUserID))
+		return models.AnneWearConversationRequest{}, ErrInvalidUserID
 	}
 
 	deviceID, err := strconv.Atoi(headers.XDeviceID)
 	if err != nil {
-		log.Println("Invalid device ID:", headers.XDeviceID)
-		return models.AnneWearConversationRequest{}, errors.New("invalid device ID")
+		logger.WarnContext(ctx, "invalid device id", "device_id", logging.PII(headers.XDeviceID))
+		return models.AnneWearConversationRequest{}, ErrInvalidDeviceID
+	}
+
+	format := pcm.M5Format
+	if headers.XAudioFormat != nil {
+		format = headers.XAudioFormat.WithDefaults()
+		if err := format.Validate(); err != nil {
+			logger.WarnContext(ctx, "invalid audio format", "error", err)
+			return models.AnneWearConversationRequest{}, ErrInvalidAudioFormat
+		}
 	}
 
 	req := models.AnneWearConversationRequest{
 		UserID:      userID,
 		DeviceID:    deviceID,
 		RequestPCM:  pcmData,
+		AudioFormat: format,
 		Language:    headers.XLanguage,
 	}
 
@@ -56,33 +79,19 @@ func HandleProcessConversationInput(
 }
 
 // checks if a previous conversation exists and retrieves it.
-func GetPreviousConversation(userID uuid.UUID, resetMinutes int) (*models.Conversation, models.ConversationHistory, error) {
-	var lastConversation models.Conversation
-	var conversationHistory models.ConversationHistory
+func GetPreviousConversation(ctx context.Context, conversations repository.Conversations, userID uuid.UUID, resetMinutes int) (*models.Conversation, models.ConversationHistory, error) {
+	ctx, span := tracing.Start(ctx, "services.GetPreviousConversation")
+	defer span.End()
 
-	query := `
-        SELECT id, user_id, conversation_history, created_at
-        FROM conversations
-        WHERE user_id = $1
-          AND created_at >= NOW() - $2 * INTERVAL '1 minute'
-        ORDER BY created_at DESC
-        LIMIT 1;
-    `
-
-	// log.Printf("Executing SQL Query with UserID=%s, ResetMinutes=%d", userID, resetMinutes)
-	err := db.DB.QueryRow(query, userID, resetMinutes).Scan(
-		&lastConversation.ID,
-		&lastConversation.UserID,
-		&lastConversation.ConversationHistory,
-		&lastConversation.CreatedAt,
-	)
+	var conversationHistory models.ConversationHistory
 
+	lastConversation, err := conversations.Latest(ctx, userID, time.Duration(resetMinutes)*time.Minute)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			log.Println("No previous conversation found within the reset time")
+		if errors.Is(err, repository.ErrNotFound) {
+			logger.DebugContext(ctx, "no previous conversation within the reset time")
 			return nil, models.ConversationHistory{}, nil
 		}
-		log.Printf("Error querying conversation: %v\n", err)
+		logger.ErrorContext(ctx, "failed to query conversation", "error", err)
 		return nil, models.ConversationHistory{}, err
 	}
 
@@ -92,7 +101,7 @@ func GetPreviousConversation(userID uuid.UUID, resetMinutes int) (*models.Conver
 	if len(lastConversation.ConversationHistory) > 0 {
 		err = json.Unmarshal(lastConversation.ConversationHistory, &conversationHistory)
 		if err != nil {
-			log.Printf("Error unmarshalling ConversationHistory: %v\n", err)
+			logger.ErrorContext(ctx, "failed to unmarshal conversation history", "conversation_id", lastConversation.ID, "error", err)
 			return nil, models.ConversationHistory{}, err
 		}
 	}
@@ -101,67 +110,45 @@ func GetPreviousConversation(userID uuid.UUID, resetMinutes int) (*models.Conver
 }
 
 // updates an existing conversation in the database.
-func UpdateExistingConversation(convoID int64, convoJSON []byte) error {
-	// log.Printf("Updating existing conversation ID: %d\n", convoID)
-	updateQuery := `
-		UPDATE conversations
-		SET conversation_history = $1, updated_at = NOW()
-		WHERE id = $2
-		RETURNING updated_at;
-	`
-
-	// log.Printf("Executing UPDATE Query with ConversationHistory: %s and ID: %d", string(convoJSON), convoID)
-
-	var updatedAt time.Time
-	err := db.DB.QueryRow(updateQuery, convoJSON, convoID).Scan(&updatedAt)
+func UpdateExistingConversation(ctx context.Context, conversations repository.Conversations, convoID int64, convoJSON []byte) error {
+	err := conversations.UpdateHistory(ctx, convoID, convoJSON)
 	if err != nil {
-		log.Printf("Error updating conversation: %v\n", err)
+		logger.ErrorContext(ctx, "failed to update conversation", "conversation_id", convoID, "error", err)
 		return &echo.HTTPError{
 			Code:     http.StatusInternalServerError,
 			Message:  "Failed to update conversation.",
 			Internal: err,
 		}
 	}
-	// log.Printf("Conversation ID: %d updated at %v\n", convoID, updatedAt)
 	return nil
 }
 
 //  inserts a new conversation into the database.
-func InsertNewConversation(userID uuid.UUID, convoJSON []byte) error {
-	log.Println("Inserting new conversation into the database")
-	insertQuery := `
-		INSERT INTO conversations (user_id, conversation_history)
-		VALUES ($1, $2)
-		RETURNING id, created_at;
-	`
-
-	// log.Printf("Executing INSERT Query with UserID: %s and ConversationHistory: %s", userID, string(convoJSON))
-
-	var newID int64
-	var createdAt time.Time
-	err := db.DB.QueryRow(insertQuery, userID, convoJSON).Scan(&newID, &createdAt)
+func InsertNewConversation(ctx context.Context, conversations repository.Conversations, userID uuid.UUID, convoJSON []byte) error {
+	newID, err := conversations.Create(ctx, userID, convoJSON)
 	if err != nil {
-		if pgErr, ok := err.(*pq.Error); ok && pgErr.Code.Name() == "foreign_key_violation" {
-			log.Println("Foreign key violation: Invalid user_id")
+		if errors.Is(err, repository.ErrInvalidReference) {
+			logger.WarnContext(ctx, "conversation for unknown user", "user_id", logging.PII(userID.String()))
 			return &echo.HTTPError{
 				Code:    http.StatusBadRequest,
 				Message: "Invalid user_id. User does not exist.",
 			}
 		}
-		log.Printf("Error inserting conversation: %v\n", err)
+		logger.ErrorContext(ctx, "failed to insert conversation", "error", err)
 		return &echo.HTTPError{
 			Code:     http.StatusInternalServerError,
 			Message:  "Failed to store conversation.",
 			Internal: err,
 		}
 	}
-	// log.Printf("New conversation inserted with ID: %d at %v\n", newID, createdAt)
+	logger.DebugContext(ctx, "conversation inserted", "conversation_id", newID)
 	return nil
 }
 
 
-// appends a new message to the conversation history.
-func AppendMessageToConversationHistory(history *models.ConversationHistory, sender, content string) {
+// appends a new message to the conversation history and returns it so callers
+// can attach per-turn details. The pointer is only valid until the next append.
+func AppendMessageToConversationHistory(history *models.ConversationHistory, sender, content string) *models.Message {
 	message := models.Message{
 		Sender:    sender,
 		Content:   content,
@@ -169,5 +156,6 @@ func AppendMessageToConversationHistory(history *models.ConversationHistory, sen
 	}
 	history.Messages = append(history.Messages, message)
 	// log.Printf("Appended %s message to conversation history: %+v\n", sender, message)
+	return &history.Messages[len(history.Messages)-1]
 }
 
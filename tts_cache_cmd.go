@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"anne-hub/pkg/apology"
+	"anne-hub/pkg/blob"
+	"anne-hub/pkg/config"
+	"anne-hub/pkg/pipeline"
+	"anne-hub/pkg/ttscache"
+)
+
+const ttsCacheUsage = `usage: anne-hub tts-cache <command>
+
+commands:
+  warm [-language en] [FILE...]   synthesize the common phrases and those in
+                                  FILE (one per line, "de: ..." to override
+                                  the language) into the cache
+  stats                           print the number and size of cached entries`
+
+// commonPhrases are warmed besides the apologies of pkg/apology.
+var commonPhrases = map[string][]string{
+	"en": {"I didn't quite understand that. Could you please try again?"},
+	"de": {"Das habe ich nicht ganz verstanden. Kannst du es bitte noch einmal versuchen?"},
+}
+
+// runTTSCache runs the "anne-hub tts-cache" subcommand.
+func runTTSCache(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(ttsCacheUsage)
+	}
+	if !cfg.TTSCache.Enabled {
+		return errors.New("the tts cache is disabled (TTS_CACHE_ENABLED)")
+	}
+
+	cache := ttscache.Setup(cfg.TTSCache, blob.Setup(cfg.Storage))
+
+	command, args := args[0], args[1:]
+	switch command {
+	case "warm":
+		return warmTTSCache(cfg, cache, args)
+	case "stats":
+		entries, size := cache.Len()
+		fmt.Printf("%d entries, %d of %d bytes\n", entries, size, cfg.TTSCache.MaxBytes)
+		return nil
+	default:
+		return fmt.Errorf("unknown tts-cache command %q\n\n%s", command, ttsCacheUsage)
+	}
+}
+
+type phrase struct {
+	language string
+	text     string
+}
+
+func warmTTSCache(cfg *config.Config, cache *ttscache.Cache, args []string) error {
+	flags := flag.NewFlagSet("warm", flag.ContinueOnError)
+	language := flags.String("language", "en", "language of phrases without a prefix")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var phrases []phrase
+	for lang, texts := range commonPhrases {
+		for _, text := range texts {
+			phrases = append(phrases, phrase{lang, text})
+		}
+	}
+	for _, p := range apology.Phrases {
+		for lang, text := range p.Text {
+			phrases = append(phrases, phrase{lang, text})
+		}
+	}
+	for _, path := range flags.Args() {
+		fromFile, err := readPhrases(path, *language)
+		if err != nil {
+			return err
+		}
+		phrases = append(phrases, fromFile...)
+	}
+
+	speech := pipeline.Setup(cfg, cache)
+
+	ctx := context.Background()
+	failed := 0
+	for _, p := range phrases {
+		start := time.Now()
+		_, provider, err := speech.Synthesize(ctx, p.text, p.language)
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "failed [%s] %q: %v\n", p.language, p.text, err)
+			continue
+		}
+		fmt.Printf("%-10s %6s [%s] %s\n", provider, time.Since(start).Round(time.Millisecond), p.language, p.text)
+	}
+
+	entries, size := cache.Len()
+	fmt.Printf("%d phrases, %d failed, cache holds %d entries, %d bytes\n", len(phrases), failed, entries, size)
+	if failed > 0 {
+		return fmt.Errorf("%d phrases could not be synthesized", failed)
+	}
+	return nil
+}
+
+// readPhrases reads one phrase per line, skipping blank lines and lines
+// starting with #. A "de: " prefix sets the language of a line.
+func readPhrases(path, language string) ([]phrase, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var phrases []phrase
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := phrase{language, line}
+		if lang, text, ok := strings.Cut(line, ":"); ok && len(lang) == 2 {
+			p = phrase{lang, strings.TrimSpace(text)}
+		}
+		phrases = append(phrases, p)
+	}
+	return phrases, scanner.Err()
+}